@@ -10,12 +10,23 @@ import (
 	"strings"
 	"syscall"
 	"time"
+	_ "time/tzdata" // itinerary time zones; the runtime image has no zoneinfo
 
 	"github.com/rally-go/rally-realtime/internal/config"
+	"github.com/rally-go/rally-realtime/internal/features/chat"
+	"github.com/rally-go/rally-realtime/internal/features/events"
+	"github.com/rally-go/rally-realtime/internal/features/moderation"
+	"github.com/rally-go/rally-realtime/internal/features/notify"
+	"github.com/rally-go/rally-realtime/internal/features/planning"
+	"github.com/rally-go/rally-realtime/internal/features/polls"
 	"github.com/rally-go/rally-realtime/internal/firebase"
+	"github.com/rally-go/rally-realtime/internal/middleware"
 	"github.com/rally-go/rally-realtime/internal/pubsub"
+	"github.com/rally-go/rally-realtime/internal/rooms"
 	"github.com/rally-go/rally-realtime/internal/socket"
+	"github.com/rally-go/rally-realtime/internal/storage"
 	"github.com/rally-go/rally-realtime/internal/version"
+	"github.com/rally-go/rally-realtime/internal/webhooks"
 )
 
 func main() {
@@ -24,8 +35,12 @@ func main() {
 	log.Printf("Starting Rally Realtime Server %s", version.Version)
 	log.Printf("Commit SHA: %s, Build Time: %s", version.CommitSHA, version.BuildTime)
 
-	// Initialise Firebase Auth
-	firebase.MustInitialize(cfg.Firebase.CredentialsPath)
+	// Initialise Firebase, unless tokens are verified without it and push
+	// notifications are off
+	if cfg.Auth.Mode == "firebase" || cfg.Push.Enabled {
+		firebase.MustInitialize(cfg.Firebase.CredentialsPath)
+	}
+	verifier := newTokenVerifier(cfg)
 
 	// Initialise Redis pub/sub
 	redisPubSub, err := pubsub.NewRedisPubSub(cfg.Redis.Addr, cfg.Redis.Password, cfg.Redis.TLS)
@@ -43,11 +58,64 @@ func main() {
 			}
 		}
 	}
+	// Initialise chat persistence
+	var messages chat.MessageStore
+	var mongoClient *storage.MongoClient
+	if cfg.Mongo.URI != "" {
+		mongoClient, err = storage.NewMongoClient(cfg.Mongo.URI)
+		if err != nil {
+			log.Fatalf("Failed to connect to MongoDB: %v", err)
+		}
+		messages = mongoClient
+	} else {
+		log.Println("MONGO_URI is not set; chat messages are not stored")
+	}
+
 	// Initialise WebSocket hub and server
-	hub := socket.NewHub(redisPubSub)
+	members := rooms.NewRedisStore(redisPubSub.Client())
+	if err := members.SetDefaultRole(rooms.Role(cfg.Rooms.DefaultRole)); err != nil {
+		log.Fatalf("Invalid DEFAULT_ROOM_ROLE: %v", err)
+	}
+	itinerary := planning.NewHandler(members, planning.NewRedisStore(redisPubSub.Client()))
+	var notifications *notify.Handler
+	if cfg.Push.Enabled {
+		notifyStore := notify.NewRedisStore(redisPubSub.Client())
+		notifications = notify.NewHandler(members, members, notifyStore, notify.NewFCMNotifier(firebase.GetMessagingClient(), notifyStore))
+	}
+	hub := socket.NewHub(redisPubSub, members, members, socket.Features{
+		Chat:       chat.NewHandler(members, messages),
+		Moderation: moderation.NewHandler(members, moderation.NewRedisStore(redisPubSub.Client())),
+		Planning:   itinerary,
+		Polls:      polls.NewHandler(members, polls.NewRedisStore(redisPubSub.Client()), itinerary),
+		Events:     events.NewHandler(events.NewRedisStore(redisPubSub.Client())),
+		Notify:     notifications,
+	})
+	hub.Chat.SetFilterStore(chat.NewRedisFilterStore(redisPubSub.Client()))
+	if cfg.Chat.ModerationConfigPath != "" {
+		modCfg, err := chat.LoadModerationConfig(cfg.Chat.ModerationConfigPath)
+		if err != nil {
+			log.Fatalf("Failed to load moderation config: %v", err)
+		}
+		if err := hub.Chat.ConfigureFilters(modCfg); err != nil {
+			log.Fatalf("Invalid moderation config: %v", err)
+		}
+	}
+	if cfg.Webhooks.ConfigPath != "" {
+		hookCfg, err := webhooks.LoadConfig(cfg.Webhooks.ConfigPath)
+		if err != nil {
+			log.Fatalf("Failed to load webhook config: %v", err)
+		}
+		hub.Webhooks = webhooks.NewDispatcher(hookCfg.Subscriptions, webhooks.NewRedisStore(redisPubSub.Client()), webhooks.Options{})
+		log.Printf("Delivering webhooks to %d subscriptions", len(hookCfg.Subscriptions))
+	}
 	go hub.Run()
 
-	wsServer := socket.NewServer(hub, firebase.GetAuthClient(), allowedOrigins)
+	wsServer := socket.NewServer(hub, verifier, socket.ServerOptions{
+		AllowedOrigins:     allowedOrigins,
+		MinProtocolVersion: cfg.Server.MinProtocolVersion,
+		ServiceSecret:      cfg.Server.ServiceSecret,
+		AllowQueryToken:    cfg.Auth.QueryToken,
+	})
 
 	// Setup HTTP routes
 	mux := http.NewServeMux()
@@ -77,6 +145,18 @@ func main() {
 	// WebSocket endpoint
 	mux.HandleFunc("/ws", wsServer.ServeWs)
 
+	// Fallback transports for networks that block WebSockets
+	mux.HandleFunc("/sse", wsServer.ServeSSE)
+	mux.HandleFunc("/poll", wsServer.ServePoll)
+	mux.HandleFunc("/send", wsServer.ServeSend)
+
+	// Itinerary exports
+	mux.HandleFunc("GET /rooms/{id}/itinerary.ics", wsServer.ServeItineraryICS)
+	mux.HandleFunc("GET /rooms/{id}/itinerary.geojson", wsServer.ServeItineraryGeoJSON)
+
+	// Events published by backend services
+	mux.HandleFunc("POST /rooms/{id}/events", wsServer.ServeRoomEvents)
+
 	// Create server
 	server := &http.Server{
 		Addr:         ":" + cfg.Server.Port,
@@ -107,6 +187,55 @@ func main() {
 	if err := server.Shutdown(ctx); err != nil {
 		log.Fatalf("Server forced to shutdown: %v", err)
 	}
+	if jwks, ok := verifier.(*middleware.JWKSVerifier); ok {
+		jwks.Close()
+	}
+	if hub.Webhooks != nil {
+		if err := hub.Webhooks.Close(ctx); err != nil {
+			log.Printf("Webhooks not drained: %v", err)
+		}
+	}
+	if mongoClient != nil {
+		if err := mongoClient.Close(ctx); err != nil {
+			log.Printf("Failed to close MongoDB client: %v", err)
+		}
+	}
 
 	log.Println("Server exited")
 }
+
+// newTokenVerifier creates the verifier selected by AUTH_MODE.
+func newTokenVerifier(cfg *config.Config) middleware.TokenVerifier {
+	switch cfg.Auth.Mode {
+	case "firebase":
+		return middleware.NewFirebaseVerifier(firebase.GetAuthClient())
+	case "jwks":
+		verifier, err := middleware.NewJWKSVerifier(middleware.JWKSOptions{
+			Source:   cfg.Auth.JWKS,
+			Issuer:   cfg.Auth.Issuer,
+			Audience: cfg.Auth.Audience,
+		})
+		if err != nil {
+			log.Fatalf("Failed to create JWKS token verifier: %v", err)
+		}
+		log.Printf("Verifying tokens with keys from %s", cfg.Auth.JWKS)
+		return verifier
+	case "emulator":
+		verifier, err := middleware.NewEmulatorVerifier(cfg.Firebase.ProjectID)
+		if err != nil {
+			log.Fatalf("Failed to create emulator token verifier: %v", err)
+		}
+		log.Printf("Accepting unsigned Firebase Auth emulator tokens for %s", cfg.Firebase.ProjectID)
+		return verifier
+	case "static":
+		users, err := middleware.ParseStaticTokens(cfg.Auth.StaticTokens)
+		if err != nil || len(users) == 0 {
+			log.Fatalf("AUTH_STATIC_TOKENS must list token=user pairs: %v", err)
+		}
+		log.Printf("WARNING: accepting %d static tokens; do not use in production", len(users))
+		return middleware.NewStaticVerifier(users, time.Hour)
+	default:
+		log.Fatalf("Unknown AUTH_MODE %q", cfg.Auth.Mode)
+		return nil
+	}
+}
@@ -2,6 +2,7 @@ package config
 
 import (
 	"log"
+	"strconv"
 
 	"github.com/spf13/viper"
 )
@@ -9,12 +10,20 @@ import (
 type Config struct {
 	Server   ServerConfig
 	Redis    RedisConfig
+	Mongo    MongoConfig
 	Firebase FirebaseConfig
+	Auth     AuthConfig
+	Rooms    RoomsConfig
+	Chat     ChatConfig
+	Webhooks WebhooksConfig
+	Push     PushConfig
 }
 
 type ServerConfig struct {
-	Port           string
-	AllowedOrigins string
+	Port               string
+	AllowedOrigins     string
+	MinProtocolVersion int
+	ServiceSecret      string
 }
 
 type RedisConfig struct {
@@ -23,8 +32,38 @@ type RedisConfig struct {
 	TLS      bool
 }
 
+type MongoConfig struct {
+	URI string
+}
+
 type FirebaseConfig struct {
 	CredentialsPath string
+	ProjectID       string
+}
+
+type AuthConfig struct {
+	Mode         string
+	JWKS         string
+	Issuer       string
+	Audience     string
+	StaticTokens string
+	QueryToken   bool
+}
+
+type RoomsConfig struct {
+	DefaultRole string
+}
+
+type ChatConfig struct {
+	ModerationConfigPath string
+}
+
+type WebhooksConfig struct {
+	ConfigPath string
+}
+
+type PushConfig struct {
+	Enabled bool
 }
 
 // Load reads configuration from the .env file and environment variables.
@@ -37,19 +76,75 @@ func Load() *Config {
 		log.Println("Warning: failed to read .env file, relying on system envs")
 	}
 
+	authMode := getEnv("AUTH_MODE", "firebase")
+
 	return &Config{
 		Server: ServerConfig{
 			Port:           getEnv("PORT", "8080"),
 			AllowedOrigins: getEnv("ALLOWED_ORIGINS", ""),
+			// Clients below this WebSocket protocol version are told to
+			// upgrade.
+			MinProtocolVersion: getEnvInt("MIN_PROTOCOL_VERSION", 1),
+			// Shared secret for backend services publishing room events.
+			// Leave empty to disable the endpoint.
+			ServiceSecret: getEnv("SERVICE_API_SECRET", ""),
 		},
 		Redis: RedisConfig{
 			Addr:     getEnv("REDIS_ADDR", "localhost:6379"),
 			Password: getEnv("REDIS_PASSWORD", ""),
 			TLS:      getEnv("REDIS_TLS", "false") == "true",
 		},
+		Mongo: MongoConfig{
+			// Chat messages and their mentions are stored here.
+			// Leave empty to disable persistence.
+			URI: getEnv("MONGO_URI", ""),
+		},
 		Firebase: FirebaseConfig{
 			// Leave empty on Cloud Run to use Application Default Credentials.
 			CredentialsPath: getEnv("FIREBASE_CREDENTIALS_PATH", ""),
+			// Required by the emulator token verifier.
+			ProjectID: getEnv("FIREBASE_PROJECT_ID", ""),
+		},
+		Auth: AuthConfig{
+			// How ID tokens are verified: firebase, jwks, emulator or
+			// static.
+			Mode: authMode,
+			// JWKS URL or file, and the issuer and audience tokens must
+			// carry, for the jwks mode.
+			JWKS:     getEnv("AUTH_JWKS", ""),
+			Issuer:   getEnv("AUTH_ISSUER", ""),
+			Audience: getEnv("AUTH_AUDIENCE", ""),
+			// "token=user" pairs for the static mode, for tests only.
+			StaticTokens: getEnv("AUTH_STATIC_TOKENS", ""),
+			// Accept WebSocket tokens in the URL, where they end up in
+			// proxy logs, for clients that cannot send them otherwise.
+			// Off unless app builds that predate the other forms are
+			// still in use. HTTP transports and exports accept them
+			// regardless.
+			QueryToken: getEnv("AUTH_QUERY_TOKEN", "false") == "true",
+		},
+		Rooms: RoomsConfig{
+			// Role of users joining a room after its owner: member, or
+			// guest to keep them from editing until a token claim
+			// promotes them.
+			DefaultRole: getEnv("DEFAULT_ROOM_ROLE", "member"),
+		},
+		Chat: ChatConfig{
+			// JSON file with default and per-room moderation filters.
+			// Leave empty to disable moderation.
+			ModerationConfigPath: getEnv("CHAT_MODERATION_CONFIG", ""),
+		},
+		Webhooks: WebhooksConfig{
+			// JSON file with webhook subscriptions.
+			// Leave empty to disable webhooks.
+			ConfigPath: getEnv("WEBHOOKS_CONFIG", ""),
+		},
+		Push: PushConfig{
+			// Push mentions and itinerary changes to offline members
+			// with Firebase Cloud Messaging. On by default only when
+			// Firebase verifies tokens, since it needs the same
+			// credentials.
+			Enabled: getEnv("PUSH_NOTIFICATIONS", strconv.FormatBool(authMode == "firebase")) == "true",
 		},
 	}
 }
@@ -60,3 +155,10 @@ func getEnv(key, defaultValue string) string {
 	}
 	return defaultValue
 }
+
+func getEnvInt(key string, defaultValue int) int {
+	if viper.IsSet(key) {
+		return viper.GetInt(key)
+	}
+	return defaultValue
+}
@@ -0,0 +1,75 @@
+package chat
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/rally-go/rally-realtime/internal/rooms"
+)
+
+// ErrUnknownRecipient is returned for a direct message to a user the sender
+// has no room in common with.
+var ErrUnknownRecipient = errors.New("recipient is not a member of the room or an existing conversation")
+
+// directEnvelope is the addressing part of a direct message payload.
+type directEnvelope struct {
+	ToUserID string `json:"to_user_id"`
+}
+
+// ProcessDirectMessage processes a 1:1 message from senderID, sent from
+// fromRoomID. The recipient is taken from the payload's "to_user_id" and
+// must be a member of fromRoomID, or already in a conversation with the
+// sender. The message is stored in the deterministic private room of the
+// two users, whose ID is returned.
+func (h *Handler) ProcessDirectMessage(ctx context.Context, senderID, fromRoomID string, payload json.RawMessage) (*ChatMessage, string, error) {
+	var env directEnvelope
+	if err := json.Unmarshal(payload, &env); err != nil {
+		return nil, "", fmt.Errorf("%w: %v", ErrInvalidPayload, err)
+	}
+
+	roomID, err := rooms.DirectRoomID(senderID, env.ToUserID)
+	if err != nil {
+		return nil, "", fmt.Errorf("%w: %v", ErrInvalidPayload, err)
+	}
+
+	// Both participants are members so that mentions, history and
+	// moderation lookups treat the conversation like any other room. The
+	// recipient is checked first, so that messages to arbitrary user IDs
+	// create no rooms.
+	if h.members != nil {
+		ok, err := h.knownRecipient(ctx, fromRoomID, roomID, env.ToUserID)
+		if err != nil {
+			return nil, "", err
+		}
+		if !ok {
+			return nil, "", ErrUnknownRecipient
+		}
+		for _, id := range []string{senderID, env.ToUserID} {
+			if err := h.members.AddMember(ctx, roomID, id); err != nil {
+				return nil, "", err
+			}
+		}
+	}
+
+	msg, err := h.ProcessMessage(ctx, senderID, roomID, payload)
+	if err != nil {
+		return nil, "", err
+	}
+	msg.ToUserID = env.ToUserID
+
+	return msg, roomID, nil
+}
+
+// knownRecipient reports whether userID is a member of fromRoomID or of the
+// direct conversation roomID.
+func (h *Handler) knownRecipient(ctx context.Context, fromRoomID, roomID, userID string) (bool, error) {
+	if fromRoomID != "" {
+		ok, err := h.members.IsMember(ctx, fromRoomID, userID)
+		if err != nil || ok {
+			return ok, err
+		}
+	}
+	return h.members.IsMember(ctx, roomID, userID)
+}
@@ -0,0 +1,224 @@
+package chat
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+)
+
+// Verdict is the outcome of running a message through a Filter.
+type Verdict int
+
+const (
+	// VerdictAllow passes the message through unchanged.
+	VerdictAllow Verdict = iota
+	// VerdictMask passes the message through with offending parts replaced.
+	VerdictMask
+	// VerdictReject drops the message and reports an error to the sender.
+	VerdictReject
+)
+
+// ErrMessageRejected is returned when a moderation filter rejects a message.
+var ErrMessageRejected = errors.New("message rejected by moderation")
+
+// FilterInput is the message a Filter inspects.
+type FilterInput struct {
+	RoomID  string
+	UserID  string
+	Content string
+}
+
+// FilterResult is returned by a Filter. Content is only used for VerdictMask.
+type FilterResult struct {
+	Verdict Verdict
+	Content string
+	Reason  string
+}
+
+// Filter inspects chat content before it is stored and broadcast.
+type Filter interface {
+	// Name identifies the filter in logs and rejection errors.
+	Name() string
+
+	// Check returns the verdict for in.
+	Check(ctx context.Context, in FilterInput) FilterResult
+}
+
+// Chain runs filters in order. Masked content is handed to the next filter;
+// the first rejection stops the chain.
+type Chain []Filter
+
+// Run applies the chain to in and returns the final content, whether it was
+// masked, and an error wrapping ErrMessageRejected if a filter rejected it.
+func (c Chain) Run(ctx context.Context, in FilterInput) (string, bool, error) {
+	masked := false
+	for _, f := range c {
+		res := f.Check(ctx, in)
+		switch res.Verdict {
+		case VerdictReject:
+			return "", false, fmt.Errorf("%w: %s: %s", ErrMessageRejected, f.Name(), res.Reason)
+		case VerdictMask:
+			in.Content = res.Content
+			masked = true
+		}
+	}
+	return in.Content, masked, nil
+}
+
+// FilterConfig selects and configures the built-in filters for a room.
+// A nil section disables that filter.
+type FilterConfig struct {
+	Words *WordListConfig `json:"words,omitempty"`
+	Links *LinkConfig     `json:"links,omitempty"`
+	Spam  *SpamConfig     `json:"spam,omitempty"`
+}
+
+// ModerationConfig is the moderation configuration file format. Rooms listed
+// under Rooms use their own FilterConfig instead of Default.
+type ModerationConfig struct {
+	Default FilterConfig            `json:"default"`
+	Rooms   map[string]FilterConfig `json:"rooms,omitempty"`
+}
+
+// LoadModerationConfig reads a ModerationConfig from a JSON file.
+func LoadModerationConfig(path string) (*ModerationConfig, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var cfg ModerationConfig
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return nil, fmt.Errorf("parse moderation config %s: %w", path, err)
+	}
+	return &cfg, nil
+}
+
+// Build creates the filter chain described by the config.
+func (c FilterConfig) Build() (Chain, error) {
+	var chain Chain
+	if c.Spam != nil {
+		chain = append(chain, NewSpamFilter(*c.Spam))
+	}
+	if c.Words != nil {
+		f, err := NewWordListFilter(*c.Words)
+		if err != nil {
+			return nil, err
+		}
+		chain = append(chain, f)
+	}
+	if c.Links != nil {
+		f, err := NewLinkFilter(*c.Links)
+		if err != nil {
+			return nil, err
+		}
+		chain = append(chain, f)
+	}
+	return chain, nil
+}
+
+// parseAction converts a config action name to a Verdict.
+func parseAction(action string, fallback Verdict) (Verdict, error) {
+	switch action {
+	case "":
+		return fallback, nil
+	case "mask":
+		return VerdictMask, nil
+	case "reject":
+		return VerdictReject, nil
+	}
+	return VerdictAllow, fmt.Errorf("unknown moderation action %q", action)
+}
+
+// filterSet holds the filters from the configuration file, the default
+// chain and per-room overrides, and caches the filters moderators set for
+// their rooms, which take precedence.
+type filterSet struct {
+	defaultChain Chain
+	configured   map[string]Chain
+	store        FilterStore
+	loaded       map[string]*roomChain
+	epoch        uint64 // advanced whenever loaded entries are dropped
+	mu           sync.RWMutex
+}
+
+// roomChain is a room's cached chain from the FilterStore; own is false if
+// the room has no filters of its own.
+type roomChain struct {
+	chain Chain
+	own   bool
+}
+
+// chainFor returns the filters for roomID, reading the room's own from the
+// store the first time.
+func (s *filterSet) chainFor(ctx context.Context, roomID string) (Chain, error) {
+	s.mu.RLock()
+	rc, ok := s.loaded[roomID]
+	store, epoch := s.store, s.epoch
+	s.mu.RUnlock()
+
+	if !ok {
+		cfg, err := store.RoomFilters(ctx, roomID)
+		if err != nil {
+			return nil, err
+		}
+		rc = &roomChain{}
+		if cfg != nil {
+			chain, err := cfg.Build()
+			if err != nil {
+				return nil, fmt.Errorf("filters of room %s: %w", roomID, err)
+			}
+			rc = &roomChain{chain: chain, own: true}
+		}
+
+		// Another instance may have changed the filters meanwhile; cache
+		// the result only if no reload was asked for.
+		s.mu.Lock()
+		if s.epoch == epoch {
+			if s.loaded == nil {
+				s.loaded = make(map[string]*roomChain)
+			}
+			s.loaded[roomID] = rc
+		}
+		s.mu.Unlock()
+	}
+	if rc.own {
+		return rc.chain, nil
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if chain, ok := s.configured[roomID]; ok {
+		return chain, nil
+	}
+	return s.defaultChain, nil
+}
+
+// ConfigureFilters replaces the moderation filters from the configuration
+// file with those described by cfg. Filters moderators set for their rooms
+// still take precedence.
+func (h *Handler) ConfigureFilters(cfg *ModerationConfig) error {
+	defaultChain, err := cfg.Default.Build()
+	if err != nil {
+		return fmt.Errorf("default filters: %w", err)
+	}
+
+	rooms := make(map[string]Chain, len(cfg.Rooms))
+	for roomID, rc := range cfg.Rooms {
+		chain, err := rc.Build()
+		if err != nil {
+			return fmt.Errorf("filters for room %s: %w", roomID, err)
+		}
+		rooms[roomID] = chain
+	}
+
+	h.filters.mu.Lock()
+	h.filters.defaultChain = defaultChain
+	h.filters.configured = rooms
+	h.filters.mu.Unlock()
+	return nil
+}
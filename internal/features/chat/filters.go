@@ -0,0 +1,316 @@
+package chat
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+)
+
+// WordListConfig configures WordListFilter.
+type WordListConfig struct {
+	Words  []string `json:"words"`
+	Action string   `json:"action,omitempty"` // "mask" (default) or "reject"
+}
+
+// WordListFilter blocks words from a list. Matching is case-insensitive and
+// sees through common leetspeak substitutions, repeated letters and
+// separators inside a word (e.g. "B4.d" and "baaad" both match "bad").
+type WordListFilter struct {
+	words  map[string]bool
+	action Verdict
+}
+
+// NewWordListFilter creates a WordListFilter.
+func NewWordListFilter(cfg WordListConfig) (*WordListFilter, error) {
+	action, err := parseAction(cfg.Action, VerdictMask)
+	if err != nil {
+		return nil, err
+	}
+
+	words := make(map[string]bool, len(cfg.Words))
+	for _, w := range cfg.Words {
+		if n := normalizeWord(w); n != "" {
+			words[n] = true
+		}
+	}
+	return &WordListFilter{words: words, action: action}, nil
+}
+
+// Name implements Filter.
+func (f *WordListFilter) Name() string { return "words" }
+
+// Check implements Filter.
+func (f *WordListFilter) Check(ctx context.Context, in FilterInput) FilterResult {
+	runes := []rune(in.Content)
+	hit := false
+
+	start := -1
+	for i := 0; i <= len(runes); i++ {
+		if i < len(runes) && !unicode.IsSpace(runes[i]) {
+			if start < 0 {
+				start = i
+			}
+			continue
+		}
+		if start < 0 {
+			continue
+		}
+
+		// Trailing punctuation ("bad!") is never part of the word, while
+		// leading and inner symbols may be leetspeak ("@ss", "sh!t").
+		token := strings.TrimRightFunc(string(runes[start:i]), unicode.IsPunct)
+		if f.words[normalizeWord(token)] || f.words[collapseRepeats(stripNonLetters(strings.ToLower(token)))] {
+			hit = true
+			for j := start; j < i; j++ {
+				if !unicode.IsPunct(runes[j]) || isLeet(runes[j]) {
+					runes[j] = '*'
+				}
+			}
+		}
+		start = -1
+	}
+
+	if !hit {
+		return FilterResult{Verdict: VerdictAllow}
+	}
+	return FilterResult{Verdict: f.action, Content: string(runes), Reason: "blocked word"}
+}
+
+// leetspeak maps common character substitutions back to letters.
+var leetspeak = map[rune]rune{
+	'0': 'o', '1': 'i', '3': 'e', '4': 'a', '5': 's', '7': 't', '8': 'b',
+	'@': 'a', '$': 's', '!': 'i', '|': 'l', '+': 't',
+}
+
+func isLeet(r rune) bool {
+	_, ok := leetspeak[r]
+	return ok
+}
+
+// normalizeWord lowercases w, undoes leetspeak, drops everything that is not a
+// letter and collapses runs of the same letter.
+func normalizeWord(w string) string {
+	var b strings.Builder
+	for _, r := range strings.ToLower(w) {
+		if l, ok := leetspeak[r]; ok {
+			r = l
+		}
+		b.WriteRune(r)
+	}
+	return collapseRepeats(stripNonLetters(b.String()))
+}
+
+func stripNonLetters(s string) string {
+	return strings.Map(func(r rune) rune {
+		if unicode.IsLetter(r) || unicode.Is(unicode.Mn, r) {
+			return r
+		}
+		return -1
+	}, s)
+}
+
+func collapseRepeats(s string) string {
+	var b strings.Builder
+	var prev rune = -1
+	for _, r := range s {
+		if r != prev {
+			b.WriteRune(r)
+		}
+		prev = r
+	}
+	return b.String()
+}
+
+// LinkConfig configures LinkFilter. Domains match themselves and their
+// subdomains. If Allow is non-empty, only links to allowed domains pass.
+type LinkConfig struct {
+	Allow  []string `json:"allow,omitempty"`
+	Deny   []string `json:"deny,omitempty"`
+	Action string   `json:"action,omitempty"` // "reject" (default) or "mask"
+}
+
+// LinkFilter blocks links by domain.
+type LinkFilter struct {
+	allow  []string
+	deny   []string
+	action Verdict
+}
+
+// linkPattern matches http(s) URLs and bare "www." links.
+var linkPattern = regexp.MustCompile(`(?i)\b(?:https?://|www\.)[^\s<>"']+`)
+
+// NewLinkFilter creates a LinkFilter.
+func NewLinkFilter(cfg LinkConfig) (*LinkFilter, error) {
+	action, err := parseAction(cfg.Action, VerdictReject)
+	if err != nil {
+		return nil, err
+	}
+	return &LinkFilter{
+		allow:  lowerAll(cfg.Allow),
+		deny:   lowerAll(cfg.Deny),
+		action: action,
+	}, nil
+}
+
+// Name implements Filter.
+func (f *LinkFilter) Name() string { return "links" }
+
+// Check implements Filter.
+func (f *LinkFilter) Check(ctx context.Context, in FilterInput) FilterResult {
+	hit := false
+	content := linkPattern.ReplaceAllStringFunc(in.Content, func(link string) string {
+		if f.permits(linkHost(link)) {
+			return link
+		}
+		hit = true
+		return "[link removed]"
+	})
+
+	if !hit {
+		return FilterResult{Verdict: VerdictAllow}
+	}
+	return FilterResult{Verdict: f.action, Content: content, Reason: "link not allowed"}
+}
+
+func (f *LinkFilter) permits(host string) bool {
+	if host == "" {
+		return false
+	}
+	for _, d := range f.deny {
+		if matchesDomain(host, d) {
+			return false
+		}
+	}
+	if len(f.allow) == 0 {
+		return true
+	}
+	for _, d := range f.allow {
+		if matchesDomain(host, d) {
+			return true
+		}
+	}
+	return false
+}
+
+// linkHost returns the lowercase host name of a matched link.
+func linkHost(link string) string {
+	if !strings.Contains(link, "://") {
+		link = "http://" + link
+	}
+	u, err := url.Parse(link)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSuffix(strings.ToLower(u.Hostname()), ".")
+}
+
+func matchesDomain(host, domain string) bool {
+	return host == domain || strings.HasSuffix(host, "."+domain)
+}
+
+func lowerAll(ss []string) []string {
+	out := make([]string, 0, len(ss))
+	for _, s := range ss {
+		if s = strings.ToLower(strings.TrimSpace(s)); s != "" {
+			out = append(out, s)
+		}
+	}
+	return out
+}
+
+// spamWindow is the default look-back window of SpamFilter.
+const spamWindow = 30 * time.Second
+
+// SpamConfig configures SpamFilter.
+type SpamConfig struct {
+	// MaxRepeats is how many times the same message may be sent within the
+	// window. Defaults to 3.
+	MaxRepeats int `json:"max_repeats,omitempty"`
+
+	// WindowSeconds is the look-back window. Defaults to 30 seconds.
+	WindowSeconds int `json:"window_seconds,omitempty"`
+}
+
+// SpamFilter rejects a user's message when they have already sent the same
+// (normalized) text MaxRepeats times in the window. State is kept in memory
+// per server instance.
+type SpamFilter struct {
+	maxRepeats int
+	window     time.Duration
+	recent     map[string][]time.Time // "room\x00user\x00text" -> send times
+	lastSweep  time.Time
+	now        func() time.Time
+	mu         sync.Mutex
+}
+
+// NewSpamFilter creates a SpamFilter.
+func NewSpamFilter(cfg SpamConfig) *SpamFilter {
+	f := &SpamFilter{
+		maxRepeats: cfg.MaxRepeats,
+		window:     time.Duration(cfg.WindowSeconds) * time.Second,
+		recent:     make(map[string][]time.Time),
+		now:        time.Now,
+	}
+	if f.maxRepeats <= 0 {
+		f.maxRepeats = 3
+	}
+	if f.window <= 0 {
+		f.window = spamWindow
+	}
+	return f
+}
+
+// Name implements Filter.
+func (f *SpamFilter) Name() string { return "spam" }
+
+// Check implements Filter.
+func (f *SpamFilter) Check(ctx context.Context, in FilterInput) FilterResult {
+	key := in.RoomID + "\x00" + in.UserID + "\x00" + strings.ToLower(strings.Join(strings.Fields(in.Content), " "))
+	now := f.now()
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	// Only this message's entry is pruned on every check. Entries of
+	// messages that are never repeated are swept once per window so that
+	// the map does not grow unbounded.
+	if now.Sub(f.lastSweep) >= f.window {
+		for k, times := range f.recent {
+			f.prune(k, times, now)
+		}
+		f.lastSweep = now
+	}
+
+	times := f.prune(key, f.recent[key], now)
+	if len(times) >= f.maxRepeats {
+		return FilterResult{
+			Verdict: VerdictReject,
+			Reason:  fmt.Sprintf("same message sent more than %d times in %s", f.maxRepeats, f.window),
+		}
+	}
+	f.recent[key] = append(times, now)
+	return FilterResult{Verdict: VerdictAllow}
+}
+
+// prune drops the send times of key that fell out of the window and
+// returns those left. Times are in order, so the kept ones are a suffix.
+func (f *SpamFilter) prune(key string, times []time.Time, now time.Time) []time.Time {
+	i := 0
+	for i < len(times) && now.Sub(times[i]) >= f.window {
+		i++
+	}
+	if i == len(times) {
+		delete(f.recent, key)
+		return nil
+	}
+	if i > 0 {
+		times = times[i:]
+		f.recent[key] = times
+	}
+	return times
+}
@@ -0,0 +1,249 @@
+package chat
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestWordListFilter(t *testing.T) {
+	f, err := NewWordListFilter(WordListConfig{Words: []string{"bad", "ass"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		in      string
+		want    string
+		verdict Verdict
+	}{
+		{name: "clean", in: "a good day", want: "", verdict: VerdictAllow},
+		{name: "exact", in: "a bad day", want: "a *** day", verdict: VerdictMask},
+		{name: "case", in: "BAD news", want: "*** news", verdict: VerdictMask},
+		{name: "trailing punctuation kept", in: "so bad.", want: "so ***.", verdict: VerdictMask},
+		{name: "surrounding punctuation kept", in: "(bad)", want: "(***)", verdict: VerdictMask},
+		{name: "leetspeak", in: "b4d luck", want: "*** luck", verdict: VerdictMask},
+		{name: "leading symbol", in: "you @ss", want: "you ***", verdict: VerdictMask},
+		{name: "repeated letters", in: "baaad", want: "*****", verdict: VerdictMask},
+		{name: "separators", in: "b.a.d", want: "*.*.*", verdict: VerdictMask},
+		{name: "inside a longer word", in: "badminton", want: "", verdict: VerdictAllow},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res := f.Check(context.Background(), FilterInput{Content: tt.in})
+			if res.Verdict != tt.verdict || res.Content != tt.want {
+				t.Errorf("Check(%q) = %v %q, want %v %q", tt.in, res.Verdict, res.Content, tt.verdict, tt.want)
+			}
+		})
+	}
+
+	reject, err := NewWordListFilter(WordListConfig{Words: []string{"bad"}, Action: "reject"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res := reject.Check(context.Background(), FilterInput{Content: "bad"}); res.Verdict != VerdictReject {
+		t.Errorf("reject action: verdict = %v, want VerdictReject", res.Verdict)
+	}
+
+	if _, err := NewWordListFilter(WordListConfig{Action: "delete"}); err == nil {
+		t.Error("unknown action accepted")
+	}
+}
+
+func TestLinkFilter(t *testing.T) {
+	tests := []struct {
+		name    string
+		cfg     LinkConfig
+		in      string
+		want    string
+		verdict Verdict
+	}{
+		{name: "no links", cfg: LinkConfig{Deny: []string{"evil.com"}}, in: "see you there", verdict: VerdictAllow},
+		{name: "denied", cfg: LinkConfig{Deny: []string{"evil.com"}}, in: "go to https://evil.com/x", verdict: VerdictReject},
+		{name: "denied subdomain", cfg: LinkConfig{Deny: []string{"evil.com"}}, in: "http://www.EVIL.com", verdict: VerdictReject},
+		{name: "suffix is not a subdomain", cfg: LinkConfig{Deny: []string{"evil.com"}}, in: "https://notevil.com", verdict: VerdictAllow},
+		{name: "allowed", cfg: LinkConfig{Allow: []string{"maps.google.com"}}, in: "https://maps.google.com/?q=1", verdict: VerdictAllow},
+		{name: "not on allow list", cfg: LinkConfig{Allow: []string{"maps.google.com"}}, in: "www.example.com", verdict: VerdictReject},
+		{name: "deny beats allow", cfg: LinkConfig{Allow: []string{"example.com"}, Deny: []string{"bad.example.com"}}, in: "https://bad.example.com", verdict: VerdictReject},
+		{name: "masked", cfg: LinkConfig{Deny: []string{"evil.com"}, Action: "mask"}, in: "see https://evil.com now", want: "see [link removed] now", verdict: VerdictMask},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f, err := NewLinkFilter(tt.cfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			res := f.Check(context.Background(), FilterInput{Content: tt.in})
+			if res.Verdict != tt.verdict {
+				t.Errorf("Check(%q) verdict = %v, want %v", tt.in, res.Verdict, tt.verdict)
+			}
+			if tt.verdict == VerdictMask && res.Content != tt.want {
+				t.Errorf("Check(%q) content = %q, want %q", tt.in, res.Content, tt.want)
+			}
+		})
+	}
+}
+
+func TestSpamFilter(t *testing.T) {
+	now := time.Date(2026, 6, 1, 12, 0, 0, 0, time.UTC)
+	f := NewSpamFilter(SpamConfig{MaxRepeats: 2, WindowSeconds: 10})
+	f.now = func() time.Time { return now }
+
+	check := func(user, content string) Verdict {
+		return f.Check(context.Background(), FilterInput{RoomID: "room", UserID: user, Content: content}).Verdict
+	}
+
+	if check("alice", "hello") != VerdictAllow || check("alice", "  HELLO ") != VerdictAllow {
+		t.Fatal("first messages rejected")
+	}
+	if check("alice", "hello") != VerdictReject {
+		t.Error("third repeat within the window allowed")
+	}
+	if check("bob", "hello") != VerdictAllow {
+		t.Error("another user's message counted against alice")
+	}
+	if check("alice", "something else") != VerdictAllow {
+		t.Error("different message rejected")
+	}
+
+	now = now.Add(11 * time.Second)
+	if check("alice", "hello") != VerdictAllow {
+		t.Error("repeat after the window rejected")
+	}
+
+	// Stale entries of other messages are swept.
+	if _, ok := f.recent["room\x00bob\x00hello"]; ok {
+		t.Error("entry older than the window kept after a sweep")
+	}
+}
+
+func TestChainRun(t *testing.T) {
+	words, _ := NewWordListFilter(WordListConfig{Words: []string{"bad"}})
+	links, _ := NewLinkFilter(LinkConfig{Deny: []string{"evil.com"}})
+	chain := Chain{words, links}
+
+	content, masked, err := chain.Run(context.Background(), FilterInput{Content: "bad day"})
+	if err != nil || !masked || content != "*** day" {
+		t.Errorf("Run = %q, %v, %v; want masked content", content, masked, err)
+	}
+
+	_, _, err = chain.Run(context.Background(), FilterInput{Content: "bad https://evil.com"})
+	if !errors.Is(err, ErrMessageRejected) {
+		t.Errorf("Run error = %v, want ErrMessageRejected", err)
+	}
+}
+
+// TestFiltersSeeUnescapedText checks that filters run on the text as typed,
+// before it is escaped.
+func TestFiltersSeeUnescapedText(t *testing.T) {
+	h := NewHandler(nil, nil)
+	err := h.ConfigureFilters(&ModerationConfig{Default: FilterConfig{
+		Words: &WordListConfig{Words: []string{"bad"}},
+		Links: &LinkConfig{Allow: []string{"example.com"}},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{in: `Meet at "https://example.com"`, want: "Meet at &#34;https://example.com&#34;"},
+		{in: `"bad"`, want: `&#34;***&#34;`},
+	}
+	for _, tt := range tests {
+		payload, _ := json.Marshal(ChatMessage{Content: tt.in})
+		msg, err := h.ProcessMessage(context.Background(), "alice", "room", payload)
+		if err != nil {
+			t.Errorf("ProcessMessage(%q): %v", tt.in, err)
+			continue
+		}
+		if msg.Content != tt.want {
+			t.Errorf("ProcessMessage(%q) content = %q, want %q", tt.in, msg.Content, tt.want)
+		}
+	}
+}
+
+func TestRoomFilters(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryFilterStore()
+	h := NewHandler(nil, nil)
+	h.SetFilterStore(store)
+	err := h.ConfigureFilters(&ModerationConfig{
+		Default: FilterConfig{Words: &WordListConfig{Words: []string{"bad"}}},
+		Rooms:   map[string]FilterConfig{"school": {Words: &WordListConfig{Words: []string{"bad", "silly"}}}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	content := func(roomID, text string) string {
+		t.Helper()
+		payload, _ := json.Marshal(ChatMessage{Content: text})
+		msg, err := h.ProcessMessage(ctx, "alice", roomID, payload)
+		if err != nil {
+			t.Fatalf("ProcessMessage(%s, %q): %v", roomID, text, err)
+		}
+		return msg.Content
+	}
+	action := func(roomID string, payload any) *FilterAction {
+		t.Helper()
+		data, _ := json.Marshal(payload)
+		a, err := h.ProcessFilterAction(ctx, "alice", roomID, data)
+		if err != nil {
+			t.Fatalf("ProcessFilterAction(%s): %v", roomID, err)
+		}
+		return a
+	}
+
+	if got := content("school", "silly bad"); got != "***** ***" {
+		t.Errorf("room from the file: content = %q", got)
+	}
+	if a := action("school", map[string]string{"action": FiltersGet}); a.Filters != nil {
+		t.Errorf("get before set = %+v, want nil", a.Filters)
+	}
+
+	// The room's own filters replace those from the file.
+	a := action("school", map[string]any{"action": FiltersSet, "filters": FilterConfig{Words: &WordListConfig{Words: []string{"rain"}}}})
+	if a.Filters == nil || a.Filters.Words == nil {
+		t.Fatalf("set answered with %+v", a.Filters)
+	}
+	if got := content("school", "silly rain"); got != "silly ****" {
+		t.Errorf("own filters: content = %q", got)
+	}
+	if got := content("other", "bad rain"); got != "*** rain" {
+		t.Errorf("other room: content = %q", got)
+	}
+
+	// Another instance changing the store takes effect after a reload.
+	_ = store.SetRoomFilters(ctx, "school", &FilterConfig{Words: &WordListConfig{Words: []string{"snow"}}})
+	if got := content("school", "rain"); got != "****" {
+		t.Errorf("before reload: content = %q, want the cached filters", got)
+	}
+	h.ReloadRoomFilters("school")
+	if got := content("school", "rain snow"); got != "rain ****" {
+		t.Errorf("after reload: content = %q", got)
+	}
+
+	action("school", map[string]string{"action": FiltersReset})
+	if got := content("school", "silly snow"); got != "***** snow" {
+		t.Errorf("after reset: content = %q", got)
+	}
+
+	for _, payload := range []string{
+		`{"action":"set"}`,
+		`{"action":"set","filters":{"words":{"action":"delete"}}}`,
+		`{"action":"drop"}`,
+		`[]`,
+	} {
+		if _, err := h.ProcessFilterAction(ctx, "alice", "school", json.RawMessage(payload)); !errors.Is(err, ErrInvalidFilterAction) {
+			t.Errorf("ProcessFilterAction(%s) error = %v, want ErrInvalidFilterAction", payload, err)
+		}
+	}
+}
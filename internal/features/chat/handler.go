@@ -1,9 +1,15 @@
 package chat
 
 import (
+	"context"
 	"encoding/json"
+	"fmt"
+	"html"
 	"log"
 	"time"
+
+	"github.com/google/uuid"
+	"github.com/rally-go/rally-realtime/internal/rooms"
 )
 
 // ChatMessage represents a chat message payload.
@@ -12,45 +18,86 @@ type ChatMessage struct {
 	UserID    string    `json:"user_id"`
 	Username  string    `json:"username"`
 	Content   string    `json:"content"`
+	Mentions  *Mentions `json:"mentions,omitempty"`
+	Moderated bool      `json:"moderated,omitempty"`  // content was masked by a filter
+	ToUserID  string    `json:"to_user_id,omitempty"` // recipient of a direct message
 	Timestamp time.Time `json:"timestamp"`
 }
 
+// MessageStore persists chat messages.
+type MessageStore interface {
+	SaveChatMessage(ctx context.Context, roomID string, message any) error
+}
+
 // Handler handles chat-related operations.
 type Handler struct {
-	// TODO: Add MongoDB client for persistence
+	members rooms.Store
+	store   MessageStore
+	filters filterSet
 }
 
-// NewHandler creates a new chat handler.
-func NewHandler() *Handler {
-	return &Handler{}
+// NewHandler creates a new chat handler. members is used to validate
+// mentions; store may be nil to disable persistence. Filters moderators set
+// for their rooms are kept in memory; see SetFilterStore.
+func NewHandler(members rooms.Store, store MessageStore) *Handler {
+	return &Handler{
+		members: members,
+		store:   store,
+		filters: filterSet{store: NewMemoryFilterStore()},
+	}
 }
 
 // ProcessMessage processes an incoming chat message.
-func (h *Handler) ProcessMessage(userID string, payload json.RawMessage) (*ChatMessage, error) {
+func (h *Handler) ProcessMessage(ctx context.Context, userID, roomID string, payload json.RawMessage) (*ChatMessage, error) {
 	var msg ChatMessage
 	if err := json.Unmarshal(payload, &msg); err != nil {
-		return nil, err
+		return nil, fmt.Errorf("%w: %v", ErrInvalidPayload, err)
 	}
 
-	// Set metadata
+	// Set metadata; clients cannot choose IDs, which name the message
+	// in mentions, notifications and storage.
+	msg.ID = uuid.New().String()
 	msg.UserID = userID
+	msg.ToUserID = ""
 	msg.Timestamp = time.Now()
 
-	// Sanitize content
-	msg.Content = sanitizeText(msg.Content)
+	// Sanitize content. Filters and mentions work on the text as typed; it
+	// is escaped once they are done.
+	content, err := cleanText(msg.Content)
+	if err != nil {
+		return nil, err
+	}
+	msg.Content = content
+
+	// Run moderation filters configured for the room
+	chain, err := h.filters.chainFor(ctx, roomID)
+	if err != nil {
+		return nil, err
+	}
+	if len(chain) > 0 {
+		content, masked, err := chain.Run(ctx, FilterInput{RoomID: roomID, UserID: userID, Content: msg.Content})
+		if err != nil {
+			return nil, err
+		}
+		msg.Content = content
+		msg.Moderated = masked
+	}
+
+	// Clients cannot assert mentions; they are always derived from content.
+	mentions, err := h.parseMentions(ctx, roomID, userID, msg.Content)
+	if err != nil {
+		return nil, err
+	}
+	msg.Mentions = mentions
+	msg.Content = html.EscapeString(msg.Content)
 
-	// TODO: Save to MongoDB
+	if h.store != nil {
+		if err := h.store.SaveChatMessage(ctx, roomID, &msg); err != nil {
+			return nil, err
+		}
+	}
 
 	log.Printf("Chat message processed: user=%s content=%s", userID, msg.Content)
 
 	return &msg, nil
 }
-
-// sanitizeText performs basic text sanitization.
-func sanitizeText(text string) string {
-	// TODO: Implement proper sanitization (XSS prevention, etc.)
-	if len(text) > 2000 {
-		text = text[:2000]
-	}
-	return text
-}
@@ -0,0 +1,121 @@
+package chat
+
+import (
+	"context"
+	"regexp"
+	"time"
+)
+
+// Mention kinds. MentionAll and MentionHere double as the "@all" and "@here"
+// tokens.
+const (
+	// MentionUser notifies a single member by user ID.
+	MentionUser = "user"
+
+	// MentionAll notifies every member of the room, online or not.
+	MentionAll = "all"
+
+	// MentionHere notifies everyone currently connected to the room.
+	MentionHere = "here"
+)
+
+// mentionPattern matches "@token" where token is a Firebase UID or one of the
+// special tokens. The mention must start the text or follow whitespace so that
+// e-mail addresses are not treated as mentions.
+var mentionPattern = regexp.MustCompile(`(?:^|\s)@([A-Za-z0-9_-]{1,128})`)
+
+// Mentions lists who a chat message mentions.
+type Mentions struct {
+	UserIDs []string `json:"user_ids,omitempty"`
+	All     bool     `json:"all,omitempty"`
+	Here    bool     `json:"here,omitempty"`
+}
+
+// IsEmpty reports whether the message mentions nobody.
+func (m *Mentions) IsEmpty() bool {
+	return m == nil || (len(m.UserIDs) == 0 && !m.All && !m.Here)
+}
+
+// MentionEvent is delivered to each mentioned user's connections.
+type MentionEvent struct {
+	MessageID  string    `json:"message_id"`
+	RoomID     string    `json:"room_id"`
+	FromUserID string    `json:"from_user_id"`
+	Kind       string    `json:"kind"` // "user", "all" or "here"
+	Content    string    `json:"content"`
+	Timestamp  time.Time `json:"timestamp"`
+}
+
+// parseMentions extracts mention tokens from content and validates user
+// mentions against room membership. Unknown users and self-mentions are
+// dropped; each user appears at most once.
+func (h *Handler) parseMentions(ctx context.Context, roomID, senderID, content string) (*Mentions, error) {
+	matches := mentionPattern.FindAllStringSubmatch(content, -1)
+	if len(matches) == 0 {
+		return nil, nil
+	}
+
+	m := &Mentions{}
+	seen := make(map[string]bool)
+	for _, match := range matches {
+		token := match[1]
+		switch token {
+		case MentionAll:
+			m.All = true
+			continue
+		case MentionHere:
+			m.Here = true
+			continue
+		}
+
+		if token == senderID || seen[token] {
+			continue
+		}
+		seen[token] = true
+
+		if h.members == nil {
+			continue
+		}
+		ok, err := h.members.IsMember(ctx, roomID, token)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			m.UserIDs = append(m.UserIDs, token)
+		}
+	}
+
+	if m.IsEmpty() {
+		return nil, nil
+	}
+	return m, nil
+}
+
+// MentionTargets resolves the users that should receive a direct mention
+// event for msg. "@all" expands to every room member; "@here" is not expanded
+// because it is delivered to the room itself. The sender is never a target.
+func (h *Handler) MentionTargets(ctx context.Context, roomID string, msg *ChatMessage) ([]string, error) {
+	if msg.Mentions.IsEmpty() {
+		return nil, nil
+	}
+
+	ids := msg.Mentions.UserIDs
+	if msg.Mentions.All && h.members != nil {
+		members, err := h.members.Members(ctx, roomID)
+		if err != nil {
+			return nil, err
+		}
+		ids = append(append([]string{}, ids...), members...)
+	}
+
+	seen := make(map[string]bool, len(ids))
+	targets := make([]string, 0, len(ids))
+	for _, id := range ids {
+		if id == msg.UserID || seen[id] {
+			continue
+		}
+		seen[id] = true
+		targets = append(targets, id)
+	}
+	return targets, nil
+}
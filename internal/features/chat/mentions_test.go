@@ -0,0 +1,109 @@
+package chat
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/rally-go/rally-realtime/internal/rooms"
+)
+
+// savedMessages is a MessageStore that keeps what it is given.
+type savedMessages []*ChatMessage
+
+func (s *savedMessages) SaveChatMessage(ctx context.Context, roomID string, message any) error {
+	*s = append(*s, message.(*ChatMessage))
+	return nil
+}
+
+// newMentionHandler returns a handler for room "trip" with members alice,
+// bob and carol.
+func newMentionHandler(t *testing.T, store MessageStore) *Handler {
+	t.Helper()
+
+	members := rooms.NewMemoryStore()
+	for _, user := range []string{"alice", "bob", "carol"} {
+		if err := members.AddMember(context.Background(), "trip", user); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return NewHandler(members, store)
+}
+
+func TestParseMentions(t *testing.T) {
+	h := newMentionHandler(t, nil)
+
+	tests := []struct {
+		content string
+		want    *Mentions
+	}{
+		{content: "no mentions here"},
+		{content: "@bob are you in?", want: &Mentions{UserIDs: []string{"bob"}}},
+		{content: "@bob and @carol, and @bob again", want: &Mentions{UserIDs: []string{"bob", "carol"}}},
+		{content: "@alice talking to myself"},
+		{content: "@dave is not in the room"},
+		{content: "mail bob@example.com"},
+		{content: "@all dinner at 7", want: &Mentions{All: true}},
+		{content: "@here who is nearby? @carol", want: &Mentions{UserIDs: []string{"carol"}, Here: true}},
+		{content: "line\n@bob", want: &Mentions{UserIDs: []string{"bob"}}},
+	}
+	for _, tt := range tests {
+		got, err := h.parseMentions(context.Background(), "trip", "alice", tt.content)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("parseMentions(%q) = %+v, want %+v", tt.content, got, tt.want)
+		}
+	}
+}
+
+func TestMentionTargets(t *testing.T) {
+	h := newMentionHandler(t, nil)
+
+	tests := []struct {
+		name     string
+		mentions *Mentions
+		want     []string
+	}{
+		{name: "none"},
+		{name: "users", mentions: &Mentions{UserIDs: []string{"bob"}}, want: []string{"bob"}},
+		{name: "all", mentions: &Mentions{All: true}, want: []string{"bob", "carol"}},
+		{name: "all and a user", mentions: &Mentions{UserIDs: []string{"bob"}, All: true}, want: []string{"bob", "carol"}},
+		{name: "here is delivered to the room", mentions: &Mentions{Here: true}, want: []string{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := h.MentionTargets(context.Background(), "trip", &ChatMessage{UserID: "alice", Mentions: tt.mentions})
+			if err != nil {
+				t.Fatal(err)
+			}
+			sort.Strings(got)
+			if len(got) != len(tt.want) || (len(got) > 0 && !reflect.DeepEqual(got, tt.want)) {
+				t.Errorf("MentionTargets = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMentionsStored(t *testing.T) {
+	var saved savedMessages
+	h := newMentionHandler(t, &saved)
+
+	payload, _ := json.Marshal(map[string]string{"id": "chosen-by-client", "content": "@bob @all"})
+	msg, err := h.ProcessMessage(context.Background(), "alice", "trip", payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg.ID == "chosen-by-client" || msg.ID == "" {
+		t.Errorf("message ID = %q, want one generated by the server", msg.ID)
+	}
+	if len(saved) != 1 || saved[0].ID != msg.ID {
+		t.Fatalf("stored %d messages, want the processed one", len(saved))
+	}
+	if want := (&Mentions{UserIDs: []string{"bob"}, All: true}); !reflect.DeepEqual(saved[0].Mentions, want) {
+		t.Errorf("stored mentions = %+v, want %+v", saved[0].Mentions, want)
+	}
+}
@@ -0,0 +1,185 @@
+package chat
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Room filter action names. Every action is answered with the filters the
+// room's moderators set, nil if they set none.
+const (
+	FiltersGet   = "get"
+	FiltersSet   = "set"
+	FiltersReset = "reset" // go back to the filters from the configuration file
+)
+
+// ErrInvalidFilterAction is returned for malformed or unknown filter
+// actions.
+var ErrInvalidFilterAction = errors.New("invalid filter action")
+
+// FilterAction represents a room filter settings payload.
+type FilterAction struct {
+	Action    string        `json:"action"` // see Filters* constants
+	Filters   *FilterConfig `json:"filters"`
+	Timestamp time.Time     `json:"timestamp"`
+}
+
+// FilterStore persists the filters moderators set for their rooms. They
+// replace the room's filters from the configuration file.
+type FilterStore interface {
+	// RoomFilters returns nil if the room has no filters of its own.
+	RoomFilters(ctx context.Context, roomID string) (*FilterConfig, error)
+	// SetRoomFilters stores cfg for roomID, or removes the room's filters
+	// if cfg is nil.
+	SetRoomFilters(ctx context.Context, roomID string, cfg *FilterConfig) error
+}
+
+// ProcessFilterAction applies a filter settings action from userID in
+// roomID. The router lets only moderators through. A set or reset takes
+// effect on this instance at once; other instances must be told to
+// ReloadRoomFilters.
+func (h *Handler) ProcessFilterAction(ctx context.Context, userID, roomID string, payload json.RawMessage) (*FilterAction, error) {
+	var action FilterAction
+	if err := json.Unmarshal(payload, &action); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidFilterAction, err)
+	}
+	action.Timestamp = time.Now()
+
+	switch action.Action {
+	case FiltersSet:
+		if action.Filters == nil {
+			return nil, fmt.Errorf("%w: filters are required", ErrInvalidFilterAction)
+		}
+		if _, err := action.Filters.Build(); err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidFilterAction, err)
+		}
+		if err := h.filters.store.SetRoomFilters(ctx, roomID, action.Filters); err != nil {
+			return nil, err
+		}
+		h.ReloadRoomFilters(roomID)
+		log.Printf("Chat filters set: room=%s by=%s", roomID, userID)
+	case FiltersReset:
+		if err := h.filters.store.SetRoomFilters(ctx, roomID, nil); err != nil {
+			return nil, err
+		}
+		h.ReloadRoomFilters(roomID)
+		log.Printf("Chat filters reset: room=%s by=%s", roomID, userID)
+	case FiltersGet:
+	default:
+		return nil, fmt.Errorf("%w: unknown action %q", ErrInvalidFilterAction, action.Action)
+	}
+
+	cfg, err := h.filters.store.RoomFilters(ctx, roomID)
+	if err != nil {
+		return nil, err
+	}
+	action.Filters = cfg
+	return &action, nil
+}
+
+// ReloadRoomFilters drops the room's cached filters so that they are read
+// from the FilterStore again with its next message.
+func (h *Handler) ReloadRoomFilters(roomID string) {
+	h.filters.mu.Lock()
+	defer h.filters.mu.Unlock()
+
+	delete(h.filters.loaded, roomID)
+	h.filters.epoch++
+}
+
+// SetFilterStore replaces the in-memory FilterStore a Handler starts with,
+// e.g. with a RedisFilterStore shared by all server instances.
+func (h *Handler) SetFilterStore(store FilterStore) {
+	h.filters.mu.Lock()
+	defer h.filters.mu.Unlock()
+
+	h.filters.store = store
+	h.filters.loaded = nil
+	h.filters.epoch++
+}
+
+// MemoryFilterStore implements FilterStore in process memory.
+// It is only suitable for a single server instance or local development.
+type MemoryFilterStore struct {
+	rooms map[string]*FilterConfig
+	mu    sync.RWMutex
+}
+
+// NewMemoryFilterStore creates an empty in-memory filter store.
+func NewMemoryFilterStore() *MemoryFilterStore {
+	return &MemoryFilterStore{rooms: make(map[string]*FilterConfig)}
+}
+
+// RoomFilters returns the filters set for roomID.
+func (s *MemoryFilterStore) RoomFilters(ctx context.Context, roomID string) (*FilterConfig, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.rooms[roomID], nil
+}
+
+// SetRoomFilters stores or removes the filters of roomID.
+func (s *MemoryFilterStore) SetRoomFilters(ctx context.Context, roomID string, cfg *FilterConfig) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if cfg == nil {
+		delete(s.rooms, roomID)
+	} else {
+		s.rooms[roomID] = cfg
+	}
+	return nil
+}
+
+// RedisFilterStore implements FilterStore using Redis, with the filters of
+// each room as JSON under one key.
+type RedisFilterStore struct {
+	client *redis.Client
+}
+
+// NewRedisFilterStore creates a filter store backed by the given Redis
+// client.
+func NewRedisFilterStore(client *redis.Client) *RedisFilterStore {
+	return &RedisFilterStore{client: client}
+}
+
+func filtersKey(roomID string) string {
+	return "rally:room:" + roomID + ":chat_filters"
+}
+
+// RoomFilters returns the filters set for roomID.
+func (s *RedisFilterStore) RoomFilters(ctx context.Context, roomID string) (*FilterConfig, error) {
+	data, err := s.client.Get(ctx, filtersKey(roomID)).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var cfg FilterConfig
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return nil, fmt.Errorf("decode filters of room %s: %w", roomID, err)
+	}
+	return &cfg, nil
+}
+
+// SetRoomFilters stores or removes the filters of roomID.
+func (s *RedisFilterStore) SetRoomFilters(ctx context.Context, roomID string, cfg *FilterConfig) error {
+	if cfg == nil {
+		return s.client.Del(ctx, filtersKey(roomID)).Err()
+	}
+
+	data, err := json.Marshal(cfg)
+	if err != nil {
+		return err
+	}
+	return s.client.Set(ctx, filtersKey(roomID), data, 0).Err()
+}
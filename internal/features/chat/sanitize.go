@@ -0,0 +1,74 @@
+package chat
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// MaxContentRunes is the maximum length of a chat message in characters
+// (Unicode code points after NFC normalization), not bytes.
+const MaxContentRunes = 2000
+
+var (
+	// ErrInvalidPayload is returned when a chat payload cannot be decoded.
+	ErrInvalidPayload = errors.New("invalid chat payload")
+
+	// ErrEmptyContent is returned when a message has no visible content.
+	ErrEmptyContent = errors.New("message content is empty")
+
+	// ErrContentTooLong is returned when a message exceeds MaxContentRunes.
+	ErrContentTooLong = fmt.Errorf("message content exceeds %d characters", MaxContentRunes)
+)
+
+// cleanText normalizes user-supplied chat content without escaping it:
+//
+//  1. invalid UTF-8 sequences are replaced with U+FFFD;
+//  2. the text is normalized to NFC so that precomposed and combining forms
+//     (common in Vietnamese) compare and count the same;
+//  3. control characters other than newline and tab, and bidi embedding,
+//     override and isolate characters, are removed;
+//  4. surrounding whitespace is trimmed and empty content is rejected;
+//  5. content longer than MaxContentRunes is rejected rather than truncated.
+//
+// The length limit is checked on the text as the user typed it, and
+// moderation filters see that text too; escaping comes last.
+func cleanText(text string) (string, error) {
+	text = strings.ToValidUTF8(text, string(utf8.RuneError))
+	text = norm.NFC.String(text)
+	text = strings.ReplaceAll(text, "\r\n", "\n")
+	text = strings.Map(dropUnsafeRune, text)
+	text = strings.TrimSpace(text)
+
+	if text == "" {
+		return "", ErrEmptyContent
+	}
+	if n := utf8.RuneCountInString(text); n > MaxContentRunes {
+		return "", fmt.Errorf("%w (got %d)", ErrContentTooLong, n)
+	}
+	return text, nil
+}
+
+// dropUnsafeRune is a strings.Map callback that removes r by returning -1 if
+// it is a control or bidi formatting character.
+func dropUnsafeRune(r rune) rune {
+	switch r {
+	case '\n', '\t':
+		return r
+	}
+	if unicode.IsControl(r) || isBidiControl(r) {
+		return -1
+	}
+	return r
+}
+
+// isBidiControl reports whether r is a bidi embedding, override or isolate
+// character, which can be used to visually reorder text (e.g. to disguise
+// links). The implicit marks LRM, RLM and ALM are kept.
+func isBidiControl(r rune) bool {
+	return (r >= '\u202a' && r <= '\u202e') || (r >= '\u2066' && r <= '\u2069')
+}
@@ -0,0 +1,63 @@
+package chat
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+)
+
+// TestSanitizeText checks the content of messages that pass through
+// ProcessMessage without filters.
+func TestSanitizeText(t *testing.T) {
+	h := NewHandler(nil, nil)
+
+	tests := []struct {
+		name    string
+		in      string
+		want    string
+		wantErr error
+	}{
+		{name: "plain ascii", in: "Hello world!", want: "Hello world!"},
+		{name: "vietnamese precomposed", in: "Xin chào các bạn", want: "Xin chào các bạn"},
+		{name: "vietnamese combining normalized to nfc", in: "Vie\u0302\u0323t Nam", want: "Việt Nam"},
+		{name: "thai", in: "สวัสดีครับ", want: "สวัสดีครับ"},
+		{name: "emoji with zwj kept", in: "👨\u200d👩\u200d👧 trip!", want: "👨\u200d👩\u200d👧 trip!"},
+		{name: "surrounding whitespace trimmed", in: "  hi \n", want: "hi"},
+		{name: "newline and tab kept", in: "a\n\tb", want: "a\n\tb"},
+		{name: "crlf normalized", in: "a\r\nb", want: "a\nb"},
+		{name: "control characters stripped", in: "a\x00b\x07c\x1bd\u0085e", want: "abcde"},
+		{name: "bidi override stripped", in: "evil\u202egnp.exe", want: "evilgnp.exe"},
+		{name: "bidi isolates stripped", in: "\u2066a\u2067b\u2068c\u2069", want: "abc"},
+		{name: "rlm kept", in: "a\u200fb", want: "a\u200fb"},
+		{name: "invalid utf8 replaced", in: "a\xffb", want: "a�b"},
+		{name: "html escaped", in: `<script>alert("x")</script>`, want: "&lt;script&gt;alert(&#34;x&#34;)&lt;/script&gt;"},
+		{name: "ampersand escaped", in: "fish & chips <3", want: "fish &amp; chips &lt;3"},
+		{name: "empty", in: "", wantErr: ErrEmptyContent},
+		{name: "only whitespace and controls", in: " \x00\u202e\t ", wantErr: ErrEmptyContent},
+		{name: "exactly max runes multibyte", in: strings.Repeat("ệ", MaxContentRunes), want: strings.Repeat("ệ", MaxContentRunes)},
+		{name: "max runes counted after nfc", in: strings.Repeat("e\u0302\u0323", MaxContentRunes), want: strings.Repeat("ệ", MaxContentRunes)},
+		{name: "too long", in: strings.Repeat("ก", MaxContentRunes+1), wantErr: ErrContentTooLong},
+		{name: "length checked before escaping", in: strings.Repeat("<", MaxContentRunes), want: strings.Repeat("&lt;", MaxContentRunes)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			payload, _ := json.Marshal(ChatMessage{Content: tt.in})
+			msg, err := h.ProcessMessage(context.Background(), "alice", "room", payload)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("content %q: error = %v, want %v", tt.in, err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("content %q: unexpected error: %v", tt.in, err)
+			}
+			if msg.Content != tt.want {
+				t.Errorf("content %q sanitized to %q, want %q", tt.in, msg.Content, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,116 @@
+// Package events accepts events that backend services publish into rooms,
+// such as a trip being updated or a member being added.
+package events
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Limits on published events.
+const (
+	// MaxKeyLength is the maximum length of an idempotency key.
+	MaxKeyLength = 255
+
+	// KeyTTL is how long an idempotency key is remembered. Retries after
+	// that publish the event again.
+	KeyTTL = 24 * time.Hour
+)
+
+var (
+	ErrInvalidEvent = errors.New("invalid event")
+	// ErrKeyReused is returned when an idempotency key is sent again with a
+	// different body.
+	ErrKeyReused = errors.New("idempotency key reused with a different request")
+)
+
+// typePattern matches event types: lowercase dotted names such as
+// "trip.updated" or "member.added".
+var typePattern = regexp.MustCompile(`^[a-z][a-z0-9_]*(\.[a-z][a-z0-9_]*)+$`)
+
+// Event is an event published by a backend service, as delivered to the
+// room's clients.
+type Event struct {
+	ID        string          `json:"id"`
+	Type      string          `json:"type"` // e.g. "trip.updated"
+	RoomID    string          `json:"room_id"`
+	Data      json.RawMessage `json:"data,omitempty"`
+	Timestamp time.Time       `json:"timestamp"`
+}
+
+// request is the body of a publish request.
+type request struct {
+	Type string          `json:"type"`
+	Data json.RawMessage `json:"data,omitempty"`
+}
+
+// Handler validates published events and deduplicates them by idempotency
+// key.
+type Handler struct {
+	store Store
+}
+
+// NewHandler creates a new event handler.
+func NewHandler(store Store) *Handler {
+	return &Handler{store: store}
+}
+
+// Publish validates the request body and returns the event to deliver to
+// roomID. If key was already used with the same body, it returns the event
+// published then and replayed is true; the caller must not deliver it
+// again. An empty key disables deduplication. A caller that fails to
+// deliver a new event must Release its key.
+func (h *Handler) Publish(ctx context.Context, roomID, key string, body []byte) (event *Event, replayed bool, err error) {
+	var req request
+	if err := json.Unmarshal(body, &req); err != nil {
+		return nil, false, fmt.Errorf("%w: %v", ErrInvalidEvent, err)
+	}
+	if !typePattern.MatchString(req.Type) {
+		return nil, false, fmt.Errorf("%w: type must be a dotted lowercase name such as trip.updated", ErrInvalidEvent)
+	}
+	if len(key) > MaxKeyLength {
+		return nil, false, fmt.Errorf("%w: idempotency key longer than %d bytes", ErrInvalidEvent, MaxKeyLength)
+	}
+
+	event = &Event{
+		ID:        uuid.New().String(),
+		Type:      req.Type,
+		RoomID:    roomID,
+		Data:      req.Data,
+		Timestamp: time.Now(),
+	}
+	if key == "" {
+		return event, false, nil
+	}
+
+	sum := sha256.Sum256(body)
+	hash := hex.EncodeToString(sum[:])
+	prev, err := h.store.Claim(ctx, roomID, key, &Record{BodyHash: hash, Event: event}, KeyTTL)
+	if err != nil {
+		return nil, false, err
+	}
+	if prev == nil {
+		return event, false, nil
+	}
+	if prev.BodyHash != hash {
+		return nil, false, ErrKeyReused
+	}
+	return prev.Event, true, nil
+}
+
+// Release forgets key after the event published under it could not be
+// delivered, so that a retry publishes it again. An empty key is ignored.
+func (h *Handler) Release(ctx context.Context, roomID, key string) error {
+	if key == "" {
+		return nil
+	}
+	return h.store.Release(ctx, roomID, key)
+}
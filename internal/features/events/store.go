@@ -0,0 +1,129 @@
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Record is what an idempotency key remembers: the event published under
+// it and a hash of the request body that produced it.
+type Record struct {
+	BodyHash string `json:"body_hash"`
+	Event    *Event `json:"event"`
+}
+
+// Store remembers idempotency keys. Claim must be atomic across server
+// instances so that retries racing each other publish an event only once.
+type Store interface {
+	// Claim stores rec under key unless the key is already taken, in which
+	// case it returns the record stored first. A nil record means rec was
+	// stored. Keys are forgotten after ttl.
+	Claim(ctx context.Context, roomID, key string, rec *Record, ttl time.Duration) (*Record, error)
+
+	// Release forgets key, if it is stored.
+	Release(ctx context.Context, roomID, key string) error
+}
+
+// MemoryStore implements Store in process memory.
+// It is only suitable for a single server instance or local development.
+type MemoryStore struct {
+	keys map[string]memoryRecord // "room\x00key"
+	mu   sync.Mutex
+}
+
+type memoryRecord struct {
+	rec     *Record
+	expires time.Time
+}
+
+// NewMemoryStore creates an empty in-memory idempotency store.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{keys: make(map[string]memoryRecord)}
+}
+
+// Claim stores rec under key unless it is taken.
+func (s *MemoryStore) Claim(ctx context.Context, roomID, key string, rec *Record, ttl time.Duration) (*Record, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	for k, r := range s.keys {
+		if now.After(r.expires) {
+			delete(s.keys, k)
+		}
+	}
+
+	k := roomID + "\x00" + key
+	if r, ok := s.keys[k]; ok {
+		return r.rec, nil
+	}
+	s.keys[k] = memoryRecord{rec: rec, expires: now.Add(ttl)}
+	return nil, nil
+}
+
+// Release forgets key.
+func (s *MemoryStore) Release(ctx context.Context, roomID, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.keys, roomID+"\x00"+key)
+	return nil
+}
+
+// RedisStore implements Store using Redis, with one expiring key per
+// idempotency key.
+type RedisStore struct {
+	client *redis.Client
+}
+
+// NewRedisStore creates an idempotency store backed by the given Redis
+// client.
+func NewRedisStore(client *redis.Client) *RedisStore {
+	return &RedisStore{client: client}
+}
+
+func idempotencyKey(roomID, key string) string {
+	return "rally:room:" + roomID + ":idem:" + key
+}
+
+// Claim stores rec under key unless it is taken.
+func (s *RedisStore) Claim(ctx context.Context, roomID, key string, rec *Record, ttl time.Duration) (*Record, error) {
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return nil, err
+	}
+
+	k := idempotencyKey(roomID, key)
+	for {
+		ok, err := s.client.SetNX(ctx, k, data, ttl).Result()
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			return nil, nil
+		}
+
+		stored, err := s.client.Get(ctx, k).Bytes()
+		if errors.Is(err, redis.Nil) {
+			continue // expired in between; try again
+		}
+		if err != nil {
+			return nil, err
+		}
+		var prev Record
+		if err := json.Unmarshal(stored, &prev); err != nil {
+			return nil, err
+		}
+		return &prev, nil
+	}
+}
+
+// Release forgets key.
+func (s *RedisStore) Release(ctx context.Context, roomID, key string) error {
+	return s.client.Del(ctx, idempotencyKey(roomID, key)).Err()
+}
@@ -0,0 +1,174 @@
+package moderation
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/rally-go/rally-realtime/internal/rooms"
+)
+
+// Moderation action names.
+const (
+	ActionMute            = "mute"
+	ActionUnmute          = "unmute"
+	ActionKick            = "kick"
+	ActionBan             = "ban"
+	ActionUnban           = "unban"
+	ActionGrantModerator  = "grant_moderator"
+	ActionRevokeModerator = "revoke_moderator"
+)
+
+// maxMuteDuration caps how long a single mute can last.
+const maxMuteDuration = 30 * 24 * time.Hour
+
+var (
+	// ErrInvalidAction is returned for malformed or unknown actions.
+	ErrInvalidAction = errors.New("invalid moderation action")
+
+	// ErrForbidden is returned when the actor may not perform the action.
+	ErrForbidden = errors.New("not allowed to moderate this user")
+)
+
+// ModerationAction represents a moderation action payload.
+type ModerationAction struct {
+	Action          string     `json:"action"`
+	UserID          string     `json:"user_id"` // target of the action
+	DurationSeconds int        `json:"duration_seconds,omitempty"`
+	Reason          string     `json:"reason,omitempty"`
+	ActorID         string     `json:"actor_id"`
+	ExpiresAt       *time.Time `json:"expires_at,omitempty"`
+	Timestamp       time.Time  `json:"timestamp"`
+}
+
+// Handler handles moderation actions.
+type Handler struct {
+	members rooms.Store
+	store   Store
+}
+
+// NewHandler creates a new moderation handler.
+func NewHandler(members rooms.Store, store Store) *Handler {
+	return &Handler{
+		members: members,
+		store:   store,
+	}
+}
+
+// ProcessAction validates and applies a moderation action sent by actorID.
+// The caller is responsible for enforcing kicks and bans on live connections.
+func (h *Handler) ProcessAction(ctx context.Context, actorID, roomID string, payload json.RawMessage) (*ModerationAction, error) {
+	var action ModerationAction
+	if err := json.Unmarshal(payload, &action); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidAction, err)
+	}
+	if action.UserID == "" {
+		return nil, fmt.Errorf("%w: user_id is required", ErrInvalidAction)
+	}
+
+	action.ActorID = actorID
+	action.Timestamp = time.Now()
+
+	if err := h.authorize(ctx, roomID, &action); err != nil {
+		return nil, err
+	}
+
+	var err error
+	switch action.Action {
+	case ActionMute:
+		d := time.Duration(action.DurationSeconds) * time.Second
+		if d <= 0 || d > maxMuteDuration {
+			return nil, fmt.Errorf("%w: duration_seconds must be between 1 and %d", ErrInvalidAction, int(maxMuteDuration.Seconds()))
+		}
+		until := action.Timestamp.Add(d)
+		action.ExpiresAt = &until
+		err = h.store.Mute(ctx, roomID, action.UserID, until)
+	case ActionUnmute:
+		err = h.store.Unmute(ctx, roomID, action.UserID)
+	case ActionKick:
+		// Kicks only affect live connections; nothing to persist.
+	case ActionBan:
+		// The ban ends the membership too, so that the user drops out of
+		// mentions, pushes and role checks.
+		if err = h.store.Ban(ctx, roomID, action.UserID); err == nil {
+			err = h.members.RemoveMember(ctx, roomID, action.UserID)
+		}
+	case ActionUnban:
+		err = h.store.Unban(ctx, roomID, action.UserID)
+	case ActionGrantModerator:
+		err = h.members.SetRole(ctx, roomID, action.UserID, rooms.RoleModerator)
+	case ActionRevokeModerator:
+		err = h.members.SetRole(ctx, roomID, action.UserID, rooms.RoleMember)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	log.Printf("Moderation: %s user=%s room=%s by=%s", action.Action, action.UserID, roomID, actorID)
+
+	return &action, nil
+}
+
+// authorize checks that the actor may apply action to its target. Moderators
+// can act on members; only the owner can act on moderators and appoint them.
+// Nobody can act on the owner or on themselves.
+func (h *Handler) authorize(ctx context.Context, roomID string, action *ModerationAction) error {
+	switch action.Action {
+	case ActionMute, ActionUnmute, ActionKick, ActionBan, ActionUnban,
+		ActionGrantModerator, ActionRevokeModerator:
+	default:
+		return fmt.Errorf("%w: unknown action %q", ErrInvalidAction, action.Action)
+	}
+
+	if rooms.IsDirectRoom(roomID) {
+		return fmt.Errorf("%w: direct conversations cannot be moderated", ErrForbidden)
+	}
+
+	if action.UserID == action.ActorID {
+		return fmt.Errorf("%w: cannot moderate yourself", ErrForbidden)
+	}
+
+	actorRole, err := h.members.Role(ctx, roomID, action.ActorID)
+	if err != nil {
+		return err
+	}
+	if !actorRole.CanModerate() {
+		return ErrForbidden
+	}
+
+	targetRole, err := h.members.Role(ctx, roomID, action.UserID)
+	if err != nil {
+		return err
+	}
+
+	// Banned users are no longer members, but unban must still be
+	// possible, so a missing membership is only an error for
+	// actions on current members.
+	if targetRole == rooms.RoleNone && action.Action != ActionUnban && action.Action != ActionBan {
+		return fmt.Errorf("%w: user %s is not a member of this room", ErrInvalidAction, action.UserID)
+	}
+
+	switch {
+	case targetRole == rooms.RoleOwner:
+		return fmt.Errorf("%w: the room owner cannot be moderated", ErrForbidden)
+	case targetRole == rooms.RoleModerator && actorRole != rooms.RoleOwner:
+		return fmt.Errorf("%w: only the owner can moderate moderators", ErrForbidden)
+	case (action.Action == ActionGrantModerator || action.Action == ActionRevokeModerator) && actorRole != rooms.RoleOwner:
+		return fmt.Errorf("%w: only the owner can appoint moderators", ErrForbidden)
+	}
+	return nil
+}
+
+// MutedUntil returns when userID's mute in roomID ends, or the zero time if
+// they are not muted.
+func (h *Handler) MutedUntil(ctx context.Context, roomID, userID string) (time.Time, error) {
+	return h.store.MutedUntil(ctx, roomID, userID)
+}
+
+// IsBanned reports whether userID is banned from roomID.
+func (h *Handler) IsBanned(ctx context.Context, roomID, userID string) (bool, error) {
+	return h.store.IsBanned(ctx, roomID, userID)
+}
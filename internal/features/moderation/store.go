@@ -0,0 +1,163 @@
+package moderation
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Store persists mutes and bans.
+type Store interface {
+	Mute(ctx context.Context, roomID, userID string, until time.Time) error
+	Unmute(ctx context.Context, roomID, userID string) error
+	// MutedUntil returns the zero time if the user is not muted.
+	MutedUntil(ctx context.Context, roomID, userID string) (time.Time, error)
+
+	Ban(ctx context.Context, roomID, userID string) error
+	Unban(ctx context.Context, roomID, userID string) error
+	IsBanned(ctx context.Context, roomID, userID string) (bool, error)
+}
+
+// MemoryStore implements Store in process memory.
+// It is only suitable for a single server instance or local development.
+type MemoryStore struct {
+	mutes map[string]time.Time // "room\x00user" -> until
+	bans  map[string]bool      // "room\x00user"
+	mu    sync.RWMutex
+}
+
+// NewMemoryStore creates an empty in-memory moderation store.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		mutes: make(map[string]time.Time),
+		bans:  make(map[string]bool),
+	}
+}
+
+func memoryKey(roomID, userID string) string {
+	return roomID + "\x00" + userID
+}
+
+// Mute mutes userID in roomID until the given time.
+func (s *MemoryStore) Mute(ctx context.Context, roomID, userID string, until time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.mutes[memoryKey(roomID, userID)] = until
+	return nil
+}
+
+// Unmute lifts a mute.
+func (s *MemoryStore) Unmute(ctx context.Context, roomID, userID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.mutes, memoryKey(roomID, userID))
+	return nil
+}
+
+// MutedUntil returns when the mute ends.
+func (s *MemoryStore) MutedUntil(ctx context.Context, roomID, userID string) (time.Time, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := memoryKey(roomID, userID)
+	until, ok := s.mutes[key]
+	if !ok {
+		return time.Time{}, nil
+	}
+	if time.Now().After(until) {
+		delete(s.mutes, key)
+		return time.Time{}, nil
+	}
+	return until, nil
+}
+
+// Ban bans userID from roomID.
+func (s *MemoryStore) Ban(ctx context.Context, roomID, userID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.bans[memoryKey(roomID, userID)] = true
+	return nil
+}
+
+// Unban lifts a ban.
+func (s *MemoryStore) Unban(ctx context.Context, roomID, userID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.bans, memoryKey(roomID, userID))
+	return nil
+}
+
+// IsBanned reports whether userID is banned from roomID.
+func (s *MemoryStore) IsBanned(ctx context.Context, roomID, userID string) (bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.bans[memoryKey(roomID, userID)], nil
+}
+
+// RedisStore implements Store using Redis. Mutes are keys that expire with the
+// mute; bans are a set per room.
+type RedisStore struct {
+	client *redis.Client
+}
+
+// NewRedisStore creates a moderation store backed by the given Redis client.
+func NewRedisStore(client *redis.Client) *RedisStore {
+	return &RedisStore{client: client}
+}
+
+func muteKey(roomID, userID string) string {
+	return "rally:room:" + roomID + ":mute:" + userID
+}
+
+func bansKey(roomID string) string {
+	return "rally:room:" + roomID + ":bans"
+}
+
+// Mute mutes userID in roomID until the given time.
+func (s *RedisStore) Mute(ctx context.Context, roomID, userID string, until time.Time) error {
+	ttl := time.Until(until)
+	if ttl <= 0 {
+		return nil
+	}
+	return s.client.Set(ctx, muteKey(roomID, userID), until.Unix(), ttl).Err()
+}
+
+// Unmute lifts a mute.
+func (s *RedisStore) Unmute(ctx context.Context, roomID, userID string) error {
+	return s.client.Del(ctx, muteKey(roomID, userID)).Err()
+}
+
+// MutedUntil returns when the mute ends.
+func (s *RedisStore) MutedUntil(ctx context.Context, roomID, userID string) (time.Time, error) {
+	unix, err := s.client.Get(ctx, muteKey(roomID, userID)).Int64()
+	if errors.Is(err, redis.Nil) {
+		return time.Time{}, nil
+	}
+	if err != nil {
+		return time.Time{}, err
+	}
+	return time.Unix(unix, 0), nil
+}
+
+// Ban bans userID from roomID.
+func (s *RedisStore) Ban(ctx context.Context, roomID, userID string) error {
+	return s.client.SAdd(ctx, bansKey(roomID), userID).Err()
+}
+
+// Unban lifts a ban.
+func (s *RedisStore) Unban(ctx context.Context, roomID, userID string) error {
+	return s.client.SRem(ctx, bansKey(roomID), userID).Err()
+}
+
+// IsBanned reports whether userID is banned from roomID.
+func (s *RedisStore) IsBanned(ctx context.Context, roomID, userID string) (bool, error) {
+	return s.client.SIsMember(ctx, bansKey(roomID), userID).Result()
+}
@@ -0,0 +1,89 @@
+package notify
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log"
+
+	"firebase.google.com/go/v4/messaging"
+)
+
+// DeviceStore looks up and forgets the FCM registration tokens of a user's
+// devices. Store implements it.
+type DeviceStore interface {
+	Devices(ctx context.Context, userID string) ([]string, error)
+	UnregisterDevice(ctx context.Context, userID, token string) error
+}
+
+// FCMNotifier sends notifications with Firebase Cloud Messaging to every
+// registered device of the user. Tokens that FCM reports as no longer
+// registered are forgotten.
+type FCMNotifier struct {
+	client  *messaging.Client
+	devices DeviceStore
+}
+
+// NewFCMNotifier creates a notifier sending through client.
+func NewFCMNotifier(client *messaging.Client, devices DeviceStore) *FCMNotifier {
+	return &FCMNotifier{client: client, devices: devices}
+}
+
+// Notify sends n to the user's devices. A user without devices is not an
+// error.
+func (f *FCMNotifier) Notify(ctx context.Context, n *Notification) error {
+	tokens, err := f.devices.Devices(ctx, n.UserID)
+	if err != nil {
+		return err
+	}
+	if len(tokens) == 0 {
+		return nil
+	}
+
+	resp, err := f.client.SendEachForMulticast(ctx, &messaging.MulticastMessage{
+		Tokens:       tokens,
+		Data:         n.Data,
+		Notification: &messaging.Notification{Title: n.Title, Body: n.Body},
+		Android: &messaging.AndroidConfig{
+			CollapseKey: n.CollapseKey,
+		},
+		APNS: &messaging.APNSConfig{
+			Headers: map[string]string{"apns-collapse-id": shortKey(n.CollapseKey, 64)},
+		},
+		Webpush: &messaging.WebpushConfig{
+			// Web push topics are at most 32 URL-safe characters.
+			Headers: map[string]string{"Topic": shortKey(n.CollapseKey, 0)},
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	var lastErr error
+	for i, r := range resp.Responses {
+		switch {
+		case r.Success:
+		case messaging.IsUnregistered(r.Error) || messaging.IsRegistrationTokenNotRegistered(r.Error):
+			if err := f.devices.UnregisterDevice(ctx, n.UserID, tokens[i]); err != nil {
+				log.Printf("Failed to forget unregistered device of user %s: %v", n.UserID, err)
+			}
+		default:
+			lastErr = r.Error
+		}
+	}
+	if lastErr != nil && resp.SuccessCount == 0 {
+		return fmt.Errorf("fcm: %d of %d sends failed: %w", resp.FailureCount, len(tokens), lastErr)
+	}
+	return nil
+}
+
+// shortKey returns key if it is at most max bytes long, or else a 32
+// character hash of it.
+func shortKey(key string, max int) string {
+	if len(key) <= max {
+		return key
+	}
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:16])
+}
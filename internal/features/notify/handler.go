@@ -0,0 +1,230 @@
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"time"
+
+	"github.com/rally-go/rally-realtime/internal/rooms"
+)
+
+// Settings action names. Every action is answered with the user's mutes.
+const (
+	ActionRegister   = "register"   // add a device token
+	ActionUnregister = "unregister" // remove a device token, e.g. on sign-out
+	ActionMute       = "mute"
+	ActionUnmute     = "unmute"
+	ActionGet        = "get"
+)
+
+// throttleWindows is how long a user gets no further notification of a
+// kind from a room after one was sent. The app shows the room's activity
+// once it is opened.
+var throttleWindows = map[string]time.Duration{
+	KindMention:   30 * time.Second,
+	KindItinerary: 10 * time.Minute,
+}
+
+// maxTokenLength bounds device tokens; FCM tokens are about 160 bytes.
+const maxTokenLength = 4096
+
+// ErrInvalidAction is returned for malformed or unknown actions.
+var ErrInvalidAction = errors.New("invalid notification action")
+
+// SettingsAction represents a notification settings payload.
+type SettingsAction struct {
+	Action          string     `json:"action"`                     // see Action* constants
+	Token           string     `json:"token,omitempty"`            // register, unregister
+	RoomID          string     `json:"room_id,omitempty"`          // mute, unmute; defaults to the current room
+	DurationSeconds int        `json:"duration_seconds,omitempty"` // mute; 0 mutes until unmuted
+	Mutes           []RoomMute `json:"mutes"`
+	Timestamp       time.Time  `json:"timestamp"`
+}
+
+// RoomMute is a muted room in the user's settings.
+type RoomMute struct {
+	RoomID string     `json:"room_id"`
+	Until  *time.Time `json:"until,omitempty"` // nil until unmuted
+}
+
+// Handler manages notification settings and notifies offline members.
+type Handler struct {
+	members  rooms.Store
+	presence rooms.Presence
+	store    Store
+	notifier Notifier
+}
+
+// NewHandler creates a new notification handler.
+func NewHandler(members rooms.Store, presence rooms.Presence, store Store, notifier Notifier) *Handler {
+	return &Handler{
+		members:  members,
+		presence: presence,
+		store:    store,
+		notifier: notifier,
+	}
+}
+
+// ProcessAction applies a settings action from userID, connected to roomID.
+func (h *Handler) ProcessAction(ctx context.Context, userID, roomID string, payload json.RawMessage) (*SettingsAction, error) {
+	var action SettingsAction
+	if err := json.Unmarshal(payload, &action); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidAction, err)
+	}
+	action.Mutes = nil
+	action.Timestamp = time.Now()
+	if action.RoomID == "" {
+		action.RoomID = roomID
+	}
+
+	switch action.Action {
+	case ActionRegister, ActionUnregister:
+		if action.Token == "" || len(action.Token) > maxTokenLength {
+			return nil, fmt.Errorf("%w: token is required", ErrInvalidAction)
+		}
+		var err error
+		if action.Action == ActionRegister {
+			err = h.store.RegisterDevice(ctx, userID, action.Token)
+		} else {
+			err = h.store.UnregisterDevice(ctx, userID, action.Token)
+		}
+		if err != nil {
+			return nil, err
+		}
+		action.Token = ""
+	case ActionMute:
+		if action.DurationSeconds < 0 {
+			return nil, fmt.Errorf("%w: duration_seconds must not be negative", ErrInvalidAction)
+		}
+		member, err := h.members.IsMember(ctx, action.RoomID, userID)
+		if err != nil {
+			return nil, err
+		}
+		if !member {
+			return nil, fmt.Errorf("%w: not a member of room %s", ErrInvalidAction, action.RoomID)
+		}
+		var until time.Time
+		if action.DurationSeconds > 0 {
+			until = action.Timestamp.Add(time.Duration(action.DurationSeconds) * time.Second)
+		}
+		if err := h.store.Mute(ctx, userID, action.RoomID, until); err != nil {
+			return nil, err
+		}
+		log.Printf("Notifications muted: user=%s room=%s until=%v", userID, action.RoomID, until)
+	case ActionUnmute:
+		if err := h.store.Unmute(ctx, userID, action.RoomID); err != nil {
+			return nil, err
+		}
+	case ActionGet:
+	default:
+		return nil, fmt.Errorf("%w: unknown action %q", ErrInvalidAction, action.Action)
+	}
+
+	mutes, err := h.store.Mutes(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	action.Mutes = make([]RoomMute, 0, len(mutes))
+	for room, until := range mutes {
+		m := RoomMute{RoomID: room}
+		if !until.IsZero() {
+			m.Until = &until
+		}
+		action.Mutes = append(action.Mutes, m)
+	}
+	sort.Slice(action.Mutes, func(i, j int) bool { return action.Mutes[i].RoomID < action.Mutes[j].RoomID })
+	return &action, nil
+}
+
+// NotifyRoom sends n to every member of roomID but exceptUserID; see
+// NotifyUsers.
+func (h *Handler) NotifyRoom(ctx context.Context, roomID, exceptUserID string, n Notification) error {
+	return h.notify(ctx, roomID, nil, exceptUserID, n)
+}
+
+// NotifyUsers sends n to each of userIDs who is a member of roomID with no
+// connection to it on any server instance, has not muted the room, and was
+// not sent a notification of the same kind from the room recently. The
+// collapse key is set per room and kind.
+func (h *Handler) NotifyUsers(ctx context.Context, roomID string, userIDs []string, n Notification) error {
+	return h.notify(ctx, roomID, userIDs, "", n)
+}
+
+// notify sends n to the offline members among userIDs, or among all
+// members if userIDs is nil, but exceptUserID.
+func (h *Handler) notify(ctx context.Context, roomID string, userIDs []string, exceptUserID string, n Notification) error {
+	members, err := h.members.Members(ctx, roomID)
+	if err != nil {
+		return err
+	}
+	online, err := h.presence.Online(ctx, roomID)
+	if err != nil {
+		return err
+	}
+
+	skip := map[string]bool{exceptUserID: true}
+	for _, id := range online {
+		skip[id] = true
+	}
+	if userIDs == nil {
+		userIDs = members
+	} else {
+		isMember := make(map[string]bool, len(members))
+		for _, id := range members {
+			isMember[id] = true
+		}
+		for _, id := range userIDs {
+			if !isMember[id] {
+				skip[id] = true
+			}
+		}
+	}
+
+	n.RoomID = roomID
+	n.CollapseKey = n.Kind + ":" + roomID
+	data := map[string]string{"room_id": roomID, "kind": n.Kind}
+	for k, v := range n.Data {
+		data[k] = v
+	}
+	n.Data = data
+
+	for _, userID := range userIDs {
+		if skip[userID] {
+			continue
+		}
+		skip[userID] = true
+		h.notifyUser(ctx, userID, n)
+	}
+	return nil
+}
+
+// notifyUser sends n to userID unless they muted the room or were notified
+// within the throttle window.
+func (h *Handler) notifyUser(ctx context.Context, userID string, n Notification) {
+	mutes, err := h.store.Mutes(ctx, userID)
+	if err != nil {
+		log.Printf("Failed to load notification mutes of user %s: %v", userID, err)
+		return
+	}
+	if _, muted := mutes[n.RoomID]; muted {
+		return
+	}
+
+	ok, err := h.store.Throttle(ctx, userID, n.CollapseKey, throttleWindows[n.Kind])
+	if err != nil {
+		log.Printf("Failed to throttle notification to user %s: %v", userID, err)
+		return
+	}
+	if !ok {
+		return
+	}
+
+	n.UserID = userID
+	if err := h.notifier.Notify(ctx, &n); err != nil {
+		log.Printf("Failed to notify user %s in room %s: %v", userID, n.RoomID, err)
+	}
+}
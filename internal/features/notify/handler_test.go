@@ -0,0 +1,152 @@
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/rally-go/rally-realtime/internal/rooms"
+)
+
+// newTestHandler returns a handler for room "trip" with members alice, bob
+// and carol, of whom alice is connected, and the notifier it pushes to.
+func newTestHandler(t *testing.T) (*Handler, *RecordingNotifier) {
+	t.Helper()
+
+	ctx := context.Background()
+	members := rooms.NewMemoryStore()
+	for _, user := range []string{"alice", "bob", "carol"} {
+		if err := members.AddMember(ctx, "trip", user); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := members.Connect(ctx, "trip", "alice", "conn-1"); err != nil {
+		t.Fatal(err)
+	}
+	notifier := NewRecordingNotifier()
+	return NewHandler(members, members, NewMemoryStore(), notifier), notifier
+}
+
+// recipients returns the users notifier was asked to notify, and forgets
+// them.
+func recipients(notifier *RecordingNotifier) []string {
+	var users []string
+	for _, n := range notifier.Sent() {
+		users = append(users, n.UserID)
+	}
+	notifier.Reset()
+	return users
+}
+
+func settings(t *testing.T, h *Handler, user string, payload any) (*SettingsAction, error) {
+	t.Helper()
+
+	data, _ := json.Marshal(payload)
+	return h.ProcessAction(context.Background(), user, "trip", data)
+}
+
+func TestNotifyOfflineMembers(t *testing.T) {
+	h, notifier := newTestHandler(t)
+	ctx := context.Background()
+	mention := Notification{Kind: KindMention, Title: "alice mentioned you", Data: map[string]string{"message_id": "m1"}}
+
+	// Connected members, non-members and duplicates are skipped.
+	if err := h.NotifyUsers(ctx, "trip", []string{"alice", "bob", "dave", "bob"}, mention); err != nil {
+		t.Fatal(err)
+	}
+	sent := notifier.Sent()
+	if len(sent) != 1 || sent[0].UserID != "bob" {
+		t.Fatalf("notified %v, want bob", recipients(notifier))
+	}
+	want := map[string]string{"room_id": "trip", "kind": KindMention, "message_id": "m1"}
+	if n := sent[0]; n.RoomID != "trip" || n.CollapseKey != "mention:trip" || !reflect.DeepEqual(n.Data, want) {
+		t.Errorf("notification = %+v, want room, collapse key and data %v", n, want)
+	}
+	notifier.Reset()
+
+	// The room is notified but for the editor and the connected.
+	if err := h.NotifyRoom(ctx, "trip", "carol", Notification{Kind: KindItinerary}); err != nil {
+		t.Fatal(err)
+	}
+	if got := recipients(notifier); len(got) != 1 || got[0] != "bob" {
+		t.Errorf("room notification sent to %v, want bob", got)
+	}
+}
+
+func TestNotifyMutedRooms(t *testing.T) {
+	h, notifier := newTestHandler(t)
+	ctx := context.Background()
+
+	if _, err := settings(t, h, "bob", map[string]any{"action": ActionMute}); err != nil {
+		t.Fatal(err)
+	}
+	action, err := settings(t, h, "carol", map[string]any{"action": ActionMute, "duration_seconds": 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(action.Mutes) != 1 || action.Mutes[0].RoomID != "trip" || action.Mutes[0].Until == nil {
+		t.Errorf("mutes = %+v, want trip until a time", action.Mutes)
+	}
+
+	if err := h.NotifyUsers(ctx, "trip", []string{"bob", "carol"}, Notification{Kind: KindMention}); err != nil {
+		t.Fatal(err)
+	}
+	if got := recipients(notifier); len(got) != 0 {
+		t.Errorf("muted members notified: %v", got)
+	}
+
+	// Carol's mute ends, and bob's lasts until unmuted.
+	time.Sleep(1100 * time.Millisecond)
+	if err := h.NotifyUsers(ctx, "trip", []string{"bob", "carol"}, Notification{Kind: KindItinerary}); err != nil {
+		t.Fatal(err)
+	}
+	if got := recipients(notifier); len(got) != 1 || got[0] != "carol" {
+		t.Errorf("notified %v after carol's mute ended, want carol", got)
+	}
+
+	if _, err := settings(t, h, "bob", map[string]any{"action": ActionUnmute}); err != nil {
+		t.Fatal(err)
+	}
+	if err := h.NotifyUsers(ctx, "trip", []string{"bob"}, Notification{Kind: KindMention}); err != nil {
+		t.Fatal(err)
+	}
+	if got := recipients(notifier); len(got) != 1 || got[0] != "bob" {
+		t.Errorf("notified %v after bob unmuted, want bob", got)
+	}
+
+	// Only members can mute a room.
+	if _, err := h.ProcessAction(ctx, "dave", "trip", json.RawMessage(`{"action":"mute"}`)); !errors.Is(err, ErrInvalidAction) {
+		t.Errorf("mute by a non-member: error = %v, want ErrInvalidAction", err)
+	}
+}
+
+func TestNotifyThrottled(t *testing.T) {
+	h, notifier := newTestHandler(t)
+	ctx := context.Background()
+	members := h.members.(*rooms.MemoryStore)
+	if err := members.AddMember(ctx, "other", "bob"); err != nil {
+		t.Fatal(err)
+	}
+
+	steps := []struct {
+		room, kind string
+		want       int
+	}{
+		{"trip", KindMention, 1},
+		{"trip", KindMention, 0},   // within the window
+		{"trip", KindItinerary, 1}, // another kind
+		{"other", KindMention, 1},  // another room
+		{"trip", KindItinerary, 0},
+	}
+	for i, s := range steps {
+		if err := h.NotifyUsers(ctx, s.room, []string{"bob"}, Notification{Kind: s.kind}); err != nil {
+			t.Fatal(err)
+		}
+		if got := recipients(notifier); len(got) != s.want {
+			t.Errorf("step %d: %s in %s sent %d notifications, want %d", i, s.kind, s.room, len(got), s.want)
+		}
+	}
+}
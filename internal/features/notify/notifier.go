@@ -0,0 +1,71 @@
+// Package notify sends push notifications to room members who have no live
+// connection to the room, so that mentions and itinerary changes reach them
+// anyway.
+package notify
+
+import (
+	"context"
+	"sync"
+)
+
+// Notification kinds.
+const (
+	KindMention   = "mention"
+	KindItinerary = "itinerary"
+)
+
+// Notification is a push notification for one user.
+type Notification struct {
+	UserID string
+	RoomID string
+	Kind   string // see Kind* constants
+	Title  string
+	Body   string
+	// CollapseKey groups notifications that replace each other on the
+	// device, so that a burst shows as one.
+	CollapseKey string
+	// Data is handed to the app, which opens the room from it.
+	Data map[string]string
+}
+
+// Notifier delivers push notifications to a user's devices.
+type Notifier interface {
+	Notify(ctx context.Context, n *Notification) error
+}
+
+// RecordingNotifier records notifications instead of sending them, for
+// tests and local development without FCM credentials.
+type RecordingNotifier struct {
+	sent []*Notification
+	mu   sync.Mutex
+}
+
+// NewRecordingNotifier creates an empty RecordingNotifier.
+func NewRecordingNotifier() *RecordingNotifier {
+	return &RecordingNotifier{}
+}
+
+// Notify records n.
+func (r *RecordingNotifier) Notify(ctx context.Context, n *Notification) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.sent = append(r.sent, n)
+	return nil
+}
+
+// Sent returns the notifications recorded so far, oldest first.
+func (r *RecordingNotifier) Sent() []*Notification {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return append([]*Notification(nil), r.sent...)
+}
+
+// Reset forgets the recorded notifications.
+func (r *RecordingNotifier) Reset() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.sent = nil
+}
@@ -0,0 +1,227 @@
+package notify
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Store persists each user's devices and notification settings, and
+// remembers recent notifications for throttling.
+type Store interface {
+	RegisterDevice(ctx context.Context, userID, token string) error
+	UnregisterDevice(ctx context.Context, userID, token string) error
+	Devices(ctx context.Context, userID string) ([]string, error)
+
+	// Mute silences notifications from roomID until the given time, or
+	// until unmuted if it is zero.
+	Mute(ctx context.Context, userID, roomID string, until time.Time) error
+	Unmute(ctx context.Context, userID, roomID string) error
+	// Mutes returns the user's muted rooms and when each mute ends, zero
+	// for never. Ended mutes are left out.
+	Mutes(ctx context.Context, userID string) (map[string]time.Time, error)
+
+	// Throttle reports whether a notification with collapseKey may be sent
+	// to userID, which is the case at most once per window. It must be
+	// atomic across server instances.
+	Throttle(ctx context.Context, userID, collapseKey string, window time.Duration) (bool, error)
+}
+
+// MemoryStore implements Store in process memory.
+// It is only suitable for a single server instance or local development.
+type MemoryStore struct {
+	devices map[string]map[string]bool      // user -> tokens
+	mutes   map[string]map[string]time.Time // user -> room -> until
+	sent    map[string]time.Time            // "user\x00key" -> throttled until
+	mu      sync.Mutex
+}
+
+// NewMemoryStore creates an empty in-memory notification store.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		devices: make(map[string]map[string]bool),
+		mutes:   make(map[string]map[string]time.Time),
+		sent:    make(map[string]time.Time),
+	}
+}
+
+// RegisterDevice adds a device token of userID.
+func (s *MemoryStore) RegisterDevice(ctx context.Context, userID, token string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.devices[userID] == nil {
+		s.devices[userID] = make(map[string]bool)
+	}
+	s.devices[userID][token] = true
+	return nil
+}
+
+// UnregisterDevice removes a device token of userID.
+func (s *MemoryStore) UnregisterDevice(ctx context.Context, userID, token string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.devices[userID], token)
+	return nil
+}
+
+// Devices returns the device tokens of userID.
+func (s *MemoryStore) Devices(ctx context.Context, userID string) ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	tokens := make([]string, 0, len(s.devices[userID]))
+	for t := range s.devices[userID] {
+		tokens = append(tokens, t)
+	}
+	return tokens, nil
+}
+
+// Mute silences roomID for userID.
+func (s *MemoryStore) Mute(ctx context.Context, userID, roomID string, until time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.mutes[userID] == nil {
+		s.mutes[userID] = make(map[string]time.Time)
+	}
+	s.mutes[userID][roomID] = until
+	return nil
+}
+
+// Unmute lifts a mute.
+func (s *MemoryStore) Unmute(ctx context.Context, userID, roomID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.mutes[userID], roomID)
+	return nil
+}
+
+// Mutes returns the user's current mutes.
+func (s *MemoryStore) Mutes(ctx context.Context, userID string) (map[string]time.Time, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	out := make(map[string]time.Time)
+	for room, until := range s.mutes[userID] {
+		if !until.IsZero() && now.After(until) {
+			delete(s.mutes[userID], room)
+			continue
+		}
+		out[room] = until
+	}
+	return out, nil
+}
+
+// Throttle allows one notification per user and collapse key per window.
+func (s *MemoryStore) Throttle(ctx context.Context, userID, collapseKey string, window time.Duration) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	for k, until := range s.sent {
+		if now.After(until) {
+			delete(s.sent, k)
+		}
+	}
+
+	key := userID + "\x00" + collapseKey
+	if _, ok := s.sent[key]; ok {
+		return false, nil
+	}
+	s.sent[key] = now.Add(window)
+	return true, nil
+}
+
+// RedisStore implements Store using Redis: a set of device tokens and a
+// hash of mutes per user, and an expiring key per throttled notification.
+type RedisStore struct {
+	client *redis.Client
+}
+
+// NewRedisStore creates a notification store backed by the given Redis
+// client.
+func NewRedisStore(client *redis.Client) *RedisStore {
+	return &RedisStore{client: client}
+}
+
+func devicesKey(userID string) string {
+	return "rally:user:" + userID + ":devices"
+}
+
+func mutesKey(userID string) string {
+	return "rally:user:" + userID + ":notify_mutes"
+}
+
+func throttleKey(userID, collapseKey string) string {
+	return "rally:user:" + userID + ":notified:" + collapseKey
+}
+
+// RegisterDevice adds a device token of userID.
+func (s *RedisStore) RegisterDevice(ctx context.Context, userID, token string) error {
+	return s.client.SAdd(ctx, devicesKey(userID), token).Err()
+}
+
+// UnregisterDevice removes a device token of userID.
+func (s *RedisStore) UnregisterDevice(ctx context.Context, userID, token string) error {
+	return s.client.SRem(ctx, devicesKey(userID), token).Err()
+}
+
+// Devices returns the device tokens of userID.
+func (s *RedisStore) Devices(ctx context.Context, userID string) ([]string, error) {
+	return s.client.SMembers(ctx, devicesKey(userID)).Result()
+}
+
+// Mute silences roomID for userID. Mutes are stored as Unix seconds, 0 for
+// never ending.
+func (s *RedisStore) Mute(ctx context.Context, userID, roomID string, until time.Time) error {
+	var unix int64
+	if !until.IsZero() {
+		unix = until.Unix()
+	}
+	return s.client.HSet(ctx, mutesKey(userID), roomID, unix).Err()
+}
+
+// Unmute lifts a mute.
+func (s *RedisStore) Unmute(ctx context.Context, userID, roomID string) error {
+	return s.client.HDel(ctx, mutesKey(userID), roomID).Err()
+}
+
+// Mutes returns the user's current mutes. Ended mutes stay in the hash
+// until replaced or lifted.
+func (s *RedisStore) Mutes(ctx context.Context, userID string) (map[string]time.Time, error) {
+	all, err := s.client.HGetAll(ctx, mutesKey(userID)).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	out := make(map[string]time.Time, len(all))
+	for room, v := range all {
+		unix, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		if unix == 0 {
+			out[room] = time.Time{}
+			continue
+		}
+		until := time.Unix(unix, 0)
+		if now.After(until) {
+			continue
+		}
+		out[room] = until
+	}
+	return out, nil
+}
+
+// Throttle allows one notification per user and collapse key per window.
+func (s *RedisStore) Throttle(ctx context.Context, userID, collapseKey string, window time.Duration) (bool, error) {
+	return s.client.SetNX(ctx, throttleKey(userID, collapseKey), 1, window).Result()
+}
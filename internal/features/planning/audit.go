@@ -0,0 +1,283 @@
+package planning
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"reflect"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// History query limits.
+const (
+	defaultHistoryLimit = 50
+	maxHistoryLimit     = 200
+)
+
+// ErrNothingToUndo is returned by undo and redo when the user's stack is
+// empty.
+var ErrNothingToUndo = errors.New("nothing to undo or redo")
+
+// AuditEntry records one accepted edit: who changed which item, and the
+// item before and after the change. Before is nil for inserts and After is
+// nil for deletes.
+type AuditEntry struct {
+	ID        string    `json:"id"`
+	RoomID    string    `json:"room_id"`
+	ItemID    string    `json:"item_id"`
+	UserID    string    `json:"user_id"`
+	Action    string    `json:"action"`
+	Source    string    `json:"source,omitempty"`   // "undo" or "redo" for compensating actions
+	Reverts   string    `json:"reverts,omitempty"`  // ID of the entry a compensating action reverts
+	Restores  uint64    `json:"restores,omitempty"` // version whose state a compensating action restored
+	Before    *ItemView `json:"before,omitempty"`
+	After     *ItemView `json:"after,omitempty"`
+	Version   uint64    `json:"version"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// record appends an accepted edit to the audit log and maintains the user's
+// undo and redo stacks: a new edit can be undone and clears the redo stack,
+// an undo can be redone, and a redo can be undone again. Failures are logged
+// because the edit itself has already been persisted.
+func (h *Handler) record(ctx context.Context, roomID string, action *PlanningAction, before, after *ItemView) {
+	entry := &AuditEntry{
+		ID:        uuid.New().String(),
+		RoomID:    roomID,
+		ItemID:    action.ItemID,
+		UserID:    action.UserID,
+		Action:    action.Action,
+		Source:    action.Source,
+		Reverts:   action.Reverts,
+		Restores:  action.restores,
+		Before:    before,
+		After:     after,
+		Version:   action.Version,
+		Timestamp: action.Timestamp,
+	}
+	action.AuditID = entry.ID
+
+	if err := h.store.AppendAudit(ctx, entry); err != nil {
+		log.Printf("Failed to record audit entry for item %s in room %s: %v", action.ItemID, roomID, err)
+	}
+
+	var err error
+	switch action.Source {
+	case ActionUndo:
+		err = h.store.PushStack(ctx, roomID, action.UserID, StackRedo, entry)
+	case ActionRedo:
+		err = h.store.PushStack(ctx, roomID, action.UserID, StackUndo, entry)
+	default:
+		if err = h.store.PushStack(ctx, roomID, action.UserID, StackUndo, entry); err == nil {
+			err = h.store.ClearStack(ctx, roomID, action.UserID, StackRedo)
+		}
+	}
+	if err != nil {
+		log.Printf("Failed to update undo stack of user %s in room %s: %v", action.UserID, roomID, err)
+	}
+}
+
+// History returns the room's audit log, or one item's if itemID is set,
+// newest first.
+func (h *Handler) History(ctx context.Context, roomID, itemID string, limit int) ([]AuditEntry, error) {
+	if limit <= 0 {
+		limit = defaultHistoryLimit
+	}
+	if limit > maxHistoryLimit {
+		limit = maxHistoryLimit
+	}
+
+	entries, err := h.store.History(ctx, roomID, itemID, limit)
+	if err != nil {
+		return nil, err
+	}
+	if entries == nil {
+		entries = []AuditEntry{}
+	}
+	return entries, nil
+}
+
+// undo reverts the most recent entry on the user's from stack by turning
+// action into a compensating edit. The edit goes through the same lock and
+// version checks as any other, so it fails if someone else has changed the
+// item since. Entries that can never apply are dropped; entries blocked by
+// a lock or a transient failure go back on the stack.
+func (h *Handler) undo(ctx context.Context, roomID string, action *PlanningAction, from UndoStack) error {
+	entry, err := h.store.PopStack(ctx, roomID, action.UserID, from)
+	if err != nil {
+		return err
+	}
+	if entry == nil {
+		return ErrNothingToUndo
+	}
+
+	source := action.Action
+	if err := compensate(entry, action); err != nil {
+		return err
+	}
+	action.Source = source
+	action.Reverts = entry.ID
+	if entry.Before != nil {
+		action.restores = entry.Before.Version
+	}
+	if err := h.rebase(ctx, roomID, action); err != nil {
+		return err
+	}
+
+	err = h.edit(ctx, roomID, action)
+	if err != nil && (errors.Is(err, ErrItemLocked) || !isRejection(err)) {
+		if perr := h.store.PushStack(ctx, roomID, action.UserID, from, entry); perr != nil {
+			log.Printf("Failed to restore %s stack of user %s in room %s: %v", from, action.UserID, roomID, perr)
+		}
+	}
+	return err
+}
+
+// rebase updates the base version of a compensating action when the item
+// has since been changed only by compensating actions that brought it back
+// to the state the action expects, such as the user undoing a later edit of
+// the same item. Any other change leaves the base version as it is, so the
+// edit is rejected as stale.
+func (h *Handler) rebase(ctx context.Context, roomID string, action *PlanningAction) error {
+	if action.BaseVersion == nil {
+		return nil
+	}
+
+	rd, err := h.document(ctx, roomID)
+	if err != nil {
+		return err
+	}
+	current, ok := rd.doc.Item(action.ItemID)
+	rd.mu.Unlock()
+	if !ok || current.Version == *action.BaseVersion {
+		return nil
+	}
+
+	entries, err := h.store.History(ctx, roomID, action.ItemID, maxHistoryLimit)
+	if err != nil {
+		return err
+	}
+	v := current.Version
+	for _, e := range entries {
+		if e.Version != v {
+			continue
+		}
+		if e.Reverts == "" || e.Restores == 0 {
+			return nil
+		}
+		v = e.Restores
+		if v == *action.BaseVersion {
+			action.BaseVersion = &current.Version
+			return nil
+		}
+	}
+	return nil
+}
+
+// isRejection reports whether err rejects the action itself rather than
+// reporting a failure to process it.
+func isRejection(err error) bool {
+	var invalid *ValidationError
+	return errors.Is(err, ErrInvalidAction) || errors.Is(err, ErrItemNotFound) ||
+		errors.Is(err, ErrItemLocked) || errors.Is(err, ErrStaleVersion) || errors.As(err, &invalid)
+}
+
+// compensate fills action with the edit that takes the item from
+// entry.After back to entry.Before.
+func compensate(entry *AuditEntry, action *PlanningAction) error {
+	action.ItemID = entry.ItemID
+	action.Data = nil
+	action.BaseVersion = nil
+	action.AfterItemID = ""
+	action.BeforeItemID = ""
+
+	if entry.After != nil {
+		version := entry.After.Version
+		action.BaseVersion = &version
+	}
+
+	switch entry.Action {
+	case ActionInsert:
+		action.Action = ActionDelete
+	case ActionDelete:
+		if entry.Before == nil {
+			return fmt.Errorf("%w: entry %s has no previous state", ErrInvalidAction, entry.ID)
+		}
+		action.Action = ActionInsert
+		action.Data = mustMarshal(entry.Before.Fields)
+		action.position = entry.Before.Position
+	case ActionMove:
+		action.Action = ActionMove
+		action.position = entry.Before.Position
+	case ActionUpdate:
+		patch, err := diffMergePatch(entry.After.Fields, entry.Before.Fields)
+		if err != nil {
+			return err
+		}
+		action.Action = ActionUpdate
+		action.Data = patch
+	default:
+		return fmt.Errorf("%w: cannot undo %q", ErrInvalidAction, entry.Action)
+	}
+	return nil
+}
+
+// diffMergePatch returns the JSON Merge Patch (RFC 7396) that turns from
+// into to.
+func diffMergePatch(from, to map[string]json.RawMessage) (json.RawMessage, error) {
+	decode := func(fields map[string]json.RawMessage) (map[string]any, error) {
+		out := make(map[string]any, len(fields))
+		for name, raw := range fields {
+			v, err := decodeJSON(raw)
+			if err != nil {
+				return nil, err
+			}
+			out[name] = v
+		}
+		return out, nil
+	}
+
+	f, err := decode(from)
+	if err != nil {
+		return nil, err
+	}
+	t, err := decode(to)
+	if err != nil {
+		return nil, err
+	}
+
+	patch, _ := diffValue(f, t)
+	return json.Marshal(patch)
+}
+
+// diffValue returns the merge patch from one value to another and whether
+// they differ. Objects are diffed key by key; anything else is replaced.
+func diffValue(from, to any) (any, bool) {
+	fo, fok := from.(map[string]any)
+	tobj, tok := to.(map[string]any)
+	if !fok || !tok {
+		return to, !reflect.DeepEqual(from, to)
+	}
+
+	patch := make(map[string]any)
+	for k := range fo {
+		if _, ok := tobj[k]; !ok {
+			patch[k] = nil
+		}
+	}
+	for k, tv := range tobj {
+		fv, ok := fo[k]
+		if !ok {
+			patch[k] = tv
+			continue
+		}
+		if d, changed := diffValue(fv, tv); changed {
+			patch[k] = d
+		}
+	}
+	return patch, len(patch) > 0
+}
@@ -0,0 +1,193 @@
+package planning
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+)
+
+func TestDiffMergePatch(t *testing.T) {
+	tests := []struct {
+		name     string
+		from, to string
+		want     string
+	}{
+		{name: "equal", from: `{"title":"Temple"}`, to: `{"title":"Temple"}`, want: `{}`},
+		{name: "changed", from: `{"title":"Temple"}`, to: `{"title":"Wat Pho"}`, want: `{"title":"Wat Pho"}`},
+		{name: "added", from: `{"title":"Temple"}`, to: `{"title":"Temple","notes":"Early"}`, want: `{"notes":"Early"}`},
+		{name: "removed", from: `{"title":"Temple","notes":"Early"}`, to: `{"title":"Temple"}`, want: `{"notes":null}`},
+		{
+			name: "nested objects diffed by key",
+			from: `{"place":{"name":"Wat Pho","address":"2 Sanam Chai Rd"}}`,
+			to:   `{"place":{"name":"Wat Pho","latitude":13.7465}}`,
+			want: `{"place":{"address":null,"latitude":13.7465}}`,
+		},
+		{name: "arrays replaced", from: `{"assignees":["alice","bob"]}`, to: `{"assignees":["bob"]}`, want: `{"assignees":["bob"]}`},
+		{name: "object replaced by scalar", from: `{"cost":{"amount":1}}`, to: `{"cost":"free"}`, want: `{"cost":"free"}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var from, to map[string]json.RawMessage
+			if err := json.Unmarshal([]byte(tt.from), &from); err != nil {
+				t.Fatal(err)
+			}
+			if err := json.Unmarshal([]byte(tt.to), &to); err != nil {
+				t.Fatal(err)
+			}
+			patch, err := diffMergePatch(from, to)
+			if err != nil {
+				t.Fatalf("diffMergePatch: %v", err)
+			}
+			if string(patch) != tt.want {
+				t.Errorf("diffMergePatch(%s, %s) = %s, want %s", tt.from, tt.to, patch, tt.want)
+			}
+
+			// Applying the patch gives back to.
+			changed, _, err := mergeItemPatch(from, "", patch)
+			if err != nil {
+				return // not every case is a valid item
+			}
+			for name, value := range changed {
+				if string(value) == "null" {
+					delete(from, name)
+				} else {
+					from[name] = value
+				}
+			}
+			if got, want := mustMarshal(from), mustMarshal(to); string(got) != string(want) {
+				t.Errorf("patched fields = %s, want %s", got, want)
+			}
+		})
+	}
+}
+
+// titles returns the titles of the room's items in order.
+func titles(t *testing.T, h *Handler, roomID string) []string {
+	t.Helper()
+
+	view, err := h.Document(context.Background(), roomID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := make([]string, 0, len(view.Items))
+	for _, it := range view.Items {
+		var title string
+		_ = json.Unmarshal(it.Fields["title"], &title)
+		out = append(out, title)
+	}
+	return out
+}
+
+func TestUndoRedo(t *testing.T) {
+	replicas, _ := newReplicas(1)
+	h := replicas[0]
+
+	undo := func(user string) (*PlanningAction, error) {
+		return processErr(h, user, "room", map[string]string{"action": ActionUndo})
+	}
+	redo := func(user string) (*PlanningAction, error) {
+		return processErr(h, user, "room", map[string]string{"action": ActionRedo})
+	}
+	check := func(step string, want ...string) {
+		t.Helper()
+		if got := titles(t, h, "room"); string(mustMarshal(got)) != string(mustMarshal(want)) {
+			t.Errorf("%s: titles = %q, want %q", step, got, want)
+		}
+	}
+
+	process(t, h, "alice", "room", insert("a", "Temple"))
+	created := process(t, h, "alice", "room", insert("b", "Market"))
+	process(t, h, "alice", "room", map[string]any{"action": ActionUpdate, "item_id": "b", "base_version": created.Version, "data": map[string]any{"title": "Night market"}})
+	check("edits", "Temple", "Night market")
+
+	action, err := undo("alice")
+	if err != nil {
+		t.Fatalf("undo: %v", err)
+	}
+	if action.Action != ActionUpdate || action.Source != ActionUndo || action.ItemID != "b" || action.Reverts == "" {
+		t.Errorf("undo answered with action=%s source=%s item=%s reverts=%q", action.Action, action.Source, action.ItemID, action.Reverts)
+	}
+	check("undo update", "Temple", "Market")
+
+	action, err = undo("alice")
+	if err != nil {
+		t.Fatalf("second undo: %v", err)
+	}
+	if action.Action != ActionDelete {
+		t.Errorf("undoing an insert gave %s, want %s", action.Action, ActionDelete)
+	}
+	check("undo insert", "Temple")
+
+	// Others' edits are not on alice's stack.
+	if _, err := undo("bob"); !errors.Is(err, ErrNothingToUndo) {
+		t.Errorf("undo by bob: error = %v, want ErrNothingToUndo", err)
+	}
+
+	action, err = redo("alice")
+	if err != nil {
+		t.Fatalf("redo: %v", err)
+	}
+	if action.Action != ActionInsert || action.Source != ActionRedo {
+		t.Errorf("redo answered with action=%s source=%s", action.Action, action.Source)
+	}
+	check("redo insert", "Temple", "Market")
+
+	if _, err := redo("alice"); err != nil {
+		t.Fatalf("second redo: %v", err)
+	}
+	check("redo update", "Temple", "Night market")
+	if _, err := redo("alice"); !errors.Is(err, ErrNothingToUndo) {
+		t.Errorf("redo with an empty stack: error = %v, want ErrNothingToUndo", err)
+	}
+
+	// A redo can be undone again, and a new edit clears the redo stack.
+	if _, err := undo("alice"); err != nil {
+		t.Fatalf("undo after redo: %v", err)
+	}
+	check("undo redone update", "Temple", "Market")
+	process(t, h, "alice", "room", insert("c", "Dinner"))
+	if _, err := redo("alice"); !errors.Is(err, ErrNothingToUndo) {
+		t.Errorf("redo after a new edit: error = %v, want ErrNothingToUndo", err)
+	}
+
+	// Deleted items come back where they were.
+	view, _ := h.Document(context.Background(), "room")
+	process(t, h, "alice", "room", map[string]any{"action": ActionDelete, "item_id": "b"})
+	if _, err := undo("alice"); err != nil {
+		t.Fatalf("undo delete: %v", err)
+	}
+	check("undo delete", "Temple", "Market", "Dinner")
+	after, _ := h.Document(context.Background(), "room")
+	if after.Items[1].Position != view.Items[1].Position {
+		t.Errorf("restored position = %q, want %q", after.Items[1].Position, view.Items[1].Position)
+	}
+}
+
+func TestUndoRejectedAfterOthersEdit(t *testing.T) {
+	replicas, _ := newReplicas(1)
+	h := replicas[0]
+
+	created := process(t, h, "alice", "room", insert("a", "Temple"))
+	updated := process(t, h, "alice", "room", map[string]any{"action": ActionUpdate, "item_id": "a", "base_version": created.Version, "data": map[string]any{"title": "Wat Pho"}})
+	process(t, h, "bob", "room", map[string]any{"action": ActionUpdate, "item_id": "a", "base_version": updated.Version, "data": map[string]any{"notes": "Closes at 6"}})
+
+	_, err := processErr(h, "alice", "room", map[string]string{"action": ActionUndo})
+	var stale *StaleVersionError
+	if !errors.As(err, &stale) {
+		t.Fatalf("undo over another user's edit: error = %v, want *StaleVersionError", err)
+	}
+	if got := titles(t, h, "room"); len(got) != 1 || got[0] != "Wat Pho" {
+		t.Errorf("titles after rejected undo = %q", got)
+	}
+
+	// Entries that can never apply are dropped: undoing the insert is
+	// stale too, and then the stack is empty.
+	if _, err := processErr(h, "alice", "room", map[string]string{"action": ActionUndo}); !errors.As(err, &stale) {
+		t.Errorf("undo of the insert: error = %v, want *StaleVersionError", err)
+	}
+	if _, err := processErr(h, "alice", "room", map[string]string{"action": ActionUndo}); !errors.Is(err, ErrNothingToUndo) {
+		t.Errorf("third undo: error = %v, want ErrNothingToUndo", err)
+	}
+}
@@ -0,0 +1,173 @@
+package planning
+
+import (
+	"context"
+	"math"
+	"sort"
+	"time"
+)
+
+// Travel estimates use straight-line distance at a conservative average
+// door-to-door speed, which covers city traffic and short walks.
+const (
+	travelSpeedKmh = 30.0
+	earthRadiusKm  = 6371.0
+)
+
+// Conflict kinds.
+const (
+	ConflictOverlap    = "overlap"     // the two items overlap in time
+	ConflictTravelTime = "travel_time" // not enough time to travel between them
+)
+
+// Conflict describes a problem between two items on the same day.
+type Conflict struct {
+	Kind    string    `json:"kind"`
+	Day     string    `json:"day"` // local date of the first item, YYYY-MM-DD
+	ItemIDs [2]string `json:"item_ids"`
+
+	OverlapMinutes   float64 `json:"overlap_minutes,omitempty"`
+	DistanceKm       float64 `json:"distance_km,omitempty"`
+	RequiredMinutes  float64 `json:"required_minutes,omitempty"`
+	AvailableMinutes float64 `json:"available_minutes,omitempty"`
+}
+
+// ConflictReport lists every conflict in a room's itinerary.
+type ConflictReport struct {
+	RoomID    string     `json:"room_id"`
+	Conflicts []Conflict `json:"conflicts"`
+	Timestamp time.Time  `json:"timestamp"`
+}
+
+// scheduledItem is an item with a resolved time span.
+type scheduledItem struct {
+	id         string
+	day        string
+	start, end time.Time
+	place      *Place
+}
+
+// Conflicts checks a room's itinerary for overlapping items and for
+// consecutive items on the same day that are too far apart to travel between
+// in the time available.
+func (h *Handler) Conflicts(ctx context.Context, roomID string) (*ConflictReport, error) {
+	view, err := h.Document(ctx, roomID)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ConflictReport{
+		RoomID:    roomID,
+		Conflicts: findConflicts(view.Items),
+		Timestamp: time.Now(),
+	}, nil
+}
+
+func findConflicts(items []ItemView) []Conflict {
+	days := make(map[string][]scheduledItem)
+	for _, v := range items {
+		item, err := v.Item()
+		if err != nil {
+			continue
+		}
+		start, ok := item.StartTime()
+		if !ok {
+			continue
+		}
+		end, ok := item.EndTime()
+		if !ok || end.Before(start) {
+			end = start
+		}
+
+		// Days are grouped by the item's own time zone so that an evening
+		// event is not moved to the next day by UTC.
+		day := start.Format(time.DateOnly)
+		days[day] = append(days[day], scheduledItem{id: v.ID, day: day, start: start, end: end, place: item.Place})
+	}
+
+	dayKeys := make([]string, 0, len(days))
+	for day := range days {
+		dayKeys = append(dayKeys, day)
+	}
+	sort.Strings(dayKeys)
+
+	conflicts := []Conflict{}
+	for _, day := range dayKeys {
+		sched := days[day]
+		sort.Slice(sched, func(i, j int) bool {
+			if !sched[i].start.Equal(sched[j].start) {
+				return sched[i].start.Before(sched[j].start)
+			}
+			return sched[i].id < sched[j].id
+		})
+
+		for i := range sched {
+			for j := i + 1; j < len(sched); j++ {
+				a, b := sched[i], sched[j]
+				if !b.start.Before(a.end) {
+					break // sorted by start: no later item overlaps a
+				}
+				overlapEnd := a.end
+				if b.end.Before(overlapEnd) {
+					overlapEnd = b.end
+				}
+				conflicts = append(conflicts, Conflict{
+					Kind:           ConflictOverlap,
+					Day:            day,
+					ItemIDs:        [2]string{a.id, b.id},
+					OverlapMinutes: round1(overlapEnd.Sub(b.start).Minutes()),
+				})
+			}
+		}
+
+		// Travel is checked between consecutive items that have coordinates.
+		// Items without a place in between still take up time, so the time
+		// available is measured from the latest end since the previous place.
+		var prev *scheduledItem
+		var busyUntil time.Time
+		for i := range sched {
+			b := &sched[i]
+			if prev != nil && hasCoordinates(b.place) {
+				available := b.start.Sub(busyUntil)
+				km := distanceKm(*prev.place.Latitude, *prev.place.Longitude, *b.place.Latitude, *b.place.Longitude)
+				required := time.Duration(km / travelSpeedKmh * float64(time.Hour))
+				if available >= 0 && available < required {
+					conflicts = append(conflicts, Conflict{
+						Kind:             ConflictTravelTime,
+						Day:              day,
+						ItemIDs:          [2]string{prev.id, b.id},
+						DistanceKm:       round1(km),
+						RequiredMinutes:  round1(required.Minutes()),
+						AvailableMinutes: round1(available.Minutes()),
+					})
+				}
+			}
+
+			if hasCoordinates(b.place) {
+				prev = b
+				busyUntil = b.end
+			} else if b.end.After(busyUntil) {
+				busyUntil = b.end
+			}
+		}
+	}
+	return conflicts
+}
+
+func hasCoordinates(p *Place) bool {
+	return p != nil && p.Latitude != nil && p.Longitude != nil
+}
+
+// distanceKm returns the great-circle distance using the haversine formula.
+func distanceKm(lat1, lng1, lat2, lng2 float64) float64 {
+	const rad = math.Pi / 180
+	dLat := (lat2 - lat1) * rad
+	dLng := (lng2 - lng1) * rad
+	a := math.Sin(dLat/2)*math.Sin(dLat/2) +
+		math.Cos(lat1*rad)*math.Cos(lat2*rad)*math.Sin(dLng/2)*math.Sin(dLng/2)
+	return 2 * earthRadiusKm * math.Asin(math.Sqrt(a))
+}
+
+func round1(f float64) float64 {
+	return math.Round(f*10) / 10
+}
@@ -0,0 +1,183 @@
+package planning
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+// scheduled returns an item view from start to end in Bangkok time, at
+// coordinates if given as lat, lng.
+func scheduled(id, start, end string, coords ...float64) ItemView {
+	item := Item{Title: id, Start: &start, TimeZone: "Asia/Bangkok"}
+	if end != "" {
+		item.End = &end
+	}
+	if len(coords) == 2 {
+		item.Place = &Place{Name: id, Latitude: &coords[0], Longitude: &coords[1]}
+	}
+
+	fields := make(map[string]json.RawMessage)
+	_ = json.Unmarshal(mustMarshal(item), &fields)
+	return ItemView{ID: id, Fields: fields}
+}
+
+// conflictSummary is the part of a conflict the tests compare.
+type conflictSummary struct {
+	Kind    string
+	Day     string
+	ItemIDs [2]string
+	Minutes float64 // overlap or available minutes
+}
+
+func summarize(conflicts []Conflict) []conflictSummary {
+	var out []conflictSummary
+	for _, c := range conflicts {
+		s := conflictSummary{Kind: c.Kind, Day: c.Day, ItemIDs: c.ItemIDs, Minutes: c.OverlapMinutes}
+		if c.Kind == ConflictTravelTime {
+			s.Minutes = c.AvailableMinutes
+		}
+		out = append(out, s)
+	}
+	return out
+}
+
+func TestFindConflicts(t *testing.T) {
+	// Wat Pho and Chatuchak market are about 8.6 km apart, 17 minutes at
+	// travelSpeedKmh; the Grand Palace is next door to Wat Pho.
+	watPho := []float64{13.7465, 100.4927}
+	chatuchak := []float64{13.7999, 100.5500}
+	palace := []float64{13.7500, 100.4913}
+
+	tests := []struct {
+		name  string
+		items []ItemView
+		want  []conflictSummary
+	}{
+		{
+			name: "overlap",
+			items: []ItemView{
+				scheduled("a", "2026-03-01T09:00:00+07:00", "2026-03-01T11:00:00+07:00"),
+				scheduled("b", "2026-03-01T10:00:00+07:00", "2026-03-01T12:00:00+07:00"),
+			},
+			want: []conflictSummary{{Kind: ConflictOverlap, Day: "2026-03-01", ItemIDs: [2]string{"a", "b"}, Minutes: 60}},
+		},
+		{
+			name: "back to back",
+			items: []ItemView{
+				scheduled("a", "2026-03-01T09:00:00+07:00", "2026-03-01T10:00:00+07:00"),
+				scheduled("b", "2026-03-01T10:00:00+07:00", "2026-03-01T11:00:00+07:00"),
+			},
+		},
+		{
+			name: "one item inside another overlaps both neighbours",
+			items: []ItemView{
+				scheduled("long", "2026-03-01T09:00:00+07:00", "2026-03-01T12:00:00+07:00"),
+				scheduled("inner", "2026-03-01T10:00:00+07:00", "2026-03-01T10:30:00+07:00"),
+				scheduled("late", "2026-03-01T11:30:00+07:00", "2026-03-01T13:00:00+07:00"),
+			},
+			want: []conflictSummary{
+				{Kind: ConflictOverlap, Day: "2026-03-01", ItemIDs: [2]string{"long", "inner"}, Minutes: 30},
+				{Kind: ConflictOverlap, Day: "2026-03-01", ItemIDs: [2]string{"long", "late"}, Minutes: 30},
+			},
+		},
+		{
+			name: "same start ordered by ID",
+			items: []ItemView{
+				scheduled("b", "2026-03-01T09:00:00+07:00", "2026-03-01T10:00:00+07:00"),
+				scheduled("a", "2026-03-01T09:00:00+07:00", "2026-03-01T09:15:00+07:00"),
+			},
+			want: []conflictSummary{{Kind: ConflictOverlap, Day: "2026-03-01", ItemIDs: [2]string{"a", "b"}, Minutes: 15}},
+		},
+		{
+			name: "days follow the item's time zone",
+			items: []ItemView{
+				scheduled("a", "2026-03-01T17:30:00Z", "2026-03-01T18:30:00Z"),
+				scheduled("b", "2026-03-02T01:00:00+07:00", "2026-03-02T02:00:00+07:00"),
+			},
+			want: []conflictSummary{{Kind: ConflictOverlap, Day: "2026-03-02", ItemIDs: [2]string{"a", "b"}, Minutes: 30}},
+		},
+		{
+			name: "different days never conflict",
+			items: []ItemView{
+				scheduled("a", "2026-03-01T09:00:00+07:00", "2026-03-01T10:00:00+07:00", watPho...),
+				scheduled("b", "2026-03-02T09:00:00+07:00", "2026-03-02T10:00:00+07:00", chatuchak...),
+			},
+		},
+		{
+			name: "too little time to travel",
+			items: []ItemView{
+				scheduled("temple", "2026-03-01T09:00:00+07:00", "2026-03-01T10:00:00+07:00", watPho...),
+				scheduled("market", "2026-03-01T10:10:00+07:00", "2026-03-01T12:00:00+07:00", chatuchak...),
+			},
+			want: []conflictSummary{{Kind: ConflictTravelTime, Day: "2026-03-01", ItemIDs: [2]string{"temple", "market"}, Minutes: 10}},
+		},
+		{
+			name: "enough time to travel",
+			items: []ItemView{
+				scheduled("temple", "2026-03-01T09:00:00+07:00", "2026-03-01T10:00:00+07:00", watPho...),
+				scheduled("market", "2026-03-01T10:30:00+07:00", "2026-03-01T12:00:00+07:00", chatuchak...),
+			},
+		},
+		{
+			name: "short walk",
+			items: []ItemView{
+				scheduled("temple", "2026-03-01T09:00:00+07:00", "2026-03-01T10:00:00+07:00", watPho...),
+				scheduled("palace", "2026-03-01T10:05:00+07:00", "2026-03-01T12:00:00+07:00", palace...),
+			},
+		},
+		{
+			name: "items without a place take up travel time",
+			items: []ItemView{
+				scheduled("temple", "2026-03-01T09:00:00+07:00", "2026-03-01T10:00:00+07:00", watPho...),
+				scheduled("lunch", "2026-03-01T10:00:00+07:00", "2026-03-01T11:00:00+07:00"),
+				scheduled("market", "2026-03-01T11:05:00+07:00", "2026-03-01T12:00:00+07:00", chatuchak...),
+			},
+			want: []conflictSummary{{Kind: ConflictTravelTime, Day: "2026-03-01", ItemIDs: [2]string{"temple", "market"}, Minutes: 5}},
+		},
+		{
+			name: "missing end counts as an instant",
+			items: []ItemView{
+				scheduled("a", "2026-03-01T09:00:00+07:00", ""),
+				scheduled("b", "2026-03-01T09:00:00+07:00", "2026-03-01T10:00:00+07:00"),
+			},
+		},
+		{
+			name: "unscheduled and invalid items are skipped",
+			items: []ItemView{
+				{ID: "unscheduled", Fields: map[string]json.RawMessage{"title": json.RawMessage(`"Free"`)}},
+				{ID: "invalid", Fields: map[string]json.RawMessage{"title": json.RawMessage(`1`), "start": json.RawMessage(`"2026-03-01T09:00:00+07:00"`)}},
+				scheduled("a", "2026-03-01T09:00:00+07:00", "2026-03-01T10:00:00+07:00"),
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conflicts := findConflicts(tt.items)
+			if conflicts == nil {
+				t.Fatal("findConflicts returned nil, want an empty list")
+			}
+			if got := summarize(conflicts); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("conflicts = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTravelConflictDetails(t *testing.T) {
+	conflicts := findConflicts([]ItemView{
+		scheduled("temple", "2026-03-01T09:00:00+07:00", "2026-03-01T10:00:00+07:00", 13.7465, 100.4927),
+		scheduled("market", "2026-03-01T10:10:00+07:00", "2026-03-01T12:00:00+07:00", 13.7999, 100.5500),
+	})
+	if len(conflicts) != 1 {
+		t.Fatalf("conflicts = %+v, want one", conflicts)
+	}
+	c := conflicts[0]
+	if c.DistanceKm < 8.5 || c.DistanceKm > 9.5 {
+		t.Errorf("distance = %v km, want about 8.6", c.DistanceKm)
+	}
+	if want := round1(c.DistanceKm / travelSpeedKmh * 60); c.RequiredMinutes < want-0.2 || c.RequiredMinutes > want+0.2 {
+		t.Errorf("required = %v minutes, want about %v", c.RequiredMinutes, want)
+	}
+}
@@ -0,0 +1,275 @@
+package planning
+
+import (
+	"encoding/json"
+	"sort"
+)
+
+// The itinerary of a room is a CRDT document so that edits made concurrently
+// on different server instances converge to the same state regardless of the
+// order in which they are applied:
+//
+//   - every item field is a last-writer-wins register;
+//   - item order is a sequence of fractional position keys, each also an LWW
+//     register, with ties broken by item ID;
+//   - deletion is an LWW tombstone, so a later insert can revive an item.
+//
+// Writes are ordered by Stamp: a Lamport counter, with the op ID as a
+// tie-breaker. Applying an op is idempotent and commutative.
+
+// Stamp totally orders operations.
+type Stamp struct {
+	Counter uint64 `json:"counter"`
+	OpID    string `json:"op_id"`
+}
+
+// After reports whether s is ordered after o.
+func (s Stamp) After(o Stamp) bool {
+	if s.Counter != o.Counter {
+		return s.Counter > o.Counter
+	}
+	return s.OpID > o.OpID
+}
+
+// Register is a last-writer-wins register.
+type Register struct {
+	Value json.RawMessage `json:"value"`
+	Stamp Stamp           `json:"stamp"`
+}
+
+// set stores value if stamp is newer than the current write.
+func (r *Register) set(value json.RawMessage, stamp Stamp) bool {
+	if r.Stamp.OpID != "" && !stamp.After(r.Stamp) {
+		return false
+	}
+	r.Value = value
+	r.Stamp = stamp
+	return true
+}
+
+// OpKind identifies the kind of a document operation.
+type OpKind string
+
+const (
+	// OpInsert creates (or revives) an item at a position.
+	OpInsert OpKind = "insert"
+	// OpSet writes one field of an item.
+	OpSet OpKind = "set"
+	// OpMove changes the position of an item.
+	OpMove OpKind = "move"
+	// OpDelete tombstones an item.
+	OpDelete OpKind = "delete"
+)
+
+// Op is a single CRDT operation on a room's itinerary.
+type Op struct {
+	ID       string          `json:"id"`
+	ItemID   string          `json:"item_id"`
+	Kind     OpKind          `json:"kind"`
+	Field    string          `json:"field,omitempty"`    // OpSet
+	Value    json.RawMessage `json:"value,omitempty"`    // OpSet
+	Position string          `json:"position,omitempty"` // OpInsert, OpMove
+	Counter  uint64          `json:"counter"`
+	UserID   string          `json:"user_id"`
+}
+
+func (op *Op) stamp() Stamp {
+	return Stamp{Counter: op.Counter, OpID: op.ID}
+}
+
+// ItemState is the replicated state of one itinerary item.
+//
+// Version is the highest op counter applied to the item. Taking the maximum
+// keeps it convergent across replicas, and it increases with every accepted
+// change, so clients can use it for optimistic concurrency.
+type ItemState struct {
+	Fields   map[string]*Register `json:"fields"`
+	Position Register             `json:"position"`
+	Deleted  Register             `json:"deleted"`
+	Version  uint64               `json:"version"`
+}
+
+// Document is the replicated itinerary of one room.
+type Document struct {
+	RoomID string                `json:"room_id"`
+	Clock  uint64                `json:"clock"`
+	Items  map[string]*ItemState `json:"items"`
+}
+
+// NewDocument creates an empty document.
+func NewDocument(roomID string) *Document {
+	return &Document{
+		RoomID: roomID,
+		Items:  make(map[string]*ItemState),
+	}
+}
+
+var (
+	jsonTrue  = json.RawMessage("true")
+	jsonFalse = json.RawMessage("false")
+)
+
+// Apply merges op into the document.
+func (d *Document) Apply(op *Op) {
+	if op.Counter > d.Clock {
+		d.Clock = op.Counter
+	}
+
+	item, ok := d.Items[op.ItemID]
+	if !ok {
+		item = &ItemState{Fields: make(map[string]*Register)}
+		d.Items[op.ItemID] = item
+	}
+
+	if op.Counter > item.Version {
+		item.Version = op.Counter
+	}
+
+	stamp := op.stamp()
+	switch op.Kind {
+	case OpInsert:
+		item.Position.set(mustMarshal(op.Position), stamp)
+		item.Deleted.set(jsonFalse, stamp)
+	case OpMove:
+		item.Position.set(mustMarshal(op.Position), stamp)
+	case OpSet:
+		reg, ok := item.Fields[op.Field]
+		if !ok {
+			reg = &Register{}
+			item.Fields[op.Field] = reg
+		}
+		reg.set(op.Value, stamp)
+	case OpDelete:
+		item.Deleted.set(jsonTrue, stamp)
+	}
+}
+
+// Tick advances the Lamport clock and returns the counter for a new op.
+func (d *Document) Tick() uint64 {
+	d.Clock++
+	return d.Clock
+}
+
+// Exists reports whether itemID is a live (inserted, not deleted) item.
+func (d *Document) Exists(itemID string) bool {
+	item, ok := d.Items[itemID]
+	return ok && item.live()
+}
+
+func (s *ItemState) live() bool {
+	return s.Position.Stamp.OpID != "" && string(s.Deleted.Value) != string(jsonTrue)
+}
+
+func (s *ItemState) position() string {
+	var p string
+	_ = json.Unmarshal(s.Position.Value, &p)
+	return p
+}
+
+// ItemView is the client-facing view of an item.
+type ItemView struct {
+	ID       string                     `json:"id"`
+	Version  uint64                     `json:"version"`
+	Position string                     `json:"position"`
+	Fields   map[string]json.RawMessage `json:"fields"`
+}
+
+// DocumentView is the client-facing view of a document: live items in order.
+type DocumentView struct {
+	RoomID string     `json:"room_id"`
+	Clock  uint64     `json:"clock"`
+	Items  []ItemView `json:"items"`
+}
+
+// View returns the live items in order.
+func (d *Document) View() *DocumentView {
+	view := &DocumentView{RoomID: d.RoomID, Clock: d.Clock, Items: []ItemView{}}
+	for id, item := range d.Items {
+		if item.live() {
+			view.Items = append(view.Items, item.view(id))
+		}
+	}
+
+	sort.Slice(view.Items, func(i, j int) bool {
+		a, b := view.Items[i], view.Items[j]
+		if a.Position != b.Position {
+			return a.Position < b.Position
+		}
+		return a.ID < b.ID
+	})
+	return view
+}
+
+// Item returns the view of a live item.
+func (d *Document) Item(itemID string) (ItemView, bool) {
+	item, ok := d.Items[itemID]
+	if !ok || !item.live() {
+		return ItemView{}, false
+	}
+	return item.view(itemID), true
+}
+
+func (s *ItemState) view(id string) ItemView {
+	fields := make(map[string]json.RawMessage, len(s.Fields))
+	for name, reg := range s.Fields {
+		if len(reg.Value) > 0 && string(reg.Value) != "null" {
+			fields[name] = reg.Value
+		}
+	}
+	return ItemView{ID: id, Version: s.Version, Position: s.position(), Fields: fields}
+}
+
+// neighbours returns the position keys around the slot where an item should
+// go: after afterID, before beforeID, or at the end if both are empty.
+// movingID is excluded so that an item can be moved relative to its own
+// neighbours.
+func (d *Document) neighbours(afterID, beforeID, movingID string) (string, string, bool) {
+	items := d.View().Items
+	if movingID != "" {
+		kept := items[:0]
+		for _, it := range items {
+			if it.ID != movingID {
+				kept = append(kept, it)
+			}
+		}
+		items = kept
+	}
+
+	switch {
+	case afterID != "":
+		for i, it := range items {
+			if it.ID == afterID {
+				next := ""
+				if i+1 < len(items) {
+					next = items[i+1].Position
+				}
+				return it.Position, next, true
+			}
+		}
+		return "", "", false
+	case beforeID != "":
+		for i, it := range items {
+			if it.ID == beforeID {
+				prev := ""
+				if i > 0 {
+					prev = items[i-1].Position
+				}
+				return prev, it.Position, true
+			}
+		}
+		return "", "", false
+	}
+
+	if len(items) == 0 {
+		return "", "", true
+	}
+	return items[len(items)-1].Position, "", true
+}
+
+func mustMarshal(v any) json.RawMessage {
+	data, err := json.Marshal(v)
+	if err != nil {
+		panic(err)
+	}
+	return data
+}
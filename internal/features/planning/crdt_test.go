@@ -0,0 +1,171 @@
+package planning
+
+import (
+	"encoding/json"
+	"math/rand"
+	"strings"
+	"testing"
+)
+
+func setOp(id, itemID, field, value string, counter uint64) Op {
+	return Op{ID: id, ItemID: itemID, Kind: OpSet, Field: field, Value: json.RawMessage(value), Counter: counter}
+}
+
+func viewJSON(t *testing.T, d *Document) string {
+	t.Helper()
+	data, err := json.Marshal(d.View())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func TestDocumentConvergesInAnyOrder(t *testing.T) {
+	ops := []Op{
+		{ID: "op-01", ItemID: "a", Kind: OpInsert, Position: "V", Counter: 1},
+		setOp("op-02", "a", "title", `"Temple"`, 2),
+		{ID: "op-03", ItemID: "b", Kind: OpInsert, Position: "k", Counter: 3},
+		setOp("op-04", "b", "title", `"Market"`, 4),
+		// Concurrent writes to the same field: the higher counter wins.
+		setOp("op-05", "a", "title", `"Wat Pho"`, 5),
+		setOp("op-06", "a", "title", `"Wat Arun"`, 4),
+		// Concurrent writes with equal counters: the higher op ID wins.
+		setOp("op-07", "b", "notes", `"early"`, 6),
+		setOp("op-08", "b", "notes", `"late"`, 6),
+		{ID: "op-09", ItemID: "b", Kind: OpMove, Position: "F", Counter: 7},
+		{ID: "op-10", ItemID: "c", Kind: OpInsert, Position: "z", Counter: 8},
+		{ID: "op-11", ItemID: "c", Kind: OpDelete, Counter: 9},
+	}
+
+	want := NewDocument("room")
+	for i := range ops {
+		want.Apply(&ops[i])
+	}
+	wantJSON := viewJSON(t, want)
+
+	view := want.View()
+	if len(view.Items) != 2 || view.Items[0].ID != "b" || view.Items[1].ID != "a" {
+		t.Fatalf("items = %+v, want b then a with c deleted", view.Items)
+	}
+	if got := string(view.Items[1].Fields["title"]); got != `"Wat Pho"` {
+		t.Errorf("a.title = %s, want the write with the higher counter", got)
+	}
+	if got := string(view.Items[0].Fields["notes"]); got != `"late"` {
+		t.Errorf("b.notes = %s, want the write with the higher op ID", got)
+	}
+	if view.Items[1].Version != 5 || view.Items[0].Version != 7 {
+		t.Errorf("versions = %d, %d, want the highest counters 5 and 7", view.Items[1].Version, view.Items[0].Version)
+	}
+
+	rng := rand.New(rand.NewSource(1))
+	for range 200 {
+		shuffled := append([]Op(nil), ops...)
+		rng.Shuffle(len(shuffled), func(i, j int) { shuffled[i], shuffled[j] = shuffled[j], shuffled[i] })
+
+		doc := NewDocument("room")
+		for i := range shuffled {
+			doc.Apply(&shuffled[i])
+			doc.Apply(&shuffled[i]) // applying twice changes nothing
+		}
+		if got := viewJSON(t, doc); got != wantJSON {
+			t.Fatalf("order %v converged to\n%s\nwant\n%s", opIDs(shuffled), got, wantJSON)
+		}
+	}
+}
+
+func opIDs(ops []Op) string {
+	ids := make([]string, len(ops))
+	for i, op := range ops {
+		ids[i] = op.ID
+	}
+	return strings.Join(ids, " ")
+}
+
+func TestDocumentDeleteAndRevive(t *testing.T) {
+	tests := []struct {
+		name string
+		ops  []Op
+		live bool
+	}{
+		{
+			name: "delete after insert",
+			ops: []Op{
+				{ID: "1", ItemID: "a", Kind: OpInsert, Position: "V", Counter: 1},
+				{ID: "2", ItemID: "a", Kind: OpDelete, Counter: 2},
+			},
+		},
+		{
+			name: "later insert revives",
+			ops: []Op{
+				{ID: "1", ItemID: "a", Kind: OpInsert, Position: "V", Counter: 1},
+				{ID: "2", ItemID: "a", Kind: OpDelete, Counter: 2},
+				{ID: "3", ItemID: "a", Kind: OpInsert, Position: "V", Counter: 3},
+			},
+			live: true,
+		},
+		{
+			name: "delete concurrent with a later insert loses",
+			ops: []Op{
+				{ID: "3", ItemID: "a", Kind: OpInsert, Position: "V", Counter: 3},
+				{ID: "2", ItemID: "a", Kind: OpDelete, Counter: 2},
+			},
+			live: true,
+		},
+		{
+			name: "field write without insert is not an item",
+			ops:  []Op{setOp("1", "a", "title", `"x"`, 1)},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			doc := NewDocument("room")
+			for i := range tt.ops {
+				doc.Apply(&tt.ops[i])
+			}
+			if got := doc.Exists("a"); got != tt.live {
+				t.Errorf("Exists = %v, want %v", got, tt.live)
+			}
+		})
+	}
+}
+
+func TestDocumentClock(t *testing.T) {
+	doc := NewDocument("room")
+	doc.Apply(&Op{ID: "1", ItemID: "a", Kind: OpInsert, Position: "V", Counter: 41})
+	if got := doc.Tick(); got != 42 {
+		t.Errorf("Tick after a remote op with counter 41 = %d, want 42", got)
+	}
+}
+
+func TestKeyBetween(t *testing.T) {
+	tests := []struct{ a, b string }{
+		{"", ""},
+		{"", "V"},
+		{"V", ""},
+		{"V", "W"},
+		{"V", "V1"},
+		{"A", "z"},
+		{"Vz", "W"},
+		{"z", ""},
+		{"", "01"},
+	}
+	for _, tt := range tests {
+		k := keyBetween(tt.a, tt.b)
+		if k <= tt.a || (tt.b != "" && k >= tt.b) {
+			t.Errorf("keyBetween(%q, %q) = %q, not strictly between", tt.a, tt.b, k)
+		}
+		if strings.HasSuffix(k, "0") {
+			t.Errorf("keyBetween(%q, %q) = %q ends in the zero digit", tt.a, tt.b, k)
+		}
+	}
+
+	// Appending keeps keys short.
+	key := ""
+	for range 1000 {
+		key = keyBetween(key, "")
+	}
+	if len(key) > 20 {
+		t.Errorf("key after 1000 appends is %d digits long", len(key))
+	}
+}
@@ -0,0 +1,286 @@
+package planning
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// icsProdID identifies the generator in exported calendars.
+const icsProdID = "-//Rally//Rally Realtime//EN"
+
+// icsLineOctets is the maximum length of an iCalendar content line before
+// it must be folded (RFC 5545, section 3.1).
+const icsLineOctets = 75
+
+// RenderICS renders the scheduled items of an itinerary as an iCalendar
+// feed. Items without a start time are left out. Each item keeps the same
+// UID across exports and its version as SEQUENCE, so that calendar apps
+// update events on re-import instead of duplicating them. Items with a time
+// zone are written in local time with a VTIMEZONE covering the itinerary.
+func RenderICS(view *DocumentView, now time.Time) []byte {
+	type event struct {
+		view       ItemView
+		item       *Item
+		start, end time.Time
+		hasEnd     bool
+	}
+
+	var events []event
+	zones := make(map[string]*time.Location)
+	var first, last time.Time
+	for _, v := range view.Items {
+		item, err := v.Item()
+		if err != nil {
+			continue
+		}
+		start, ok := item.StartTime()
+		if !ok {
+			continue
+		}
+		end, hasEnd := item.EndTime()
+		if hasEnd && end.Before(start) {
+			hasEnd = false
+		}
+		events = append(events, event{view: v, item: item, start: start, end: end, hasEnd: hasEnd})
+
+		if item.TimeZone != "" {
+			zones[item.TimeZone] = start.Location()
+		}
+		if first.IsZero() || start.Before(first) {
+			first = start
+		}
+		if hasEnd && end.After(last) {
+			last = end
+		} else if start.After(last) {
+			last = start
+		}
+	}
+
+	w := &icsWriter{}
+	w.line("BEGIN:VCALENDAR")
+	w.line("VERSION:2.0")
+	w.line("PRODID:" + icsProdID)
+	w.line("CALSCALE:GREGORIAN")
+	w.line("METHOD:PUBLISH")
+	w.line("X-WR-CALNAME:" + icsText("Rally itinerary "+view.RoomID))
+
+	names := make([]string, 0, len(zones))
+	for name := range zones {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		writeVTimezone(w, name, zones[name], first, last)
+	}
+
+	stamp := now.UTC().Format("20060102T150405Z")
+	for _, e := range events {
+		w.line("BEGIN:VEVENT")
+		w.line("UID:" + icsText(e.view.ID+"@"+view.RoomID+".rally"))
+		w.line("DTSTAMP:" + stamp)
+		w.line("SEQUENCE:" + strconv.FormatUint(e.view.Version, 10))
+		w.line(icsDateTime("DTSTART", e.item.TimeZone, e.start))
+		if e.hasEnd {
+			w.line(icsDateTime("DTEND", e.item.TimeZone, e.end))
+		}
+		w.line("SUMMARY:" + icsText(e.item.Title))
+		if p := e.item.Place; p != nil {
+			location := p.Name
+			if p.Address != "" {
+				location += ", " + p.Address
+			}
+			w.line("LOCATION:" + icsText(location))
+			if hasCoordinates(p) {
+				w.line(fmt.Sprintf("GEO:%s;%s", formatCoord(*p.Latitude), formatCoord(*p.Longitude)))
+			}
+		}
+		if desc := icsDescription(e.item); desc != "" {
+			w.line("DESCRIPTION:" + icsText(desc))
+		}
+		w.line("END:VEVENT")
+	}
+
+	w.line("END:VCALENDAR")
+	return w.buf.Bytes()
+}
+
+func icsDescription(item *Item) string {
+	var parts []string
+	if item.Notes != "" {
+		parts = append(parts, item.Notes)
+	}
+	if item.Cost != nil {
+		parts = append(parts, fmt.Sprintf("Cost: %s %s", strconv.FormatFloat(item.Cost.Amount, 'f', -1, 64), item.Cost.Currency))
+	}
+	return strings.Join(parts, "\n\n")
+}
+
+// icsDateTime formats a DATE-TIME property, in local time with a TZID if the
+// item has a time zone and in UTC otherwise.
+func icsDateTime(name, tzid string, t time.Time) string {
+	if tzid == "" {
+		return name + ":" + t.UTC().Format("20060102T150405Z")
+	}
+	return name + ";TZID=" + tzid + ":" + t.Format("20060102T150405")
+}
+
+// writeVTimezone writes the definition of a time zone with every offset
+// change between from and to, taken from the Go time zone database. Each
+// change is its own STANDARD or DAYLIGHT component, without recurrence
+// rules, which every client understands.
+func writeVTimezone(w *icsWriter, name string, loc *time.Location, from, to time.Time) {
+	w.line("BEGIN:VTIMEZONE")
+	w.line("TZID:" + name)
+
+	t := from.In(loc)
+	start, end := t.ZoneBounds()
+	abbr, offset := t.Zone()
+	if start.IsZero() {
+		// The offset has not changed since before the zone's history, so
+		// one component starting at the epoch covers it.
+		epoch := time.Date(1970, 1, 1, 0, 0, 0, 0, time.FixedZone("", offset))
+		writeTimezonePeriod(w, t.IsDST(), epoch, offset, offset, abbr)
+	} else {
+		writeTimezoneChange(w, loc, start)
+	}
+	for !end.IsZero() && !end.After(to) {
+		start, end = end.In(loc).ZoneBounds()
+		writeTimezoneChange(w, loc, start)
+	}
+
+	w.line("END:VTIMEZONE")
+}
+
+// writeTimezoneChange writes the offset change that happened at at.
+func writeTimezoneChange(w *icsWriter, loc *time.Location, at time.Time) {
+	_, before := at.Add(-time.Second).In(loc).Zone()
+	local := at.In(loc)
+	abbr, offset := local.Zone()
+	writeTimezonePeriod(w, local.IsDST(), at, before, offset, abbr)
+}
+
+func writeTimezonePeriod(w *icsWriter, dst bool, at time.Time, from, to int, abbr string) {
+	kind := "STANDARD"
+	if dst {
+		kind = "DAYLIGHT"
+	}
+	w.line("BEGIN:" + kind)
+	// DTSTART is the local time of the change in the offset before it.
+	w.line("DTSTART:" + at.In(time.FixedZone("", from)).Format("20060102T150405"))
+	w.line("TZOFFSETFROM:" + icsOffset(from))
+	w.line("TZOFFSETTO:" + icsOffset(to))
+	if abbr != "" {
+		w.line("TZNAME:" + icsText(abbr))
+	}
+	w.line("END:" + kind)
+}
+
+func icsOffset(seconds int) string {
+	sign := '+'
+	if seconds < 0 {
+		sign = '-'
+		seconds = -seconds
+	}
+	s := fmt.Sprintf("%c%02d%02d", sign, seconds/3600, seconds/60%60)
+	if rem := seconds % 60; rem != 0 {
+		s += fmt.Sprintf("%02d", rem)
+	}
+	return s
+}
+
+// icsText escapes a TEXT value (RFC 5545, section 3.3.11).
+func icsText(s string) string {
+	s = strings.ReplaceAll(s, "\r\n", "\n")
+	return strings.NewReplacer(`\`, `\\`, ";", `\;`, ",", `\,`, "\n", `\n`).Replace(s)
+}
+
+// icsWriter writes CRLF-terminated content lines, folding long lines
+// without splitting UTF-8 sequences.
+type icsWriter struct {
+	buf bytes.Buffer
+}
+
+func (w *icsWriter) line(s string) {
+	limit := icsLineOctets
+	for len(s) > limit {
+		cut := limit
+		for cut > 0 && !utf8.RuneStart(s[cut]) {
+			cut--
+		}
+		w.buf.WriteString(s[:cut])
+		w.buf.WriteString("\r\n ")
+		s = s[cut:]
+		limit = icsLineOctets - 1 // continuation lines start with a space
+	}
+	w.buf.WriteString(s)
+	w.buf.WriteString("\r\n")
+}
+
+func formatCoord(v float64) string {
+	return strconv.FormatFloat(v, 'f', 6, 64)
+}
+
+// GeoJSON types (RFC 7946).
+type (
+	featureCollection struct {
+		Type     string    `json:"type"`
+		Features []feature `json:"features"`
+	}
+
+	feature struct {
+		Type       string         `json:"type"`
+		ID         string         `json:"id"`
+		Geometry   point          `json:"geometry"`
+		Properties map[string]any `json:"properties"`
+	}
+
+	point struct {
+		Type        string     `json:"type"`
+		Coordinates [2]float64 `json:"coordinates"` // longitude, latitude
+	}
+)
+
+// RenderGeoJSON renders the itinerary items that have coordinates as a
+// GeoJSON FeatureCollection of points, in itinerary order.
+func RenderGeoJSON(view *DocumentView) ([]byte, error) {
+	fc := featureCollection{Type: "FeatureCollection", Features: []feature{}}
+	for i, v := range view.Items {
+		item, err := v.Item()
+		if err != nil || !hasCoordinates(item.Place) {
+			continue
+		}
+
+		props := map[string]any{
+			"title":   item.Title,
+			"place":   item.Place.Name,
+			"order":   i,
+			"version": v.Version,
+		}
+		if item.Place.Address != "" {
+			props["address"] = item.Place.Address
+		}
+		if item.Start != nil {
+			props["start"] = *item.Start
+		}
+		if item.End != nil {
+			props["end"] = *item.End
+		}
+		if item.TimeZone != "" {
+			props["time_zone"] = item.TimeZone
+		}
+
+		fc.Features = append(fc.Features, feature{
+			Type:       "Feature",
+			ID:         v.ID,
+			Geometry:   point{Type: "Point", Coordinates: [2]float64{*item.Place.Longitude, *item.Place.Latitude}},
+			Properties: props,
+		})
+	}
+	return json.Marshal(fc)
+}
@@ -0,0 +1,184 @@
+package planning
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+)
+
+// viewOf returns the view of item at version.
+func viewOf(id string, version uint64, item Item) ItemView {
+	fields := make(map[string]json.RawMessage)
+	_ = json.Unmarshal(mustMarshal(item), &fields)
+	return ItemView{ID: id, Version: version, Fields: fields}
+}
+
+// unfold splits an iCalendar feed into content lines, undoing line folding.
+func unfold(t *testing.T, ics []byte) []string {
+	t.Helper()
+
+	s := string(ics)
+	if !strings.HasSuffix(s, "\r\n") {
+		t.Errorf("feed does not end with CRLF")
+	}
+	for _, line := range strings.Split(strings.TrimSuffix(s, "\r\n"), "\r\n") {
+		if len(line) > icsLineOctets {
+			t.Errorf("line of %d octets: %q", len(line), line)
+		}
+		if !utf8.ValidString(line) {
+			t.Errorf("line splits a UTF-8 sequence: %q", line)
+		}
+	}
+	return strings.Split(strings.ReplaceAll(strings.TrimSuffix(s, "\r\n"), "\r\n ", ""), "\r\n")
+}
+
+// component returns the lines of the n-th component named name.
+func component(lines []string, name string, n int) []string {
+	for i, line := range lines {
+		if line != "BEGIN:"+name {
+			continue
+		}
+		if n > 0 {
+			n--
+			continue
+		}
+		for j := i; j < len(lines); j++ {
+			if lines[j] == "END:"+name {
+				return lines[i : j+1]
+			}
+		}
+	}
+	return nil
+}
+
+func TestRenderICS(t *testing.T) {
+	now := time.Date(2026, 2, 1, 8, 30, 0, 0, time.UTC)
+	view := &DocumentView{RoomID: "trip", Items: []ItemView{
+		viewOf("palace", 3, Item{
+			Title:    "Grand Palace; Wat Phra Kaew",
+			Start:    ptr("2026-03-01T09:00:00+07:00"),
+			End:      ptr("2026-03-01T11:30:00+07:00"),
+			TimeZone: "Asia/Bangkok",
+			Place:    &Place{Name: "Grand Palace", Address: "Na Phra Lan Rd, Bangkok", Latitude: ptr(13.75), Longitude: ptr(100.4913)},
+			Cost:     &Cost{Amount: 500, Currency: "THB"},
+			Notes:    "Dress code:\ncovered shoulders",
+		}),
+		viewOf("free", 1, Item{Title: "Free afternoon"}),
+		viewOf("flight", 2, Item{Title: strings.Repeat("เที่ยวบิน ", 12), Start: ptr("2026-03-02T01:15:00Z")}),
+	}}
+
+	lines := unfold(t, RenderICS(view, now))
+	if lines[0] != "BEGIN:VCALENDAR" || lines[len(lines)-1] != "END:VCALENDAR" {
+		t.Errorf("feed is not a calendar: %q ... %q", lines[0], lines[len(lines)-1])
+	}
+	if component(lines, "VEVENT", 2) != nil {
+		t.Error("unscheduled item exported")
+	}
+
+	want := []string{
+		"BEGIN:VEVENT",
+		"UID:palace@trip.rally",
+		"DTSTAMP:20260201T083000Z",
+		"SEQUENCE:3",
+		"DTSTART;TZID=Asia/Bangkok:20260301T090000",
+		"DTEND;TZID=Asia/Bangkok:20260301T113000",
+		`SUMMARY:Grand Palace\; Wat Phra Kaew`,
+		`LOCATION:Grand Palace\, Na Phra Lan Rd\, Bangkok`,
+		"GEO:13.750000;100.491300",
+		`DESCRIPTION:Dress code:\ncovered shoulders\n\nCost: 500 THB`,
+		"END:VEVENT",
+	}
+	if got := component(lines, "VEVENT", 0); !reflect.DeepEqual(got, want) {
+		t.Errorf("event =\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+
+	// Items without a time zone are written in UTC, and long lines are
+	// folded between runes.
+	flight := component(lines, "VEVENT", 1)
+	if !reflect.DeepEqual(flight[4:6], []string{"DTSTART:20260302T011500Z", "SUMMARY:" + strings.Repeat("เที่ยวบิน ", 12)}) {
+		t.Errorf("UTC event = %q", flight)
+	}
+
+	want = []string{
+		"BEGIN:VTIMEZONE",
+		"TZID:Asia/Bangkok",
+		"BEGIN:STANDARD",
+		"DTSTART:19200401T000000",
+		"TZOFFSETFROM:+064204",
+		"TZOFFSETTO:+0700",
+		"TZNAME:+07",
+		"END:STANDARD",
+		"END:VTIMEZONE",
+	}
+	if got := component(lines, "VTIMEZONE", 0); !reflect.DeepEqual(got, want) {
+		t.Errorf("time zone =\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+func TestRenderICSDaylightSaving(t *testing.T) {
+	// The itinerary spans the change to summer time on 29 March 2026.
+	view := &DocumentView{RoomID: "trip", Items: []ItemView{
+		viewOf("museum", 1, Item{Title: "Museum", Start: ptr("2026-03-27T10:00:00+01:00"), TimeZone: "Europe/Berlin"}),
+		viewOf("zoo", 1, Item{Title: "Zoo", Start: ptr("2026-04-02T10:00:00+02:00"), TimeZone: "Europe/Berlin"}),
+	}}
+
+	lines := unfold(t, RenderICS(view, time.Now()))
+	want := []string{
+		"BEGIN:VTIMEZONE",
+		"TZID:Europe/Berlin",
+		"BEGIN:STANDARD",
+		"DTSTART:20251026T030000",
+		"TZOFFSETFROM:+0200",
+		"TZOFFSETTO:+0100",
+		"TZNAME:CET",
+		"END:STANDARD",
+		"BEGIN:DAYLIGHT",
+		"DTSTART:20260329T020000",
+		"TZOFFSETFROM:+0100",
+		"TZOFFSETTO:+0200",
+		"TZNAME:CEST",
+		"END:DAYLIGHT",
+		"END:VTIMEZONE",
+	}
+	if got := component(lines, "VTIMEZONE", 0); !reflect.DeepEqual(got, want) {
+		t.Errorf("time zone =\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+	if got := component(lines, "VEVENT", 1)[4]; got != "DTSTART;TZID=Europe/Berlin:20260402T100000" {
+		t.Errorf("summer time start = %q", got)
+	}
+}
+
+func TestRenderGeoJSON(t *testing.T) {
+	view := &DocumentView{RoomID: "trip", Items: []ItemView{
+		viewOf("hotel", 1, Item{Title: "Check in", Place: &Place{Name: "Hotel"}}),
+		viewOf("temple", 4, Item{
+			Title:    "Temple",
+			Start:    ptr("2026-03-01T09:00:00+07:00"),
+			TimeZone: "Asia/Bangkok",
+			Place:    &Place{Name: "Wat Pho", Address: "2 Sanam Chai Rd", Latitude: ptr(13.7465), Longitude: ptr(100.4927)},
+		}),
+		viewOf("market", 2, Item{Title: "Market", Place: &Place{Name: "Chatuchak", Latitude: ptr(13.7999), Longitude: ptr(100.55)}}),
+	}}
+
+	data, err := RenderGeoJSON(view)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `{"type":"FeatureCollection","features":[` +
+		`{"type":"Feature","id":"temple","geometry":{"type":"Point","coordinates":[100.4927,13.7465]},` +
+		`"properties":{"address":"2 Sanam Chai Rd","order":1,"place":"Wat Pho","start":"2026-03-01T09:00:00+07:00","time_zone":"Asia/Bangkok","title":"Temple","version":4}},` +
+		`{"type":"Feature","id":"market","geometry":{"type":"Point","coordinates":[100.55,13.7999]},` +
+		`"properties":{"order":2,"place":"Chatuchak","title":"Market","version":2}}]}`
+	if string(data) != want {
+		t.Errorf("RenderGeoJSON =\n%s\nwant\n%s", data, want)
+	}
+
+	// An itinerary without places is an empty collection, not null.
+	data, _ = RenderGeoJSON(&DocumentView{RoomID: "trip"})
+	if string(data) != `{"type":"FeatureCollection","features":[]}` {
+		t.Errorf("empty RenderGeoJSON = %s", data)
+	}
+}
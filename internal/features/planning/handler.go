@@ -1,120 +1,557 @@
 package planning
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"log"
 	"sync"
 	"time"
+
+	"github.com/google/uuid"
+	"github.com/rally-go/rally-realtime/internal/rooms"
+)
+
+// Planning action names.
+const (
+	ActionLock   = "lock"
+	ActionUnlock = "unlock"
+	ActionInsert = "insert"
+	ActionUpdate = "update"
+	ActionMove   = "move"
+	ActionDelete = "delete"
+	ActionSync   = "sync" // request the current document; answered to the sender only
+
+	ActionHistory = "history" // request the audit log; answered to the sender only
+	ActionUndo    = "undo"    // revert the user's last edit
+	ActionRedo    = "redo"    // reapply the user's last undone edit
+)
+
+// snapshotEvery is how many ops an instance appends to a room's log before it
+// saves a new snapshot.
+const snapshotEvery = 100
+
+var (
+	// ErrInvalidAction is returned for malformed or unknown actions.
+	ErrInvalidAction = errors.New("invalid planning action")
+
+	// ErrItemNotFound is returned when an action targets a missing item.
+	ErrItemNotFound = errors.New("itinerary item not found")
+
+	// ErrItemLocked is returned when another user holds the item's lock.
+	// The error is a *LockedError.
+	ErrItemLocked = errors.New("itinerary item is locked by another user")
+
+	// ErrForbidden is returned when a user may not steal a lock.
+	ErrForbidden = errors.New("only moderators can take over another user's lock")
+
+	// ErrStaleVersion is returned when an edit is based on an outdated
+	// version of the item. The error is a *StaleVersionError.
+	ErrStaleVersion = errors.New("itinerary item has changed since base_version")
 )
 
+// StaleVersionError carries the item's current state so that the client can
+// rebase its edit.
+type StaleVersionError struct {
+	BaseVersion uint64
+	Current     ItemView
+}
+
+func (e *StaleVersionError) Error() string {
+	return fmt.Sprintf("%v: base_version %d, current version %d", ErrStaleVersion, e.BaseVersion, e.Current.Version)
+}
+
+func (e *StaleVersionError) Unwrap() error {
+	return ErrStaleVersion
+}
+
 // PlanningAction represents a planning action payload.
 type PlanningAction struct {
-	Action    string    `json:"action"` // "lock", "unlock", "update"
-	ItemID    string    `json:"item_id"`
-	UserID    string    `json:"user_id"`
-	Data      any       `json:"data,omitempty"`
-	Timestamp time.Time `json:"timestamp"`
+	Action       string          `json:"action"` // see Action* constants
+	ItemID       string          `json:"item_id"`
+	UserID       string          `json:"user_id"`
+	Data         json.RawMessage `json:"data,omitempty"`           // Item (insert) or JSON Merge Patch (update)
+	AfterItemID  string          `json:"after_item_id,omitempty"`  // insert, move
+	BeforeItemID string          `json:"before_item_id,omitempty"` // insert, move
+	BaseVersion  *uint64         `json:"base_version,omitempty"`   // required for update
+	Version      uint64          `json:"version,omitempty"`        // item version after the change
+	Item         *ItemView       `json:"item,omitempty"`           // insert, update, move: the item after the change
+	Ops          []Op            `json:"ops,omitempty"`            // CRDT ops produced by the action
+	Limit        int             `json:"limit,omitempty"`          // history
+	Source       string          `json:"source,omitempty"`         // "undo" or "redo" for compensating actions
+	Reverts      string          `json:"reverts,omitempty"`        // audit entry reverted by a compensating action
+	AuditID      string          `json:"audit_id,omitempty"`       // audit entry recording the action
+	Steal        bool            `json:"steal,omitempty"`          // lock: take over another user's lock (moderators)
+	Lock         *LockEvent      `json:"lock,omitempty"`           // lock, unlock: resulting lock change
+	Timestamp    time.Time       `json:"timestamp"`
+
+	// Set only by compensating actions: position places an insert or move
+	// at an exact key instead of between anchors, and restores is the
+	// version whose state the action brings back.
+	position string
+	restores uint64
 }
 
-// ItemLock represents a lock on an itinerary item.
-type ItemLock struct {
-	UserID    string
-	ExpiresAt time.Time
+// roomDocument is the local replica of a room's itinerary. mu serializes
+// local edits, remote ops and loading.
+//
+// Ops from other instances arrive over pub/sub and may lag behind the log,
+// so the replica can hold ops from later in the log while missing earlier
+// ones. applied is the length of the log prefix known to be in doc; only
+// that much may be claimed by a snapshot.
+type roomDocument struct {
+	doc           *Document
+	applied       int64
+	sinceSnapshot int
+	used          time.Time // last local use, for eviction
+	evicted       bool      // dropped from Handler.docs; callers must look again
+	mu            sync.Mutex
 }
 
+const (
+	// docIdleTTL is how long a replica stays in memory after its last
+	// local use. Evicted rooms are loaded from the store again on demand.
+	docIdleTTL = 30 * time.Minute
+
+	// docSweepInterval is how often idle replicas are evicted.
+	docSweepInterval = time.Minute
+)
+
 // Handler handles planning/collaboration operations.
 type Handler struct {
-	// In-memory locks (TODO: Use Redis for distributed locking)
-	locks map[string]*ItemLock
-	mu    sync.RWMutex
+	members rooms.Store
+
+	// Local replicas of itinerary documents, by room
+	docs   map[string]*roomDocument
+	docsMu sync.Mutex
+	store  Store
+
+	// Lock changes not caused by a client action, such as expiry
+	lockEvents chan *LockEvent
 }
 
-// NewHandler creates a new planning handler.
-func NewHandler() *Handler {
+// NewHandler creates a new planning handler backed by store. Room roles
+// decide who may steal a lock.
+func NewHandler(members rooms.Store, store Store) *Handler {
 	h := &Handler{
-		locks: make(map[string]*ItemLock),
+		members:    members,
+		docs:       make(map[string]*roomDocument),
+		store:      store,
+		lockEvents: make(chan *LockEvent, 64),
 	}
 
-	// Start lock cleanup goroutine
+	// Start lock cleanup and replica eviction goroutines
 	go h.cleanupExpiredLocks()
+	go h.evictIdleDocuments()
 
 	return h
 }
 
 // ProcessAction processes an incoming planning action.
-func (h *Handler) ProcessAction(userID string, payload json.RawMessage) (*PlanningAction, error) {
+func (h *Handler) ProcessAction(ctx context.Context, userID, roomID string, payload json.RawMessage) (*PlanningAction, error) {
 	var action PlanningAction
 	if err := json.Unmarshal(payload, &action); err != nil {
-		return nil, err
+		return nil, fmt.Errorf("%w: %v", ErrInvalidAction, err)
 	}
 
 	action.UserID = userID
 	action.Timestamp = time.Now()
+	action.Version = 0
+	action.Item = nil
+	action.Ops = nil
+	action.Source = ""
+	action.Reverts = ""
+	action.AuditID = ""
+	action.Lock = nil
 
 	switch action.Action {
-	case "lock":
-		if err := h.lockItem(action.ItemID, userID); err != nil {
-			log.Printf("Failed to lock item %s: %v", action.ItemID, err)
+	case ActionLock:
+		event, err := h.lockItem(ctx, roomID, userID, action.ItemID, action.Steal)
+		if err != nil {
+			return nil, err
+		}
+		action.Lock = event
+	case ActionUnlock:
+		event, err := h.unlockItem(ctx, roomID, userID, action.ItemID)
+		if err != nil {
+			return nil, err
+		}
+		action.Lock = event
+	case ActionInsert, ActionUpdate, ActionMove, ActionDelete:
+		if err := h.edit(ctx, roomID, &action); err != nil {
+			return nil, err
+		}
+		log.Printf("Planning %s: item=%s user=%s ops=%d", action.Action, action.ItemID, userID, len(action.Ops))
+	case ActionSync:
+		view, err := h.Document(ctx, roomID)
+		if err != nil {
+			return nil, err
+		}
+		action.Data = mustMarshal(view)
+	case ActionHistory:
+		entries, err := h.History(ctx, roomID, action.ItemID, action.Limit)
+		if err != nil {
 			return nil, err
 		}
-	case "unlock":
-		h.unlockItem(action.ItemID, userID)
-	case "update":
-		// TODO: Validate lock ownership before allowing update
-		log.Printf("Planning update: item=%s user=%s", action.ItemID, userID)
+		action.Data = mustMarshal(entries)
+	case ActionUndo:
+		if err := h.undo(ctx, roomID, &action, StackUndo); err != nil {
+			return nil, err
+		}
+		log.Printf("Planning undo (%s): item=%s user=%s", action.Action, action.ItemID, userID)
+	case ActionRedo:
+		if err := h.undo(ctx, roomID, &action, StackRedo); err != nil {
+			return nil, err
+		}
+		log.Printf("Planning redo (%s): item=%s user=%s", action.Action, action.ItemID, userID)
+	default:
+		return nil, fmt.Errorf("%w: unknown action %q", ErrInvalidAction, action.Action)
 	}
 
 	return &action, nil
 }
 
-// lockItem attempts to acquire a lock on an item.
-func (h *Handler) lockItem(itemID, userID string) error {
-	h.mu.Lock()
-	defer h.mu.Unlock()
+// edit turns an insert, update, move or delete into CRDT ops, persists them
+// and applies them to the local replica.
+func (h *Handler) edit(ctx context.Context, roomID string, action *PlanningAction) error {
+	if action.Action == ActionInsert && action.ItemID == "" {
+		action.ItemID = uuid.New().String()
+	}
+	if action.ItemID == "" {
+		return fmt.Errorf("%w: item_id is required", ErrInvalidAction)
+	}
+	if err := h.checkLock(ctx, roomID, action.ItemID, action.UserID); err != nil {
+		return err
+	}
+
+	rd, err := h.document(ctx, roomID)
+	if err != nil {
+		return err
+	}
+	defer rd.mu.Unlock()
+
+	doc := rd.doc
+	var current ItemView
+	if action.Action != ActionInsert {
+		var ok bool
+		current, ok = doc.Item(action.ItemID)
+		if !ok {
+			return ErrItemNotFound
+		}
 
-	// Check if already locked by another user
-	if lock, exists := h.locks[itemID]; exists {
-		if lock.UserID != userID && time.Now().Before(lock.ExpiresAt) {
-			log.Printf("Item %s is locked by user %s", itemID, lock.UserID)
-			return nil // Item is locked
+		// Updates must say which version they were based on; moves and
+		// deletes are checked only if they do.
+		if action.BaseVersion == nil && action.Action == ActionUpdate {
+			return fmt.Errorf("%w: base_version is required", ErrInvalidAction)
+		}
+		if action.BaseVersion != nil && *action.BaseVersion != current.Version {
+			return &StaleVersionError{BaseVersion: *action.BaseVersion, Current: current}
 		}
 	}
 
-	// Acquire lock (expires in 5 minutes)
-	h.locks[itemID] = &ItemLock{
-		UserID:    userID,
-		ExpiresAt: time.Now().Add(5 * time.Minute),
+	// Validate everything before generating ops so that a rejected action
+	// leaves the document, including its clock, untouched.
+	var position string
+	if action.Action == ActionInsert || action.Action == ActionMove {
+		moving := ""
+		if action.Action == ActionMove {
+			moving = action.ItemID
+		} else if doc.Exists(action.ItemID) {
+			return fmt.Errorf("%w: item %s already exists", ErrInvalidAction, action.ItemID)
+		}
+
+		if action.position != "" {
+			position = action.position
+		} else {
+			prev, next, ok := doc.neighbours(action.AfterItemID, action.BeforeItemID, moving)
+			if !ok {
+				return fmt.Errorf("%w: anchor item not found", ErrItemNotFound)
+			}
+			position = keyBetween(prev, next)
+		}
 	}
 
-	log.Printf("Item %s locked by user %s", itemID, userID)
+	var fields map[string]json.RawMessage
+	if action.Action == ActionInsert || action.Action == ActionUpdate {
+		// Inserts are validated as a patch onto an empty item, so both
+		// paths produce the same field-level errors.
+		if len(action.Data) == 0 {
+			return fmt.Errorf("%w: data is required", ErrInvalidAction)
+		}
+		fields, _, err = mergeItemPatch(current.Fields, current.Position, action.Data)
+		if err != nil {
+			return err
+		}
+		if len(fields) == 0 {
+			return fmt.Errorf("%w: data must contain at least one field", ErrInvalidAction)
+		}
+	}
+
+	newOp := func(kind OpKind) Op {
+		return Op{
+			ID:      uuid.New().String(),
+			ItemID:  action.ItemID,
+			Kind:    kind,
+			Counter: doc.Tick(),
+			UserID:  action.UserID,
+		}
+	}
+
+	var ops []Op
+	switch action.Action {
+	case ActionInsert:
+		op := newOp(OpInsert)
+		op.Position = position
+		ops = append(ops, op)
+	case ActionMove:
+		op := newOp(OpMove)
+		op.Position = position
+		ops = append(ops, op)
+	case ActionDelete:
+		ops = append(ops, newOp(OpDelete))
+	}
+	for name, value := range fields {
+		if action.Action == ActionInsert && string(value) == "null" {
+			continue
+		}
+		op := newOp(OpSet)
+		op.Field = name
+		op.Value = value
+		ops = append(ops, op)
+	}
+
+	// The check above used this replica, which may not have every op yet;
+	// the store checks the base version again atomically with the append.
+	var check *VersionCheck
+	if action.BaseVersion != nil {
+		check = &VersionCheck{ItemID: action.ItemID, Version: *action.BaseVersion}
+	}
+	n, err := h.store.AppendOps(ctx, roomID, ops, check)
+	if errors.Is(err, errVersionConflict) {
+		// Another instance changed the item first. Catch up so that the
+		// client gets the version it has to rebase on.
+		if err := h.catchUp(ctx, roomID, rd); err != nil {
+			rd.doc = nil
+			return err
+		}
+		current, ok := rd.doc.Item(action.ItemID)
+		if !ok {
+			return ErrItemNotFound
+		}
+		return &StaleVersionError{BaseVersion: *action.BaseVersion, Current: current}
+	}
+	if err != nil {
+		// Ops were never published, so roll the replica back by reloading.
+		rd.doc = nil
+		return err
+	}
+	for i := range ops {
+		doc.Apply(&ops[i])
+	}
+	if rd.applied == n-int64(len(ops)) {
+		rd.applied = n
+	}
+	action.Ops = ops
+	action.Version = doc.Items[action.ItemID].Version
+
+	var before, after *ItemView
+	if action.Action != ActionInsert {
+		before = &current
+	}
+	if view, ok := doc.Item(action.ItemID); ok {
+		after = &view
+	}
+	action.Item = after
+	h.record(ctx, roomID, action, before, after)
+
+	rd.sinceSnapshot += len(ops)
+	if rd.sinceSnapshot >= snapshotEvery {
+		h.snapshot(ctx, roomID, rd)
+	}
+	return nil
+}
+
+// catchUp applies the ops logged since the replica's applied prefix, so
+// that it holds the whole log as of now. rd must be locked.
+func (h *Handler) catchUp(ctx context.Context, roomID string, rd *roomDocument) error {
+	ops, err := h.store.OpsSince(ctx, roomID, rd.applied)
+	if err != nil {
+		return err
+	}
+	for i := range ops {
+		rd.doc.Apply(&ops[i])
+	}
+	rd.applied += int64(len(ops))
 	return nil
 }
 
-// unlockItem releases a lock on an item.
-func (h *Handler) unlockItem(itemID, userID string) {
-	h.mu.Lock()
-	defer h.mu.Unlock()
+// snapshot saves the replica once it has caught up with the log, so that
+// the snapshot never claims ops another instance logged before this one's
+// that have not arrived here yet. rd must be locked.
+func (h *Handler) snapshot(ctx context.Context, roomID string, rd *roomDocument) {
+	if err := h.catchUp(ctx, roomID, rd); err != nil {
+		log.Printf("Failed to catch up itinerary of room %s before snapshot: %v", roomID, err)
+		return
+	}
+	if err := h.store.SaveSnapshot(ctx, roomID, &Snapshot{Document: rd.doc, LogLength: rd.applied}); err != nil {
+		log.Printf("Failed to save itinerary snapshot for room %s: %v", roomID, err)
+		return
+	}
+	rd.sinceSnapshot = 0
+}
+
+// ApplyRemote merges ops broadcast by another server instance into the local
+// replica. Rooms without a local replica are skipped; their ops are loaded
+// from the store when the replica is created.
+func (h *Handler) ApplyRemote(roomID string, payload json.RawMessage) {
+	var action PlanningAction
+	if err := json.Unmarshal(payload, &action); err != nil || len(action.Ops) == 0 {
+		return
+	}
+
+	h.docsMu.Lock()
+	rd, ok := h.docs[roomID]
+	h.docsMu.Unlock()
+	if !ok {
+		return
+	}
+
+	rd.mu.Lock()
+	defer rd.mu.Unlock()
+
+	if rd.doc == nil {
+		return
+	}
+	for i := range action.Ops {
+		rd.doc.Apply(&action.Ops[i])
+	}
+}
+
+// Document returns the current itinerary of a room.
+func (h *Handler) Document(ctx context.Context, roomID string) (*DocumentView, error) {
+	rd, err := h.document(ctx, roomID)
+	if err != nil {
+		return nil, err
+	}
+	defer rd.mu.Unlock()
+
+	return rd.doc.View(), nil
+}
+
+// ItemExists reports whether itemID is a live item in the room's itinerary.
+func (h *Handler) ItemExists(ctx context.Context, roomID, itemID string) (bool, error) {
+	rd, err := h.document(ctx, roomID)
+	if err != nil {
+		return false, err
+	}
+	defer rd.mu.Unlock()
+
+	return rd.doc.Exists(itemID), nil
+}
+
+// Item returns a live item of the room's itinerary.
+func (h *Handler) Item(ctx context.Context, roomID, itemID string) (ItemView, bool, error) {
+	rd, err := h.document(ctx, roomID)
+	if err != nil {
+		return ItemView{}, false, err
+	}
+	defer rd.mu.Unlock()
+
+	item, ok := rd.doc.Item(itemID)
+	return item, ok, nil
+}
+
+// document returns the local replica of a room, loading it from the store if
+// needed. The replica is returned locked; the caller must unlock rd.mu.
+func (h *Handler) document(ctx context.Context, roomID string) (*roomDocument, error) {
+	for {
+		h.docsMu.Lock()
+		rd, ok := h.docs[roomID]
+		if !ok {
+			// Register before loading so that remote ops arriving
+			// meanwhile wait for the load instead of being dropped.
+			rd = &roomDocument{}
+			h.docs[roomID] = rd
+		}
+		h.docsMu.Unlock()
 
-	if lock, exists := h.locks[itemID]; exists && lock.UserID == userID {
-		delete(h.locks, itemID)
-		log.Printf("Item %s unlocked by user %s", itemID, userID)
+		rd.mu.Lock()
+		if rd.evicted {
+			// Evicted between the lookup and the lock
+			rd.mu.Unlock()
+			continue
+		}
+		rd.used = time.Now()
+		return h.load(ctx, roomID, rd)
 	}
 }
 
-// cleanupExpiredLocks periodically removes expired locks.
-func (h *Handler) cleanupExpiredLocks() {
-	ticker := time.NewTicker(1 * time.Minute)
+// load fills a new replica from the store. rd must be locked; it is
+// unlocked if loading fails.
+func (h *Handler) load(ctx context.Context, roomID string, rd *roomDocument) (*roomDocument, error) {
+	if rd.doc != nil {
+		return rd, nil
+	}
+
+	snap, ops, err := h.store.Load(ctx, roomID)
+	if err != nil {
+		rd.mu.Unlock()
+		return nil, fmt.Errorf("load itinerary for room %s: %w", roomID, err)
+	}
+
+	doc := NewDocument(roomID)
+	if snap != nil && snap.Document != nil {
+		doc = snap.Document
+		doc.RoomID = roomID
+		if doc.Items == nil {
+			doc.Items = make(map[string]*ItemState)
+		}
+	}
+	for i := range ops {
+		doc.Apply(&ops[i])
+	}
+	rd.doc = doc
+	rd.applied = int64(len(ops))
+	if snap != nil {
+		rd.applied += snap.LogLength
+	}
+	rd.sinceSnapshot = len(ops)
+
+	return rd, nil
+}
+
+// evictIdleDocuments drops replicas of rooms not used locally for
+// docIdleTTL, so that memory does not grow with every room the instance
+// has served.
+func (h *Handler) evictIdleDocuments() {
+	ticker := time.NewTicker(docSweepInterval)
 	defer ticker.Stop()
 
-	for range ticker.C {
-		h.mu.Lock()
-		now := time.Now()
-		for itemID, lock := range h.locks {
-			if now.After(lock.ExpiresAt) {
-				delete(h.locks, itemID)
-				log.Printf("Lock expired: item=%s user=%s", itemID, lock.UserID)
-			}
+	for now := range ticker.C {
+		h.evictIdle(now.Add(-docIdleTTL))
+	}
+}
+
+// evictIdle drops the replicas last used before cutoff. Replicas in use
+// are skipped and looked at again on the next sweep.
+func (h *Handler) evictIdle(cutoff time.Time) {
+	h.docsMu.Lock()
+	defer h.docsMu.Unlock()
+
+	evicted := 0
+	for roomID, rd := range h.docs {
+		if !rd.mu.TryLock() {
+			continue
+		}
+		if rd.used.Before(cutoff) {
+			delete(h.docs, roomID)
+			rd.evicted = true
+			evicted++
 		}
-		h.mu.Unlock()
+		rd.mu.Unlock()
+	}
+	if evicted > 0 {
+		log.Printf("Evicted %d idle itinerary replicas, %d remain", evicted, len(h.docs))
 	}
 }
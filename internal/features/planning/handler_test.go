@@ -0,0 +1,181 @@
+package planning
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/rally-go/rally-realtime/internal/rooms"
+)
+
+// newReplicas returns handlers standing for server instances that share a
+// store. Ops are not exchanged between them unless a test calls
+// ApplyRemote, as if their pub/sub messages were late.
+func newReplicas(n int) ([]*Handler, *MemoryStore) {
+	store := NewMemoryStore()
+	members := rooms.NewMemoryStore()
+	handlers := make([]*Handler, n)
+	for i := range handlers {
+		handlers[i] = NewHandler(members, store)
+	}
+	return handlers, store
+}
+
+func process(t *testing.T, h *Handler, userID, roomID string, payload any) *PlanningAction {
+	t.Helper()
+	action, err := processErr(h, userID, roomID, payload)
+	if err != nil {
+		t.Fatalf("ProcessAction(%v): %v", payload, err)
+	}
+	return action
+}
+
+func processErr(h *Handler, userID, roomID string, payload any) (*PlanningAction, error) {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+	return h.ProcessAction(context.Background(), userID, roomID, data)
+}
+
+func insert(itemID, title string) map[string]any {
+	return map[string]any{"action": ActionInsert, "item_id": itemID, "data": map[string]any{"title": title}}
+}
+
+func itemIDs(view *DocumentView) map[string]bool {
+	ids := make(map[string]bool, len(view.Items))
+	for _, it := range view.Items {
+		ids[it.ID] = true
+	}
+	return ids
+}
+
+func TestSnapshotIncludesLaggingRemoteOps(t *testing.T) {
+	ctx := context.Background()
+	replicas, store := newReplicas(2)
+	a, b := replicas[0], replicas[1]
+
+	// a loads the room, then b logs an edit whose broadcast never reaches
+	// a, while a logs enough edits of its own to save a snapshot.
+	if _, err := a.Document(ctx, "room"); err != nil {
+		t.Fatal(err)
+	}
+	process(t, b, "bob", "room", insert("from-b", "Dinner"))
+	for i := range snapshotEvery / 2 {
+		process(t, a, "alice", "room", insert(fmt.Sprintf("from-a-%d", i), "Stop"))
+	}
+
+	snap, _, err := store.Load(ctx, "room")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if snap == nil {
+		t.Fatal("no snapshot saved")
+	}
+	if !snap.Document.Exists("from-b") {
+		t.Errorf("snapshot covering %d ops lacks the op logged by the other replica", snap.LogLength)
+	}
+
+	// A replica started afterwards loads the snapshot and sees every edit.
+	view, err := NewHandler(rooms.NewMemoryStore(), store).Document(ctx, "room")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ids := itemIDs(view)
+	if len(ids) != snapshotEvery/2+1 || !ids["from-b"] {
+		t.Errorf("reloaded document has %d items (from-b: %v), want %d", len(ids), ids["from-b"], snapshotEvery/2+1)
+	}
+}
+
+func TestRemoteOpsConverge(t *testing.T) {
+	ctx := context.Background()
+	replicas, _ := newReplicas(2)
+	a, b := replicas[0], replicas[1]
+	for _, h := range replicas {
+		if _, err := h.Document(ctx, "room"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	fromA := process(t, a, "alice", "room", insert("x", "Temple"))
+	fromB := process(t, b, "bob", "room", insert("y", "Market"))
+
+	// Deliver the broadcasts in opposite orders, one of them twice.
+	a.ApplyRemote("room", mustMarshal(fromB))
+	b.ApplyRemote("room", mustMarshal(fromA))
+	b.ApplyRemote("room", mustMarshal(fromA))
+
+	va, _ := a.Document(ctx, "room")
+	vb, _ := b.Document(ctx, "room")
+	if string(mustMarshal(va)) != string(mustMarshal(vb)) {
+		t.Errorf("replicas diverged:\n%s\n%s", mustMarshal(va), mustMarshal(vb))
+	}
+	if len(va.Items) != 2 {
+		t.Errorf("got %d items, want 2", len(va.Items))
+	}
+}
+
+func TestStaleUpdateRejectedAcrossReplicas(t *testing.T) {
+	replicas, _ := newReplicas(2)
+	a, b := replicas[0], replicas[1]
+
+	created := process(t, a, "alice", "room", insert("x", "Temple"))
+	b.ApplyRemote("room", mustMarshal(created))
+
+	// Both replicas accept an update based on the same version locally; b
+	// has not received a's update yet.
+	update := func(title string) map[string]any {
+		return map[string]any{"action": ActionUpdate, "item_id": "x", "base_version": created.Version, "data": map[string]any{"title": title}}
+	}
+	updated := process(t, a, "alice", "room", update("Wat Pho"))
+
+	_, err := processErr(b, "bob", "room", update("Wat Arun"))
+	var stale *StaleVersionError
+	if !errors.As(err, &stale) {
+		t.Fatalf("concurrent update on another replica: err = %v, want *StaleVersionError", err)
+	}
+	if stale.Current.Version != updated.Version {
+		t.Errorf("current version = %d, want %d", stale.Current.Version, updated.Version)
+	}
+	if got := string(stale.Current.Fields["title"]); got != `"Wat Pho"` {
+		t.Errorf("current title = %s, want the accepted update", got)
+	}
+
+	// Rebased on the version it was given, the update goes through.
+	rebased := update("Wat Arun")
+	rebased["base_version"] = stale.Current.Version
+	if _, err := processErr(b, "bob", "room", rebased); err != nil {
+		t.Errorf("rebased update: %v", err)
+	}
+}
+
+func TestEvictIdleDocuments(t *testing.T) {
+	replicas, _ := newReplicas(1)
+	h := replicas[0]
+
+	created := process(t, h, "alice", "room", insert("a", "Temple"))
+	process(t, h, "alice", "other", insert("b", "Market"))
+
+	// Only replicas unused since the cutoff go.
+	cutoff := time.Now()
+	if _, err := h.Document(context.Background(), "other"); err != nil {
+		t.Fatal(err)
+	}
+	h.evictIdle(cutoff)
+	h.docsMu.Lock()
+	_, room := h.docs["room"]
+	_, other := h.docs["other"]
+	h.docsMu.Unlock()
+	if room || !other {
+		t.Fatalf("after eviction: room cached %v, other cached %v; want only other", room, other)
+	}
+
+	// An evicted room is loaded again with its edits.
+	process(t, h, "alice", "room", map[string]any{"action": ActionUpdate, "item_id": "a", "base_version": created.Version, "data": map[string]any{"title": "Wat Pho"}})
+	if got := titles(t, h, "room"); len(got) != 1 || got[0] != "Wat Pho" {
+		t.Errorf("titles after reload = %q, want [Wat Pho]", got)
+	}
+}
@@ -0,0 +1,344 @@
+package planning
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// Limits for itinerary item fields.
+const (
+	maxTitleRunes = 200
+	maxNotesRunes = 5000
+	maxAssignees  = 50
+)
+
+// Item is the schema of an itinerary item. Each top-level field is stored in
+// its own CRDT register, so concurrent edits to different fields merge.
+type Item struct {
+	Title     string   `json:"title"`
+	Start     *string  `json:"start,omitempty"`     // RFC 3339, e.g. "2026-03-01T09:00:00+07:00"
+	End       *string  `json:"end,omitempty"`       // RFC 3339
+	TimeZone  string   `json:"time_zone,omitempty"` // IANA name, e.g. "Asia/Bangkok"
+	Place     *Place   `json:"place,omitempty"`
+	Cost      *Cost    `json:"cost,omitempty"`
+	Assignees []string `json:"assignees,omitempty"` // user IDs
+	Notes     string   `json:"notes,omitempty"`
+
+	// OrderKey is the item's position key. It is read-only; use the move
+	// action to reorder items.
+	OrderKey string `json:"order_key,omitempty"`
+}
+
+// Place is where an itinerary item happens.
+type Place struct {
+	Name      string   `json:"name"`
+	Address   string   `json:"address,omitempty"`
+	Latitude  *float64 `json:"latitude,omitempty"`
+	Longitude *float64 `json:"longitude,omitempty"`
+}
+
+// Cost is the expected cost of an itinerary item.
+type Cost struct {
+	Amount   float64 `json:"amount"`
+	Currency string  `json:"currency"` // ISO 4217, e.g. "THB"
+}
+
+// itemFields lists the top-level fields clients may write.
+var itemFields = map[string]bool{
+	"title": true, "start": true, "end": true, "time_zone": true,
+	"place": true, "cost": true, "assignees": true, "notes": true,
+}
+
+// ErrInvalidItem is returned when an item fails validation. The error is a
+// *ValidationError.
+var ErrInvalidItem = errors.New("invalid itinerary item")
+
+// FieldError describes a problem with one field.
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// ValidationError lists every field that failed validation.
+type ValidationError struct {
+	Fields []FieldError
+}
+
+func (e *ValidationError) Error() string {
+	msgs := make([]string, 0, len(e.Fields))
+	for _, f := range e.Fields {
+		msgs = append(msgs, f.Field+": "+f.Message)
+	}
+	return fmt.Sprintf("%v: %s", ErrInvalidItem, strings.Join(msgs, "; "))
+}
+
+func (e *ValidationError) Unwrap() error {
+	return ErrInvalidItem
+}
+
+func (e *ValidationError) add(field, format string, args ...any) {
+	e.Fields = append(e.Fields, FieldError{Field: field, Message: fmt.Sprintf(format, args...)})
+}
+
+var currencyPattern = regexp.MustCompile(`^[A-Z]{3}$`)
+
+// StartTime returns the parsed start time in the item's time zone.
+func (it *Item) StartTime() (time.Time, bool) {
+	return it.parseTime(it.Start)
+}
+
+// EndTime returns the parsed end time in the item's time zone.
+func (it *Item) EndTime() (time.Time, bool) {
+	return it.parseTime(it.End)
+}
+
+func (it *Item) parseTime(value *string) (time.Time, bool) {
+	if value == nil {
+		return time.Time{}, false
+	}
+	t, err := time.Parse(time.RFC3339, *value)
+	if err != nil {
+		return time.Time{}, false
+	}
+	if loc, err := time.LoadLocation(it.TimeZone); err == nil && it.TimeZone != "" {
+		t = t.In(loc)
+	}
+	return t, true
+}
+
+// Validate checks the item against the schema.
+func (it *Item) Validate() error {
+	verr := &ValidationError{}
+
+	title := strings.TrimSpace(it.Title)
+	switch {
+	case title == "":
+		verr.add("title", "is required")
+	case utf8.RuneCountInString(title) > maxTitleRunes:
+		verr.add("title", "must be at most %d characters", maxTitleRunes)
+	}
+
+	if it.TimeZone != "" {
+		if _, err := time.LoadLocation(it.TimeZone); err != nil {
+			verr.add("time_zone", "unknown time zone %q", it.TimeZone)
+		}
+	} else if it.Start != nil || it.End != nil {
+		verr.add("time_zone", "is required when start or end is set")
+	}
+
+	var start, end time.Time
+	if it.Start != nil {
+		t, err := time.Parse(time.RFC3339, *it.Start)
+		if err != nil {
+			verr.add("start", "must be an RFC 3339 timestamp with offset")
+		}
+		start = t
+	}
+	if it.End != nil {
+		t, err := time.Parse(time.RFC3339, *it.End)
+		if err != nil {
+			verr.add("end", "must be an RFC 3339 timestamp with offset")
+		}
+		end = t
+	}
+	if !start.IsZero() && !end.IsZero() && end.Before(start) {
+		verr.add("end", "must not be before start")
+	}
+
+	if p := it.Place; p != nil {
+		if strings.TrimSpace(p.Name) == "" {
+			verr.add("place.name", "is required")
+		}
+		if (p.Latitude == nil) != (p.Longitude == nil) {
+			verr.add("place", "latitude and longitude must be set together")
+		}
+		if p.Latitude != nil && (*p.Latitude < -90 || *p.Latitude > 90) {
+			verr.add("place.latitude", "must be between -90 and 90")
+		}
+		if p.Longitude != nil && (*p.Longitude < -180 || *p.Longitude > 180) {
+			verr.add("place.longitude", "must be between -180 and 180")
+		}
+	}
+
+	if c := it.Cost; c != nil {
+		if c.Amount < 0 {
+			verr.add("cost.amount", "must not be negative")
+		}
+		if !currencyPattern.MatchString(c.Currency) {
+			verr.add("cost.currency", "must be a 3-letter ISO 4217 code")
+		}
+	}
+
+	if len(it.Assignees) > maxAssignees {
+		verr.add("assignees", "must have at most %d entries", maxAssignees)
+	}
+	seen := make(map[string]bool, len(it.Assignees))
+	for i, a := range it.Assignees {
+		switch {
+		case a == "":
+			verr.add(fmt.Sprintf("assignees[%d]", i), "must not be empty")
+		case seen[a]:
+			verr.add(fmt.Sprintf("assignees[%d]", i), "duplicate user %q", a)
+		}
+		seen[a] = true
+	}
+
+	if utf8.RuneCountInString(it.Notes) > maxNotesRunes {
+		verr.add("notes", "must be at most %d characters", maxNotesRunes)
+	}
+
+	if len(verr.Fields) > 0 {
+		return verr
+	}
+	return nil
+}
+
+// decodeItem builds an Item from per-field raw values, reporting type errors
+// per field.
+func decodeItem(fields map[string]json.RawMessage, orderKey string) (*Item, error) {
+	verr := &ValidationError{}
+	clean := make(map[string]json.RawMessage, len(fields))
+	for name, value := range fields {
+		if !itemFields[name] {
+			verr.add(name, "unknown field")
+			continue
+		}
+		if string(value) == "null" {
+			continue
+		}
+		clean[name] = value
+	}
+
+	item := &Item{OrderKey: orderKey}
+	for name, value := range clean {
+		// Decode each field on its own so errors name the field.
+		one, _ := json.Marshal(map[string]json.RawMessage{name: value})
+		dec := json.NewDecoder(bytes.NewReader(one))
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(item); err != nil {
+			verr.add(name, "has the wrong type: %v", decodeErrorDetail(err))
+		}
+	}
+
+	// The item holds every field that decoded, so callers can still
+	// validate it and report all problems at once.
+	if len(verr.Fields) > 0 {
+		return item, verr
+	}
+	return item, nil
+}
+
+func decodeErrorDetail(err error) string {
+	var typeErr *json.UnmarshalTypeError
+	if errors.As(err, &typeErr) {
+		return fmt.Sprintf("expected %s, got %s", typeErr.Type, typeErr.Value)
+	}
+	return err.Error()
+}
+
+// Item decodes the view's fields into the item schema.
+func (v ItemView) Item() (*Item, error) {
+	item, err := decodeItem(v.Fields, v.Position)
+	if err != nil {
+		return nil, err
+	}
+	return item, nil
+}
+
+// mergeItemPatch applies an RFC 7396 JSON Merge Patch to an item's fields.
+// It returns the new raw value of every top-level field the patch touches
+// (JSON null for removed fields) and the complete merged item.
+func mergeItemPatch(current map[string]json.RawMessage, orderKey string, patch json.RawMessage) (map[string]json.RawMessage, *Item, error) {
+	var p map[string]json.RawMessage
+	if err := json.Unmarshal(patch, &p); err != nil || p == nil {
+		return nil, nil, &ValidationError{Fields: []FieldError{{Field: "data", Message: "must be a JSON object"}}}
+	}
+	if _, ok := p["order_key"]; ok {
+		return nil, nil, &ValidationError{Fields: []FieldError{{Field: "order_key", Message: "is read-only; use the move action"}}}
+	}
+
+	changed := make(map[string]json.RawMessage, len(p))
+	merged := make(map[string]json.RawMessage, len(current)+len(p))
+	for name, value := range current {
+		merged[name] = value
+	}
+	for name, value := range p {
+		next, err := mergePatch(current[name], value)
+		if err != nil {
+			return nil, nil, &ValidationError{Fields: []FieldError{{Field: name, Message: err.Error()}}}
+		}
+		changed[name] = next
+		merged[name] = next
+	}
+
+	item, err := decodeItem(merged, orderKey)
+	var verr *ValidationError
+	if !errors.As(err, &verr) {
+		verr = &ValidationError{}
+	}
+	var rules *ValidationError
+	if errors.As(item.Validate(), &rules) {
+		verr.Fields = append(verr.Fields, rules.Fields...)
+	}
+	if len(verr.Fields) > 0 {
+		sort.SliceStable(verr.Fields, func(i, j int) bool { return verr.Fields[i].Field < verr.Fields[j].Field })
+		return nil, nil, verr
+	}
+	return changed, item, nil
+}
+
+// mergePatch implements RFC 7396 for a single value.
+func mergePatch(target, patch json.RawMessage) (json.RawMessage, error) {
+	p, err := decodeJSON(patch)
+	if err != nil {
+		return nil, err
+	}
+	pm, ok := p.(map[string]any)
+	if !ok {
+		return patch, nil
+	}
+
+	var t any
+	if len(target) > 0 {
+		if t, err = decodeJSON(target); err != nil {
+			return nil, err
+		}
+	}
+	return json.Marshal(mergeValue(t, pm))
+}
+
+func mergeValue(target any, patch map[string]any) map[string]any {
+	out, ok := target.(map[string]any)
+	if !ok {
+		out = make(map[string]any)
+	}
+	for k, v := range patch {
+		switch pv := v.(type) {
+		case nil:
+			delete(out, k)
+		case map[string]any:
+			out[k] = mergeValue(out[k], pv)
+		default:
+			out[k] = v
+		}
+	}
+	return out
+}
+
+// decodeJSON decodes data keeping numbers exact.
+func decodeJSON(data json.RawMessage) (any, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var v any
+	if err := dec.Decode(&v); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
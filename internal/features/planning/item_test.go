@@ -0,0 +1,203 @@
+package planning
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// fieldNames returns the fields a validation error names, in order.
+func fieldNames(t *testing.T, err error) []string {
+	t.Helper()
+
+	if err == nil {
+		return nil
+	}
+	var verr *ValidationError
+	if !errors.As(err, &verr) {
+		t.Fatalf("error %v is not a *ValidationError", err)
+	}
+	if !errors.Is(err, ErrInvalidItem) {
+		t.Errorf("error %v does not wrap ErrInvalidItem", err)
+	}
+	names := make([]string, 0, len(verr.Fields))
+	for _, f := range verr.Fields {
+		names = append(names, f.Field)
+	}
+	return names
+}
+
+func ptr[T any](v T) *T { return &v }
+
+func TestItemValidate(t *testing.T) {
+	valid := func() Item {
+		return Item{
+			Title:     "Grand Palace",
+			Start:     ptr("2026-03-01T09:00:00+07:00"),
+			End:       ptr("2026-03-01T11:30:00+07:00"),
+			TimeZone:  "Asia/Bangkok",
+			Place:     &Place{Name: "Grand Palace", Latitude: ptr(13.75), Longitude: ptr(100.49)},
+			Cost:      &Cost{Amount: 500, Currency: "THB"},
+			Assignees: []string{"alice", "bob"},
+			Notes:     "Dress code: covered shoulders",
+		}
+	}
+
+	tests := []struct {
+		name   string
+		change func(*Item)
+		want   []string
+	}{
+		{name: "valid", change: func(*Item) {}},
+		{name: "title only", change: func(it *Item) { *it = Item{Title: "Free day"} }},
+		{name: "missing title", change: func(it *Item) { it.Title = "  " }, want: []string{"title"}},
+		{name: "title too long", change: func(it *Item) { it.Title = strings.Repeat("ก", maxTitleRunes+1) }, want: []string{"title"}},
+		{name: "title at the limit", change: func(it *Item) { it.Title = strings.Repeat("ก", maxTitleRunes) }},
+		{name: "time zone required with times", change: func(it *Item) { it.TimeZone = "" }, want: []string{"time_zone"}},
+		{name: "unknown time zone", change: func(it *Item) { it.TimeZone = "Asia/Atlantis" }, want: []string{"time_zone"}},
+		{name: "start without offset", change: func(it *Item) { it.Start = ptr("2026-03-01T09:00:00") }, want: []string{"start"}},
+		{name: "end before start", change: func(it *Item) { it.End = ptr("2026-03-01T08:00:00+07:00") }, want: []string{"end"}},
+		{name: "end compared across offsets", change: func(it *Item) { it.End = ptr("2026-03-01T02:30:00Z") }},
+		{name: "place without name", change: func(it *Item) { it.Place.Name = "" }, want: []string{"place.name"}},
+		{name: "latitude without longitude", change: func(it *Item) { it.Place.Longitude = nil }, want: []string{"place"}},
+		{name: "latitude out of range", change: func(it *Item) { it.Place.Latitude = ptr(91.0) }, want: []string{"place.latitude"}},
+		{name: "longitude out of range", change: func(it *Item) { it.Place.Longitude = ptr(-181.0) }, want: []string{"place.longitude"}},
+		{name: "negative cost", change: func(it *Item) { it.Cost.Amount = -1 }, want: []string{"cost.amount"}},
+		{name: "lowercase currency", change: func(it *Item) { it.Cost.Currency = "thb" }, want: []string{"cost.currency"}},
+		{name: "empty assignee", change: func(it *Item) { it.Assignees = []string{"alice", ""} }, want: []string{"assignees[1]"}},
+		{name: "duplicate assignee", change: func(it *Item) { it.Assignees = []string{"alice", "alice"} }, want: []string{"assignees[1]"}},
+		{name: "too many assignees", change: func(it *Item) {
+			it.Assignees = nil
+			for i := 0; i <= maxAssignees; i++ {
+				it.Assignees = append(it.Assignees, strings.Repeat("u", i+1))
+			}
+		}, want: []string{"assignees"}},
+		{name: "notes too long", change: func(it *Item) { it.Notes = strings.Repeat("x", maxNotesRunes+1) }, want: []string{"notes"}},
+		{name: "every problem reported", change: func(it *Item) {
+			it.Title = ""
+			it.Cost.Currency = "baht"
+			it.Place.Latitude = ptr(100.0)
+		}, want: []string{"title", "place.latitude", "cost.currency"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			item := valid()
+			tt.change(&item)
+			if got := fieldNames(t, item.Validate()); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Validate() fields = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDecodeItem(t *testing.T) {
+	fields := map[string]json.RawMessage{
+		"title":     json.RawMessage(`"Temple"`),
+		"notes":     json.RawMessage(`null`),
+		"assignees": json.RawMessage(`["alice"]`),
+	}
+	item, err := decodeItem(fields, "m")
+	if err != nil {
+		t.Fatalf("decodeItem: %v", err)
+	}
+	want := &Item{Title: "Temple", Assignees: []string{"alice"}, OrderKey: "m"}
+	if !reflect.DeepEqual(item, want) {
+		t.Errorf("decodeItem = %+v, want %+v", item, want)
+	}
+
+	// Every field that fails is named, and the rest still decodes.
+	item, err = decodeItem(map[string]json.RawMessage{
+		"title":    json.RawMessage(`"Temple"`),
+		"cost":     json.RawMessage(`"500 THB"`),
+		"place":    json.RawMessage(`{"name":"Wat Pho","floor":2}`),
+		"priority": json.RawMessage(`1`),
+	}, "")
+	got := fieldNames(t, err)
+	for _, name := range []string{"cost", "place", "priority"} {
+		if !strings.Contains(strings.Join(got, " "), name) {
+			t.Errorf("decodeItem error fields = %v, want %s among them", got, name)
+		}
+	}
+	if item == nil || item.Title != "Temple" {
+		t.Errorf("decodeItem with errors returned %+v, want the fields that decoded", item)
+	}
+}
+
+func TestMergeItemPatch(t *testing.T) {
+	current := map[string]json.RawMessage{
+		"title": json.RawMessage(`"Temple"`),
+		"place": json.RawMessage(`{"name":"Wat Pho","address":"2 Sanam Chai Rd"}`),
+		"notes": json.RawMessage(`"Bring water"`),
+	}
+
+	tests := []struct {
+		name        string
+		patch       string
+		wantChanged map[string]string
+		wantErr     []string
+	}{
+		{
+			name:        "set a field",
+			patch:       `{"title":"Wat Pho"}`,
+			wantChanged: map[string]string{"title": `"Wat Pho"`},
+		},
+		{
+			name:        "nested fields merge",
+			patch:       `{"place":{"latitude":13.7465,"longitude":100.4927}}`,
+			wantChanged: map[string]string{"place": `{"address":"2 Sanam Chai Rd","latitude":13.7465,"longitude":100.4927,"name":"Wat Pho"}`},
+		},
+		{
+			name:        "null removes a nested field",
+			patch:       `{"place":{"address":null}}`,
+			wantChanged: map[string]string{"place": `{"name":"Wat Pho"}`},
+		},
+		{
+			name:        "null removes a field",
+			patch:       `{"notes":null}`,
+			wantChanged: map[string]string{"notes": `null`},
+		},
+		{name: "order key is read-only", patch: `{"order_key":"a"}`, wantErr: []string{"order_key"}},
+		{name: "not an object", patch: `["title"]`, wantErr: []string{"data"}},
+		{name: "null patch", patch: `null`, wantErr: []string{"data"}},
+		{name: "required field removed", patch: `{"title":null}`, wantErr: []string{"title"}},
+		{name: "unknown field", patch: `{"rating":5}`, wantErr: []string{"rating"}},
+		{
+			name:    "errors sorted by field",
+			patch:   `{"title":"","cost":{"amount":-1,"currency":"THB"},"place":{"name":""}}`,
+			wantErr: []string{"cost.amount", "place.name", "title"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			changed, item, err := mergeItemPatch(current, "m", json.RawMessage(tt.patch))
+			if tt.wantErr != nil {
+				if got := fieldNames(t, err); !reflect.DeepEqual(got, tt.wantErr) {
+					t.Errorf("error fields = %v, want %v", got, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("mergeItemPatch: %v", err)
+			}
+			got := make(map[string]string, len(changed))
+			for name, value := range changed {
+				got[name] = string(value)
+			}
+			if !reflect.DeepEqual(got, tt.wantChanged) {
+				t.Errorf("changed = %v, want %v", got, tt.wantChanged)
+			}
+			if item.OrderKey != "m" {
+				t.Errorf("merged item order key = %q, want %q", item.OrderKey, "m")
+			}
+		})
+	}
+
+	// The current fields are left alone.
+	if string(current["notes"]) != `"Bring water"` {
+		t.Errorf("current fields modified: notes = %s", current["notes"])
+	}
+}
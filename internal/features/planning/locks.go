@@ -0,0 +1,208 @@
+package planning
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"time"
+)
+
+// Lock timing.
+const (
+	// lockTTL is how long a lock lasts unless renewed by locking again.
+	lockTTL = 5 * time.Minute
+
+	// lockSweepInterval is how often expired locks are released.
+	lockSweepInterval = 5 * time.Second
+)
+
+// Lock event names.
+const (
+	LockLocked   = "locked"
+	LockUnlocked = "unlocked"
+	LockStolen   = "stolen"
+	LockExpired  = "expired"
+	LockSnapshot = "snapshot" // the room's full lock table, sent on join
+)
+
+// ItemLock represents a lock on an itinerary item.
+type ItemLock struct {
+	RoomID    string    `json:"-"`
+	ItemID    string    `json:"item_id"`
+	UserID    string    `json:"user_id"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// LockedError is returned when another user holds the item's lock.
+type LockedError struct {
+	Lock ItemLock
+}
+
+func (e *LockedError) Error() string {
+	return fmt.Sprintf("%v: held by %s until %s", ErrItemLocked, e.Lock.UserID, e.Lock.ExpiresAt.Format(time.RFC3339))
+}
+
+func (e *LockedError) Unwrap() error {
+	return ErrItemLocked
+}
+
+// LockEvent describes a change to a room's locks. For locked and stolen
+// UserID is the new holder; for unlocked and expired it is the user who
+// held the lock. PreviousUserID is set for stolen.
+type LockEvent struct {
+	Event          string     `json:"event"` // see Lock* constants
+	RoomID         string     `json:"room_id"`
+	ItemID         string     `json:"item_id,omitempty"`
+	UserID         string     `json:"user_id,omitempty"`
+	PreviousUserID string     `json:"previous_user_id,omitempty"`
+	ExpiresAt      *time.Time `json:"expires_at,omitempty"`
+	Locks          []ItemLock `json:"locks,omitempty"` // snapshot
+	Timestamp      time.Time  `json:"timestamp"`
+}
+
+// LockEvents delivers lock changes that no client action caused, currently
+// expiries. Each expiry is delivered on exactly one server instance.
+func (h *Handler) LockEvents() <-chan *LockEvent {
+	return h.lockEvents
+}
+
+// Locks returns the room's lock table as a snapshot event.
+func (h *Handler) Locks(ctx context.Context, roomID string) (*LockEvent, error) {
+	locks, err := h.store.Locks(ctx, roomID)
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(locks, func(i, j int) bool { return locks[i].ItemID < locks[j].ItemID })
+	if locks == nil {
+		locks = []ItemLock{}
+	}
+	return &LockEvent{Event: LockSnapshot, RoomID: roomID, Locks: locks, Timestamp: time.Now()}, nil
+}
+
+// checkLock returns a *LockedError if another user holds the item's lock.
+func (h *Handler) checkLock(ctx context.Context, roomID, itemID, userID string) error {
+	lock, err := h.store.Lock(ctx, roomID, itemID)
+	if err != nil {
+		return err
+	}
+	if lock != nil && lock.UserID != userID {
+		return &LockedError{Lock: *lock}
+	}
+	return nil
+}
+
+// lockItem acquires or renews userID's lock on an item. Moderators may
+// steal another user's lock.
+func (h *Handler) lockItem(ctx context.Context, roomID, userID, itemID string, steal bool) (*LockEvent, error) {
+	if itemID == "" {
+		return nil, fmt.Errorf("%w: item_id is required", ErrInvalidAction)
+	}
+	if steal {
+		role, err := h.members.Role(ctx, roomID, userID)
+		if err != nil {
+			return nil, err
+		}
+		if !role.CanModerate() {
+			return nil, ErrForbidden
+		}
+	}
+
+	now := time.Now()
+	lock := ItemLock{RoomID: roomID, ItemID: itemID, UserID: userID, ExpiresAt: now.Add(lockTTL)}
+	prev, acquired, err := h.store.AcquireLock(ctx, lock, steal)
+	if err != nil {
+		return nil, err
+	}
+	if !acquired {
+		return nil, &LockedError{Lock: *prev}
+	}
+
+	event := &LockEvent{
+		Event:     LockLocked,
+		RoomID:    roomID,
+		ItemID:    itemID,
+		UserID:    userID,
+		ExpiresAt: &lock.ExpiresAt,
+		Timestamp: now,
+	}
+	if prev != nil {
+		event.Event = LockStolen
+		event.PreviousUserID = prev.UserID
+		log.Printf("Item %s lock taken from %s by %s", itemID, prev.UserID, userID)
+	} else {
+		log.Printf("Item %s locked by user %s", itemID, userID)
+	}
+	return event, nil
+}
+
+// unlockItem releases userID's lock on an item. It returns a nil event if
+// the user held no lock.
+func (h *Handler) unlockItem(ctx context.Context, roomID, userID, itemID string) (*LockEvent, error) {
+	released, err := h.store.ReleaseLock(ctx, roomID, itemID, userID)
+	if err != nil || !released {
+		return nil, err
+	}
+
+	log.Printf("Item %s unlocked by user %s", itemID, userID)
+	return &LockEvent{Event: LockUnlocked, RoomID: roomID, ItemID: itemID, UserID: userID, Timestamp: time.Now()}, nil
+}
+
+// ReleaseUserLocks releases every lock userID holds in the room, for when
+// the user's last connection to it closes. It returns one unlocked event per
+// released lock.
+func (h *Handler) ReleaseUserLocks(ctx context.Context, roomID, userID string) ([]*LockEvent, error) {
+	locks, err := h.store.Locks(ctx, roomID)
+	if err != nil {
+		return nil, err
+	}
+
+	var events []*LockEvent
+	for _, lock := range locks {
+		if lock.UserID != userID {
+			continue
+		}
+		event, err := h.unlockItem(ctx, roomID, userID, lock.ItemID)
+		if err != nil {
+			return events, err
+		}
+		if event != nil {
+			events = append(events, event)
+		}
+	}
+	return events, nil
+}
+
+// cleanupExpiredLocks periodically removes expired locks and reports them on
+// LockEvents.
+func (h *Handler) cleanupExpiredLocks() {
+	ticker := time.NewTicker(lockSweepInterval)
+	defer ticker.Stop()
+
+	for now := range ticker.C {
+		h.expireLocks(now)
+	}
+}
+
+// expireLocks removes the locks that expired at or before now and reports
+// each on LockEvents.
+func (h *Handler) expireLocks(now time.Time) {
+	ctx, cancel := context.WithTimeout(context.Background(), lockSweepInterval)
+	expired, err := h.store.ExpireLocks(ctx, now)
+	cancel()
+	if err != nil {
+		log.Printf("Failed to expire locks: %v", err)
+	}
+
+	for _, lock := range expired {
+		log.Printf("Lock expired: room=%s item=%s user=%s", lock.RoomID, lock.ItemID, lock.UserID)
+		h.lockEvents <- &LockEvent{
+			Event:     LockExpired,
+			RoomID:    lock.RoomID,
+			ItemID:    lock.ItemID,
+			UserID:    lock.UserID,
+			Timestamp: now,
+		}
+	}
+}
@@ -0,0 +1,111 @@
+package planning
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// newLockRoom returns a handler whose room has an item "a", with alice as
+// its owner and bob as a member.
+func newLockRoom(t *testing.T) *Handler {
+	t.Helper()
+
+	replicas, _ := newReplicas(1)
+	h := replicas[0]
+	for _, user := range []string{"alice", "bob"} {
+		if err := h.members.AddMember(context.Background(), "room", user); err != nil {
+			t.Fatal(err)
+		}
+	}
+	process(t, h, "alice", "room", insert("a", "Temple"))
+	return h
+}
+
+func lockAction(action string, steal bool) map[string]any {
+	return map[string]any{"action": action, "item_id": "a", "steal": steal}
+}
+
+func TestLocks(t *testing.T) {
+	h := newLockRoom(t)
+
+	locked := process(t, h, "alice", "room", lockAction(ActionLock, false))
+	if e := locked.Lock; e == nil || e.Event != LockLocked || e.UserID != "alice" || e.ExpiresAt == nil {
+		t.Fatalf("lock event = %+v, want locked by alice with an expiry", e)
+	}
+
+	// Others can neither lock, edit, unlock nor, as members, steal.
+	for _, tc := range []struct {
+		name    string
+		payload map[string]any
+		want    error
+	}{
+		{"lock", lockAction(ActionLock, false), ErrItemLocked},
+		{"edit", map[string]any{"action": ActionDelete, "item_id": "a"}, ErrItemLocked},
+		{"unlock", lockAction(ActionUnlock, false), ErrItemLocked},
+		{"steal", lockAction(ActionLock, true), ErrForbidden},
+	} {
+		_, err := processErr(h, "bob", "room", tc.payload)
+		if !errors.Is(err, tc.want) {
+			t.Errorf("bob's %s: error = %v, want %v", tc.name, err, tc.want)
+		}
+		var held *LockedError
+		if errors.As(err, &held) && held.Lock.UserID != "alice" {
+			t.Errorf("bob's %s: lock held by %q, want alice", tc.name, held.Lock.UserID)
+		}
+	}
+
+	unlocked := process(t, h, "alice", "room", lockAction(ActionUnlock, false))
+	if e := unlocked.Lock; e == nil || e.Event != LockUnlocked || e.UserID != "alice" {
+		t.Fatalf("unlock event = %+v, want unlocked by alice", e)
+	}
+	if again := process(t, h, "alice", "room", lockAction(ActionUnlock, false)); again.Lock != nil {
+		t.Errorf("unlocking an unlocked item: event = %+v, want none", again.Lock)
+	}
+
+	// The owner may take bob's lock.
+	process(t, h, "bob", "room", lockAction(ActionLock, false))
+	stolen := process(t, h, "alice", "room", lockAction(ActionLock, true))
+	if e := stolen.Lock; e == nil || e.Event != LockStolen || e.UserID != "alice" || e.PreviousUserID != "bob" {
+		t.Fatalf("steal event = %+v, want stolen by alice from bob", e)
+	}
+
+	snapshot, err := h.Locks(context.Background(), "room")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if snapshot.Event != LockSnapshot || len(snapshot.Locks) != 1 || snapshot.Locks[0].UserID != "alice" {
+		t.Errorf("lock table = %+v, want alice's lock only", snapshot)
+	}
+}
+
+func TestLockExpiry(t *testing.T) {
+	h := newLockRoom(t)
+	process(t, h, "alice", "room", lockAction(ActionLock, false))
+
+	// Locks live until lockTTL has passed.
+	h.expireLocks(time.Now())
+	if _, err := processErr(h, "bob", "room", lockAction(ActionLock, false)); !errors.Is(err, ErrItemLocked) {
+		t.Fatalf("lock before expiry: error = %v, want %v", err, ErrItemLocked)
+	}
+
+	h.expireLocks(time.Now().Add(lockTTL + time.Second))
+	select {
+	case e := <-h.LockEvents():
+		if e.Event != LockExpired || e.RoomID != "room" || e.ItemID != "a" || e.UserID != "alice" {
+			t.Errorf("expiry event = %+v, want alice's lock on a expired", e)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("no expiry event")
+	}
+
+	snapshot, err := h.Locks(context.Background(), "room")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(snapshot.Locks) != 0 {
+		t.Errorf("lock table after expiry = %+v, want empty", snapshot.Locks)
+	}
+	process(t, h, "bob", "room", lockAction(ActionLock, false))
+}
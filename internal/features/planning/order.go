@@ -0,0 +1,68 @@
+package planning
+
+// Position keys order itinerary items. They are strings over orderDigits
+// compared byte-wise, read as base-62 fractions in (0, 1), so there is always
+// room for a key between two others. Keys never end in the zero digit.
+const orderDigits = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
+
+const orderBase = len(orderDigits)
+
+func orderDigit(key string, i int) int {
+	if i >= len(key) {
+		return 0
+	}
+	for d := 0; d < orderBase; d++ {
+		if orderDigits[d] == key[i] {
+			return d
+		}
+	}
+	return 0
+}
+
+// keyBetween returns a position key that sorts strictly after a and before b.
+// An empty a means the start of the list and an empty b the end.
+//
+// Concurrent inserts at the same spot can produce equal keys; items are then
+// ordered by ID. If a is not less than b (which can only happen in that case)
+// the result sorts after a.
+func keyBetween(a, b string) string {
+	if b != "" && a >= b {
+		b = ""
+	}
+
+	// Appending and prepending step by one digit away from the neighbour
+	// instead of halving the gap, so that keys grow slowly when items are
+	// repeatedly added at either end.
+	atEnd := b == ""
+	atStart := a == ""
+	upperOpen := b == ""
+
+	var out []byte
+	for i := 0; ; i++ {
+		da := orderDigit(a, i)
+		db := orderBase
+		if !upperOpen {
+			db = orderDigit(b, i)
+		}
+
+		switch {
+		case da == db:
+			out = append(out, orderDigits[da])
+		case db-da > 1:
+			d := (da + db) / 2
+			switch {
+			case atStart && atEnd:
+			case atStart:
+				d = db - 1
+			case atEnd || upperOpen:
+				d = da + 1
+			}
+			return string(append(out, orderDigits[d]))
+		default:
+			// No digit fits here: keep a's digit and look for room in the
+			// next position, where b no longer constrains the result.
+			out = append(out, orderDigits[da])
+			upperOpen = true
+		}
+	}
+}
@@ -0,0 +1,826 @@
+package planning
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Snapshot is a persisted document together with the length of the op log it
+// includes. Ops after LogLength must be replayed on load; replaying ops that
+// are already included is harmless because applying ops is idempotent.
+type Snapshot struct {
+	Document  *Document `json:"document"`
+	LogLength int64     `json:"log_length"`
+}
+
+// VersionCheck makes AppendOps conditional on the version of one item.
+type VersionCheck struct {
+	ItemID  string
+	Version uint64
+}
+
+// errVersionConflict is returned by AppendOps when the checked item's
+// version in the log is not the expected one.
+var errVersionConflict = errors.New("item version changed in the log")
+
+// DocumentStore persists itinerary documents as snapshots plus an op log.
+//
+// Alongside the log it keeps each item's version, the highest op counter
+// logged for it, which is the version every replica converges to. Version
+// checks against it are atomic with the append, so that two instances
+// cannot both accept an edit based on the same version.
+type DocumentStore interface {
+	// AppendOps appends ops to the room's log and returns the new log
+	// length. If check is not nil and the item has a logged version other
+	// than check.Version, nothing is appended and errVersionConflict is
+	// returned. Items logged before versions were kept pass any check.
+	AppendOps(ctx context.Context, roomID string, ops []Op, check *VersionCheck) (int64, error)
+
+	// Load returns the latest snapshot (nil if none) and every op logged
+	// after it.
+	Load(ctx context.Context, roomID string) (*Snapshot, []Op, error)
+
+	// OpsSince returns every op logged at or after index start.
+	OpsSince(ctx context.Context, roomID string, start int64) ([]Op, error)
+
+	// SaveSnapshot stores a snapshot if it covers more of the log than the
+	// current one.
+	SaveSnapshot(ctx context.Context, roomID string, snap *Snapshot) error
+}
+
+// UndoStack names a per-user stack of audit entries.
+type UndoStack string
+
+const (
+	StackUndo UndoStack = "undo"
+	StackRedo UndoStack = "redo"
+)
+
+// maxUndoDepth is how many entries a user's undo or redo stack keeps.
+const maxUndoDepth = 50
+
+// AuditStore persists the audit log and the per-user undo and redo stacks.
+type AuditStore interface {
+	// AppendAudit records an accepted action.
+	AppendAudit(ctx context.Context, entry *AuditEntry) error
+
+	// History returns up to limit of the most recent entries of the room,
+	// or of one item if itemID is not empty, newest first.
+	History(ctx context.Context, roomID, itemID string, limit int) ([]AuditEntry, error)
+
+	// PushStack pushes entry onto the user's stack, dropping the oldest
+	// entries beyond maxUndoDepth.
+	PushStack(ctx context.Context, roomID, userID string, stack UndoStack, entry *AuditEntry) error
+
+	// PopStack pops the most recent entry, or returns nil if the stack is
+	// empty.
+	PopStack(ctx context.Context, roomID, userID string, stack UndoStack) (*AuditEntry, error)
+
+	// ClearStack empties the user's stack.
+	ClearStack(ctx context.Context, roomID, userID string, stack UndoStack) error
+}
+
+// LockStore holds item locks. Locks are shared by all server instances so
+// that every instance enforces them and can list them.
+type LockStore interface {
+	// AcquireLock gives lock to its user unless another user holds an
+	// unexpired lock on the item and steal is false. It returns the other
+	// user's unexpired lock, if any: the holder when refused, or the lock
+	// that was taken over.
+	AcquireLock(ctx context.Context, lock ItemLock, steal bool) (prev *ItemLock, acquired bool, err error)
+
+	// ReleaseLock removes userID's lock on the item. It returns
+	// ErrItemLocked if another user holds an unexpired lock, and false if
+	// there was nothing to release.
+	ReleaseLock(ctx context.Context, roomID, itemID, userID string) (bool, error)
+
+	// Lock returns the unexpired lock on the item, or nil.
+	Lock(ctx context.Context, roomID, itemID string) (*ItemLock, error)
+
+	// Locks returns every unexpired lock in the room.
+	Locks(ctx context.Context, roomID string) ([]ItemLock, error)
+
+	// ExpireLocks removes locks that expired at or before now and returns
+	// them. Each expired lock is returned to exactly one caller.
+	ExpireLocks(ctx context.Context, now time.Time) ([]ItemLock, error)
+}
+
+// Store is everything the planning handler persists.
+type Store interface {
+	DocumentStore
+	AuditStore
+	LockStore
+}
+
+// MemoryStore implements Store in process memory.
+// It is only suitable for a single server instance or local development.
+type MemoryStore struct {
+	logs      map[string][]Op
+	versions  map[string]uint64 // "room\x00item" -> logged version
+	snapshots map[string][]byte
+	audit     map[string][]AuditEntry // room -> entries, oldest first
+	stacks    map[string][]AuditEntry // "room\x00user\x00stack" -> entries
+	locks     map[string]ItemLock     // "room\x00item" -> lock
+	mu        sync.Mutex
+}
+
+// NewMemoryStore creates an empty in-memory document store.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		logs:      make(map[string][]Op),
+		versions:  make(map[string]uint64),
+		snapshots: make(map[string][]byte),
+		audit:     make(map[string][]AuditEntry),
+		stacks:    make(map[string][]AuditEntry),
+		locks:     make(map[string]ItemLock),
+	}
+}
+
+// AppendOps appends ops to the room's log.
+func (s *MemoryStore) AppendOps(ctx context.Context, roomID string, ops []Op, check *VersionCheck) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if check != nil {
+		if v, ok := s.versions[versionKey(roomID, check.ItemID)]; ok && v != check.Version {
+			return 0, errVersionConflict
+		}
+	}
+	for item, v := range opVersions(ops) {
+		key := versionKey(roomID, item)
+		if v > s.versions[key] {
+			s.versions[key] = v
+		}
+	}
+	s.logs[roomID] = append(s.logs[roomID], ops...)
+	return int64(len(s.logs[roomID])), nil
+}
+
+func versionKey(roomID, itemID string) string {
+	return roomID + "\x00" + itemID
+}
+
+// opVersions returns the highest counter of ops for each item.
+func opVersions(ops []Op) map[string]uint64 {
+	versions := make(map[string]uint64)
+	for _, op := range ops {
+		if op.Counter > versions[op.ItemID] {
+			versions[op.ItemID] = op.Counter
+		}
+	}
+	return versions
+}
+
+// Load returns the latest snapshot and the ops logged after it.
+func (s *MemoryStore) Load(ctx context.Context, roomID string) (*Snapshot, []Op, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var snap *Snapshot
+	if data, ok := s.snapshots[roomID]; ok {
+		// Stored encoded so that callers never share state with the store.
+		snap = &Snapshot{}
+		if err := json.Unmarshal(data, snap); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	log := s.logs[roomID]
+	start := 0
+	if snap != nil && snap.LogLength <= int64(len(log)) {
+		start = int(snap.LogLength)
+	}
+	return snap, append([]Op(nil), log[start:]...), nil
+}
+
+// OpsSince returns the ops logged at or after index start.
+func (s *MemoryStore) OpsSince(ctx context.Context, roomID string, start int64) ([]Op, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	log := s.logs[roomID]
+	if start >= int64(len(log)) {
+		return nil, nil
+	}
+	return append([]Op(nil), log[start:]...), nil
+}
+
+// SaveSnapshot stores a snapshot.
+func (s *MemoryStore) SaveSnapshot(ctx context.Context, roomID string, snap *Snapshot) error {
+	data, err := json.Marshal(snap)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if cur, ok := s.snapshots[roomID]; ok {
+		var existing Snapshot
+		if err := json.Unmarshal(cur, &existing); err == nil && existing.LogLength >= snap.LogLength {
+			return nil
+		}
+	}
+	s.snapshots[roomID] = data
+	return nil
+}
+
+// AppendAudit records an accepted action.
+func (s *MemoryStore) AppendAudit(ctx context.Context, entry *AuditEntry) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.audit[entry.RoomID] = append(s.audit[entry.RoomID], *entry)
+	return nil
+}
+
+// History returns the most recent entries, newest first.
+func (s *MemoryStore) History(ctx context.Context, roomID, itemID string, limit int) ([]AuditEntry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entries := s.audit[roomID]
+	var out []AuditEntry
+	for i := len(entries) - 1; i >= 0 && len(out) < limit; i-- {
+		if itemID == "" || entries[i].ItemID == itemID {
+			out = append(out, entries[i])
+		}
+	}
+	return out, nil
+}
+
+func stackKey(roomID, userID string, stack UndoStack) string {
+	return roomID + "\x00" + userID + "\x00" + string(stack)
+}
+
+// PushStack pushes entry onto the user's stack.
+func (s *MemoryStore) PushStack(ctx context.Context, roomID, userID string, stack UndoStack, entry *AuditEntry) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := stackKey(roomID, userID, stack)
+	entries := append(s.stacks[key], *entry)
+	if len(entries) > maxUndoDepth {
+		entries = entries[len(entries)-maxUndoDepth:]
+	}
+	s.stacks[key] = entries
+	return nil
+}
+
+// PopStack pops the most recent entry.
+func (s *MemoryStore) PopStack(ctx context.Context, roomID, userID string, stack UndoStack) (*AuditEntry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := stackKey(roomID, userID, stack)
+	entries := s.stacks[key]
+	if len(entries) == 0 {
+		return nil, nil
+	}
+	entry := entries[len(entries)-1]
+	s.stacks[key] = entries[:len(entries)-1]
+	return &entry, nil
+}
+
+// ClearStack empties the user's stack.
+func (s *MemoryStore) ClearStack(ctx context.Context, roomID, userID string, stack UndoStack) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.stacks, stackKey(roomID, userID, stack))
+	return nil
+}
+
+func lockKey(roomID, itemID string) string {
+	return roomID + "\x00" + itemID
+}
+
+// AcquireLock locks an item.
+func (s *MemoryStore) AcquireLock(ctx context.Context, lock ItemLock, steal bool) (*ItemLock, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := lockKey(lock.RoomID, lock.ItemID)
+	var prev *ItemLock
+	if cur, ok := s.locks[key]; ok && cur.UserID != lock.UserID && time.Now().Before(cur.ExpiresAt) {
+		prev = &cur
+		if !steal {
+			return prev, false, nil
+		}
+	}
+	s.locks[key] = lock
+	return prev, true, nil
+}
+
+// ReleaseLock unlocks an item.
+func (s *MemoryStore) ReleaseLock(ctx context.Context, roomID, itemID, userID string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := lockKey(roomID, itemID)
+	cur, ok := s.locks[key]
+	if !ok {
+		return false, nil
+	}
+	if cur.UserID != userID {
+		if time.Now().Before(cur.ExpiresAt) {
+			return false, &LockedError{Lock: cur}
+		}
+		return false, nil
+	}
+	delete(s.locks, key)
+	return true, nil
+}
+
+// Lock returns the unexpired lock on an item.
+func (s *MemoryStore) Lock(ctx context.Context, roomID, itemID string) (*ItemLock, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	cur, ok := s.locks[lockKey(roomID, itemID)]
+	if !ok || !time.Now().Before(cur.ExpiresAt) {
+		return nil, nil
+	}
+	return &cur, nil
+}
+
+// Locks returns the room's unexpired locks.
+func (s *MemoryStore) Locks(ctx context.Context, roomID string) ([]ItemLock, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	var locks []ItemLock
+	for _, lock := range s.locks {
+		if lock.RoomID == roomID && now.Before(lock.ExpiresAt) {
+			locks = append(locks, lock)
+		}
+	}
+	return locks, nil
+}
+
+// ExpireLocks removes and returns expired locks.
+func (s *MemoryStore) ExpireLocks(ctx context.Context, now time.Time) ([]ItemLock, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var expired []ItemLock
+	for key, lock := range s.locks {
+		if !now.Before(lock.ExpiresAt) {
+			expired = append(expired, lock)
+			delete(s.locks, key)
+		}
+	}
+	return expired, nil
+}
+
+// RedisStore implements Store using a Redis list for the op log, a hash of
+// item versions and a string key for the snapshot. The audit log is a list
+// per room and per item; undo and redo stacks are a list per user. Locks are
+// a hash per room, with a sorted set of expiry times shared by all rooms.
+type RedisStore struct {
+	client *redis.Client
+}
+
+// NewRedisStore creates a document store backed by the given Redis client.
+func NewRedisStore(client *redis.Client) *RedisStore {
+	return &RedisStore{client: client}
+}
+
+func opsKey(roomID string) string {
+	return "rally:plan:" + roomID + ":ops"
+}
+
+func versionsKey(roomID string) string {
+	return "rally:plan:" + roomID + ":versions"
+}
+
+func snapshotKey(roomID string) string {
+	return "rally:plan:" + roomID + ":snapshot"
+}
+
+func auditKey(roomID string) string {
+	return "rally:plan:" + roomID + ":audit"
+}
+
+func itemAuditKey(roomID, itemID string) string {
+	return "rally:plan:" + roomID + ":audit:" + itemID
+}
+
+func redisStackKey(roomID, userID string, stack UndoStack) string {
+	return "rally:plan:" + roomID + ":" + string(stack) + ":" + userID
+}
+
+func locksKey(roomID string) string {
+	return "rally:plan:" + roomID + ":locks"
+}
+
+// lockExpiryKey orders every lock by expiry time. Members are
+// "room\x00item".
+const lockExpiryKey = "rally:plan:locks:expiry"
+
+// lockRecord is a lock as stored in Redis; times are Unix milliseconds so
+// that Lua scripts can compare them.
+type lockRecord struct {
+	UserID    string `json:"user_id"`
+	ExpiresAt int64  `json:"expires_at"`
+}
+
+func (r *lockRecord) lock(roomID, itemID string) ItemLock {
+	return ItemLock{RoomID: roomID, ItemID: itemID, UserID: r.UserID, ExpiresAt: time.UnixMilli(r.ExpiresAt)}
+}
+
+func decodeLock(roomID, itemID, data string) (*ItemLock, error) {
+	var rec lockRecord
+	if err := json.Unmarshal([]byte(data), &rec); err != nil {
+		return nil, err
+	}
+	lock := rec.lock(roomID, itemID)
+	return &lock, nil
+}
+
+// appendOpsScript checks an item's version, raises the versions of the
+// items the ops touch and appends the ops. ARGV holds the checked item ("" for
+// none) and its expected version, the number of version updates followed by
+// item and version pairs, then the ops. It returns {length}, or {-1} if the
+// check fails.
+var appendOpsScript = redis.NewScript(`
+if ARGV[1] ~= "" then
+	local cur = redis.call("HGET", KEYS[2], ARGV[1])
+	if cur and cur ~= ARGV[2] then
+		return {-1}
+	end
+end
+local n = tonumber(ARGV[3])
+for i = 0, n - 1 do
+	local item, v = ARGV[4 + 2 * i], ARGV[5 + 2 * i]
+	local cur = redis.call("HGET", KEYS[2], item)
+	if not cur or tonumber(v) > tonumber(cur) then
+		redis.call("HSET", KEYS[2], item, v)
+	end
+end
+local len = 0
+for i = 4 + 2 * n, #ARGV do
+	len = redis.call("RPUSH", KEYS[1], ARGV[i])
+end
+return {len}
+`)
+
+// AppendOps appends ops to the room's log.
+func (s *RedisStore) AppendOps(ctx context.Context, roomID string, ops []Op, check *VersionCheck) (int64, error) {
+	args := []any{"", 0}
+	if check != nil {
+		args = []any{check.ItemID, check.Version}
+	}
+	versions := opVersions(ops)
+	args = append(args, len(versions))
+	for item, v := range versions {
+		args = append(args, item, v)
+	}
+	for i := range ops {
+		data, err := json.Marshal(&ops[i])
+		if err != nil {
+			return 0, err
+		}
+		args = append(args, data)
+	}
+
+	res, err := appendOpsScript.Run(ctx, s.client, []string{opsKey(roomID), versionsKey(roomID)}, args...).Int64Slice()
+	if err != nil {
+		return 0, err
+	}
+	if res[0] < 0 {
+		return 0, errVersionConflict
+	}
+	return res[0], nil
+}
+
+// Load returns the latest snapshot and the ops logged after it.
+func (s *RedisStore) Load(ctx context.Context, roomID string) (*Snapshot, []Op, error) {
+	var snap *Snapshot
+	data, err := s.client.Get(ctx, snapshotKey(roomID)).Bytes()
+	switch {
+	case errors.Is(err, redis.Nil):
+	case err != nil:
+		return nil, nil, err
+	default:
+		snap = &Snapshot{}
+		if err := json.Unmarshal(data, snap); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	var start int64
+	if snap != nil {
+		start = snap.LogLength
+	}
+	ops, err := s.OpsSince(ctx, roomID, start)
+	if err != nil {
+		return nil, nil, err
+	}
+	return snap, ops, nil
+}
+
+// OpsSince returns the ops logged at or after index start.
+func (s *RedisStore) OpsSince(ctx context.Context, roomID string, start int64) ([]Op, error) {
+	raw, err := s.client.LRange(ctx, opsKey(roomID), start, -1).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	ops := make([]Op, 0, len(raw))
+	for _, r := range raw {
+		var op Op
+		if err := json.Unmarshal([]byte(r), &op); err != nil {
+			return nil, err
+		}
+		ops = append(ops, op)
+	}
+	return ops, nil
+}
+
+// saveSnapshotScript replaces the snapshot only if the new one covers more of
+// the op log, so that instances racing to compact never go backwards.
+var saveSnapshotScript = redis.NewScript(`
+local cur = redis.call("GET", KEYS[1])
+if cur then
+	local ok, decoded = pcall(cjson.decode, cur)
+	if ok and tonumber(decoded["log_length"]) >= tonumber(ARGV[2]) then
+		return 0
+	end
+end
+redis.call("SET", KEYS[1], ARGV[1])
+return 1
+`)
+
+// SaveSnapshot stores a snapshot.
+func (s *RedisStore) SaveSnapshot(ctx context.Context, roomID string, snap *Snapshot) error {
+	data, err := json.Marshal(snap)
+	if err != nil {
+		return err
+	}
+	return saveSnapshotScript.Run(ctx, s.client, []string{snapshotKey(roomID)}, data, snap.LogLength).Err()
+}
+
+// AppendAudit records an accepted action in the room's and the item's log.
+func (s *RedisStore) AppendAudit(ctx context.Context, entry *AuditEntry) error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	pipe := s.client.TxPipeline()
+	pipe.RPush(ctx, auditKey(entry.RoomID), data)
+	pipe.RPush(ctx, itemAuditKey(entry.RoomID, entry.ItemID), data)
+	_, err = pipe.Exec(ctx)
+	return err
+}
+
+// History returns the most recent entries, newest first.
+func (s *RedisStore) History(ctx context.Context, roomID, itemID string, limit int) ([]AuditEntry, error) {
+	key := auditKey(roomID)
+	if itemID != "" {
+		key = itemAuditKey(roomID, itemID)
+	}
+
+	raw, err := s.client.LRange(ctx, key, int64(-limit), -1).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make([]AuditEntry, 0, len(raw))
+	for i := len(raw) - 1; i >= 0; i-- {
+		var entry AuditEntry
+		if err := json.Unmarshal([]byte(raw[i]), &entry); err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+// PushStack pushes entry onto the user's stack.
+func (s *RedisStore) PushStack(ctx context.Context, roomID, userID string, stack UndoStack, entry *AuditEntry) error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	key := redisStackKey(roomID, userID, stack)
+	pipe := s.client.TxPipeline()
+	pipe.RPush(ctx, key, data)
+	pipe.LTrim(ctx, key, -maxUndoDepth, -1)
+	_, err = pipe.Exec(ctx)
+	return err
+}
+
+// PopStack pops the most recent entry.
+func (s *RedisStore) PopStack(ctx context.Context, roomID, userID string, stack UndoStack) (*AuditEntry, error) {
+	data, err := s.client.RPop(ctx, redisStackKey(roomID, userID, stack)).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var entry AuditEntry
+	if err := json.Unmarshal(data, &entry); err != nil {
+		return nil, err
+	}
+	return &entry, nil
+}
+
+// ClearStack empties the user's stack.
+func (s *RedisStore) ClearStack(ctx context.Context, roomID, userID string, stack UndoStack) error {
+	return s.client.Del(ctx, redisStackKey(roomID, userID, stack)).Err()
+}
+
+// acquireLockScript sets a lock unless another user holds an unexpired one
+// and stealing is not allowed. It returns {acquired, previous lock}.
+var acquireLockScript = redis.NewScript(`
+local cur = redis.call("HGET", KEYS[1], ARGV[1])
+if cur then
+	local lock = cjson.decode(cur)
+	if lock.user_id ~= ARGV[2] and tonumber(lock.expires_at) > tonumber(ARGV[4]) then
+		if ARGV[5] ~= "1" then
+			return {0, cur}
+		end
+	else
+		cur = false
+	end
+end
+redis.call("HSET", KEYS[1], ARGV[1], ARGV[3])
+redis.call("ZADD", KEYS[2], ARGV[6], ARGV[7])
+if cur then
+	return {1, cur}
+end
+return {1}
+`)
+
+// AcquireLock locks an item.
+func (s *RedisStore) AcquireLock(ctx context.Context, lock ItemLock, steal bool) (*ItemLock, bool, error) {
+	data, err := json.Marshal(lockRecord{UserID: lock.UserID, ExpiresAt: lock.ExpiresAt.UnixMilli()})
+	if err != nil {
+		return nil, false, err
+	}
+	stealArg := "0"
+	if steal {
+		stealArg = "1"
+	}
+
+	res, err := acquireLockScript.Run(ctx, s.client,
+		[]string{locksKey(lock.RoomID), lockExpiryKey},
+		lock.ItemID, lock.UserID, data, time.Now().UnixMilli(), stealArg,
+		lock.ExpiresAt.UnixMilli(), lockKey(lock.RoomID, lock.ItemID),
+	).Slice()
+	if err != nil {
+		return nil, false, err
+	}
+
+	acquired := res[0].(int64) == 1
+	var prev *ItemLock
+	if len(res) > 1 {
+		if prev, err = decodeLock(lock.RoomID, lock.ItemID, res[1].(string)); err != nil {
+			return nil, false, err
+		}
+	}
+	return prev, acquired, nil
+}
+
+// releaseLockScript removes a user's lock. It returns 1 if released, 0 if
+// there was nothing to release and {-1, lock} if another user holds an
+// unexpired lock.
+var releaseLockScript = redis.NewScript(`
+local cur = redis.call("HGET", KEYS[1], ARGV[1])
+if not cur then
+	return 0
+end
+local lock = cjson.decode(cur)
+if lock.user_id ~= ARGV[2] then
+	if tonumber(lock.expires_at) > tonumber(ARGV[3]) then
+		return {-1, cur}
+	end
+	return 0
+end
+redis.call("HDEL", KEYS[1], ARGV[1])
+redis.call("ZREM", KEYS[2], ARGV[4])
+return 1
+`)
+
+// ReleaseLock unlocks an item.
+func (s *RedisStore) ReleaseLock(ctx context.Context, roomID, itemID, userID string) (bool, error) {
+	res, err := releaseLockScript.Run(ctx, s.client,
+		[]string{locksKey(roomID), lockExpiryKey},
+		itemID, userID, time.Now().UnixMilli(), lockKey(roomID, itemID),
+	).Result()
+	if err != nil {
+		return false, err
+	}
+
+	if held, ok := res.([]any); ok {
+		holder, err := decodeLock(roomID, itemID, held[1].(string))
+		if err != nil {
+			return false, err
+		}
+		return false, &LockedError{Lock: *holder}
+	}
+	return res.(int64) == 1, nil
+}
+
+// Lock returns the unexpired lock on an item.
+func (s *RedisStore) Lock(ctx context.Context, roomID, itemID string) (*ItemLock, error) {
+	data, err := s.client.HGet(ctx, locksKey(roomID), itemID).Result()
+	if errors.Is(err, redis.Nil) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	lock, err := decodeLock(roomID, itemID, data)
+	if err != nil || !time.Now().Before(lock.ExpiresAt) {
+		return nil, err
+	}
+	return lock, nil
+}
+
+// Locks returns the room's unexpired locks.
+func (s *RedisStore) Locks(ctx context.Context, roomID string) ([]ItemLock, error) {
+	raw, err := s.client.HGetAll(ctx, locksKey(roomID)).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	locks := make([]ItemLock, 0, len(raw))
+	for itemID, data := range raw {
+		lock, err := decodeLock(roomID, itemID, data)
+		if err != nil {
+			return nil, err
+		}
+		if now.Before(lock.ExpiresAt) {
+			locks = append(locks, *lock)
+		}
+	}
+	return locks, nil
+}
+
+// expireLockScript removes a lock if it is still expired, so that a lock
+// renewed meanwhile survives and only one instance reports the expiry.
+var expireLockScript = redis.NewScript(`
+redis.call("ZREM", KEYS[2], ARGV[3])
+local cur = redis.call("HGET", KEYS[1], ARGV[1])
+if not cur then
+	return false
+end
+local lock = cjson.decode(cur)
+if tonumber(lock.expires_at) > tonumber(ARGV[2]) then
+	redis.call("ZADD", KEYS[2], lock.expires_at, ARGV[3])
+	return false
+end
+redis.call("HDEL", KEYS[1], ARGV[1])
+return cur
+`)
+
+// ExpireLocks removes and returns expired locks.
+func (s *RedisStore) ExpireLocks(ctx context.Context, now time.Time) ([]ItemLock, error) {
+	nowMs := strconv.FormatInt(now.UnixMilli(), 10)
+	members, err := s.client.ZRangeByScore(ctx, lockExpiryKey, &redis.ZRangeBy{Min: "-inf", Max: nowMs}).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	var expired []ItemLock
+	for _, member := range members {
+		roomID, itemID, ok := strings.Cut(member, "\x00")
+		if !ok {
+			s.client.ZRem(ctx, lockExpiryKey, member)
+			continue
+		}
+
+		data, err := expireLockScript.Run(ctx, s.client,
+			[]string{locksKey(roomID), lockExpiryKey}, itemID, nowMs, member,
+		).Text()
+		if errors.Is(err, redis.Nil) {
+			continue // renewed, released or expired by another instance
+		}
+		if err != nil {
+			return expired, err
+		}
+
+		lock, err := decodeLock(roomID, itemID, data)
+		if err != nil {
+			return expired, err
+		}
+		expired = append(expired, *lock)
+	}
+	return expired, nil
+}
@@ -0,0 +1,283 @@
+package polls
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/rally-go/rally-realtime/internal/rooms"
+)
+
+// Poll action names. Clients send create, vote, close, get and list; the
+// server broadcasts create, tally and closed.
+const (
+	ActionCreate = "create"
+	ActionVote   = "vote"
+	ActionClose  = "close"
+	ActionGet    = "get"
+	ActionList   = "list"
+
+	ActionTally  = "tally"
+	ActionClosed = "closed"
+)
+
+// sweepInterval is how often deadlines are checked.
+const sweepInterval = time.Second
+
+// PollAction represents a poll action payload.
+type PollAction struct {
+	Action    string      `json:"action"` // see Action* constants
+	PollID    string      `json:"poll_id,omitempty"`
+	Poll      *Poll       `json:"poll,omitempty"`       // create; returned by every action but tally and list
+	OptionIDs []string    `json:"option_ids,omitempty"` // vote
+	Results   *Results    `json:"results,omitempty"`
+	Polls     []PollState `json:"polls,omitempty"` // list
+	Timestamp time.Time   `json:"timestamp"`
+}
+
+// PollState is a poll together with its current results.
+type PollState struct {
+	Poll    *Poll    `json:"poll"`
+	Results *Results `json:"results"`
+}
+
+// ItemLookup reports whether an itinerary item exists, so that polls can be
+// attached to it.
+type ItemLookup interface {
+	ItemExists(ctx context.Context, roomID, itemID string) (bool, error)
+}
+
+// Handler handles polls.
+type Handler struct {
+	members rooms.Store
+	store   Store
+	items   ItemLookup
+	closed  chan *PollAction
+}
+
+// NewHandler creates a new poll handler and starts closing polls at their
+// deadline. Closures are delivered on Closed.
+func NewHandler(members rooms.Store, store Store, items ItemLookup) *Handler {
+	h := &Handler{
+		members: members,
+		store:   store,
+		items:   items,
+		closed:  make(chan *PollAction, 64),
+	}
+
+	go h.closeExpiredPolls()
+
+	return h
+}
+
+// Closed delivers polls closed by their deadline, each on exactly one
+// server instance.
+func (h *Handler) Closed() <-chan *PollAction {
+	return h.closed
+}
+
+// ProcessAction processes an incoming poll action.
+func (h *Handler) ProcessAction(ctx context.Context, userID, roomID string, payload json.RawMessage) (*PollAction, error) {
+	var action PollAction
+	if err := json.Unmarshal(payload, &action); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidPoll, err)
+	}
+	action.Timestamp = time.Now()
+
+	switch action.Action {
+	case ActionCreate:
+		return h.create(ctx, userID, roomID, &action)
+	case ActionVote:
+		return h.vote(ctx, userID, roomID, &action)
+	case ActionClose:
+		return h.close(ctx, userID, roomID, &action)
+	case ActionGet:
+		poll, err := h.poll(ctx, roomID, action.PollID)
+		if err != nil {
+			return nil, err
+		}
+		return h.state(ctx, ActionGet, poll)
+	case ActionList:
+		return h.list(ctx, roomID)
+	default:
+		return nil, fmt.Errorf("%w: unknown action %q", ErrInvalidPoll, action.Action)
+	}
+}
+
+func (h *Handler) create(ctx context.Context, userID, roomID string, action *PollAction) (*PollAction, error) {
+	if action.Poll == nil {
+		return nil, fmt.Errorf("%w: poll is required", ErrInvalidPoll)
+	}
+
+	poll := action.Poll
+	if err := poll.normalize(action.Timestamp); err != nil {
+		return nil, err
+	}
+	poll.ID = uuid.New().String()
+	poll.RoomID = roomID
+	poll.CreatedBy = userID
+
+	if poll.ItemID != "" {
+		ok, err := h.items.ItemExists(ctx, roomID, poll.ItemID)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			return nil, fmt.Errorf("%w: item %s does not exist", ErrInvalidPoll, poll.ItemID)
+		}
+	}
+
+	if err := h.store.Create(ctx, poll); err != nil {
+		return nil, err
+	}
+
+	log.Printf("Poll %s created by %s in room %s", poll.ID, userID, roomID)
+	return h.state(ctx, ActionCreate, poll)
+}
+
+func (h *Handler) vote(ctx context.Context, userID, roomID string, action *PollAction) (*PollAction, error) {
+	poll, err := h.poll(ctx, roomID, action.PollID)
+	if err != nil {
+		return nil, err
+	}
+	if poll.Closed || poll.expired(action.Timestamp) {
+		return nil, ErrPollClosed
+	}
+	if err := poll.checkChoice(action.OptionIDs); err != nil {
+		return nil, err
+	}
+
+	if err := h.store.Vote(ctx, poll.ID, userID, action.OptionIDs); err != nil {
+		return nil, err
+	}
+
+	// Tallies are broadcast without the voter so that anonymous polls stay
+	// anonymous.
+	res, err := h.results(ctx, poll)
+	if err != nil {
+		return nil, err
+	}
+	return &PollAction{Action: ActionTally, PollID: poll.ID, Results: res, Timestamp: action.Timestamp}, nil
+}
+
+// close lets the poll's creator or a room moderator close it early.
+func (h *Handler) close(ctx context.Context, userID, roomID string, action *PollAction) (*PollAction, error) {
+	poll, err := h.poll(ctx, roomID, action.PollID)
+	if err != nil {
+		return nil, err
+	}
+
+	if poll.CreatedBy != userID {
+		role, err := h.members.Role(ctx, roomID, userID)
+		if err != nil {
+			return nil, err
+		}
+		if !role.CanModerate() {
+			return nil, ErrForbidden
+		}
+	}
+
+	return h.closePoll(ctx, poll, action.Timestamp)
+}
+
+// closePoll closes poll and returns the final results. It returns
+// ErrPollClosed if the poll was already closed, including by another
+// instance.
+func (h *Handler) closePoll(ctx context.Context, poll *Poll, at time.Time) (*PollAction, error) {
+	ok, err := h.store.Close(ctx, poll.ID, at)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, ErrPollClosed
+	}
+
+	poll.Closed = true
+	poll.ClosedAt = &at
+	log.Printf("Poll %s closed in room %s", poll.ID, poll.RoomID)
+	return h.state(ctx, ActionClosed, poll)
+}
+
+func (h *Handler) list(ctx context.Context, roomID string) (*PollAction, error) {
+	polls, err := h.store.List(ctx, roomID)
+	if err != nil {
+		return nil, err
+	}
+
+	out := &PollAction{Action: ActionList, Polls: make([]PollState, 0, len(polls)), Timestamp: time.Now()}
+	for _, poll := range polls {
+		res, err := h.results(ctx, poll)
+		if err != nil {
+			return nil, err
+		}
+		out.Polls = append(out.Polls, PollState{Poll: poll, Results: res})
+	}
+	return out, nil
+}
+
+// poll loads a poll, hiding polls of other rooms.
+func (h *Handler) poll(ctx context.Context, roomID, pollID string) (*Poll, error) {
+	if pollID == "" {
+		return nil, fmt.Errorf("%w: poll_id is required", ErrInvalidPoll)
+	}
+	poll, err := h.store.Get(ctx, pollID)
+	if err != nil {
+		return nil, err
+	}
+	if poll.RoomID != roomID {
+		return nil, ErrPollNotFound
+	}
+	return poll, nil
+}
+
+func (h *Handler) results(ctx context.Context, poll *Poll) (*Results, error) {
+	votes, err := h.store.Votes(ctx, poll.ID)
+	if err != nil {
+		return nil, err
+	}
+	return tally(poll, votes), nil
+}
+
+func (h *Handler) state(ctx context.Context, name string, poll *Poll) (*PollAction, error) {
+	res, err := h.results(ctx, poll)
+	if err != nil {
+		return nil, err
+	}
+	return &PollAction{Action: name, PollID: poll.ID, Poll: poll, Results: res, Timestamp: time.Now()}, nil
+}
+
+// closeExpiredPolls periodically closes polls whose deadline has passed.
+// Every instance sweeps; the store lets only one of them close each poll.
+func (h *Handler) closeExpiredPolls() {
+	ticker := time.NewTicker(sweepInterval)
+	defer ticker.Stop()
+
+	for now := range ticker.C {
+		ctx, cancel := context.WithTimeout(context.Background(), sweepInterval)
+		ids, err := h.store.Due(ctx, now)
+		if err != nil {
+			log.Printf("Failed to list expired polls: %v", err)
+		}
+		for _, id := range ids {
+			poll, err := h.store.Get(ctx, id)
+			if err != nil {
+				log.Printf("Failed to load expired poll %s: %v", id, err)
+				continue
+			}
+			action, err := h.closePoll(ctx, poll, *poll.Deadline)
+			if errors.Is(err, ErrPollClosed) {
+				continue // closed by another instance
+			}
+			if err != nil {
+				log.Printf("Failed to close expired poll %s: %v", id, err)
+				continue
+			}
+			h.closed <- action
+		}
+		cancel()
+	}
+}
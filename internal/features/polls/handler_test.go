@@ -0,0 +1,238 @@
+package polls
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/rally-go/rally-realtime/internal/rooms"
+)
+
+// itemSet is an ItemLookup over a fixed set of item IDs.
+type itemSet map[string]bool
+
+func (s itemSet) ItemExists(ctx context.Context, roomID, itemID string) (bool, error) {
+	return s[itemID], nil
+}
+
+// newTestHandler returns a handler for room "trip", owned by alice, with bob
+// and carol as members and a moderator dave.
+func newTestHandler(t *testing.T, store Store) *Handler {
+	t.Helper()
+
+	ctx := context.Background()
+	members := rooms.NewMemoryStore()
+	for _, user := range []string{"alice", "bob", "carol", "dave"} {
+		if err := members.AddMember(ctx, "trip", user); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := members.SetRole(ctx, "trip", "dave", rooms.RoleModerator); err != nil {
+		t.Fatal(err)
+	}
+	return NewHandler(members, store, itemSet{"temple": true})
+}
+
+func act(h *Handler, user, room string, payload any) (*PollAction, error) {
+	data, _ := json.Marshal(payload)
+	return h.ProcessAction(context.Background(), user, room, data)
+}
+
+// createPoll creates a poll in "trip" as alice.
+func createPoll(t *testing.T, h *Handler, poll map[string]any) *Poll {
+	t.Helper()
+
+	res, err := act(h, "alice", "trip", map[string]any{"action": ActionCreate, "poll": poll})
+	if err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	return res.Poll
+}
+
+func TestCreatePoll(t *testing.T) {
+	h := newTestHandler(t, NewMemoryStore())
+
+	poll := createPoll(t, h, map[string]any{
+		"question": "  Dinner <tonight>? ",
+		"options":  []map[string]string{{"id": "x", "label": "Thai"}, {"label": "Street food"}},
+		"item_id":  "temple",
+	})
+	if poll.ID == "" || poll.RoomID != "trip" || poll.CreatedBy != "alice" || poll.Closed {
+		t.Errorf("created poll = %+v", poll)
+	}
+	if poll.Question != "Dinner &lt;tonight&gt;?" {
+		t.Errorf("question = %q, want trimmed and escaped", poll.Question)
+	}
+	if want := []Option{{ID: "1", Label: "Thai"}, {ID: "2", Label: "Street food"}}; !reflect.DeepEqual(poll.Options, want) {
+		t.Errorf("options = %+v, want %+v", poll.Options, want)
+	}
+
+	now := time.Now()
+	options := []map[string]string{{"label": "Yes"}, {"label": "No"}}
+	tests := []struct {
+		name string
+		poll map[string]any
+	}{
+		{name: "no question", poll: map[string]any{"question": " ", "options": options}},
+		{name: "one option", poll: map[string]any{"question": "Go?", "options": options[:1]}},
+		{name: "duplicate options", poll: map[string]any{"question": "Go?", "options": []map[string]string{{"label": "Yes"}, {"label": " Yes"}}}},
+		{name: "empty option", poll: map[string]any{"question": "Go?", "options": []map[string]string{{"label": "Yes"}, {"label": ""}}}},
+		{name: "deadline passed", poll: map[string]any{"question": "Go?", "options": options, "deadline": now.Add(-time.Minute)}},
+		{name: "deadline too far", poll: map[string]any{"question": "Go?", "options": options, "deadline": now.Add(maxPollDuration + time.Hour)}},
+		{name: "unknown item", poll: map[string]any{"question": "Go?", "options": options, "item_id": "museum"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := act(h, "alice", "trip", map[string]any{"action": ActionCreate, "poll": tt.poll})
+			if !errors.Is(err, ErrInvalidPoll) {
+				t.Errorf("create error = %v, want ErrInvalidPoll", err)
+			}
+		})
+	}
+}
+
+func TestVote(t *testing.T) {
+	h := newTestHandler(t, NewMemoryStore())
+	options := []map[string]string{{"label": "Thai"}, {"label": "Italian"}, {"label": "Street food"}}
+	single := createPoll(t, h, map[string]any{"question": "Dinner?", "options": options})
+	multiple := createPoll(t, h, map[string]any{"question": "Which days?", "options": options, "multiple": true})
+	anonymous := createPoll(t, h, map[string]any{"question": "Rate the hotel", "options": options, "anonymous": true})
+
+	vote := func(user string, poll *Poll, ids ...string) (*PollAction, error) {
+		return act(h, user, "trip", map[string]any{"action": ActionVote, "poll_id": poll.ID, "option_ids": ids})
+	}
+
+	if _, err := vote("alice", single, "1"); err != nil {
+		t.Fatal(err)
+	}
+	res, err := vote("bob", single, "1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Action != ActionTally || res.Poll != nil {
+		t.Errorf("vote answered with %+v, want a tally without the poll", res)
+	}
+	want := &Results{
+		Counts:      map[string]int{"1": 2, "2": 0, "3": 0},
+		Voters:      map[string][]string{"1": {"alice", "bob"}},
+		TotalVoters: 2,
+	}
+	if !reflect.DeepEqual(res.Results, want) {
+		t.Errorf("results = %+v, want %+v", res.Results, want)
+	}
+
+	if res, err := vote("alice", multiple, "1", "3"); err != nil || res.Results.Counts["3"] != 1 {
+		t.Errorf("multiple choice vote = %+v, %v", res, err)
+	}
+
+	res, err = vote("carol", anonymous, "2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Results.Voters != nil || res.Results.Counts["2"] != 1 {
+		t.Errorf("anonymous results = %+v, want counts without voters", res.Results)
+	}
+
+	tests := []struct {
+		name string
+		user string
+		poll *Poll
+		ids  []string
+		want error
+	}{
+		{name: "twice", user: "alice", poll: single, ids: []string{"2"}, want: ErrAlreadyVoted},
+		{name: "several on a single choice poll", user: "carol", poll: single, ids: []string{"1", "2"}, want: ErrInvalidPoll},
+		{name: "unknown option", user: "carol", poll: single, ids: []string{"9"}, want: ErrInvalidPoll},
+		{name: "no option", user: "carol", poll: single, want: ErrInvalidPoll},
+		{name: "same option twice", user: "carol", poll: multiple, ids: []string{"1", "1"}, want: ErrInvalidPoll},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := vote(tt.user, tt.poll, tt.ids...); !errors.Is(err, tt.want) {
+				t.Errorf("vote error = %v, want %v", err, tt.want)
+			}
+		})
+	}
+
+	// Polls of other rooms are hidden.
+	if _, err := act(h, "alice", "other", map[string]any{"action": ActionGet, "poll_id": single.ID}); !errors.Is(err, ErrPollNotFound) {
+		t.Errorf("get from another room: error = %v, want ErrPollNotFound", err)
+	}
+
+	list, err := act(h, "bob", "trip", map[string]string{"action": ActionList})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list.Polls) != 3 || list.Polls[0].Results.TotalVoters != 2 {
+		t.Errorf("list = %+v, want the three polls with results", list.Polls)
+	}
+}
+
+func TestClosePoll(t *testing.T) {
+	h := newTestHandler(t, NewMemoryStore())
+	options := []map[string]string{{"label": "Yes"}, {"label": "No"}}
+
+	closeAs := func(user string, poll *Poll) (*PollAction, error) {
+		return act(h, user, "trip", map[string]any{"action": ActionClose, "poll_id": poll.ID})
+	}
+
+	poll := createPoll(t, h, map[string]any{"question": "Go?", "options": options})
+	if _, err := closeAs("bob", poll); !errors.Is(err, ErrForbidden) {
+		t.Errorf("member closing another's poll: error = %v, want ErrForbidden", err)
+	}
+	res, err := closeAs("alice", poll)
+	if err != nil {
+		t.Fatalf("creator closing: %v", err)
+	}
+	if res.Action != ActionClosed || !res.Poll.Closed || res.Poll.ClosedAt == nil {
+		t.Errorf("close answered with %+v", res)
+	}
+	if _, err := closeAs("alice", poll); !errors.Is(err, ErrPollClosed) {
+		t.Errorf("closing twice: error = %v, want ErrPollClosed", err)
+	}
+	if _, err := act(h, "bob", "trip", map[string]any{"action": ActionVote, "poll_id": poll.ID, "option_ids": []string{"1"}}); !errors.Is(err, ErrPollClosed) {
+		t.Errorf("vote after close: error = %v, want ErrPollClosed", err)
+	}
+
+	poll = createPoll(t, h, map[string]any{"question": "Go?", "options": options})
+	if _, err := closeAs("dave", poll); err != nil {
+		t.Errorf("moderator closing: %v", err)
+	}
+}
+
+// TestDeadlineClosesOnce checks that two instances sharing a store close a
+// poll at its deadline exactly once between them.
+func TestDeadlineClosesOnce(t *testing.T) {
+	store := NewMemoryStore()
+	a := newTestHandler(t, store)
+	b := newTestHandler(t, store)
+
+	poll := createPoll(t, a, map[string]any{
+		"question": "Go?",
+		"options":  []map[string]string{{"label": "Yes"}, {"label": "No"}},
+		"deadline": time.Now().Add(200 * time.Millisecond),
+	})
+
+	var closed []*PollAction
+	timeout := time.After(3 * sweepInterval)
+	for done := false; !done; {
+		select {
+		case action := <-a.Closed():
+			closed = append(closed, action)
+		case action := <-b.Closed():
+			closed = append(closed, action)
+		case <-timeout:
+			done = true
+		}
+	}
+
+	if len(closed) != 1 {
+		t.Fatalf("poll closed %d times, want once", len(closed))
+	}
+	if closed[0].PollID != poll.ID || !closed[0].Poll.Closed || !closed[0].Poll.ClosedAt.Equal(*poll.Deadline) {
+		t.Errorf("closure = %+v, want the poll closed at its deadline", closed[0].Poll)
+	}
+}
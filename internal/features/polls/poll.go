@@ -0,0 +1,187 @@
+package polls
+
+import (
+	"errors"
+	"fmt"
+	"html"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// Limits on poll content.
+const (
+	MaxQuestionRunes = 300
+	MaxOptionRunes   = 200
+	MinOptions       = 2
+	MaxOptions       = 20
+
+	// maxPollDuration caps how far in the future a deadline may be.
+	maxPollDuration = 30 * 24 * time.Hour
+)
+
+var (
+	// ErrInvalidPoll is returned for malformed polls, votes or actions.
+	ErrInvalidPoll = errors.New("invalid poll")
+
+	// ErrPollNotFound is returned when a poll does not exist in the room.
+	ErrPollNotFound = errors.New("poll not found")
+
+	// ErrPollClosed is returned when voting on a closed poll.
+	ErrPollClosed = errors.New("poll is closed")
+
+	// ErrAlreadyVoted is returned when a user votes twice on the same poll.
+	ErrAlreadyVoted = errors.New("already voted on this poll")
+
+	// ErrForbidden is returned when the user may not close the poll.
+	ErrForbidden = errors.New("not allowed to close this poll")
+)
+
+// Option is one of the choices of a poll.
+type Option struct {
+	ID    string `json:"id"`
+	Label string `json:"label"`
+}
+
+// Poll is a question put to a room, optionally attached to an itinerary item.
+type Poll struct {
+	ID        string     `json:"id"`
+	RoomID    string     `json:"room_id"`
+	ItemID    string     `json:"item_id,omitempty"` // itinerary item the poll is about
+	Question  string     `json:"question"`
+	Options   []Option   `json:"options"`
+	Multiple  bool       `json:"multiple"`  // voters may pick several options
+	Anonymous bool       `json:"anonymous"` // results do not name voters
+	CreatedBy string     `json:"created_by"`
+	CreatedAt time.Time  `json:"created_at"`
+	Deadline  *time.Time `json:"deadline,omitempty"`
+	Closed    bool       `json:"closed"`
+	ClosedAt  *time.Time `json:"closed_at,omitempty"`
+}
+
+// Results is the tally of a poll.
+type Results struct {
+	Counts      map[string]int      `json:"counts"`           // option ID -> votes
+	Voters      map[string][]string `json:"voters,omitempty"` // option ID -> user IDs; named polls only
+	TotalVoters int                 `json:"total_voters"`
+}
+
+// tally counts votes (user ID -> option IDs) for poll.
+func tally(poll *Poll, votes map[string][]string) *Results {
+	res := &Results{
+		Counts:      make(map[string]int, len(poll.Options)),
+		TotalVoters: len(votes),
+	}
+	for _, opt := range poll.Options {
+		res.Counts[opt.ID] = 0
+	}
+	if !poll.Anonymous {
+		res.Voters = make(map[string][]string, len(poll.Options))
+	}
+
+	for userID, optionIDs := range votes {
+		for _, id := range optionIDs {
+			if _, ok := res.Counts[id]; !ok {
+				continue
+			}
+			res.Counts[id]++
+			if res.Voters != nil {
+				res.Voters[id] = append(res.Voters[id], userID)
+			}
+		}
+	}
+	for _, voters := range res.Voters {
+		sort.Strings(voters)
+	}
+	return res
+}
+
+// normalize validates a poll submitted by a client and fills in the fields
+// the server owns. Option IDs are assigned by position.
+func (p *Poll) normalize(now time.Time) error {
+	question, err := cleanText(p.Question, MaxQuestionRunes)
+	if err != nil {
+		return fmt.Errorf("%w: question %v", ErrInvalidPoll, err)
+	}
+	p.Question = question
+
+	if len(p.Options) < MinOptions || len(p.Options) > MaxOptions {
+		return fmt.Errorf("%w: a poll needs %d to %d options", ErrInvalidPoll, MinOptions, MaxOptions)
+	}
+	seen := make(map[string]bool, len(p.Options))
+	for i := range p.Options {
+		label, err := cleanText(p.Options[i].Label, MaxOptionRunes)
+		if err != nil {
+			return fmt.Errorf("%w: option %d %v", ErrInvalidPoll, i+1, err)
+		}
+		if seen[label] {
+			return fmt.Errorf("%w: duplicate option %q", ErrInvalidPoll, label)
+		}
+		seen[label] = true
+		p.Options[i] = Option{ID: strconv.Itoa(i + 1), Label: label}
+	}
+
+	if p.Deadline != nil {
+		if !p.Deadline.After(now) {
+			return fmt.Errorf("%w: deadline must be in the future", ErrInvalidPoll)
+		}
+		if p.Deadline.Sub(now) > maxPollDuration {
+			return fmt.Errorf("%w: deadline must be within %s", ErrInvalidPoll, maxPollDuration)
+		}
+		deadline := p.Deadline.UTC()
+		p.Deadline = &deadline
+	}
+
+	p.CreatedAt = now
+	p.Closed = false
+	p.ClosedAt = nil
+	return nil
+}
+
+// checkChoice validates the options a user picked.
+func (p *Poll) checkChoice(optionIDs []string) error {
+	if len(optionIDs) == 0 {
+		return fmt.Errorf("%w: option_ids is required", ErrInvalidPoll)
+	}
+	if !p.Multiple && len(optionIDs) > 1 {
+		return fmt.Errorf("%w: this poll allows a single choice", ErrInvalidPoll)
+	}
+
+	valid := make(map[string]bool, len(p.Options))
+	for _, opt := range p.Options {
+		valid[opt.ID] = true
+	}
+	seen := make(map[string]bool, len(optionIDs))
+	for _, id := range optionIDs {
+		if !valid[id] {
+			return fmt.Errorf("%w: unknown option %q", ErrInvalidPoll, id)
+		}
+		if seen[id] {
+			return fmt.Errorf("%w: duplicate option %q", ErrInvalidPoll, id)
+		}
+		seen[id] = true
+	}
+	return nil
+}
+
+// expired reports whether the poll's deadline has passed.
+func (p *Poll) expired(now time.Time) bool {
+	return p.Deadline != nil && !now.Before(*p.Deadline)
+}
+
+// cleanText trims and escapes user text, enforcing a length limit.
+func cleanText(s string, maxRunes int) (string, error) {
+	if !utf8.ValidString(s) {
+		return "", errors.New("is not valid UTF-8")
+	}
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return "", errors.New("is required")
+	}
+	if utf8.RuneCountInString(s) > maxRunes {
+		return "", fmt.Errorf("exceeds %d characters", maxRunes)
+	}
+	return html.EscapeString(s), nil
+}
@@ -0,0 +1,345 @@
+package polls
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Store persists polls and votes. Vote and Close must be atomic across
+// server instances: each user votes at most once, no vote is accepted after
+// the poll closes, and exactly one caller closes a poll.
+type Store interface {
+	Create(ctx context.Context, poll *Poll) error
+	// Get returns ErrPollNotFound if the poll does not exist.
+	Get(ctx context.Context, pollID string) (*Poll, error)
+	// List returns the room's polls, oldest first.
+	List(ctx context.Context, roomID string) ([]*Poll, error)
+
+	// Vote records userID's choice. It returns ErrAlreadyVoted or
+	// ErrPollClosed if the vote cannot be accepted.
+	Vote(ctx context.Context, pollID, userID string, optionIDs []string) error
+	// Votes returns every vote as user ID -> option IDs.
+	Votes(ctx context.Context, pollID string) (map[string][]string, error)
+
+	// Close closes the poll and reports whether this call closed it.
+	Close(ctx context.Context, pollID string, at time.Time) (bool, error)
+	// Due returns open polls whose deadline is not after now.
+	Due(ctx context.Context, now time.Time) ([]string, error)
+}
+
+// MemoryStore implements Store in process memory.
+// It is only suitable for a single server instance or local development.
+type MemoryStore struct {
+	polls map[string]*Poll
+	votes map[string]map[string][]string // poll -> user -> options
+	rooms map[string][]string            // room -> poll IDs
+	mu    sync.Mutex
+}
+
+// NewMemoryStore creates an empty in-memory poll store.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		polls: make(map[string]*Poll),
+		votes: make(map[string]map[string][]string),
+		rooms: make(map[string][]string),
+	}
+}
+
+// Create stores a new poll.
+func (s *MemoryStore) Create(ctx context.Context, poll *Poll) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	p := *poll
+	s.polls[p.ID] = &p
+	s.votes[p.ID] = make(map[string][]string)
+	s.rooms[p.RoomID] = append(s.rooms[p.RoomID], p.ID)
+	return nil
+}
+
+// Get returns a copy of a poll.
+func (s *MemoryStore) Get(ctx context.Context, pollID string) (*Poll, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	p, ok := s.polls[pollID]
+	if !ok {
+		return nil, ErrPollNotFound
+	}
+	cp := *p
+	return &cp, nil
+}
+
+// List returns copies of the room's polls.
+func (s *MemoryStore) List(ctx context.Context, roomID string) ([]*Poll, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	polls := make([]*Poll, 0, len(s.rooms[roomID]))
+	for _, id := range s.rooms[roomID] {
+		cp := *s.polls[id]
+		polls = append(polls, &cp)
+	}
+	return polls, nil
+}
+
+// Vote records a vote.
+func (s *MemoryStore) Vote(ctx context.Context, pollID, userID string, optionIDs []string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	p, ok := s.polls[pollID]
+	if !ok {
+		return ErrPollNotFound
+	}
+	if p.Closed {
+		return ErrPollClosed
+	}
+	if _, voted := s.votes[pollID][userID]; voted {
+		return ErrAlreadyVoted
+	}
+	s.votes[pollID][userID] = append([]string(nil), optionIDs...)
+	return nil
+}
+
+// Votes returns a copy of the poll's votes.
+func (s *MemoryStore) Votes(ctx context.Context, pollID string) (map[string][]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	votes := make(map[string][]string, len(s.votes[pollID]))
+	for user, options := range s.votes[pollID] {
+		votes[user] = append([]string(nil), options...)
+	}
+	return votes, nil
+}
+
+// Close closes an open poll.
+func (s *MemoryStore) Close(ctx context.Context, pollID string, at time.Time) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	p, ok := s.polls[pollID]
+	if !ok {
+		return false, ErrPollNotFound
+	}
+	if p.Closed {
+		return false, nil
+	}
+	p.Closed = true
+	p.ClosedAt = &at
+	return true, nil
+}
+
+// Due returns open polls past their deadline.
+func (s *MemoryStore) Due(ctx context.Context, now time.Time) ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var due []string
+	for id, p := range s.polls {
+		if !p.Closed && p.expired(now) {
+			due = append(due, id)
+		}
+	}
+	sort.Strings(due)
+	return due, nil
+}
+
+// RedisStore implements Store using Redis. A poll is a JSON string; votes are
+// a hash of user ID to option IDs; closing sets a separate key so that votes
+// and closure can be checked atomically. Deadlines are kept in a sorted set
+// shared by all instances.
+type RedisStore struct {
+	client *redis.Client
+}
+
+// NewRedisStore creates a poll store backed by the given Redis client.
+func NewRedisStore(client *redis.Client) *RedisStore {
+	return &RedisStore{client: client}
+}
+
+const deadlinesKey = "rally:polls:deadlines"
+
+func pollKey(pollID string) string {
+	return "rally:poll:" + pollID
+}
+
+func votesKey(pollID string) string {
+	return "rally:poll:" + pollID + ":votes"
+}
+
+func closedKey(pollID string) string {
+	return "rally:poll:" + pollID + ":closed"
+}
+
+func roomPollsKey(roomID string) string {
+	return "rally:room:" + roomID + ":polls"
+}
+
+// Create stores a new poll.
+func (s *RedisStore) Create(ctx context.Context, poll *Poll) error {
+	data, err := json.Marshal(poll)
+	if err != nil {
+		return err
+	}
+
+	pipe := s.client.TxPipeline()
+	pipe.Set(ctx, pollKey(poll.ID), data, 0)
+	pipe.ZAdd(ctx, roomPollsKey(poll.RoomID), redis.Z{Score: float64(poll.CreatedAt.UnixMilli()), Member: poll.ID})
+	if poll.Deadline != nil {
+		pipe.ZAdd(ctx, deadlinesKey, redis.Z{Score: float64(poll.Deadline.UnixMilli()), Member: poll.ID})
+	}
+	_, err = pipe.Exec(ctx)
+	return err
+}
+
+// Get returns a poll with its closed state.
+func (s *RedisStore) Get(ctx context.Context, pollID string) (*Poll, error) {
+	pipe := s.client.Pipeline()
+	data := pipe.Get(ctx, pollKey(pollID))
+	closed := pipe.Get(ctx, closedKey(pollID))
+	if _, err := pipe.Exec(ctx); err != nil && !errors.Is(err, redis.Nil) {
+		return nil, err
+	}
+	return decodePoll(data, closed)
+}
+
+// List returns the room's polls.
+func (s *RedisStore) List(ctx context.Context, roomID string) ([]*Poll, error) {
+	ids, err := s.client.ZRange(ctx, roomPollsKey(roomID), 0, -1).Result()
+	if err != nil || len(ids) == 0 {
+		return nil, err
+	}
+
+	pipe := s.client.Pipeline()
+	data := make([]*redis.StringCmd, len(ids))
+	closed := make([]*redis.StringCmd, len(ids))
+	for i, id := range ids {
+		data[i] = pipe.Get(ctx, pollKey(id))
+		closed[i] = pipe.Get(ctx, closedKey(id))
+	}
+	if _, err := pipe.Exec(ctx); err != nil && !errors.Is(err, redis.Nil) {
+		return nil, err
+	}
+
+	polls := make([]*Poll, 0, len(ids))
+	for i := range ids {
+		p, err := decodePoll(data[i], closed[i])
+		if errors.Is(err, ErrPollNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		polls = append(polls, p)
+	}
+	return polls, nil
+}
+
+func decodePoll(data, closed *redis.StringCmd) (*Poll, error) {
+	raw, err := data.Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, ErrPollNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var p Poll
+	if err := json.Unmarshal(raw, &p); err != nil {
+		return nil, err
+	}
+
+	unix, err := closed.Int64()
+	switch {
+	case errors.Is(err, redis.Nil):
+	case err != nil:
+		return nil, err
+	default:
+		at := time.UnixMilli(unix).UTC()
+		p.Closed = true
+		p.ClosedAt = &at
+	}
+	return &p, nil
+}
+
+// voteScript records a vote unless the poll is closed or the user has
+// already voted. It returns 1 on success, 0 if the user has voted and -1 if
+// the poll is closed.
+var voteScript = redis.NewScript(`
+if redis.call("EXISTS", KEYS[1]) == 1 then
+	return -1
+end
+return redis.call("HSETNX", KEYS[2], ARGV[1], ARGV[2])
+`)
+
+// Vote records a vote.
+func (s *RedisStore) Vote(ctx context.Context, pollID, userID string, optionIDs []string) error {
+	choice, err := json.Marshal(optionIDs)
+	if err != nil {
+		return err
+	}
+
+	res, err := voteScript.Run(ctx, s.client, []string{closedKey(pollID), votesKey(pollID)}, userID, choice).Int()
+	if err != nil {
+		return err
+	}
+	switch res {
+	case -1:
+		return ErrPollClosed
+	case 0:
+		return ErrAlreadyVoted
+	}
+	return nil
+}
+
+// Votes returns the poll's votes.
+func (s *RedisStore) Votes(ctx context.Context, pollID string) (map[string][]string, error) {
+	raw, err := s.client.HGetAll(ctx, votesKey(pollID)).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	votes := make(map[string][]string, len(raw))
+	for user, choice := range raw {
+		var options []string
+		if err := json.Unmarshal([]byte(choice), &options); err != nil {
+			continue
+		}
+		votes[user] = options
+	}
+	return votes, nil
+}
+
+// Close closes an open poll. Only the first caller across all instances
+// gets true.
+func (s *RedisStore) Close(ctx context.Context, pollID string, at time.Time) (bool, error) {
+	closed, err := s.client.SetNX(ctx, closedKey(pollID), strconv.FormatInt(at.UnixMilli(), 10), 0).Result()
+	if err != nil {
+		return false, err
+	}
+
+	// The poll is closed either way. A deadline left behind is removed when
+	// the sweep finds it and tries to close the poll again.
+	if err := s.client.ZRem(ctx, deadlinesKey, pollID).Err(); err != nil {
+		log.Printf("Failed to remove deadline of closed poll %s: %v", pollID, err)
+	}
+	return closed, nil
+}
+
+// Due returns open polls past their deadline.
+func (s *RedisStore) Due(ctx context.Context, now time.Time) ([]string, error) {
+	return s.client.ZRangeByScore(ctx, deadlinesKey, &redis.ZRangeBy{
+		Min: "-inf",
+		Max: strconv.FormatInt(now.UnixMilli(), 10),
+	}).Result()
+}
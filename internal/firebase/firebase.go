@@ -7,16 +7,19 @@ import (
 
 	fb "firebase.google.com/go/v4"
 	"firebase.google.com/go/v4/auth"
+	"firebase.google.com/go/v4/messaging"
 	"google.golang.org/api/option"
 )
 
 var (
-	app        *fb.App
-	authClient *auth.Client
-	once       sync.Once
+	app             *fb.App
+	authClient      *auth.Client
+	messagingClient *messaging.Client
+	once            sync.Once
 )
 
-// InitializeClient initialises the Firebase app and Auth client once.
+// InitializeClient initialises the Firebase app, Auth and Messaging clients
+// once.
 // Pass an empty credentialsPath on Cloud Run to use Application Default Credentials.
 func InitializeClient(credentialsPath string) error {
 	var err error
@@ -39,6 +42,12 @@ func InitializeClient(credentialsPath string) error {
 			return
 		}
 
+		messagingClient, err = app.Messaging(ctx)
+		if err != nil {
+			log.Printf("Error initialising Firebase Messaging client: %v", err)
+			return
+		}
+
 		log.Println("Firebase initialised successfully")
 	})
 	return err
@@ -53,6 +62,15 @@ func GetAuthClient() *auth.Client {
 	return authClient
 }
 
+// GetMessagingClient returns the singleton Firebase Cloud Messaging client.
+// Panics if InitializeClient has not been called successfully first.
+func GetMessagingClient() *messaging.Client {
+	if messagingClient == nil {
+		log.Fatal("Firebase messaging client not initialised — call InitializeClient() first")
+	}
+	return messagingClient
+}
+
 // MustInitialize calls InitializeClient and fatals on error.
 func MustInitialize(credentialsPath string) {
 	if err := InitializeClient(credentialsPath); err != nil {
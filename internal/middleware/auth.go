@@ -2,31 +2,126 @@ package middleware
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"net/http"
+	"strings"
 	"time"
 
-	"firebase.google.com/go/v4/auth"
+	"github.com/gorilla/websocket"
 )
 
-// VerifyWSToken extracts a Firebase ID token from the "token" query parameter,
-// verifies it with Firebase Auth, and returns the verified Firebase UID on success.
+// verifyTimeout bounds token verification, which may fetch signing keys.
+const verifyTimeout = 5 * time.Second
+
+// TokenProtocolPrefix marks the Sec-WebSocket-Protocol entry carrying a
+// token: "rally.auth.<token>". It is offered next to the client's real
+// subprotocol and never selected.
+const TokenProtocolPrefix = "rally.auth."
+
+// ErrNoToken is returned by VerifyWSToken when the request carries no
+// token, which the client must then send in its first message.
+var ErrNoToken = errors.New("no token")
+
+// ErrTokenRejected is returned by verifiers when the token itself is not
+// acceptable, as opposed to the identity provider being unreachable.
+var ErrTokenRejected = errors.New("token rejected")
+
+// Identity is the user a verified token belongs to.
+type Identity struct {
+	UserID string
+	// ExpiresAt is when the token stops being valid. Connections opened
+	// with it must present a new one before then.
+	ExpiresAt time.Time
+	// Roles are the user's roles by room ID, from the RolesClaim custom
+	// claim, if the token has one.
+	Roles map[string]string
+}
+
+// RolesClaim is the custom claim in which the backend grants a user roles
+// in rooms, as an object of room IDs to role names.
+const RolesClaim = "rally_roles"
+
+// roomRoles reads RolesClaim from a token's claims, skipping anything that
+// is not a string.
+func roomRoles(claims map[string]any) map[string]string {
+	obj, ok := claims[RolesClaim].(map[string]any)
+	if !ok {
+		return nil
+	}
+	roles := make(map[string]string, len(obj))
+	for roomID, v := range obj {
+		if role, ok := v.(string); ok {
+			roles[roomID] = role
+		}
+	}
+	return roles
+}
+
+// TokenVerifier verifies ID tokens and returns whose they are. Errors about
+// the token itself wrap ErrTokenRejected.
+type TokenVerifier interface {
+	// Verify checks a token presented with a request or when connecting.
+	Verify(ctx context.Context, token string) (*Identity, error)
+
+	// VerifyRefresh checks a token presented to keep an open connection
+	// alive. Verifiers that can should also check that the token has not
+	// been revoked and the account is not disabled.
+	VerifyRefresh(ctx context.Context, token string) (*Identity, error)
+}
+
+// VerifyWSToken verifies the ID token of a WebSocket upgrade request and
+// returns the verified identity on success.
 //
-// WebSocket connections from browsers cannot set custom HTTP headers, so the
-// token is passed as a URL query parameter instead.
-func VerifyWSToken(firebaseAuth *auth.Client, r *http.Request) (string, error) {
-	token := r.URL.Query().Get("token")
+// WebSocket connections from browsers cannot set custom HTTP headers, so
+// the token comes in a Sec-WebSocket-Protocol entry prefixed with
+// TokenProtocolPrefix, or, if allowQuery is set for older clients, in the
+// "token" query parameter, which ends up in proxy and access logs. Without
+// either, it returns ErrNoToken.
+func VerifyWSToken(verifier TokenVerifier, r *http.Request, allowQuery bool) (*Identity, error) {
+	token := ""
+	for _, p := range websocket.Subprotocols(r) {
+		if t, ok := strings.CutPrefix(p, TokenProtocolPrefix); ok {
+			token = t
+			break
+		}
+	}
+	if token == "" && allowQuery {
+		token = r.URL.Query().Get("token")
+	}
 	if token == "" {
-		return "", fmt.Errorf("token query parameter is required")
+		return nil, ErrNoToken
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
+	return verifyToken(verifier, token)
+}
 
-	t, err := firebaseAuth.VerifyIDToken(ctx, token)
-	if err != nil {
-		return "", fmt.Errorf("invalid or expired token: %w", err)
+// VerifyRequestToken verifies the ID token of a plain HTTP request. The
+// token is read from an "Authorization: Bearer" header, or else from the
+// "token" query parameter, since EventSource and calendar apps subscribing
+// to a feed URL cannot send headers. Unlike VerifyWSToken, the query
+// parameter is always accepted.
+func VerifyRequestToken(verifier TokenVerifier, r *http.Request) (*Identity, error) {
+	header := r.Header.Get("Authorization")
+	if header == "" {
+		token := r.URL.Query().Get("token")
+		if token == "" {
+			return nil, fmt.Errorf("token query parameter is required")
+		}
+		return verifyToken(verifier, token)
 	}
 
-	return t.UID, nil
+	token, ok := strings.CutPrefix(header, "Bearer ")
+	if !ok || token == "" {
+		return nil, fmt.Errorf("authorization header must be a bearer token")
+	}
+	return verifyToken(verifier, token)
+}
+
+// verifyToken verifies a token presented with a request.
+func verifyToken(verifier TokenVerifier, token string) (*Identity, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), verifyTimeout)
+	defer cancel()
+
+	return verifier.Verify(ctx, token)
 }
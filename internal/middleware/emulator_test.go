@@ -0,0 +1,71 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+func TestEmulatorVerifier(t *testing.T) {
+	t.Setenv(emulatorHostEnv, "")
+	if _, err := NewEmulatorVerifier("demo-rally"); err == nil {
+		t.Errorf("NewEmulatorVerifier() without %s succeeded, want an error", emulatorHostEnv)
+	}
+	t.Setenv(emulatorHostEnv, "localhost:9099")
+	if _, err := NewEmulatorVerifier(""); err == nil {
+		t.Error("NewEmulatorVerifier() without a project succeeded, want an error")
+	}
+	v, err := NewEmulatorVerifier("demo-rally")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The emulator issues unsigned tokens.
+	unsigned := func(edit func(jwt.MapClaims)) string {
+		return signToken(t, jwt.SigningMethodNone, jwt.UnsafeAllowNoneSignatureType,
+			testClaims("https://securetoken.google.com/demo-rally", "demo-rally", edit))
+	}
+
+	tests := []struct {
+		name      string
+		token     string
+		wantRoles map[string]string
+		wantErr   bool
+	}{
+		{name: "valid", token: unsigned(nil)},
+		{
+			name:      "room roles",
+			token:     unsigned(func(c jwt.MapClaims) { c[RolesClaim] = map[string]any{"trip": "moderator"} }),
+			wantRoles: map[string]string{"trip": "moderator"},
+		},
+		{name: "other project", token: unsigned(func(c jwt.MapClaims) { c["iss"] = "https://securetoken.google.com/other" }), wantErr: true},
+		{name: "wrong audience", token: unsigned(func(c jwt.MapClaims) { c["aud"] = "other" }), wantErr: true},
+		{name: "expired", token: unsigned(func(c jwt.MapClaims) { c["exp"] = time.Now().Add(-time.Minute).Unix() }), wantErr: true},
+		{name: "no subject", token: unsigned(func(c jwt.MapClaims) { delete(c, "sub") }), wantErr: true},
+		{name: "not a JWT", token: "not-a-token", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ident, err := v.Verify(context.Background(), tt.token)
+			if tt.wantErr {
+				if !errors.Is(err, ErrTokenRejected) {
+					t.Fatalf("Verify() error = %v, want %v", err, ErrTokenRejected)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Verify() error = %v", err)
+			}
+			if ident.UserID != "alice" {
+				t.Errorf("Verify() user = %q, want alice", ident.UserID)
+			}
+			if !reflect.DeepEqual(ident.Roles, tt.wantRoles) {
+				t.Errorf("Verify() roles = %v, want %v", ident.Roles, tt.wantRoles)
+			}
+		})
+	}
+}
@@ -0,0 +1,57 @@
+package middleware
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"firebase.google.com/go/v4/auth"
+)
+
+// FirebaseVerifier verifies Firebase ID tokens with the Firebase Admin SDK.
+// With FIREBASE_AUTH_EMULATOR_HOST set, the SDK accepts tokens issued by
+// the Auth emulator instead; see also EmulatorVerifier, which needs no
+// credentials.
+type FirebaseVerifier struct {
+	client *auth.Client
+}
+
+// NewFirebaseVerifier creates a verifier backed by client.
+func NewFirebaseVerifier(client *auth.Client) *FirebaseVerifier {
+	return &FirebaseVerifier{client: client}
+}
+
+// Verify checks the token's signature, issuer, audience and expiry.
+func (f *FirebaseVerifier) Verify(ctx context.Context, token string) (*Identity, error) {
+	t, err := f.client.VerifyIDToken(ctx, token)
+	if err != nil {
+		return nil, firebaseError(err)
+	}
+	return firebaseIdentity(t), nil
+}
+
+// VerifyRefresh also checks with Firebase that the token has not been
+// revoked and the account is not disabled.
+func (f *FirebaseVerifier) VerifyRefresh(ctx context.Context, token string) (*Identity, error) {
+	t, err := f.client.VerifyIDTokenAndCheckRevoked(ctx, token)
+	if err != nil {
+		return nil, firebaseError(err)
+	}
+	return firebaseIdentity(t), nil
+}
+
+func firebaseIdentity(t *auth.Token) *Identity {
+	return &Identity{UserID: t.UID, ExpiresAt: time.Unix(t.Expires, 0), Roles: roomRoles(t.Claims)}
+}
+
+// firebaseError tells rejected tokens apart from failures to reach
+// Firebase.
+func firebaseError(err error) error {
+	switch {
+	case auth.IsIDTokenInvalid(err), auth.IsIDTokenExpired(err), auth.IsIDTokenRevoked(err),
+		auth.IsUserDisabled(err), auth.IsUserNotFound(err):
+		return fmt.Errorf("%w: %v", ErrTokenRejected, err)
+	default:
+		return fmt.Errorf("verify token: %w", err)
+	}
+}
@@ -0,0 +1,168 @@
+package middleware
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+const (
+	testIssuer   = "https://issuer.example.com"
+	testAudience = "rally"
+	testKID      = "test-key"
+)
+
+// newTestJWKS generates an RSA key and writes its public half to a JWKS
+// file, returning the key and the file's path.
+func newTestJWKS(t *testing.T) (*rsa.PrivateKey, string) {
+	t.Helper()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	set := map[string]any{"keys": []map[string]string{{
+		"kty": "RSA",
+		"kid": testKID,
+		"alg": "RS256",
+		"use": "sig",
+		"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+		"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+	}}}
+	data, err := json.Marshal(set)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "jwks.json")
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return key, path
+}
+
+// signToken returns a token with claims signed by key with method, naming
+// testKID.
+func signToken(t *testing.T, method jwt.SigningMethod, key any, claims jwt.MapClaims) string {
+	t.Helper()
+
+	token := jwt.NewWithClaims(method, claims)
+	token.Header["kid"] = testKID
+	signed, err := token.SignedString(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed
+}
+
+// testClaims returns valid claims for alice, changed by edit.
+func testClaims(iss, aud string, edit func(jwt.MapClaims)) jwt.MapClaims {
+	claims := jwt.MapClaims{
+		"iss": iss,
+		"aud": aud,
+		"sub": "alice",
+		"exp": time.Now().Add(time.Hour).Unix(),
+	}
+	if edit != nil {
+		edit(claims)
+	}
+	return claims
+}
+
+func TestJWKSVerifier(t *testing.T) {
+	key, path := newTestJWKS(t)
+	v, err := NewJWKSVerifier(JWKSOptions{Source: path, Issuer: testIssuer, Audience: testAudience})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(v.Close)
+
+	otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	claims := func(edit func(jwt.MapClaims)) jwt.MapClaims {
+		return testClaims(testIssuer, testAudience, edit)
+	}
+
+	tests := []struct {
+		name      string
+		token     string
+		wantRoles map[string]string
+		wantErr   bool
+	}{
+		{name: "valid", token: signToken(t, jwt.SigningMethodRS256, key, claims(nil))},
+		{
+			name: "room roles",
+			token: signToken(t, jwt.SigningMethodRS256, key, claims(func(c jwt.MapClaims) {
+				c[RolesClaim] = map[string]any{"trip": "guest", "bad": 1}
+			})),
+			wantRoles: map[string]string{"trip": "guest"},
+		},
+		{name: "bad signature", token: signToken(t, jwt.SigningMethodRS256, otherKey, claims(nil)), wantErr: true},
+		{name: "alg none", token: signToken(t, jwt.SigningMethodNone, jwt.UnsafeAllowNoneSignatureType, claims(nil)), wantErr: true},
+		{name: "HS256", token: signToken(t, jwt.SigningMethodHS256, []byte("secret"), claims(nil)), wantErr: true},
+		{name: "wrong issuer", token: signToken(t, jwt.SigningMethodRS256, key, claims(func(c jwt.MapClaims) { c["iss"] = "https://evil.example.com" })), wantErr: true},
+		{name: "wrong audience", token: signToken(t, jwt.SigningMethodRS256, key, claims(func(c jwt.MapClaims) { c["aud"] = "other" })), wantErr: true},
+		{name: "expired", token: signToken(t, jwt.SigningMethodRS256, key, claims(func(c jwt.MapClaims) { c["exp"] = time.Now().Add(-time.Minute).Unix() })), wantErr: true},
+		{name: "no expiry", token: signToken(t, jwt.SigningMethodRS256, key, claims(func(c jwt.MapClaims) { delete(c, "exp") })), wantErr: true},
+		{name: "no subject", token: signToken(t, jwt.SigningMethodRS256, key, claims(func(c jwt.MapClaims) { delete(c, "sub") })), wantErr: true},
+		{name: "not a JWT", token: "not-a-token", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ident, err := v.Verify(context.Background(), tt.token)
+			if tt.wantErr {
+				if !errors.Is(err, ErrTokenRejected) {
+					t.Fatalf("Verify() error = %v, want %v", err, ErrTokenRejected)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Verify() error = %v", err)
+			}
+			if ident.UserID != "alice" || time.Until(ident.ExpiresAt) <= 0 {
+				t.Errorf("Verify() = %+v, want alice with a future expiry", ident)
+			}
+			if !reflect.DeepEqual(ident.Roles, tt.wantRoles) {
+				t.Errorf("Verify() roles = %v, want %v", ident.Roles, tt.wantRoles)
+			}
+		})
+	}
+}
+
+func TestJWKSVerifierOptions(t *testing.T) {
+	key, path := newTestJWKS(t)
+
+	for _, opts := range []JWKSOptions{
+		{Issuer: testIssuer, Audience: testAudience},
+		{Source: path, Audience: testAudience},
+		{Source: path, Issuer: testIssuer},
+		{Source: filepath.Join(t.TempDir(), "missing.json"), Issuer: testIssuer, Audience: testAudience},
+	} {
+		if _, err := NewJWKSVerifier(opts); err == nil {
+			t.Errorf("NewJWKSVerifier(%+v) succeeded, want an error", opts)
+		}
+	}
+
+	// The user ID may come from another claim.
+	v, err := NewJWKSVerifier(JWKSOptions{Source: path, Issuer: testIssuer, Audience: testAudience, UserClaim: "user_id"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer v.Close()
+	token := signToken(t, jwt.SigningMethodRS256, key, testClaims(testIssuer, testAudience, func(c jwt.MapClaims) { c["user_id"] = "bob" }))
+	if ident, err := v.Verify(context.Background(), token); err != nil || ident.UserID != "bob" {
+		t.Errorf("Verify() = %+v, %v; want bob from user_id", ident, err)
+	}
+}
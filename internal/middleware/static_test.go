@@ -0,0 +1,53 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseStaticTokens(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    map[string]string
+		wantErr bool
+	}{
+		{in: "", want: map[string]string{}},
+		{in: "alice-token=alice", want: map[string]string{"alice-token": "alice"}},
+		{in: " alice-token=alice, bob-token=bob ,", want: map[string]string{"alice-token": "alice", "bob-token": "bob"}},
+		{in: "alice-token", wantErr: true},
+		{in: "=alice", wantErr: true},
+		{in: "alice-token=", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := ParseStaticTokens(tt.in)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParseStaticTokens(%q) error = %v, want error %v", tt.in, err, tt.wantErr)
+			continue
+		}
+		if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("ParseStaticTokens(%q) = %v, want %v", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestStaticVerifier(t *testing.T) {
+	v := NewStaticVerifier(map[string]string{"alice-token": "alice"}, time.Minute)
+
+	for _, verify := range []func(context.Context, string) (*Identity, error){v.Verify, v.VerifyRefresh} {
+		before := time.Now()
+		ident, err := verify(context.Background(), "alice-token")
+		if err != nil || ident.UserID != "alice" {
+			t.Fatalf("verify(alice-token) = %+v, %v; want alice", ident, err)
+		}
+		if ident.ExpiresAt.Before(before.Add(time.Minute)) || ident.ExpiresAt.After(time.Now().Add(time.Minute)) {
+			t.Errorf("verify(alice-token) expires at %v, want a minute from now", ident.ExpiresAt)
+		}
+
+		if _, err := verify(context.Background(), "mallory-token"); !errors.Is(err, ErrTokenRejected) {
+			t.Errorf("verify(mallory-token) error = %v, want %v", err, ErrTokenRejected)
+		}
+	}
+}
@@ -85,6 +85,12 @@ func (r *RedisPubSub) Subscribe(pattern string) <-chan PubSubMessage {
 	return messages
 }
 
+// Client returns the underlying Redis client so that Redis-backed stores can
+// share the connection pool.
+func (r *RedisPubSub) Client() *redis.Client {
+	return r.client
+}
+
 // Close closes the Redis connection.
 func (r *RedisPubSub) Close() error {
 	r.cancel()
@@ -0,0 +1,56 @@
+package rooms
+
+import (
+	"fmt"
+	"strings"
+)
+
+// directRoomPrefix marks private rooms for 1:1 direct conversations.
+const directRoomPrefix = "dm:"
+
+// DirectRoomID returns the private room shared by two users. The ID does not
+// depend on argument order, so both users always resolve the same room.
+func DirectRoomID(a, b string) (string, error) {
+	if a == "" || b == "" {
+		return "", fmt.Errorf("both user IDs are required")
+	}
+	if a == b {
+		return "", fmt.Errorf("cannot start a direct conversation with yourself")
+	}
+	if strings.Contains(a, ":") || strings.Contains(b, ":") {
+		return "", fmt.Errorf("user IDs must not contain ':'")
+	}
+
+	if b < a {
+		a, b = b, a
+	}
+	return directRoomPrefix + a + ":" + b, nil
+}
+
+// IsDirectRoom reports whether roomID is a direct conversation room.
+func IsDirectRoom(roomID string) bool {
+	return strings.HasPrefix(roomID, directRoomPrefix)
+}
+
+// DirectParticipants returns the two users of a direct conversation room.
+// ok is false if roomID is not a well-formed direct room ID.
+func DirectParticipants(roomID string) (a, b string, ok bool) {
+	if !IsDirectRoom(roomID) {
+		return "", "", false
+	}
+	a, b, ok = strings.Cut(strings.TrimPrefix(roomID, directRoomPrefix), ":")
+	if !ok || a == "" || b == "" || a >= b {
+		return "", "", false
+	}
+	return a, b, true
+}
+
+// CanAccess reports whether userID may join or send to roomID. Direct rooms
+// are limited to their two participants; every other room is open.
+func CanAccess(roomID, userID string) bool {
+	if !IsDirectRoom(roomID) {
+		return true
+	}
+	a, b, ok := DirectParticipants(roomID)
+	return ok && (userID == a || userID == b)
+}
@@ -0,0 +1,264 @@
+package rooms
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// MemoryStore implements Store in process memory.
+// It is only suitable for a single server instance or local development.
+type MemoryStore struct {
+	members map[string]map[string]Role
+	claims  map[string]map[string]Role                 // room -> user -> role claimed by token
+	conns   map[string]map[string]map[string]time.Time // room -> user -> connection -> expiry
+	joined  Role                                       // role of members joining after the owner
+	mu      sync.RWMutex
+}
+
+// NewMemoryStore creates an empty in-memory membership store.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		members: make(map[string]map[string]Role),
+		claims:  make(map[string]map[string]Role),
+		conns:   make(map[string]map[string]map[string]time.Time),
+		joined:  RoleMember,
+	}
+}
+
+// SetDefaultRole sets the role of users joining a room after its owner:
+// RoleMember, the default, or RoleGuest. Existing members keep theirs.
+func (s *MemoryStore) SetDefaultRole(role Role) error {
+	if err := checkDefaultRole(role); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.joined = role
+	return nil
+}
+
+// AddMember records userID as a member of roomID.
+func (s *MemoryStore) AddMember(ctx context.Context, roomID, userID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	room, ok := s.members[roomID]
+	if !ok {
+		room = make(map[string]Role)
+		s.members[roomID] = room
+	}
+	if _, ok := room[userID]; ok {
+		return nil
+	}
+
+	if len(room) == 0 {
+		room[userID] = RoleOwner
+	} else {
+		room[userID] = s.joined
+	}
+	return nil
+}
+
+// IsMember reports whether userID is a member of roomID.
+func (s *MemoryStore) IsMember(ctx context.Context, roomID, userID string) (bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	_, ok := s.members[roomID][userID]
+	return ok, nil
+}
+
+// Members returns the user IDs of every member of roomID.
+func (s *MemoryStore) Members(ctx context.Context, roomID string) ([]string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	ids := make([]string, 0, len(s.members[roomID]))
+	for id := range s.members[roomID] {
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+// Role returns the role of userID in roomID.
+func (s *MemoryStore) Role(ctx context.Context, roomID, userID string) (Role, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	role, ok := s.members[roomID][userID]
+	if !ok {
+		return RoleNone, nil
+	}
+	if claimed, ok := s.claims[roomID][userID]; ok {
+		return claimed, nil
+	}
+	return role, nil
+}
+
+// SetRole changes the role of an existing member.
+func (s *MemoryStore) SetRole(ctx context.Context, roomID, userID string, role Role) error {
+	if !role.IsValid() {
+		return fmt.Errorf("invalid role %q", role)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.members[roomID][userID]; !ok {
+		return fmt.Errorf("user %s is not a member of room %s", userID, roomID)
+	}
+	if role == RoleOwner {
+		// A room has a single owner; the previous one becomes a member.
+		for id, r := range s.members[roomID] {
+			if r == RoleOwner {
+				s.members[roomID][id] = RoleMember
+			}
+		}
+	}
+	s.members[roomID][userID] = role
+	return nil
+}
+
+// SetClaimedRole records or, with RoleNone, clears the role a token claim
+// grants an existing member.
+func (s *MemoryStore) SetClaimedRole(ctx context.Context, roomID, userID string, role Role) error {
+	if role != RoleNone && !role.IsValid() {
+		return fmt.Errorf("invalid role %q", role)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if role == RoleNone {
+		delete(s.claims[roomID], userID)
+		return nil
+	}
+	if _, ok := s.members[roomID][userID]; !ok {
+		return fmt.Errorf("user %s is not a member of room %s", userID, roomID)
+	}
+	if s.claims[roomID] == nil {
+		s.claims[roomID] = make(map[string]Role)
+	}
+	s.claims[roomID][userID] = role
+	return nil
+}
+
+// RemoveMember drops userID from roomID.
+func (s *MemoryStore) RemoveMember(ctx context.Context, roomID, userID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.members[roomID], userID)
+	delete(s.claims[roomID], userID)
+	return nil
+}
+
+// Connect records a connection of userID to roomID.
+func (s *MemoryStore) Connect(ctx context.Context, roomID, userID, connID string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	room, ok := s.conns[roomID]
+	if !ok {
+		room = make(map[string]map[string]time.Time)
+		s.conns[roomID] = room
+	}
+	conns, ok := room[userID]
+	if !ok {
+		conns = make(map[string]time.Time)
+		room[userID] = conns
+	}
+	conns[connID] = time.Now().Add(PresenceTTL)
+	return len(conns) == 1, nil
+}
+
+// Refresh extends the expiry of a connection of userID to roomID.
+func (s *MemoryStore) Refresh(ctx context.Context, roomID, userID, connID string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	conns := s.conns[roomID][userID]
+	if _, ok := conns[connID]; !ok {
+		return false, nil
+	}
+	conns[connID] = time.Now().Add(PresenceTTL)
+	return true, nil
+}
+
+// Disconnect removes a connection of userID from roomID.
+func (s *MemoryStore) Disconnect(ctx context.Context, roomID, userID, connID string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.disconnectLocked(roomID, userID, connID), nil
+}
+
+// disconnectLocked removes a connection and reports whether it was the
+// user's last. The caller must hold s.mu for writing.
+func (s *MemoryStore) disconnectLocked(roomID, userID, connID string) bool {
+	conns, ok := s.conns[roomID][userID]
+	if !ok {
+		return false
+	}
+	if _, ok := conns[connID]; !ok {
+		return false
+	}
+	delete(conns, connID)
+	if len(conns) > 0 {
+		return false
+	}
+
+	delete(s.conns[roomID], userID)
+	if len(s.conns[roomID]) == 0 {
+		delete(s.conns, roomID)
+	}
+	return true
+}
+
+// Expire removes the connections that expired before now.
+func (s *MemoryStore) Expire(ctx context.Context, now time.Time) ([]ExpiredConnection, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var expired []ExpiredConnection
+	for roomID, room := range s.conns {
+		for userID, conns := range room {
+			for connID, expiry := range conns {
+				if expiry.Before(now) {
+					expired = append(expired, ExpiredConnection{RoomID: roomID, UserID: userID, ConnID: connID})
+				}
+			}
+		}
+	}
+	sort.Slice(expired, func(i, j int) bool {
+		a, b := expired[i], expired[j]
+		if a.RoomID != b.RoomID {
+			return a.RoomID < b.RoomID
+		}
+		if a.UserID != b.UserID {
+			return a.UserID < b.UserID
+		}
+		return a.ConnID < b.ConnID
+	})
+	for i := range expired {
+		e := &expired[i]
+		e.Last = s.disconnectLocked(e.RoomID, e.UserID, e.ConnID)
+	}
+	return expired, nil
+}
+
+// Online returns the users connected to roomID.
+func (s *MemoryStore) Online(ctx context.Context, roomID string) ([]string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	users := make([]string, 0, len(s.conns[roomID]))
+	for userID := range s.conns[roomID] {
+		users = append(users, userID)
+	}
+	return users, nil
+}
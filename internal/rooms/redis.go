@@ -0,0 +1,287 @@
+package rooms
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// RedisStore implements Store using Redis so that every server instance sees
+// the same membership.
+//
+// Members are kept in a set, explicit roles in a hash and the owner in its own
+// key so that the first member can be elected with SETNX. Roles claimed by
+// tokens are kept in a hash of their own. Presence is a set of connection IDs
+// per user plus a set of online users per room, and a sorted set across all
+// rooms scoring each connection by its expiry.
+type RedisStore struct {
+	client *redis.Client
+	joined Role // role of members joining after the owner
+}
+
+// NewRedisStore creates a membership store backed by the given Redis client.
+func NewRedisStore(client *redis.Client) *RedisStore {
+	return &RedisStore{client: client, joined: RoleMember}
+}
+
+// SetDefaultRole sets the role of users joining a room after its owner:
+// RoleMember, the default, or RoleGuest. Existing members keep theirs. It
+// must be called before the store is used.
+func (s *RedisStore) SetDefaultRole(role Role) error {
+	if err := checkDefaultRole(role); err != nil {
+		return err
+	}
+	s.joined = role
+	return nil
+}
+
+func membersKey(roomID string) string {
+	return "rally:room:" + roomID + ":members"
+}
+
+func rolesKey(roomID string) string {
+	return "rally:room:" + roomID + ":roles"
+}
+
+func claimsKey(roomID string) string {
+	return "rally:room:" + roomID + ":claims"
+}
+
+func ownerKey(roomID string) string {
+	return "rally:room:" + roomID + ":owner"
+}
+
+func onlineKey(roomID string) string {
+	return "rally:room:" + roomID + ":online"
+}
+
+func connsKey(roomID, userID string) string {
+	return "rally:room:" + roomID + ":conns:" + userID
+}
+
+// connExpiriesKey holds every connection, in every room, scored by its
+// expiry in Unix milliseconds.
+const connExpiriesKey = "rally:presence:expiries"
+
+// connMember identifies a connection in connExpiriesKey.
+func connMember(roomID, userID, connID string) string {
+	return roomID + "\x00" + userID + "\x00" + connID
+}
+
+// AddMember records userID as a member of roomID.
+func (s *RedisStore) AddMember(ctx context.Context, roomID, userID string) error {
+	added, err := s.client.SAdd(ctx, membersKey(roomID), userID).Result()
+	if err != nil {
+		return err
+	}
+	owner, err := s.client.SetNX(ctx, ownerKey(roomID), userID, 0).Result()
+	if err != nil || added == 0 || owner || s.joined == RoleMember {
+		return err
+	}
+	// Members without an explicit role are members; see Role.
+	return s.client.HSetNX(ctx, rolesKey(roomID), userID, string(s.joined)).Err()
+}
+
+// IsMember reports whether userID is a member of roomID.
+func (s *RedisStore) IsMember(ctx context.Context, roomID, userID string) (bool, error) {
+	return s.client.SIsMember(ctx, membersKey(roomID), userID).Result()
+}
+
+// Members returns the user IDs of every member of roomID.
+func (s *RedisStore) Members(ctx context.Context, roomID string) ([]string, error) {
+	return s.client.SMembers(ctx, membersKey(roomID)).Result()
+}
+
+// Role returns the role of userID in roomID.
+func (s *RedisStore) Role(ctx context.Context, roomID, userID string) (Role, error) {
+	pipe := s.client.Pipeline()
+	isMember := pipe.SIsMember(ctx, membersKey(roomID), userID)
+	owner := pipe.Get(ctx, ownerKey(roomID))
+	role := pipe.HGet(ctx, rolesKey(roomID), userID)
+	claimed := pipe.HGet(ctx, claimsKey(roomID), userID)
+	if _, err := pipe.Exec(ctx); err != nil && !errors.Is(err, redis.Nil) {
+		return RoleNone, err
+	}
+
+	if !isMember.Val() {
+		return RoleNone, nil
+	}
+	if r := Role(claimed.Val()); r.IsValid() {
+		return r, nil
+	}
+	if owner.Val() == userID {
+		return RoleOwner, nil
+	}
+	if r := Role(role.Val()); r.IsValid() {
+		return r, nil
+	}
+	return RoleMember, nil
+}
+
+// SetRole changes the role of an existing member.
+func (s *RedisStore) SetRole(ctx context.Context, roomID, userID string, role Role) error {
+	if !role.IsValid() {
+		return fmt.Errorf("invalid role %q", role)
+	}
+
+	ok, err := s.IsMember(ctx, roomID, userID)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return fmt.Errorf("user %s is not a member of room %s", userID, roomID)
+	}
+
+	if role == RoleOwner {
+		return s.client.Set(ctx, ownerKey(roomID), userID, 0).Err()
+	}
+	return s.client.HSet(ctx, rolesKey(roomID), userID, string(role)).Err()
+}
+
+// SetClaimedRole records or, with RoleNone, clears the role a token claim
+// grants an existing member.
+func (s *RedisStore) SetClaimedRole(ctx context.Context, roomID, userID string, role Role) error {
+	if role == RoleNone {
+		return s.client.HDel(ctx, claimsKey(roomID), userID).Err()
+	}
+	if !role.IsValid() {
+		return fmt.Errorf("invalid role %q", role)
+	}
+
+	ok, err := s.IsMember(ctx, roomID, userID)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return fmt.Errorf("user %s is not a member of room %s", userID, roomID)
+	}
+	return s.client.HSet(ctx, claimsKey(roomID), userID, string(role)).Err()
+}
+
+// RemoveMember drops userID from roomID along with their roles. The owner
+// key is left alone; the owner cannot be removed by moderation.
+func (s *RedisStore) RemoveMember(ctx context.Context, roomID, userID string) error {
+	pipe := s.client.TxPipeline()
+	pipe.SRem(ctx, membersKey(roomID), userID)
+	pipe.HDel(ctx, rolesKey(roomID), userID)
+	pipe.HDel(ctx, claimsKey(roomID), userID)
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+// connectScript adds a connection with its expiry and marks the user online.
+// It returns 1 if this is the user's first connection to the room.
+var connectScript = redis.NewScript(`
+redis.call("SADD", KEYS[1], ARGV[1])
+redis.call("ZADD", KEYS[3], ARGV[4], ARGV[3])
+if redis.call("SCARD", KEYS[1]) == 1 then
+	redis.call("SADD", KEYS[2], ARGV[2])
+	return 1
+end
+return 0
+`)
+
+// refreshScript sets the expiry of a connection if it is still recorded. It
+// returns 1 if it was.
+var refreshScript = redis.NewScript(`
+if not redis.call("ZSCORE", KEYS[1], ARGV[1]) then
+	return 0
+end
+redis.call("ZADD", KEYS[1], ARGV[2], ARGV[1])
+return 1
+`)
+
+// disconnectBody removes a connection and marks the user offline once none
+// are left. It returns 1 if this was the user's last connection.
+const disconnectBody = `
+redis.call("ZREM", KEYS[3], ARGV[3])
+if redis.call("SREM", KEYS[1], ARGV[1]) == 0 then
+	return 0
+end
+if redis.call("SCARD", KEYS[1]) == 0 then
+	redis.call("SREM", KEYS[2], ARGV[2])
+	return 1
+end
+return 0
+`
+
+var disconnectScript = redis.NewScript(disconnectBody)
+
+// expireScript disconnects a connection if it expired before ARGV[4]. It
+// returns -1 if the connection was refreshed or removed in the meantime.
+var expireScript = redis.NewScript(`
+local expiry = redis.call("ZSCORE", KEYS[3], ARGV[3])
+if not expiry or tonumber(expiry) >= tonumber(ARGV[4]) then
+	return -1
+end
+` + disconnectBody)
+
+// presenceKeys returns the keys of the presence scripts.
+func presenceKeys(roomID, userID string) []string {
+	return []string{connsKey(roomID, userID), onlineKey(roomID), connExpiriesKey}
+}
+
+// Connect records a connection of userID to roomID.
+func (s *RedisStore) Connect(ctx context.Context, roomID, userID, connID string) (bool, error) {
+	expiry := time.Now().Add(PresenceTTL).UnixMilli()
+	n, err := connectScript.Run(ctx, s.client, presenceKeys(roomID, userID), connID, userID, connMember(roomID, userID, connID), expiry).Int()
+	return n == 1, err
+}
+
+// Refresh extends the expiry of a connection of userID to roomID.
+func (s *RedisStore) Refresh(ctx context.Context, roomID, userID, connID string) (bool, error) {
+	expiry := time.Now().Add(PresenceTTL).UnixMilli()
+	n, err := refreshScript.Run(ctx, s.client, []string{connExpiriesKey}, connMember(roomID, userID, connID), expiry).Int()
+	return n == 1, err
+}
+
+// Disconnect removes a connection of userID from roomID.
+func (s *RedisStore) Disconnect(ctx context.Context, roomID, userID, connID string) (bool, error) {
+	n, err := disconnectScript.Run(ctx, s.client, presenceKeys(roomID, userID), connID, userID, connMember(roomID, userID, connID)).Int()
+	return n == 1, err
+}
+
+// Expire removes the connections that expired before now. Every instance
+// sweeps; the script lets only one of them remove each connection.
+func (s *RedisStore) Expire(ctx context.Context, now time.Time) ([]ExpiredConnection, error) {
+	deadline := now.UnixMilli()
+	members, err := s.client.ZRangeByScore(ctx, connExpiriesKey, &redis.ZRangeBy{
+		Min: "-inf",
+		Max: "(" + strconv.FormatInt(deadline, 10),
+	}).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	var expired []ExpiredConnection
+	for _, member := range members {
+		parts := strings.SplitN(member, "\x00", 3)
+		if len(parts) != 3 {
+			log.Printf("Dropping malformed presence entry %q", member)
+			s.client.ZRem(ctx, connExpiriesKey, member)
+			continue
+		}
+		roomID, userID, connID := parts[0], parts[1], parts[2]
+
+		n, err := expireScript.Run(ctx, s.client, presenceKeys(roomID, userID), connID, userID, member, deadline).Int()
+		if err != nil {
+			return expired, err
+		}
+		if n < 0 {
+			continue // refreshed, or removed by another instance
+		}
+		expired = append(expired, ExpiredConnection{RoomID: roomID, UserID: userID, ConnID: connID, Last: n == 1})
+	}
+	return expired, nil
+}
+
+// Online returns the users connected to roomID.
+func (s *RedisStore) Online(ctx context.Context, roomID string) ([]string, error) {
+	return s.client.SMembers(ctx, onlineKey(roomID)).Result()
+}
@@ -0,0 +1,137 @@
+package rooms
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// Role is a member's role within a room.
+type Role string
+
+const (
+	// RoleNone means the user is not a member of the room.
+	RoleNone Role = ""
+	// RoleGuest can chat and follow the room but not change anything.
+	RoleGuest Role = "guest"
+	// RoleMember is the role of everyone who joins a room after its owner,
+	// unless the store's default role is RoleGuest.
+	RoleMember Role = "member"
+	// RoleModerator can mute, kick and ban members.
+	RoleModerator Role = "moderator"
+	// RoleOwner is the first member of a room. The owner can moderate and
+	// appoint moderators, and cannot be moderated.
+	RoleOwner Role = "owner"
+)
+
+// IsValid checks if the role can be assigned.
+func (r Role) IsValid() bool {
+	switch r {
+	case RoleGuest, RoleMember, RoleModerator, RoleOwner:
+		return true
+	}
+	return false
+}
+
+// roleRanks orders the roles, each allowed everything the ones below are.
+var roleRanks = map[Role]int{RoleGuest: 1, RoleMember: 2, RoleModerator: 3, RoleOwner: 4}
+
+// AtLeast reports whether the role is min or above it.
+func (r Role) AtLeast(min Role) bool {
+	return roleRanks[r] >= roleRanks[min]
+}
+
+// CanModerate reports whether the role may use moderation actions.
+func (r Role) CanModerate() bool {
+	return r == RoleModerator || r == RoleOwner
+}
+
+// checkDefaultRole checks a role given to stores for users joining a room
+// after its owner.
+func checkDefaultRole(role Role) error {
+	if role != RoleMember && role != RoleGuest {
+		return fmt.Errorf("default role must be %s or %s, not %q", RoleMember, RoleGuest, role)
+	}
+	return nil
+}
+
+// Store tracks which users belong to which room and their roles.
+//
+// A user becomes a member the first time they connect to a room and stays a
+// member after disconnecting, so membership outlives presence.
+type Store interface {
+	// AddMember records userID as a member of roomID. Adding an existing
+	// member is a no-op. The first member of a room becomes its owner and
+	// the others get the store's default role, RoleMember unless set
+	// otherwise.
+	AddMember(ctx context.Context, roomID, userID string) error
+
+	// IsMember reports whether userID is a member of roomID.
+	IsMember(ctx context.Context, roomID, userID string) (bool, error)
+
+	// Members returns the user IDs of every member of roomID.
+	Members(ctx context.Context, roomID string) ([]string, error)
+
+	// Role returns the role of userID in roomID, or RoleNone if they are
+	// not a member.
+	Role(ctx context.Context, roomID, userID string) (Role, error)
+
+	// SetRole changes the role of an existing member.
+	SetRole(ctx context.Context, roomID, userID string, role Role) error
+
+	// SetClaimedRole records the role a token claim grants an existing
+	// member, which Role reports instead of the one set with SetRole until
+	// it is cleared with RoleNone. Claims are kept apart so that they leave
+	// the stored role, and the room's owner, untouched.
+	SetClaimedRole(ctx context.Context, roomID, userID string, role Role) error
+
+	// RemoveMember drops userID from roomID along with their roles.
+	// Removing a user who is not a member is a no-op.
+	RemoveMember(ctx context.Context, roomID, userID string) error
+}
+
+// Presence timing.
+const (
+	// PresenceTTL is how long a connection stays present unless refreshed,
+	// so that the connections of an instance that crashed expire.
+	PresenceTTL = 90 * time.Second
+
+	// PresenceRefreshInterval is how often instances refresh their
+	// connections and sweep expired ones.
+	PresenceRefreshInterval = 30 * time.Second
+)
+
+// Presence tracks which users are connected to which room, across every
+// server instance. Each connection is counted separately so that a user with
+// several devices stays present until the last one disconnects.
+//
+// A connection expires PresenceTTL after it was recorded or last refreshed.
+// Expired connections count as present until Expire removes them.
+type Presence interface {
+	// Connect records a connection of userID to roomID and reports whether
+	// it is the user's first connection to the room.
+	Connect(ctx context.Context, roomID, userID, connID string) (first bool, err error)
+
+	// Refresh extends the expiry of a connection. It reports false if the
+	// connection is not recorded, e.g. because it has already expired.
+	Refresh(ctx context.Context, roomID, userID, connID string) (ok bool, err error)
+
+	// Disconnect removes a connection and reports whether it was the user's
+	// last connection to the room.
+	Disconnect(ctx context.Context, roomID, userID, connID string) (last bool, err error)
+
+	// Expire removes the connections that expired before now, in every
+	// room, and returns them. Each is returned by one caller only.
+	Expire(ctx context.Context, now time.Time) ([]ExpiredConnection, error)
+
+	// Online returns the user IDs with at least one connection to roomID.
+	Online(ctx context.Context, roomID string) ([]string, error)
+}
+
+// ExpiredConnection is a connection removed by Presence.Expire.
+type ExpiredConnection struct {
+	RoomID string
+	UserID string
+	ConnID string
+	Last   bool // it was the user's last connection to the room
+}
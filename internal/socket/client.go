@@ -1,11 +1,13 @@
 package socket
 
 import (
+	"context"
 	"encoding/json"
 	"log"
 	"time"
 
-	"github.com/gorilla/websocket"
+	"github.com/rally-go/rally-realtime/internal/features/chat"
+	"github.com/rally-go/rally-realtime/internal/features/planning"
 )
 
 const (
@@ -18,33 +20,98 @@ const (
 	// Send pings to peer with this period. Must be less than pongWait.
 	pingPeriod = (pongWait * 9) / 10
 
-	// Maximum message size allowed from peer.
-	maxMessageSize = 4096
+	// Maximum message size allowed from peer. It fits a chat message of
+	// chat.MaxContentRunes characters even if every one is sent as a
+	// \uXXXX surrogate pair, so that an overlong message is answered with
+	// content_too_long instead of a dropped connection.
+	maxMessageSize = chat.MaxContentRunes*12 + 8192
 )
 
-// Client represents a single WebSocket connection.
+// Close codes. 4000-4999 are reserved for private use.
+const (
+	// CloseNormal is sent when the server closes the connection for no
+	// particular reason, such as on shutdown.
+	CloseNormal = 1000
+
+	// CloseKicked is sent when a moderator removes the user from the room.
+	CloseKicked = 4001
+
+	// CloseBanned is sent when a moderator bans the user from the room.
+	CloseBanned = 4003
+
+	// CloseTokenExpired is sent when the connection's token expires
+	// without being refreshed, or a refresh is rejected because the token
+	// is invalid, revoked or the account disabled. It is also sent when a
+	// client authenticating with its first message sends no valid token.
+	CloseTokenExpired = 4401
+
+	// CloseForbidden is sent when a client authenticating with its first
+	// message turns out not to be allowed in the room.
+	CloseForbidden = 4403
+)
+
+// Client represents a single connection to a room, over whichever
+// transport the peer connected with.
 type Client struct {
 	ID     string
 	UserID string
 	RoomID string
 	Hub    *Hub
-	Conn   *websocket.Conn
 	Send   chan []byte
+
+	// Delivers frames from Send to the peer
+	transport Transport
+
+	// Codec and protocol version negotiated for the connection. Frames on
+	// Send are already encoded for it.
+	wire wire
+
+	// Close code and reason sent when the hub closes Send; set by the hub
+	// before closing the channel. Zero means a normal close.
+	closeCode   int
+	closeReason string
+
+	// Expiry of the token the client authenticated with
+	token *tokenLease
 }
 
 // MessageType represents the type of a WebSocket message.
 type MessageType string
 
 const (
-	MessageTypeChat     MessageType = "chat"
-	MessageTypeLocation MessageType = "location"
-	MessageTypePlanning MessageType = "planning"
+	MessageTypeChat       MessageType = "chat"
+	MessageTypeLocation   MessageType = "location"
+	MessageTypePlanning   MessageType = "planning"
+	MessageTypeModeration MessageType = "moderation"
+	MessageTypeDirect     MessageType = "direct"
+	MessageTypePoll       MessageType = "poll"
+
+	MessageTypeNotifications MessageType = "notifications"
+	MessageTypeChatFilters   MessageType = "chat.filters" // moderators' filter settings for the room
+	MessageTypeAuthRefresh   MessageType = "auth.refresh" // answered with the new expiry
+
+	// MessageTypeAuth carries the token in the first message of a client
+	// whose upgrade request had none, and is answered with its expiry. It
+	// is not valid afterwards.
+	MessageTypeAuth MessageType = "auth"
+
+	// Server-originated message types. Clients cannot send these.
+	MessageTypeMention MessageType = "mention"
+	MessageTypeError   MessageType = "error"
+	MessageTypeEvent   MessageType = "event" // published by a backend service
+
+	MessageTypeAuthExpiring MessageType = "auth.expiring"
+
+	MessageTypePlanningConflicts MessageType = "planning.conflicts"
+	MessageTypePlanningLockState MessageType = "planning.lock_state"
 )
 
 // IsValid checks if the message type is supported.
 func (t MessageType) IsValid() bool {
 	switch t {
-	case MessageTypeChat, MessageTypeLocation, MessageTypePlanning:
+	case MessageTypeChat, MessageTypeLocation, MessageTypePlanning, MessageTypeModeration,
+		MessageTypeDirect, MessageTypePoll, MessageTypeNotifications, MessageTypeChatFilters,
+		MessageTypeAuthRefresh:
 		return true
 	}
 	return false
@@ -58,99 +125,97 @@ type Message struct {
 }
 
 // NewClient creates a new client instance.
-func NewClient(id, userID, roomID string, hub *Hub, conn *websocket.Conn) *Client {
+func NewClient(id, userID, roomID string, hub *Hub, transport Transport) *Client {
 	return &Client{
-		ID:     id,
-		UserID: userID,
-		RoomID: roomID,
-		Hub:    hub,
-		Conn:   conn,
-		Send:   make(chan []byte, 256),
+		ID:        id,
+		UserID:    userID,
+		RoomID:    roomID,
+		Hub:       hub,
+		Send:      make(chan []byte, 256),
+		transport: transport,
+		wire:      currentWire,
 	}
 }
 
-// ReadPump pumps messages from the WebSocket connection to the hub.
-func (c *Client) ReadPump() {
-	defer func() {
-		c.Hub.Unregister <- c
-		c.Conn.Close()
-	}()
+// receive decodes a frame from the peer and routes it.
+func (c *Client) receive(data []byte) {
+	msg, err := c.wire.decode(data, c.itineraryItem)
+	if err != nil {
+		log.Printf("Invalid message format: %v", err)
+		return
+	}
+	if msg == nil {
+		return // no equivalent in the current protocol
+	}
 
-	c.Conn.SetReadLimit(maxMessageSize)
-	c.Conn.SetReadDeadline(time.Now().Add(pongWait))
-	c.Conn.SetPongHandler(func(string) error {
-		c.Conn.SetReadDeadline(time.Now().Add(pongWait))
-		return nil
-	})
+	// Validate message type
+	if !msg.Type.IsValid() {
+		log.Printf("Unsupported message type: %s", msg.Type)
+		return
+	}
 
-	for {
-		_, message, err := c.Conn.ReadMessage()
-		if err != nil {
-			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure) {
-				log.Printf("WebSocket error: %v", err)
-			}
-			break
-		}
+	// Tokens belong to the connection, not to a room
+	if msg.Type == MessageTypeAuthRefresh {
+		c.refreshToken(msg.Payload)
+		return
+	}
 
-		// Parse and route the message
-		var msg Message
-		if err := json.Unmarshal(message, &msg); err != nil {
-			log.Printf("Invalid message format: %v", err)
-			continue
-		}
+	// Set room ID from client if not in message
+	if msg.RoomID == "" {
+		msg.RoomID = c.RoomID
+	}
 
-		// Validate message type
-		if !msg.Type.IsValid() {
-			log.Printf("Unsupported message type: %s", msg.Type)
-			continue
-		}
+	c.Hub.RouteMessage(c, msg)
+}
 
-		// Set room ID from client if not in message
-		if msg.RoomID == "" {
-			msg.RoomID = c.RoomID
+// itineraryItem looks up an item for protocol adapters. Items of rooms the
+// user is not a member of are not found.
+func (c *Client) itineraryItem(ctx context.Context, roomID, itemID string) (planning.ItemView, bool, error) {
+	if roomID == "" {
+		roomID = c.RoomID
+	}
+	if roomID != c.RoomID {
+		member, err := c.Hub.Members.IsMember(ctx, roomID, c.UserID)
+		if err != nil || !member {
+			return planning.ItemView{}, false, err
 		}
-
-		c.Hub.RouteMessage(c, &msg)
 	}
+	return c.Hub.Planning.Item(ctx, roomID, itemID)
 }
 
-// WritePump pumps messages from the hub to the WebSocket connection.
+// WritePump pumps messages from the hub to the client's transport until
+// the hub closes Send or the transport fails.
 func (c *Client) WritePump() {
 	ticker := time.NewTicker(pingPeriod)
+	code, reason := 0, ""
 	defer func() {
 		ticker.Stop()
-		c.Conn.Close()
+		c.token.stop()
+		c.transport.Close(code, reason)
 	}()
 
 	for {
 		select {
 		case message, ok := <-c.Send:
-			c.Conn.SetWriteDeadline(time.Now().Add(writeWait))
 			if !ok {
 				// Hub closed the channel
-				c.Conn.WriteMessage(websocket.CloseMessage, []byte{})
+				code, reason = c.closeCode, c.closeReason
+				if code == 0 {
+					code = CloseNormal
+				}
 				return
 			}
 
-			w, err := c.Conn.NextWriter(websocket.TextMessage)
-			if err != nil {
-				return
+			// Send queued messages along with this one
+			frames := [][]byte{message}
+			for n := len(c.Send); n > 0; n-- {
+				frames = append(frames, <-c.Send)
 			}
-			w.Write(message)
-
-			// Add queued messages to the current WebSocket message
-			n := len(c.Send)
-			for i := 0; i < n; i++ {
-				w.Write([]byte{'\n'})
-				w.Write(<-c.Send)
-			}
-
-			if err := w.Close(); err != nil {
+			if err := c.transport.Send(frames); err != nil {
 				return
 			}
 		case <-ticker.C:
-			c.Conn.SetWriteDeadline(time.Now().Add(writeWait))
-			if err := c.Conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+			if err := c.transport.Ping(); err != nil {
 				return
 			}
 		}
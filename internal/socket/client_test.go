@@ -0,0 +1,133 @@
+package socket
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// countingConn counts the bytes read from the network.
+type countingConn struct {
+	net.Conn
+	n *atomic.Int64
+}
+
+func (c countingConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	c.n.Add(int64(n))
+	return n, err
+}
+
+// dialPair returns both ends of a WebSocket connection negotiated for w and
+// a counter of the bytes the client end receives.
+func dialPair(b *testing.B, w wire, compress bool) (*websocket.Conn, *websocket.Conn, *atomic.Int64) {
+	b.Helper()
+
+	upgrader := websocket.Upgrader{Subprotocols: subprotocols, EnableCompression: true}
+	conns := make(chan *websocket.Conn, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(rw, r, nil)
+		if err != nil {
+			b.Error(err)
+			return
+		}
+		conns <- conn
+	}))
+	b.Cleanup(srv.Close)
+
+	var received atomic.Int64
+	dialer := websocket.Dialer{
+		Subprotocols:      []string{subprotocol(w.codec, w.version)},
+		EnableCompression: compress,
+		NetDialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			conn, err := (&net.Dialer{}).DialContext(ctx, network, addr)
+			if err != nil {
+				return nil, err
+			}
+			return countingConn{Conn: conn, n: &received}, nil
+		},
+	}
+	client, _, err := dialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http"), nil)
+	if err != nil {
+		b.Fatal(err)
+	}
+	server := <-conns
+	b.Cleanup(func() {
+		client.Close()
+		server.Close()
+	})
+
+	received.Store(0) // leave out the handshake
+	return server, client, &received
+}
+
+// locationBatch returns n location updates encoded for w, as the hub queues
+// them for a client in a busy room.
+func locationBatch(b *testing.B, w wire, n int) [][]byte {
+	b.Helper()
+
+	start := time.Date(2026, 6, 1, 12, 0, 0, 0, time.UTC)
+	var frames [][]byte
+	for i := range n {
+		payload, _ := json.Marshal(map[string]any{
+			"user_id":   fmt.Sprintf("user-%02d", i%8),
+			"latitude":  48.856613 + float64(i)*0.000137,
+			"longitude": 2.352222 - float64(i)*0.000211,
+			"accuracy":  5 + i%10,
+			"heading":   (i * 37) % 360,
+			"speed":     1.4,
+			"timestamp": start.Add(time.Duration(i) * time.Second),
+		})
+		data, _ := json.Marshal(&Message{Type: MessageTypeLocation, RoomID: "room-7f3c2a", Payload: payload})
+		encoded, err := w.encode(data)
+		if err != nil {
+			b.Fatal(err)
+		}
+		frames = append(frames, encoded...)
+	}
+	return frames
+}
+
+// BenchmarkSendFrames measures the bytes on the wire for a batch of 20
+// location updates, per codec and protocol version, with and without
+// permessage-deflate. See the wire-B/op metric.
+func BenchmarkSendFrames(b *testing.B) {
+	for _, w := range []wire{
+		{codec: jsonCodec{}, version: ProtocolV1},
+		{codec: jsonCodec{}, version: ProtocolV2},
+		{codec: msgpackCodec{}, version: ProtocolV2},
+		{codec: cborCodec{}, version: ProtocolV2},
+	} {
+		for _, compress := range []bool{false, true} {
+			name := subprotocol(w.codec, w.version)
+			if compress {
+				name += "+deflate"
+			}
+			b.Run(name, func(b *testing.B) {
+				server, client, received := dialPair(b, w, compress)
+				t := newWSTransport(server, w)
+				frames := locationBatch(b, w, 20)
+
+				b.ResetTimer()
+				for range b.N {
+					if err := t.Send(frames); err != nil {
+						b.Fatal(err)
+					}
+					if _, _, err := client.ReadMessage(); err != nil {
+						b.Fatal(err)
+					}
+				}
+				b.ReportMetric(float64(received.Load())/float64(b.N), "wire-B/op")
+			})
+		}
+	}
+}
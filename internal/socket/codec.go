@@ -0,0 +1,233 @@
+package socket
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"strconv"
+
+	"github.com/fxamacker/cbor/v2"
+	"github.com/gorilla/websocket"
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+// Wire codec names, used in subprotocols of the form
+// "rally.<codec>.v<version>" (see protocol.go).
+const (
+	CodecJSON    = "json"
+	CodecMsgpack = "msgpack"
+	CodecCBOR    = "cbor"
+)
+
+// Codec encodes messages for the wire and decodes them from it.
+//
+// Feature payloads are JSON throughout the server and across Redis, so
+// binary codecs convert payloads between JSON and their own format at the
+// edge: a client that opts in never sees JSON.
+type Codec interface {
+	// Name returns the codec's name in subprotocols.
+	Name() string
+	// FrameType returns websocket.TextMessage or websocket.BinaryMessage.
+	FrameType() int
+	Encode(msg *Message) ([]byte, error)
+	Decode(data []byte) (*Message, error)
+	// Batch joins messages produced by Encode into an array, which clients
+	// tell apart from a single message, an object, by its type.
+	Batch(frames [][]byte) []byte
+}
+
+// codecs lists the supported codecs, JSON first.
+var codecs = []Codec{jsonCodec{}, msgpackCodec{}, cborCodec{}}
+
+// jsonCodec sends messages as JSON text frames. The hub's internal and Redis
+// representation is the same JSON, so encoded broadcasts are reused as is.
+type jsonCodec struct{}
+
+func (jsonCodec) Name() string { return CodecJSON }
+
+func (jsonCodec) FrameType() int { return websocket.TextMessage }
+
+func (jsonCodec) Encode(msg *Message) ([]byte, error) {
+	return json.Marshal(msg)
+}
+
+func (jsonCodec) Decode(data []byte) (*Message, error) {
+	var msg Message
+	if err := json.Unmarshal(data, &msg); err != nil {
+		return nil, err
+	}
+	return &msg, nil
+}
+
+func (jsonCodec) Batch(frames [][]byte) []byte {
+	var buf bytes.Buffer
+	buf.WriteByte('[')
+	for i, f := range frames {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		buf.Write(f)
+	}
+	buf.WriteByte(']')
+	return buf.Bytes()
+}
+
+// binaryMessage is the wire form of Message for binary codecs, with the
+// payload as a native value instead of embedded JSON.
+type binaryMessage struct {
+	Type    MessageType `msgpack:"type" cbor:"type"`
+	RoomID  string      `msgpack:"room_id" cbor:"room_id"`
+	Payload any         `msgpack:"payload" cbor:"payload"`
+}
+
+func toBinary(msg *Message) (*binaryMessage, error) {
+	out := &binaryMessage{Type: msg.Type, RoomID: msg.RoomID}
+	if len(msg.Payload) == 0 {
+		return out, nil
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(msg.Payload))
+	dec.UseNumber()
+	var v any
+	if err := dec.Decode(&v); err != nil {
+		return nil, fmt.Errorf("invalid payload: %w", err)
+	}
+	out.Payload = fromJSONNumbers(v)
+	return out, nil
+}
+
+func fromBinary(bm *binaryMessage) (*Message, error) {
+	msg := &Message{Type: bm.Type, RoomID: bm.RoomID}
+	if bm.Payload == nil {
+		return msg, nil
+	}
+
+	payload, err := json.Marshal(bm.Payload)
+	if err != nil {
+		return nil, fmt.Errorf("invalid payload: %w", err)
+	}
+	msg.Payload = payload
+	return msg, nil
+}
+
+// fromJSONNumbers replaces json.Number values with integers where they fit
+// and floats otherwise, so that binary codecs use compact integer types.
+func fromJSONNumbers(v any) any {
+	switch v := v.(type) {
+	case json.Number:
+		if i, err := v.Int64(); err == nil {
+			return i
+		}
+		if u, err := strconv.ParseUint(v.String(), 10, 64); err == nil {
+			return u
+		}
+		f, _ := v.Float64()
+		return f
+	case map[string]any:
+		for k, e := range v {
+			v[k] = fromJSONNumbers(e)
+		}
+	case []any:
+		for i, e := range v {
+			v[i] = fromJSONNumbers(e)
+		}
+	}
+	return v
+}
+
+// msgpackCodec sends messages as MessagePack binary frames.
+type msgpackCodec struct{}
+
+func (msgpackCodec) Name() string { return CodecMsgpack }
+
+func (msgpackCodec) FrameType() int { return websocket.BinaryMessage }
+
+func (msgpackCodec) Encode(msg *Message) ([]byte, error) {
+	bm, err := toBinary(msg)
+	if err != nil {
+		return nil, err
+	}
+	return msgpack.Marshal(bm)
+}
+
+func (msgpackCodec) Batch(frames [][]byte) []byte {
+	var buf bytes.Buffer
+	n := len(frames)
+	switch {
+	case n < 16:
+		buf.WriteByte(0x90 | byte(n)) // fixarray
+	case n <= math.MaxUint16:
+		buf.WriteByte(0xdc)
+		buf.Write(binary.BigEndian.AppendUint16(nil, uint16(n)))
+	default:
+		buf.WriteByte(0xdd)
+		buf.Write(binary.BigEndian.AppendUint32(nil, uint32(n)))
+	}
+	for _, f := range frames {
+		buf.Write(f)
+	}
+	return buf.Bytes()
+}
+
+func (msgpackCodec) Decode(data []byte) (*Message, error) {
+	var bm binaryMessage
+	if err := msgpack.Unmarshal(data, &bm); err != nil {
+		return nil, err
+	}
+	return fromBinary(&bm)
+}
+
+// cborDecMode decodes maps with string keys so that payloads convert back
+// to JSON objects.
+var cborDecMode, _ = cbor.DecOptions{
+	DefaultMapType: reflect.TypeOf(map[string]any(nil)),
+}.DecMode()
+
+// cborCodec sends messages as CBOR binary frames.
+type cborCodec struct{}
+
+func (cborCodec) Name() string { return CodecCBOR }
+
+func (cborCodec) FrameType() int { return websocket.BinaryMessage }
+
+func (cborCodec) Encode(msg *Message) ([]byte, error) {
+	bm, err := toBinary(msg)
+	if err != nil {
+		return nil, err
+	}
+	return cbor.Marshal(bm)
+}
+
+func (cborCodec) Batch(frames [][]byte) []byte {
+	var buf bytes.Buffer
+	const array = 4 << 5 // major type 4
+	n := len(frames)
+	switch {
+	case n < 24:
+		buf.WriteByte(array | byte(n))
+	case n <= math.MaxUint8:
+		buf.WriteByte(array | 24)
+		buf.WriteByte(byte(n))
+	case n <= math.MaxUint16:
+		buf.WriteByte(array | 25)
+		buf.Write(binary.BigEndian.AppendUint16(nil, uint16(n)))
+	default:
+		buf.WriteByte(array | 26)
+		buf.Write(binary.BigEndian.AppendUint32(nil, uint32(n)))
+	}
+	for _, f := range frames {
+		buf.Write(f)
+	}
+	return buf.Bytes()
+}
+
+func (cborCodec) Decode(data []byte) (*Message, error) {
+	var bm binaryMessage
+	if err := cborDecMode.Unmarshal(data, &bm); err != nil {
+		return nil, err
+	}
+	return fromBinary(&bm)
+}
@@ -0,0 +1,177 @@
+package socket
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/websocket"
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+// canonicalJSON re-encodes data with sorted keys, keeping numbers as
+// written.
+func canonicalJSON(t *testing.T, data []byte) string {
+	t.Helper()
+
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var v any
+	if err := dec.Decode(&v); err != nil {
+		t.Fatalf("decode %s: %v", data, err)
+	}
+	out, _ := json.Marshal(v)
+	return string(out)
+}
+
+func TestCodecRoundTrip(t *testing.T) {
+	payloads := []string{
+		`{"content":"สวัสดี <b>","mentions":["alice","bob"]}`,
+		`{"big":18446744073709551615,"float":1.5,"negative":-42,"small":7,"zero":0}`,
+		`{"nested":{"empty":{},"list":[1,"two",null,true,{"x":-0.25}]},"null":null}`,
+		`[1,2,3]`,
+		`"text"`,
+	}
+
+	for _, codec := range codecs {
+		for _, payload := range payloads {
+			t.Run(codec.Name()+" "+payload, func(t *testing.T) {
+				in := &Message{Type: MessageTypeChat, RoomID: "trip", Payload: json.RawMessage(payload)}
+				data, err := codec.Encode(in)
+				if err != nil {
+					t.Fatalf("Encode: %v", err)
+				}
+				out, err := codec.Decode(data)
+				if err != nil {
+					t.Fatalf("Decode: %v", err)
+				}
+				if out.Type != in.Type || out.RoomID != in.RoomID || canonicalJSON(t, out.Payload) != canonicalJSON(t, in.Payload) {
+					t.Errorf("round trip = %s %s %s, want %s %s %s", out.Type, out.RoomID, out.Payload, in.Type, in.RoomID, payload)
+				}
+			})
+		}
+
+		t.Run(codec.Name()+" without payload", func(t *testing.T) {
+			data, err := codec.Encode(&Message{Type: MessageTypeError, RoomID: "trip"})
+			if err != nil {
+				t.Fatalf("Encode: %v", err)
+			}
+			out, err := codec.Decode(data)
+			if err != nil {
+				t.Fatalf("Decode: %v", err)
+			}
+			if out.Type != MessageTypeError || (len(out.Payload) != 0 && string(out.Payload) != "null") {
+				t.Errorf("round trip = %s %s, want an error without payload", out.Type, out.Payload)
+			}
+		})
+	}
+}
+
+func TestBinaryCodecsSkipJSON(t *testing.T) {
+	msg := &Message{Type: MessageTypeChat, RoomID: "trip", Payload: json.RawMessage(`{"content":"hi","count":3}`)}
+	for _, codec := range codecs[1:] {
+		data, err := codec.Encode(msg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if codec.FrameType() != websocket.BinaryMessage {
+			t.Errorf("%s frame type = %d, want binary", codec.Name(), codec.FrameType())
+		}
+		if bytes.Contains(data, []byte(`{"content"`)) {
+			t.Errorf("%s embeds the JSON payload: %q", codec.Name(), data)
+		}
+	}
+
+	if _, err := (msgpackCodec{}).Encode(&Message{Type: MessageTypeChat, Payload: json.RawMessage(`{`)}); err == nil {
+		t.Error("invalid JSON payload encoded")
+	}
+}
+
+func TestCodecBatch(t *testing.T) {
+	// The sizes cover every array header length of MessagePack and CBOR.
+	for _, codec := range codecs {
+		for _, n := range []int{1, 15, 16, 23, 24, 255, 256, 70000} {
+			t.Run(fmt.Sprintf("%s %d", codec.Name(), n), func(t *testing.T) {
+				frames := make([][]byte, n)
+				for i := range frames {
+					payload, _ := json.Marshal(map[string]int{"i": i})
+					frame, err := codec.Encode(&Message{Type: MessageTypeChat, RoomID: "trip", Payload: payload})
+					if err != nil {
+						t.Fatal(err)
+					}
+					frames[i] = frame
+				}
+
+				var batch []binaryMessage
+				var err error
+				switch codec.(type) {
+				case jsonCodec:
+					var msgs []Message
+					err = json.Unmarshal(codec.Batch(frames), &msgs)
+					for _, m := range msgs {
+						var p map[string]any
+						_ = json.Unmarshal(m.Payload, &p)
+						batch = append(batch, binaryMessage{Type: m.Type, RoomID: m.RoomID, Payload: p})
+					}
+				case msgpackCodec:
+					err = msgpack.Unmarshal(codec.Batch(frames), &batch)
+				case cborCodec:
+					err = cborDecMode.Unmarshal(codec.Batch(frames), &batch)
+				}
+				if err != nil {
+					t.Fatalf("decode batch: %v", err)
+				}
+				if len(batch) != n {
+					t.Fatalf("batch has %d messages, want %d", len(batch), n)
+				}
+				last := batch[n-1]
+				if got := fmt.Sprint(last.Payload.(map[string]any)["i"]); last.Type != MessageTypeChat || got != fmt.Sprint(n-1) {
+					t.Errorf("last message = %+v, want chat message %d", last, n-1)
+				}
+			})
+		}
+	}
+}
+
+func TestNegotiateWire(t *testing.T) {
+	tests := []struct {
+		name      string
+		protocols string
+		query     string
+		want      wire
+		wantErr   bool
+	}{
+		{name: "nothing stated", want: wire{codec: jsonCodec{}, version: ProtocolV1}},
+		{name: "version in the query", query: "v=2", want: wire{codec: jsonCodec{}, version: ProtocolV2}},
+		{name: "unknown version in the query", query: "v=3", wantErr: true},
+		{name: "malformed version in the query", query: "v=two", wantErr: true},
+		{name: "subprotocol", protocols: "rally.msgpack.v2", want: wire{codec: msgpackCodec{}, version: ProtocolV2}},
+		{name: "subprotocol wins over the query", protocols: "rally.cbor.v1", query: "v=2", want: wire{codec: cborCodec{}, version: ProtocolV1}},
+		{name: "first supported subprotocol", protocols: "rally.xml.v2, rally.json.v9, rally.auth.x, rally.cbor.v2, rally.json.v2", want: wire{codec: cborCodec{}, version: ProtocolV2}},
+		{name: "no supported subprotocol", protocols: "rally.auth.token, graphql-ws", want: wire{codec: jsonCodec{}, version: ProtocolV1}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/ws?"+tt.query, nil)
+			if tt.protocols != "" {
+				r.Header.Set("Sec-WebSocket-Protocol", tt.protocols)
+			}
+			got, err := negotiateWire(r)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("negotiateWire = %+v, want an error", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("negotiateWire: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("negotiateWire = %s v%d, want %s v%d", got.codec.Name(), got.version, tt.want.codec.Name(), tt.want.version)
+			}
+		})
+	}
+}
@@ -0,0 +1,98 @@
+package socket
+
+import (
+	"encoding/json"
+	"log"
+)
+
+// controlChannelPrefix is the Redis channel prefix for hub control messages.
+const controlChannelPrefix = "control:"
+
+// Control actions.
+const (
+	// controlDisconnect closes every connection of a user in a room.
+	controlDisconnect = "disconnect"
+
+	// controlClose closes one local connection. It is never published.
+	controlClose = "close"
+
+	// controlReloadFilters drops the cached chat filters of a room after
+	// its moderators changed them.
+	controlReloadFilters = "reload_filters"
+)
+
+// controlMessage instructs every hub to act on its local connections. It is
+// published on "control:<room_id>".
+type controlMessage struct {
+	Action    string `json:"action"`
+	RoomID    string `json:"room_id"`
+	UserID    string `json:"user_id"`
+	CloseCode int    `json:"close_code,omitempty"`
+	Reason    string `json:"reason,omitempty"`
+
+	// Message for the room, for controlDisconnect, delivered before the
+	// connections are closed
+	Notice json.RawMessage `json:"notice,omitempty"`
+
+	// Connection to close, for controlClose
+	client *Client
+}
+
+// sendControl applies ctrl locally and publishes it to other instances.
+func (h *Hub) sendControl(ctrl *controlMessage) {
+	h.control <- ctrl
+
+	data, err := json.Marshal(ctrl)
+	if err != nil {
+		log.Printf("Failed to marshal control message: %v", err)
+		return
+	}
+	h.publish(controlChannelPrefix+ctrl.RoomID, data)
+}
+
+// applyControl runs on the hub goroutine.
+func (h *Hub) applyControl(ctrl *controlMessage) {
+	switch ctrl.Action {
+	case controlDisconnect:
+		if ctrl.Notice != nil {
+			h.broadcastToRoom(&BroadcastMessage{RoomID: ctrl.RoomID, Message: ctrl.Notice})
+		}
+		h.disconnectUser(ctrl.RoomID, ctrl.UserID, ctrl.CloseCode, ctrl.Reason)
+	case controlClose:
+		h.closeClient(ctrl.client, ctrl.CloseCode, ctrl.Reason)
+	case controlReloadFilters:
+		h.Chat.ReloadRoomFilters(ctrl.RoomID)
+	default:
+		log.Printf("Unknown control action: %s", ctrl.Action)
+	}
+}
+
+// disconnectUser closes userID's connections to roomID with the given close
+// code.
+func (h *Hub) disconnectUser(roomID, userID string, code int, reason string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for client := range h.Rooms[roomID] {
+		if client.UserID != userID {
+			continue
+		}
+		client.closeCode, client.closeReason = code, reason
+		h.removeClientLocked(client)
+		log.Printf("Client %s disconnected from room %s (code %d)", client.ID, roomID, code)
+	}
+}
+
+// closeClient closes one connection with the given close code, unless it
+// has already gone.
+func (h *Hub) closeClient(client *Client, code int, reason string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if !h.Clients[client] {
+		return
+	}
+	client.closeCode, client.closeReason = code, reason
+	h.removeClientLocked(client)
+	log.Printf("Client %s closed (code %d: %s)", client.ID, code, reason)
+}
@@ -0,0 +1,38 @@
+package socket
+
+import (
+	"encoding/json"
+	"log"
+)
+
+// Error codes sent to clients in error frames.
+const (
+	ErrCodeInvalidPayload = "invalid_payload"
+	ErrCodeForbidden      = "forbidden"
+	ErrCodeInternal       = "internal_error"
+)
+
+// ErrorPayload is the payload of an error frame sent back to the client whose
+// message was rejected.
+type ErrorPayload struct {
+	Code    string      `json:"code"`
+	Message string      `json:"message"`
+	Type    MessageType `json:"type,omitempty"` // type of the rejected message
+	Details any         `json:"details,omitempty"`
+}
+
+// sendError queues an error frame for client only.
+func (h *Hub) sendError(client *Client, roomID string, ref MessageType, code, message string, details any) {
+	payload, err := json.Marshal(ErrorPayload{
+		Code:    code,
+		Message: message,
+		Type:    ref,
+		Details: details,
+	})
+	if err != nil {
+		log.Printf("Failed to marshal error payload: %v", err)
+		return
+	}
+
+	h.sendToClientMessage(client, &Message{Type: MessageTypeError, RoomID: roomID, Payload: payload})
+}
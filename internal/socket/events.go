@@ -0,0 +1,94 @@
+package socket
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/rally-go/rally-realtime/internal/features/events"
+	"github.com/rally-go/rally-realtime/internal/signature"
+)
+
+// maxEventSize is the largest request body accepted by ServeRoomEvents.
+const maxEventSize = 64 << 10
+
+// IdempotencyKeyHeader names the header carrying a publish request's
+// idempotency key.
+const IdempotencyKeyHeader = "Idempotency-Key"
+
+// ServeRoomEvents lets backend services publish an event into a room at
+// POST /rooms/{id}/events. The body is {"type": "trip.updated", "data": ...}
+// signed with the service secret (see package signature). The event reaches
+// every client in the room, on all instances, as an "event" message.
+//
+// Requests that carry an Idempotency-Key already used for the room within a
+// day are not delivered again; they get the original event back with
+// Idempotent-Replayed: true. A key is only kept once its event has been
+// delivered: if delivery fails, the request fails with 503 and may be
+// retried with the same key.
+func (s *Server) ServeRoomEvents(w http.ResponseWriter, r *http.Request) {
+	if len(s.serviceSecret) == 0 {
+		http.NotFound(w, r)
+		return
+	}
+
+	roomID := r.PathValue("id")
+	if roomID == "" {
+		http.Error(w, "room id is required", http.StatusBadRequest)
+		return
+	}
+
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxEventSize))
+	if err != nil {
+		http.Error(w, "event too large", http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	if err := signature.Verify(s.serviceSecret, r, body, time.Now()); err != nil {
+		log.Printf("Service auth failed for room %s: %v", roomID, err)
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), handlerTimeout)
+	defer cancel()
+
+	key := r.Header.Get(IdempotencyKeyHeader)
+	event, replayed, err := s.hub.Events.Publish(ctx, roomID, key, body)
+	switch {
+	case errors.Is(err, events.ErrInvalidEvent):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	case errors.Is(err, events.ErrKeyReused):
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	case err != nil:
+		log.Printf("Failed to publish event to room %s: %v", roomID, err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	status := http.StatusAccepted
+	if replayed {
+		w.Header().Set("Idempotent-Replayed", "true")
+		status = http.StatusOK
+	} else {
+		if err := s.hub.PublishEvent(event); err != nil {
+			log.Printf("Failed to deliver event %s to room %s: %v", event.ID, roomID, err)
+			if err := s.hub.Events.Release(ctx, roomID, key); err != nil {
+				log.Printf("Failed to release idempotency key of event %s: %v", event.ID, err)
+			}
+			http.Error(w, "Service unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		log.Printf("Published %s event %s to room %s", event.Type, event.ID, roomID)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(event)
+}
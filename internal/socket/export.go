@@ -0,0 +1,99 @@
+package socket
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/rally-go/rally-realtime/internal/features/planning"
+	"github.com/rally-go/rally-realtime/internal/middleware"
+	"github.com/rally-go/rally-realtime/internal/rooms"
+)
+
+// ServeItineraryICS serves a room's itinerary as an iCalendar feed at
+// GET /rooms/{id}/itinerary.ics.
+func (s *Server) ServeItineraryICS(w http.ResponseWriter, r *http.Request) {
+	view, ok := s.itinerary(w, r)
+	if !ok {
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/calendar; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(planning.RenderICS(view, time.Now()))
+}
+
+// ServeItineraryGeoJSON serves the located items of a room's itinerary as a
+// GeoJSON FeatureCollection at GET /rooms/{id}/itinerary.geojson.
+func (s *Server) ServeItineraryGeoJSON(w http.ResponseWriter, r *http.Request) {
+	view, ok := s.itinerary(w, r)
+	if !ok {
+		return
+	}
+
+	body, err := planning.RenderGeoJSON(view)
+	if err != nil {
+		log.Printf("Failed to render GeoJSON for room %s: %v", view.RoomID, err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/geo+json")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(body)
+}
+
+// itinerary authenticates the request like ServeWs, checks that the user
+// belongs to the room and returns its itinerary. It writes the error
+// response itself and returns false if the request cannot be served.
+func (s *Server) itinerary(w http.ResponseWriter, r *http.Request) (*planning.DocumentView, bool) {
+	roomID := r.PathValue("id")
+	if roomID == "" {
+		http.Error(w, "room id is required", http.StatusBadRequest)
+		return nil, false
+	}
+
+	ident, err := middleware.VerifyRequestToken(s.verifier, r)
+	if err != nil {
+		log.Printf("Export auth failed: %v", err)
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return nil, false
+	}
+	userID := ident.UserID
+
+	if !rooms.CanAccess(roomID, userID) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return nil, false
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), handlerTimeout)
+	defer cancel()
+
+	member, err := s.hub.Members.IsMember(ctx, roomID, userID)
+	if err != nil {
+		log.Printf("Failed to check membership: user=%s room=%s: %v", userID, roomID, err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return nil, false
+	}
+	banned, err := s.hub.Moderation.IsBanned(ctx, roomID, userID)
+	if err != nil {
+		log.Printf("Failed to check ban: user=%s room=%s: %v", userID, roomID, err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return nil, false
+	}
+	if !member || banned {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return nil, false
+	}
+
+	view, err := s.hub.Planning.Document(ctx, roomID)
+	if err != nil {
+		log.Printf("Failed to load itinerary for room %s: %v", roomID, err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return nil, false
+	}
+	return view, true
+}
@@ -0,0 +1,219 @@
+package socket
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/rally-go/rally-realtime/internal/rooms"
+)
+
+// ConnEvent describes a connection lifecycle event.
+type ConnEvent struct {
+	ClientID string
+	UserID   string
+	RoomID   string
+}
+
+// LifecycleHook is called on a connection lifecycle event. Hooks run outside
+// the hub goroutine with a context bounded by handlerTimeout, so they may
+// talk to stores and publish messages.
+type LifecycleHook func(ctx context.Context, ev ConnEvent)
+
+// lifecycleHooks holds the hooks registered for each event.
+type lifecycleHooks struct {
+	connect    []LifecycleHook
+	disconnect []LifecycleHook
+	join       []LifecycleHook
+	leave      []LifecycleHook
+}
+
+// OnConnect registers a hook called for every new connection.
+func (h *Hub) OnConnect(hook LifecycleHook) {
+	h.hooksMu.Lock()
+	defer h.hooksMu.Unlock()
+	h.hooks.connect = append(h.hooks.connect, hook)
+}
+
+// OnDisconnect registers a hook called whenever a connection closes, for
+// whatever reason.
+func (h *Hub) OnDisconnect(hook LifecycleHook) {
+	h.hooksMu.Lock()
+	defer h.hooksMu.Unlock()
+	h.hooks.disconnect = append(h.hooks.disconnect, hook)
+}
+
+// OnJoin registers a hook called when a user's first connection to a room,
+// on any server instance, opens.
+func (h *Hub) OnJoin(hook LifecycleHook) {
+	h.hooksMu.Lock()
+	defer h.hooksMu.Unlock()
+	h.hooks.join = append(h.hooks.join, hook)
+}
+
+// OnLeave registers a hook called when a user's last connection to a room,
+// on any server instance, closes.
+func (h *Hub) OnLeave(hook LifecycleHook) {
+	h.hooksMu.Lock()
+	defer h.hooksMu.Unlock()
+	h.hooks.leave = append(h.hooks.leave, hook)
+}
+
+// connected records a new connection in the presence store and runs the
+// connect hooks, then the join hooks if it is the user's first connection
+// to the room. It must be called before the client is registered.
+func (h *Hub) connected(client *Client) {
+	ctx, cancel := context.WithTimeout(context.Background(), handlerTimeout)
+	defer cancel()
+
+	ev := ConnEvent{ClientID: client.ID, UserID: client.UserID, RoomID: client.RoomID}
+	first, err := h.Presence.Connect(ctx, client.RoomID, client.UserID, client.ID)
+	if err != nil {
+		log.Printf("Failed to record presence: user=%s room=%s: %v", client.UserID, client.RoomID, err)
+	}
+
+	h.hooksMu.RLock()
+	hooks := h.hooks
+	h.hooksMu.RUnlock()
+
+	h.runHooks(hooks.connect, ev)
+	if first {
+		h.runHooks(hooks.join, ev)
+	}
+}
+
+// disconnected removes a closed connection from the presence store and runs
+// the disconnect hooks, then the leave hooks if it was the user's last
+// connection to the room. It is started by removeClientLocked.
+func (h *Hub) disconnected(client *Client) {
+	ctx, cancel := context.WithTimeout(context.Background(), handlerTimeout)
+	defer cancel()
+
+	ev := ConnEvent{ClientID: client.ID, UserID: client.UserID, RoomID: client.RoomID}
+	last, err := h.Presence.Disconnect(ctx, client.RoomID, client.UserID, client.ID)
+	if err != nil {
+		log.Printf("Failed to clear presence: user=%s room=%s: %v", client.UserID, client.RoomID, err)
+	}
+
+	h.hooksMu.RLock()
+	hooks := h.hooks
+	h.hooksMu.RUnlock()
+
+	h.runHooks(hooks.disconnect, ev)
+	if last {
+		h.runHooks(hooks.leave, ev)
+	}
+}
+
+// maintainPresence periodically refreshes the presence of this instance's
+// connections and removes connections that were not refreshed, e.g. those
+// of an instance that crashed, running the leave hooks for users who are
+// gone.
+func (h *Hub) maintainPresence() {
+	ticker := time.NewTicker(rooms.PresenceRefreshInterval)
+	defer ticker.Stop()
+
+	for now := range ticker.C {
+		h.refreshPresence()
+		h.expirePresence(now)
+	}
+}
+
+// refreshPresence extends the presence of every local connection. A
+// connection whose presence has already expired, because this instance
+// stalled for longer than rooms.PresenceTTL, is recorded again.
+func (h *Hub) refreshPresence() {
+	h.mu.RLock()
+	clients := make([]*Client, 0, len(h.Clients))
+	for client := range h.Clients {
+		clients = append(clients, client)
+	}
+	h.mu.RUnlock()
+
+	for _, client := range clients {
+		ctx, cancel := context.WithTimeout(context.Background(), handlerTimeout)
+		ok, err := h.Presence.Refresh(ctx, client.RoomID, client.UserID, client.ID)
+		cancel()
+		if err != nil {
+			log.Printf("Failed to refresh presence: user=%s room=%s: %v", client.UserID, client.RoomID, err)
+			continue
+		}
+		if ok {
+			continue
+		}
+
+		h.mu.RLock()
+		registered := h.Clients[client]
+		h.mu.RUnlock()
+		if registered {
+			h.reconnected(client)
+		}
+	}
+}
+
+// reconnected records the presence of a connection that expired while it
+// was still open, and runs the join hooks if the user had been reported
+// gone.
+func (h *Hub) reconnected(client *Client) {
+	ctx, cancel := context.WithTimeout(context.Background(), handlerTimeout)
+	defer cancel()
+
+	first, err := h.Presence.Connect(ctx, client.RoomID, client.UserID, client.ID)
+	if err != nil {
+		log.Printf("Failed to record presence: user=%s room=%s: %v", client.UserID, client.RoomID, err)
+		return
+	}
+	if !first {
+		return
+	}
+
+	h.hooksMu.RLock()
+	hooks := h.hooks
+	h.hooksMu.RUnlock()
+
+	h.runHooks(hooks.join, ConnEvent{ClientID: client.ID, UserID: client.UserID, RoomID: client.RoomID})
+}
+
+// expirePresence removes expired connections of any instance and runs the
+// leave hooks for users who have no connections left.
+func (h *Hub) expirePresence(now time.Time) {
+	ctx, cancel := context.WithTimeout(context.Background(), rooms.PresenceRefreshInterval)
+	defer cancel()
+
+	expired, err := h.Presence.Expire(ctx, now)
+	if err != nil {
+		log.Printf("Failed to expire presence: %v", err)
+	}
+
+	h.hooksMu.RLock()
+	hooks := h.hooks
+	h.hooksMu.RUnlock()
+
+	for _, conn := range expired {
+		log.Printf("Presence expired: user=%s room=%s conn=%s", conn.UserID, conn.RoomID, conn.ConnID)
+		if conn.Last {
+			h.runHooks(hooks.leave, ConnEvent{ClientID: conn.ConnID, UserID: conn.UserID, RoomID: conn.RoomID})
+		}
+	}
+}
+
+// runHooks calls each hook with its own timeout.
+func (h *Hub) runHooks(hooks []LifecycleHook, ev ConnEvent) {
+	for _, hook := range hooks {
+		ctx, cancel := context.WithTimeout(context.Background(), handlerTimeout)
+		hook(ctx, ev)
+		cancel()
+	}
+}
+
+// releaseLocks releases the planning locks of a user who has left the room
+// and announces each release.
+func (h *Hub) releaseLocks(ctx context.Context, ev ConnEvent) {
+	events, err := h.Planning.ReleaseUserLocks(ctx, ev.RoomID, ev.UserID)
+	if err != nil {
+		log.Printf("Failed to release locks: user=%s room=%s: %v", ev.UserID, ev.RoomID, err)
+	}
+	for _, event := range events {
+		h.publishLockEvent(event)
+	}
+}
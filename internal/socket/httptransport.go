@@ -0,0 +1,171 @@
+package socket
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+
+	"github.com/rally-go/rally-realtime/internal/middleware"
+)
+
+// HTTP fallback transports for networks that block WebSocket upgrades.
+// Clients receive messages from GET /sse or GET /poll and send them with
+// POST /send?session=<id>. Sessions live on the instance that opened them,
+// so load balancers must route a session's requests to one instance.
+
+// openSession authenticates and admits a request opening an HTTP transport
+// session. These transports carry JSON only; the protocol version comes
+// from the "v" query parameter. It writes the error response itself and
+// returns false if the session cannot be opened.
+func (s *Server) openSession(w http.ResponseWriter, r *http.Request) (ident *middleware.Identity, roomID string, proto wire, ok bool) {
+	roomID = r.URL.Query().Get("room_id")
+	if roomID == "" {
+		http.Error(w, "room_id is required", http.StatusBadRequest)
+		return nil, "", proto, false
+	}
+
+	proto, err := negotiateWire(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return nil, "", proto, false
+	}
+	// Event streams and poll responses are text, whatever codec the client
+	// asked for in a subprotocol header.
+	proto.codec = jsonCodec{}
+	if proto.version < s.minProtocolVersion {
+		log.Printf("Rejected client on protocol version %d", proto.version)
+		http.Error(w, fmt.Sprintf("protocol version %d is no longer supported; minimum is %d", proto.version, s.minProtocolVersion),
+			http.StatusUpgradeRequired)
+		return nil, "", proto, false
+	}
+
+	ident, err = middleware.VerifyRequestToken(s.verifier, r)
+	if err != nil {
+		log.Printf("HTTP transport auth failed: %v", err)
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return nil, "", proto, false
+	}
+
+	if !s.admit(w, r, roomID, ident) {
+		return nil, "", proto, false
+	}
+	return ident, roomID, proto, true
+}
+
+// session returns the session named in the "session" query parameter if
+// it belongs to the request's authenticated user. It writes the error
+// response itself and returns nil otherwise.
+func (s *Server) session(w http.ResponseWriter, r *http.Request) *Client {
+	ident, err := middleware.VerifyRequestToken(s.verifier, r)
+	if err != nil {
+		log.Printf("HTTP transport auth failed: %v", err)
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return nil
+	}
+
+	s.sessionsMu.Lock()
+	client, ok := s.sessions[r.URL.Query().Get("session")]
+	s.sessionsMu.Unlock()
+
+	// Someone else's session is reported as unknown.
+	if !ok || client.UserID != ident.UserID {
+		http.Error(w, "unknown session", http.StatusNotFound)
+		return nil
+	}
+	return client
+}
+
+func (s *Server) addSession(client *Client) {
+	s.sessionsMu.Lock()
+	defer s.sessionsMu.Unlock()
+	s.sessions[client.ID] = client
+}
+
+func (s *Server) removeSession(id string) {
+	s.sessionsMu.Lock()
+	defer s.sessionsMu.Unlock()
+	delete(s.sessions, id)
+}
+
+// allowCORS sets the CORS headers for web clients on an allowed origin and
+// reports whether the request's origin is allowed. Every HTTP transport
+// handler calls it first, followed by preflight.
+func (s *Server) allowCORS(w http.ResponseWriter, r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true // not a browser cross-origin request
+	}
+	if !s.checkOrigin(r) {
+		return false
+	}
+
+	w.Header().Set("Access-Control-Allow-Origin", origin)
+	w.Header().Add("Vary", "Origin")
+	return true
+}
+
+// preflight answers a CORS preflight request and reports whether r was
+// one. Any method other than method and OPTIONS is refused.
+func preflight(w http.ResponseWriter, r *http.Request, method string) bool {
+	switch r.Method {
+	case method:
+		return false
+	case http.MethodOptions:
+		w.Header().Set("Access-Control-Allow-Methods", method)
+		w.Header().Set("Access-Control-Allow-Headers", "Authorization, Content-Type")
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+	return true
+}
+
+// ServeSend accepts one message from a client on an HTTP transport at
+// POST /send?session=<id>. The message is routed as if it had arrived over
+// a WebSocket; errors come back on the session's stream.
+func (s *Server) ServeSend(w http.ResponseWriter, r *http.Request) {
+	if !s.allowCORS(w, r) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+	if preflight(w, r, http.MethodPost) {
+		return
+	}
+
+	client := s.session(w, r)
+	if client == nil {
+		return
+	}
+
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxMessageSize))
+	if err != nil {
+		http.Error(w, "message too large", http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	client.receive(body)
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// closeEvent tells a client on an HTTP transport why its session ended,
+// with the same codes a WebSocket close frame would carry.
+type closeEvent struct {
+	Code   int    `json:"code"`
+	Reason string `json:"reason,omitempty"`
+}
+
+// sessionEvent names the session a client must pass to POST /send and
+// GET /poll.
+type sessionEvent struct {
+	SessionID string `json:"session_id"`
+}
+
+func mustJSON(v any) []byte {
+	data, err := json.Marshal(v)
+	if err != nil {
+		panic(err)
+	}
+	return data
+}
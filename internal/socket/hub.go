@@ -1,18 +1,43 @@
 package socket
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"log"
+	"strings"
 	"sync"
-
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/rally-go/rally-realtime/internal/features/chat"
+	"github.com/rally-go/rally-realtime/internal/features/events"
+	"github.com/rally-go/rally-realtime/internal/features/moderation"
+	"github.com/rally-go/rally-realtime/internal/features/notify"
+	"github.com/rally-go/rally-realtime/internal/features/planning"
+	"github.com/rally-go/rally-realtime/internal/features/polls"
 	"github.com/rally-go/rally-realtime/internal/pubsub"
+	"github.com/rally-go/rally-realtime/internal/rooms"
+	"github.com/rally-go/rally-realtime/internal/webhooks"
+)
+
+// Redis channel prefixes used for cross-server fan-out.
+const (
+	roomChannelPrefix = "room:"
+	userChannelPrefix = "user:"
 )
 
+// handlerTimeout bounds the time a feature handler may spend on one message.
+const handlerTimeout = 5 * time.Second
+
 // Hub maintains the set of active clients and broadcasts messages.
 type Hub struct {
 	// Registered clients by room
 	Rooms map[string]map[*Client]bool
 
+	// Registered clients by user, across all rooms
+	Users map[string]map[*Client]bool
+
 	// All registered clients
 	Clients map[*Client]bool
 
@@ -28,6 +53,35 @@ type Hub struct {
 	// Redis pub/sub for cross-server communication
 	PubSub pubsub.PubSub
 
+	// Room membership shared by all server instances
+	Members rooms.Store
+
+	// Connections per user and room, shared by all server instances
+	Presence rooms.Presence
+
+	// Feature handlers
+	Chat       *chat.Handler
+	Moderation *moderation.Handler
+	Planning   *planning.Handler
+	Polls      *polls.Handler
+	Events     *events.Handler
+	Notify     *notify.Handler
+
+	// Delivers room events to other services; nil disables webhooks
+	Webhooks *webhooks.Dispatcher
+	// Limits location.updated events per user and room
+	locationWebhooks *webhooks.Throttle
+
+	// Control messages to apply on the hub goroutine
+	control chan *controlMessage
+
+	// Identifies this instance in Redis envelopes
+	instanceID string
+
+	// Connection lifecycle hooks registered by features
+	hooks   lifecycleHooks
+	hooksMu sync.RWMutex
+
 	// Mutex for thread-safe access
 	mu sync.RWMutex
 }
@@ -35,28 +89,72 @@ type Hub struct {
 // BroadcastMessage represents a message to be broadcast.
 type BroadcastMessage struct {
 	RoomID  string
+	UserID  string  // if set, deliver to every connection of this user instead of the room
+	Target  *Client // if set, deliver to this connection only
 	Message []byte
 	Sender  *Client // nil if from Redis
 }
 
-// NewHub creates a new Hub instance.
-func NewHub(pubsub pubsub.PubSub) *Hub {
-	return &Hub{
+// relayEnvelope wraps payloads published to Redis so that an instance can
+// ignore its own messages when they come back through the subscription.
+type relayEnvelope struct {
+	Origin  string          `json:"origin"`
+	Payload json.RawMessage `json:"payload"`
+}
+
+// Features groups the feature handlers the hub routes messages to.
+type Features struct {
+	Chat       *chat.Handler
+	Moderation *moderation.Handler
+	Planning   *planning.Handler
+	Polls      *polls.Handler
+	Events     *events.Handler
+	Notify     *notify.Handler
+}
+
+// NewHub creates a new Hub instance and registers the lifecycle hooks of
+// its features.
+func NewHub(pubsub pubsub.PubSub, members rooms.Store, presence rooms.Presence, features Features) *Hub {
+	h := &Hub{
 		Rooms:      make(map[string]map[*Client]bool),
+		Users:      make(map[string]map[*Client]bool),
 		Clients:    make(map[*Client]bool),
 		Broadcast:  make(chan *BroadcastMessage, 256),
 		Register:   make(chan *Client),
 		Unregister: make(chan *Client),
 		PubSub:     pubsub,
+		Members:    members,
+		Presence:   presence,
+		Chat:       features.Chat,
+		Moderation: features.Moderation,
+		Planning:   features.Planning,
+		Polls:      features.Polls,
+		Events:     features.Events,
+		Notify:     features.Notify,
+		control:    make(chan *controlMessage, 64),
+		instanceID: uuid.New().String(),
+
+		locationWebhooks: webhooks.NewThrottle(webhooks.LocationInterval),
 	}
+
+	// A user who leaves, even by dropping the connection, cannot keep
+	// editing, so their locks are released instead of waiting for expiry.
+	h.OnLeave(h.releaseLocks)
+
+	return h
 }
 
 // Run starts the hub's main loop.
 func (h *Hub) Run() {
 	// Subscribe to Redis messages
 	if h.PubSub != nil {
-		go h.subscribeToRedis()
+		go h.subscribeToRedis(roomChannelPrefix)
+		go h.subscribeToRedis(userChannelPrefix)
+		go h.subscribeToControl()
 	}
+	go h.publishClosedPolls()
+	go h.publishLockEvents()
+	go h.maintainPresence()
 
 	for {
 		select {
@@ -66,8 +164,18 @@ func (h *Hub) Run() {
 		case client := <-h.Unregister:
 			h.unregisterClient(client)
 
+		case ctrl := <-h.control:
+			h.applyControl(ctrl)
+
 		case message := <-h.Broadcast:
-			h.broadcastToRoom(message)
+			switch {
+			case message.Target != nil:
+				h.sendToClient(message)
+			case message.UserID != "":
+				h.broadcastToUser(message)
+			default:
+				h.broadcastToRoom(message)
+			}
 		}
 	}
 }
@@ -83,6 +191,11 @@ func (h *Hub) registerClient(client *Client) {
 	}
 	h.Rooms[client.RoomID][client] = true
 
+	if _, ok := h.Users[client.UserID]; !ok {
+		h.Users[client.UserID] = make(map[*Client]bool)
+	}
+	h.Users[client.UserID][client] = true
+
 	log.Printf("Client %s joined room %s (total in room: %d)",
 		client.ID, client.RoomID, len(h.Rooms[client.RoomID]))
 }
@@ -92,17 +205,31 @@ func (h *Hub) unregisterClient(client *Client) {
 	defer h.mu.Unlock()
 
 	if _, ok := h.Clients[client]; ok {
-		delete(h.Clients, client)
-		close(client.Send)
+		h.removeClientLocked(client)
+		log.Printf("Client %s left room %s", client.ID, client.RoomID)
+	}
+}
 
-		if room, ok := h.Rooms[client.RoomID]; ok {
-			delete(room, client)
-			if len(room) == 0 {
-				delete(h.Rooms, client.RoomID)
-			}
+// removeClientLocked drops client from every index, closes its send
+// channel and runs the disconnect hooks in the background. The caller must
+// hold h.mu for writing.
+func (h *Hub) removeClientLocked(client *Client) {
+	delete(h.Clients, client)
+	close(client.Send)
+	go h.disconnected(client)
+
+	if room, ok := h.Rooms[client.RoomID]; ok {
+		delete(room, client)
+		if len(room) == 0 {
+			delete(h.Rooms, client.RoomID)
 		}
+	}
 
-		log.Printf("Client %s left room %s", client.ID, client.RoomID)
+	if conns, ok := h.Users[client.UserID]; ok {
+		delete(conns, client)
+		if len(conns) == 0 {
+			delete(h.Users, client.UserID)
+		}
 	}
 }
 
@@ -115,47 +242,96 @@ func (h *Hub) broadcastToRoom(msg *BroadcastMessage) {
 		return
 	}
 
+	h.deliver(clients, msg)
+}
+
+func (h *Hub) broadcastToUser(msg *BroadcastMessage) {
+	h.mu.RLock()
+	clients, ok := h.Users[msg.UserID]
+	h.mu.RUnlock()
+
+	if !ok {
+		return
+	}
+
+	h.deliver(clients, msg)
+}
+
+func (h *Hub) sendToClient(msg *BroadcastMessage) {
+	h.mu.RLock()
+	_, ok := h.Clients[msg.Target]
+	h.mu.RUnlock()
+
+	if !ok {
+		return
+	}
+
+	h.deliver(map[*Client]bool{msg.Target: true}, msg)
+}
+
+// deliver queues msg on each client's send buffer, encoded for the client's
+// codec and protocol version. msg.Message is in the hub's representation;
+// it is re-encoded at most once per codec and version.
+func (h *Hub) deliver(clients map[*Client]bool, msg *BroadcastMessage) {
+	frames := map[wire][][]byte{currentWire: {msg.Message}}
 	for client := range clients {
 		// Don't send back to sender (unless from Redis)
 		if msg.Sender != nil && client == msg.Sender {
 			continue
 		}
 
-		select {
-		case client.Send <- msg.Message:
-		default:
+		encoded, ok := frames[client.wire]
+		if !ok {
+			var err error
+			if encoded, err = client.wire.encode(msg.Message); err != nil {
+				log.Printf("Failed to encode message for %s: %v", subprotocol(client.wire.codec, client.wire.version), err)
+				continue
+			}
+			frames[client.wire] = encoded
+		}
+
+		for _, frame := range encoded {
+			select {
+			case client.Send <- frame:
+				continue
+			default:
+			}
+
 			// Client's send buffer is full, close connection
 			h.mu.Lock()
-			close(client.Send)
-			delete(h.Clients, client)
-			delete(h.Rooms[msg.RoomID], client)
+			if _, ok := h.Clients[client]; ok {
+				h.removeClientLocked(client)
+			}
 			h.mu.Unlock()
+			break
 		}
 	}
 }
 
 // RouteMessage routes incoming messages to appropriate handlers.
 func (h *Hub) RouteMessage(client *Client, msg *Message) {
-	// Create outbound message
-	outbound, err := json.Marshal(msg)
-	if err != nil {
-		log.Printf("Failed to marshal message: %v", err)
+	// Direct messages are addressed by user, not by room
+	if msg.Type == MessageTypeDirect {
+		h.handleDirect(client, msg)
 		return
 	}
 
-	// Broadcast to local clients
-	h.Broadcast <- &BroadcastMessage{
-		RoomID:  msg.RoomID,
-		Message: outbound,
-		Sender:  client,
+	// A client may override the room per message, but never into a direct
+	// conversation it is not part of.
+	if !rooms.CanAccess(msg.RoomID, client.UserID) {
+		h.sendError(client, msg.RoomID, msg.Type, ErrCodeForbidden, "not a participant of this conversation", nil)
+		return
 	}
 
-	// Publish to Redis for other server instances
-	if h.PubSub != nil {
-		channel := "room:" + msg.RoomID
-		if err := h.PubSub.Publish(channel, outbound); err != nil {
-			log.Printf("Failed to publish to Redis: %v", err)
-		}
+	// Nor into a room it is banned from. Its own room is checked when
+	// connecting, and a ban closes the connection.
+	if msg.RoomID != client.RoomID && h.rejectBanned(client, msg) {
+		return
+	}
+
+	// The sender's role in the room must allow the message
+	if !h.authorize(client, msg) {
+		return
 	}
 
 	// Route to specific feature handler based on message type
@@ -166,39 +342,609 @@ func (h *Hub) RouteMessage(client *Client, msg *Message) {
 		h.handleLocation(client, msg)
 	case MessageTypePlanning:
 		h.handlePlanning(client, msg)
+	case MessageTypeModeration:
+		h.handleModeration(client, msg)
+	case MessageTypePoll:
+		h.handlePoll(client, msg)
+	case MessageTypeNotifications:
+		h.handleNotifications(client, msg)
+	case MessageTypeChatFilters:
+		h.handleChatFilters(client, msg)
 	default:
 		log.Printf("Unknown message type: %s", msg.Type)
 	}
 }
 
-func (h *Hub) subscribeToRedis() {
-	// Subscribe to all room messages using pattern
-	messages := h.PubSub.Subscribe("room:*")
+// publishToRoom sends msg to every other client in its room on this and all
+// other server instances.
+func (h *Hub) publishToRoom(sender *Client, msg *Message) {
+	outbound, err := json.Marshal(msg)
+	if err != nil {
+		log.Printf("Failed to marshal message: %v", err)
+		return
+	}
+
+	// Broadcast to local clients
+	h.Broadcast <- &BroadcastMessage{
+		RoomID:  msg.RoomID,
+		Message: outbound,
+		Sender:  sender,
+	}
+
+	// Publish to Redis for other server instances
+	h.publish(roomChannelPrefix+msg.RoomID, outbound)
+}
+
+// sendToClientMessage sends msg to a single local connection.
+func (h *Hub) sendToClientMessage(client *Client, msg *Message) {
+	outbound, err := json.Marshal(msg)
+	if err != nil {
+		log.Printf("Failed to marshal message: %v", err)
+		return
+	}
+
+	h.Broadcast <- &BroadcastMessage{
+		Target:  client,
+		Message: outbound,
+	}
+}
+
+// publishToUser sends msg to every connection of userID, whichever room they
+// joined, on this and all other server instances. sender, if not nil, is
+// skipped.
+func (h *Hub) publishToUser(sender *Client, userID string, msg *Message) {
+	outbound, err := json.Marshal(msg)
+	if err != nil {
+		log.Printf("Failed to marshal message: %v", err)
+		return
+	}
+
+	h.Broadcast <- &BroadcastMessage{
+		UserID:  userID,
+		Message: outbound,
+		Sender:  sender,
+	}
+
+	h.publish(userChannelPrefix+userID, outbound)
+}
+
+// publish wraps payload in a relay envelope and publishes it to Redis,
+// logging failures.
+func (h *Hub) publish(channel string, payload []byte) {
+	if err := h.relay(channel, payload); err != nil {
+		log.Printf("Failed to publish to Redis: %v", err)
+	}
+}
+
+// relay wraps payload in a relay envelope and publishes it to Redis.
+func (h *Hub) relay(channel string, payload []byte) error {
+	if h.PubSub == nil {
+		return nil
+	}
+
+	data, err := json.Marshal(relayEnvelope{Origin: h.instanceID, Payload: payload})
+	if err != nil {
+		return err
+	}
+	return h.PubSub.Publish(channel, data)
+}
+
+func (h *Hub) subscribeToRedis(prefix string) {
+	// Subscribe to all messages under the prefix using a pattern
+	messages := h.PubSub.Subscribe(prefix + "*")
 
 	for msg := range messages {
-		// Extract room ID from channel name
-		roomID := msg.Channel[5:] // Remove "room:" prefix
+		var env relayEnvelope
+		if err := json.Unmarshal(msg.Payload, &env); err != nil {
+			log.Printf("Invalid relay envelope on %s: %v", msg.Channel, err)
+			continue
+		}
+
+		// Already delivered locally when it was published
+		if env.Origin == h.instanceID {
+			continue
+		}
+
+		// Extract the room or user ID from the channel name
+		target := strings.TrimPrefix(msg.Channel, prefix)
 
-		h.Broadcast <- &BroadcastMessage{
-			RoomID:  roomID,
-			Message: msg.Payload,
+		bm := &BroadcastMessage{
+			Message: env.Payload,
 			Sender:  nil, // From Redis, not a local client
 		}
+		if prefix == userChannelPrefix {
+			bm.UserID = target
+		} else {
+			bm.RoomID = target
+			h.applyRemote(target, env.Payload)
+		}
+		h.Broadcast <- bm
+	}
+}
+
+// applyRemote lets features update local state from a room message that was
+// handled on another instance.
+func (h *Hub) applyRemote(roomID string, data []byte) {
+	var msg Message
+	if err := json.Unmarshal(data, &msg); err != nil {
+		return
+	}
+
+	if msg.Type == MessageTypePlanning {
+		h.Planning.ApplyRemote(roomID, msg.Payload)
+	}
+}
+
+func (h *Hub) subscribeToControl() {
+	messages := h.PubSub.Subscribe(controlChannelPrefix + "*")
+
+	for msg := range messages {
+		var env relayEnvelope
+		if err := json.Unmarshal(msg.Payload, &env); err != nil {
+			log.Printf("Invalid relay envelope on %s: %v", msg.Channel, err)
+			continue
+		}
+		if env.Origin == h.instanceID {
+			continue
+		}
+
+		var ctrl controlMessage
+		if err := json.Unmarshal(env.Payload, &ctrl); err != nil {
+			log.Printf("Invalid control message on %s: %v", msg.Channel, err)
+			continue
+		}
+		h.control <- &ctrl
 	}
 }
 
 // Feature handlers (to be expanded in features package)
 func (h *Hub) handleChat(client *Client, msg *Message) {
-	log.Printf("Chat message from %s in room %s", client.UserID, msg.RoomID)
-	// TODO: Save to MongoDB, sanitize text
+	ctx, cancel := context.WithTimeout(context.Background(), handlerTimeout)
+	defer cancel()
+
+	if h.rejectMuted(ctx, client, msg.RoomID, MessageTypeChat) {
+		return
+	}
+
+	chatMsg, err := h.Chat.ProcessMessage(ctx, client.UserID, msg.RoomID, msg.Payload)
+	if err != nil {
+		log.Printf("Chat message from %s in room %s rejected: %v", client.UserID, msg.RoomID, err)
+		h.sendChatError(client, msg.RoomID, MessageTypeChat, err)
+		return
+	}
+
+	payload, err := json.Marshal(chatMsg)
+	if err != nil {
+		log.Printf("Failed to marshal chat message: %v", err)
+		return
+	}
+	h.publishToRoom(client, &Message{Type: MessageTypeChat, RoomID: msg.RoomID, Payload: payload})
+	h.dispatchWebhook(webhooks.EventChatMessage, msg.RoomID, chatMsg)
+
+	h.deliverMentions(ctx, client, msg.RoomID, chatMsg)
+}
+
+// handleChatFilters applies a moderator's filter settings for the room and
+// answers with the room's filters. Other instances are told to reload them.
+func (h *Hub) handleChatFilters(client *Client, msg *Message) {
+	ctx, cancel := context.WithTimeout(context.Background(), handlerTimeout)
+	defer cancel()
+
+	action, err := h.Chat.ProcessFilterAction(ctx, client.UserID, msg.RoomID, msg.Payload)
+	if err != nil {
+		log.Printf("Filter action from %s in room %s rejected: %v", client.UserID, msg.RoomID, err)
+		if errors.Is(err, chat.ErrInvalidFilterAction) {
+			h.sendError(client, msg.RoomID, MessageTypeChatFilters, ErrCodeInvalidPayload, err.Error(), nil)
+		} else {
+			h.sendError(client, msg.RoomID, MessageTypeChatFilters, ErrCodeInternal, "failed to process filter action", nil)
+		}
+		return
+	}
+	if action.Action != chat.FiltersGet {
+		h.sendControl(&controlMessage{Action: controlReloadFilters, RoomID: msg.RoomID})
+	}
+
+	payload, err := json.Marshal(action)
+	if err != nil {
+		log.Printf("Failed to marshal filter action: %v", err)
+		return
+	}
+	h.sendToClientMessage(client, &Message{Type: MessageTypeChatFilters, RoomID: msg.RoomID, Payload: payload})
+}
+
+// sendChatError reports a rejected chat or direct message back to its sender.
+func (h *Hub) sendChatError(client *Client, roomID string, ref MessageType, err error) {
+	switch {
+	case errors.Is(err, chat.ErrContentTooLong):
+		h.sendError(client, roomID, ref, "content_too_long", err.Error(),
+			map[string]int{"max_length": chat.MaxContentRunes})
+	case errors.Is(err, chat.ErrEmptyContent):
+		h.sendError(client, roomID, ref, "empty_content", err.Error(), nil)
+	case errors.Is(err, chat.ErrMessageRejected):
+		h.sendError(client, roomID, ref, "message_rejected", err.Error(), nil)
+	case errors.Is(err, chat.ErrInvalidPayload):
+		h.sendError(client, roomID, ref, ErrCodeInvalidPayload, err.Error(), nil)
+	case errors.Is(err, chat.ErrUnknownRecipient):
+		h.sendError(client, roomID, ref, ErrCodeForbidden, err.Error(), nil)
+	default:
+		h.sendError(client, roomID, ref, ErrCodeInternal, "failed to process chat message", nil)
+	}
+}
+
+// deliverMentions sends a mention event to each user mentioned in chatMsg.
+// "@here" goes to the room; every other mention goes to the user's own
+// connections so it reaches them even while they are in another room.
+func (h *Hub) deliverMentions(ctx context.Context, sender *Client, roomID string, chatMsg *chat.ChatMessage) {
+	if chatMsg.Mentions.IsEmpty() {
+		return
+	}
+
+	event := chat.MentionEvent{
+		MessageID:  chatMsg.ID,
+		RoomID:     roomID,
+		FromUserID: chatMsg.UserID,
+		Content:    chatMsg.Content,
+		Timestamp:  chatMsg.Timestamp,
+	}
+
+	if chatMsg.Mentions.Here {
+		event.Kind = chat.MentionHere
+		if payload, err := json.Marshal(event); err == nil {
+			h.publishToRoom(sender, &Message{Type: MessageTypeMention, RoomID: roomID, Payload: payload})
+		}
+	}
+
+	targets, err := h.Chat.MentionTargets(ctx, roomID, chatMsg)
+	if err != nil {
+		log.Printf("Failed to resolve mentions in room %s: %v", roomID, err)
+		return
+	}
+
+	direct := make(map[string]bool, len(chatMsg.Mentions.UserIDs))
+	for _, id := range chatMsg.Mentions.UserIDs {
+		direct[id] = true
+	}
+
+	for _, userID := range targets {
+		event.Kind = chat.MentionAll
+		if direct[userID] {
+			event.Kind = chat.MentionUser
+		}
+		payload, err := json.Marshal(event)
+		if err != nil {
+			log.Printf("Failed to marshal mention event: %v", err)
+			continue
+		}
+		h.publishToUser(nil, userID, &Message{Type: MessageTypeMention, RoomID: roomID, Payload: payload})
+	}
+
+	go h.notifyMentions(roomID, targets, chatMsg)
+}
+
+// rejectMuted answers a chat message with a muted error and returns true if
+// its sender is muted in roomID.
+func (h *Hub) rejectMuted(ctx context.Context, client *Client, roomID string, ref MessageType) bool {
+	until, err := h.Moderation.MutedUntil(ctx, roomID, client.UserID)
+	if err != nil {
+		log.Printf("Failed to check mute: user=%s room=%s: %v", client.UserID, roomID, err)
+		h.sendError(client, roomID, ref, ErrCodeInternal, "failed to process chat message", nil)
+		return true
+	}
+	if !until.IsZero() {
+		h.sendError(client, roomID, ref, "muted", "you are muted in this room",
+			map[string]time.Time{"muted_until": until})
+		return true
+	}
+	return false
+}
+
+// handleDirect delivers a 1:1 message to every live connection of the
+// recipient and to the sender's other connections, regardless of the rooms
+// they joined. A sender muted in the room they are connected to cannot
+// message its members privately either.
+func (h *Hub) handleDirect(client *Client, msg *Message) {
+	ctx, cancel := context.WithTimeout(context.Background(), handlerTimeout)
+	defer cancel()
+
+	if h.rejectMuted(ctx, client, client.RoomID, MessageTypeDirect) {
+		return
+	}
+
+	chatMsg, roomID, err := h.Chat.ProcessDirectMessage(ctx, client.UserID, client.RoomID, msg.Payload)
+	if err != nil {
+		log.Printf("Direct message from %s rejected: %v", client.UserID, err)
+		h.sendChatError(client, "", MessageTypeDirect, err)
+		return
+	}
+
+	payload, err := json.Marshal(chatMsg)
+	if err != nil {
+		log.Printf("Failed to marshal direct message: %v", err)
+		return
+	}
+	out := &Message{Type: MessageTypeDirect, RoomID: roomID, Payload: payload}
+	h.publishToUser(nil, chatMsg.ToUserID, out)
+	h.publishToUser(client, client.UserID, out)
+}
+
+func (h *Hub) handleModeration(client *Client, msg *Message) {
+	ctx, cancel := context.WithTimeout(context.Background(), handlerTimeout)
+	defer cancel()
+
+	action, err := h.Moderation.ProcessAction(ctx, client.UserID, msg.RoomID, msg.Payload)
+	if err != nil {
+		log.Printf("Moderation action from %s in room %s rejected: %v", client.UserID, msg.RoomID, err)
+		switch {
+		case errors.Is(err, moderation.ErrForbidden):
+			h.sendError(client, msg.RoomID, MessageTypeModeration, ErrCodeForbidden, err.Error(), nil)
+		case errors.Is(err, moderation.ErrInvalidAction):
+			h.sendError(client, msg.RoomID, MessageTypeModeration, ErrCodeInvalidPayload, err.Error(), nil)
+		default:
+			h.sendError(client, msg.RoomID, MessageTypeModeration, ErrCodeInternal, "failed to apply moderation action", nil)
+		}
+		return
+	}
+
+	payload, err := json.Marshal(action)
+	if err != nil {
+		log.Printf("Failed to marshal moderation action: %v", err)
+		return
+	}
+	notice, err := json.Marshal(&Message{Type: MessageTypeModeration, RoomID: msg.RoomID, Payload: payload})
+	if err != nil {
+		log.Printf("Failed to marshal message: %v", err)
+		return
+	}
+
+	// The whole room, including the moderator, is told. A kicked or banned
+	// target is told before being disconnected, so that they learn why:
+	// the notice rides on the disconnect, which every hub applies in order.
+	switch action.Action {
+	case moderation.ActionKick:
+		h.sendControl(&controlMessage{Action: controlDisconnect, RoomID: msg.RoomID, UserID: action.UserID, CloseCode: CloseKicked, Reason: "kicked", Notice: notice})
+	case moderation.ActionBan:
+		h.sendControl(&controlMessage{Action: controlDisconnect, RoomID: msg.RoomID, UserID: action.UserID, CloseCode: CloseBanned, Reason: "banned", Notice: notice})
+	default:
+		h.Broadcast <- &BroadcastMessage{RoomID: msg.RoomID, Message: notice}
+		h.publish(roomChannelPrefix+msg.RoomID, notice)
+	}
+}
+
+// rejectBanned answers msg with a forbidden error and returns true if its
+// sender is banned from msg.RoomID.
+func (h *Hub) rejectBanned(client *Client, msg *Message) bool {
+	ctx, cancel := context.WithTimeout(context.Background(), handlerTimeout)
+	defer cancel()
+
+	banned, err := h.Moderation.IsBanned(ctx, msg.RoomID, client.UserID)
+	if err != nil {
+		log.Printf("Failed to check ban: user=%s room=%s: %v", client.UserID, msg.RoomID, err)
+		h.sendError(client, msg.RoomID, msg.Type, ErrCodeInternal, "failed to check permissions", nil)
+		return true
+	}
+	if banned {
+		h.sendError(client, msg.RoomID, msg.Type, ErrCodeForbidden, "banned from this room", nil)
+	}
+	return banned
 }
 
 func (h *Hub) handleLocation(client *Client, msg *Message) {
 	log.Printf("Location update from %s in room %s", client.UserID, msg.RoomID)
 	// TODO: Update Firestore, filter coordinates
+	h.publishToRoom(client, msg)
+	if h.Webhooks != nil && h.locationWebhooks.Allow(msg.RoomID+"\x00"+client.UserID, time.Now()) {
+		h.dispatchWebhook(webhooks.EventLocationUpdated, msg.RoomID, &webhooks.LocationData{UserID: client.UserID, Location: msg.Payload})
+	}
 }
 
 func (h *Hub) handlePlanning(client *Client, msg *Message) {
-	log.Printf("Planning update from %s in room %s", client.UserID, msg.RoomID)
-	// TODO: Lock itinerary item for collaboration
+	ctx, cancel := context.WithTimeout(context.Background(), handlerTimeout)
+	defer cancel()
+
+	action, err := h.Planning.ProcessAction(ctx, client.UserID, msg.RoomID, msg.Payload)
+	if err != nil {
+		log.Printf("Planning action from %s in room %s rejected: %v", client.UserID, msg.RoomID, err)
+		h.sendPlanningError(client, msg.RoomID, err)
+		return
+	}
+
+	payload, err := json.Marshal(action)
+	if err != nil {
+		log.Printf("Failed to marshal planning action: %v", err)
+		return
+	}
+	out := &Message{Type: MessageTypePlanning, RoomID: msg.RoomID, Payload: payload}
+
+	switch action.Action {
+	case planning.ActionLock, planning.ActionUnlock:
+		// Lock changes are announced as lock_state events; unlocking an
+		// item the user did not hold changes nothing.
+		if action.Lock != nil {
+			h.publishLockEvent(action.Lock)
+		}
+	case planning.ActionSync, planning.ActionHistory:
+		h.sendToClientMessage(client, out)
+	case planning.ActionInsert, planning.ActionUpdate, planning.ActionMove, planning.ActionDelete:
+		h.publishToRoom(client, out)
+		h.publishConflicts(ctx, msg.RoomID)
+		h.dispatchWebhook(itineraryEvent(action), msg.RoomID, action)
+		go h.notifyItinerary(client.UserID, msg.RoomID, action)
+	}
+}
+
+// itineraryEvent returns the webhook event type of an itinerary edit. Undo
+// and redo answer with the compensating edit, and are named after their
+// source so that receivers can tell them from edits made by hand.
+func itineraryEvent(action *planning.PlanningAction) string {
+	if action.Source != "" {
+		return webhooks.EventItineraryPrefix + action.Source
+	}
+	return webhooks.EventItineraryPrefix + action.Action
+}
+
+// dispatchWebhook hands an event handled on this instance to the webhook
+// subscriptions that want it.
+func (h *Hub) dispatchWebhook(eventType, roomID string, data any) {
+	if h.Webhooks != nil {
+		h.Webhooks.Dispatch(eventType, roomID, data)
+	}
+}
+
+// publishConflicts broadcasts the room's current schedule conflicts to
+// everyone in it, including the editor. The full list is sent every time so
+// that clients can clear resolved conflicts.
+func (h *Hub) publishConflicts(ctx context.Context, roomID string) {
+	report, err := h.Planning.Conflicts(ctx, roomID)
+	if err != nil {
+		log.Printf("Failed to compute schedule conflicts for room %s: %v", roomID, err)
+		return
+	}
+
+	payload, err := json.Marshal(report)
+	if err != nil {
+		log.Printf("Failed to marshal conflict report: %v", err)
+		return
+	}
+	h.publishToRoom(nil, &Message{Type: MessageTypePlanningConflicts, RoomID: roomID, Payload: payload})
+}
+
+// publishLockEvent tells everyone in the room, the user who caused it
+// included, about a lock change.
+func (h *Hub) publishLockEvent(event *planning.LockEvent) {
+	payload, err := json.Marshal(event)
+	if err != nil {
+		log.Printf("Failed to marshal lock event: %v", err)
+		return
+	}
+	h.publishToRoom(nil, &Message{Type: MessageTypePlanningLockState, RoomID: event.RoomID, Payload: payload})
+}
+
+// PublishEvent delivers an event published by a backend service to
+// everyone in its room. The event is relayed to other instances first and
+// not delivered at all if that fails, so that the service can retry.
+func (h *Hub) PublishEvent(event *events.Event) error {
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	outbound, err := json.Marshal(&Message{Type: MessageTypeEvent, RoomID: event.RoomID, Payload: payload})
+	if err != nil {
+		return err
+	}
+
+	if err := h.relay(roomChannelPrefix+event.RoomID, outbound); err != nil {
+		return err
+	}
+	h.Broadcast <- &BroadcastMessage{RoomID: event.RoomID, Message: outbound}
+	return nil
+}
+
+// publishLockEvents broadcasts lock expiries. Each expiry is reported by one
+// instance, which relays it to the others.
+func (h *Hub) publishLockEvents() {
+	for event := range h.Planning.LockEvents() {
+		h.publishLockEvent(event)
+	}
+}
+
+// SendLocks sends the room's current lock table to a client that has just
+// joined.
+func (h *Hub) SendLocks(client *Client) {
+	ctx, cancel := context.WithTimeout(context.Background(), handlerTimeout)
+	defer cancel()
+
+	event, err := h.Planning.Locks(ctx, client.RoomID)
+	if err != nil {
+		log.Printf("Failed to load locks for room %s: %v", client.RoomID, err)
+		return
+	}
+
+	payload, err := json.Marshal(event)
+	if err != nil {
+		log.Printf("Failed to marshal lock event: %v", err)
+		return
+	}
+	h.sendToClientMessage(client, &Message{Type: MessageTypePlanningLockState, RoomID: client.RoomID, Payload: payload})
+}
+
+// sendPlanningError reports a rejected planning action back to its sender.
+func (h *Hub) sendPlanningError(client *Client, roomID string, err error) {
+	var stale *planning.StaleVersionError
+	var invalid *planning.ValidationError
+	var locked *planning.LockedError
+	switch {
+	case errors.As(err, &invalid):
+		h.sendError(client, roomID, MessageTypePlanning, "validation_failed", err.Error(),
+			map[string]any{"fields": invalid.Fields})
+	case errors.As(err, &stale):
+		h.sendError(client, roomID, MessageTypePlanning, "stale_version", err.Error(), map[string]any{
+			"current_version": stale.Current.Version,
+			"item":            stale.Current,
+		})
+	case errors.As(err, &locked):
+		h.sendError(client, roomID, MessageTypePlanning, "item_locked", err.Error(), locked.Lock)
+	case errors.Is(err, planning.ErrForbidden):
+		h.sendError(client, roomID, MessageTypePlanning, ErrCodeForbidden, err.Error(), nil)
+	case errors.Is(err, planning.ErrItemNotFound):
+		h.sendError(client, roomID, MessageTypePlanning, "item_not_found", err.Error(), nil)
+	case errors.Is(err, planning.ErrNothingToUndo):
+		h.sendError(client, roomID, MessageTypePlanning, "nothing_to_undo", err.Error(), nil)
+	case errors.Is(err, planning.ErrInvalidAction):
+		h.sendError(client, roomID, MessageTypePlanning, ErrCodeInvalidPayload, err.Error(), nil)
+	default:
+		h.sendError(client, roomID, MessageTypePlanning, ErrCodeInternal, "failed to apply planning action", nil)
+	}
+}
+
+func (h *Hub) handlePoll(client *Client, msg *Message) {
+	ctx, cancel := context.WithTimeout(context.Background(), handlerTimeout)
+	defer cancel()
+
+	action, err := h.Polls.ProcessAction(ctx, client.UserID, msg.RoomID, msg.Payload)
+	if err != nil {
+		log.Printf("Poll action from %s in room %s rejected: %v", client.UserID, msg.RoomID, err)
+		switch {
+		case errors.Is(err, polls.ErrPollNotFound):
+			h.sendError(client, msg.RoomID, MessageTypePoll, "poll_not_found", err.Error(), nil)
+		case errors.Is(err, polls.ErrPollClosed):
+			h.sendError(client, msg.RoomID, MessageTypePoll, "poll_closed", err.Error(), nil)
+		case errors.Is(err, polls.ErrAlreadyVoted):
+			h.sendError(client, msg.RoomID, MessageTypePoll, "already_voted", err.Error(), nil)
+		case errors.Is(err, polls.ErrForbidden):
+			h.sendError(client, msg.RoomID, MessageTypePoll, ErrCodeForbidden, err.Error(), nil)
+		case errors.Is(err, polls.ErrInvalidPoll):
+			h.sendError(client, msg.RoomID, MessageTypePoll, ErrCodeInvalidPayload, err.Error(), nil)
+		default:
+			h.sendError(client, msg.RoomID, MessageTypePoll, ErrCodeInternal, "failed to process poll action", nil)
+		}
+		return
+	}
+
+	payload, err := json.Marshal(action)
+	if err != nil {
+		log.Printf("Failed to marshal poll action: %v", err)
+		return
+	}
+	out := &Message{Type: MessageTypePoll, RoomID: msg.RoomID, Payload: payload}
+
+	switch action.Action {
+	case polls.ActionGet, polls.ActionList:
+		h.sendToClientMessage(client, out)
+	default:
+		// Creations, tallies and closures go to everyone, the sender included.
+		h.publishToRoom(nil, out)
+	}
+}
+
+// publishClosedPolls broadcasts polls closed by their deadline. Each poll is
+// closed by exactly one instance, which relays it to the others.
+func (h *Hub) publishClosedPolls() {
+	for action := range h.Polls.Closed() {
+		payload, err := json.Marshal(action)
+		if err != nil {
+			log.Printf("Failed to marshal poll action: %v", err)
+			continue
+		}
+		h.publishToRoom(nil, &Message{Type: MessageTypePoll, RoomID: action.Poll.RoomID, Payload: payload})
+	}
 }
@@ -0,0 +1,499 @@
+package socket
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/rally-go/rally-realtime/internal/features/chat"
+	"github.com/rally-go/rally-realtime/internal/features/events"
+	"github.com/rally-go/rally-realtime/internal/features/moderation"
+	"github.com/rally-go/rally-realtime/internal/features/notify"
+	"github.com/rally-go/rally-realtime/internal/features/planning"
+	"github.com/rally-go/rally-realtime/internal/features/polls"
+	"github.com/rally-go/rally-realtime/internal/middleware"
+	"github.com/rally-go/rally-realtime/internal/pubsub"
+	"github.com/rally-go/rally-realtime/internal/rooms"
+	"github.com/rally-go/rally-realtime/internal/signature"
+)
+
+// testTokens are the tokens the test server accepts, by user.
+var testTokens = map[string]string{
+	"alice-token": "alice",
+	"bob-token":   "bob",
+	"carol-token": "carol",
+}
+
+// newTestHub returns a running hub on in-memory stores, without Redis.
+func newTestHub(t *testing.T) *Hub {
+	t.Helper()
+	return newTestHubWith(t, nil, nil)
+}
+
+// newTestHubWith returns a running hub on in-memory stores that relays
+// through ps and pushes notifications to notifier, if not nil.
+func newTestHubWith(t *testing.T, ps pubsub.PubSub, notifier notify.Notifier) *Hub {
+	t.Helper()
+
+	members := rooms.NewMemoryStore()
+	itinerary := planning.NewHandler(members, planning.NewMemoryStore())
+	features := Features{
+		Chat:       chat.NewHandler(members, nil),
+		Moderation: moderation.NewHandler(members, moderation.NewMemoryStore()),
+		Planning:   itinerary,
+		Polls:      polls.NewHandler(members, polls.NewMemoryStore(), itinerary),
+		Events:     events.NewHandler(events.NewMemoryStore()),
+	}
+	if notifier != nil {
+		features.Notify = notify.NewHandler(members, members, notify.NewMemoryStore(), notifier)
+	}
+	hub := NewHub(ps, members, members, features)
+	go hub.Run()
+	return hub
+}
+
+// newTestServer serves hub's WebSocket and HTTP transports, accepting
+// testTokens.
+func newTestServer(t *testing.T, hub *Hub, opts ServerOptions) *httptest.Server {
+	t.Helper()
+
+	s := NewServer(hub, middleware.NewStaticVerifier(testTokens, time.Hour), opts)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/ws", s.ServeWs)
+	mux.HandleFunc("/sse", s.ServeSSE)
+	mux.HandleFunc("/poll", s.ServePoll)
+	mux.HandleFunc("/send", s.ServeSend)
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+// dial connects to roomID on srv as the owner of token, speaking JSON at
+// the current protocol version.
+func dial(t *testing.T, srv *httptest.Server, token, roomID string) *websocket.Conn {
+	t.Helper()
+
+	dialer := websocket.Dialer{
+		Subprotocols: []string{subprotocol(jsonCodec{}, CurrentProtocolVersion), middleware.TokenProtocolPrefix + token},
+	}
+	url := "ws" + strings.TrimPrefix(srv.URL, "http") + "/ws?room_id=" + roomID
+	conn, _, err := dialer.Dial(url, nil)
+	if err != nil {
+		t.Fatalf("dial %s as %s: %v", roomID, token, err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+// send writes msg to conn.
+func send(t *testing.T, conn *websocket.Conn, msgType MessageType, roomID string, payload any) {
+	t.Helper()
+
+	data, err := json.Marshal(payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := conn.WriteJSON(&Message{Type: msgType, RoomID: roomID, Payload: data}); err != nil {
+		t.Fatalf("send %s: %v", msgType, err)
+	}
+}
+
+// receive reads from conn until a message matching want arrives, skipping
+// others such as the initial lock state. Batched frames are unpacked.
+func receive(t *testing.T, conn *websocket.Conn, want func(*Message) bool) *Message {
+	t.Helper()
+
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	defer conn.SetReadDeadline(time.Time{})
+	for {
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			t.Fatalf("read: %v", err)
+		}
+
+		var batch []*Message
+		if bytes.HasPrefix(data, []byte("[")) {
+			err = json.Unmarshal(data, &batch)
+		} else {
+			var msg Message
+			err = json.Unmarshal(data, &msg)
+			batch = append(batch, &msg)
+		}
+		if err != nil {
+			t.Fatalf("decode %s: %v", data, err)
+		}
+		for _, msg := range batch {
+			if want(msg) {
+				return msg
+			}
+		}
+	}
+}
+
+// ofType matches messages of type msgType.
+func ofType(msgType MessageType) func(*Message) bool {
+	return func(msg *Message) bool { return msg.Type == msgType }
+}
+
+// receiveError reads from conn until an error frame arrives.
+func receiveError(t *testing.T, conn *websocket.Conn) ErrorPayload {
+	t.Helper()
+
+	var payload ErrorPayload
+	if err := json.Unmarshal(receive(t, conn, ofType(MessageTypeError)).Payload, &payload); err != nil {
+		t.Fatal(err)
+	}
+	return payload
+}
+
+func TestLongChatMessages(t *testing.T) {
+	hub := newTestHub(t)
+	srv := newTestServer(t, hub, ServerOptions{})
+	alice := dial(t, srv, "alice-token", "trip")
+	bob := dial(t, srv, "bob-token", "trip")
+
+	// Thai and Vietnamese take three bytes a character in UTF-8, and a
+	// client may escape every one of them in JSON.
+	thai := strings.Repeat("ส", chat.MaxContentRunes)
+	escaped, _ := json.Marshal(thai)
+	escaped = bytes.ReplaceAll(escaped, []byte("ส"), []byte(`\u0e2a`))
+
+	if err := alice.WriteMessage(websocket.TextMessage, []byte(`{"type":"chat","room_id":"trip","payload":{"content":`+string(escaped)+`}}`)); err != nil {
+		t.Fatal(err)
+	}
+	got := receive(t, bob, ofType(MessageTypeChat))
+	var msg chat.ChatMessage
+	if err := json.Unmarshal(got.Payload, &msg); err != nil {
+		t.Fatal(err)
+	}
+	if msg.Content != thai {
+		t.Errorf("delivered %d bytes of content, want the %d sent", len(msg.Content), len(thai))
+	}
+
+	// One character over the limit is refused with an error, and the
+	// connection stays open.
+	send(t, alice, MessageTypeChat, "trip", map[string]string{"content": thai + "ส"})
+	if e := receiveError(t, alice); e.Code != "content_too_long" {
+		t.Errorf("error code = %q, want content_too_long", e.Code)
+	}
+	send(t, alice, MessageTypeChat, "trip", map[string]string{"content": "still here"})
+	receive(t, bob, ofType(MessageTypeChat))
+}
+
+func TestChatFiltersByModerators(t *testing.T) {
+	hub := newTestHub(t)
+	srv := newTestServer(t, hub, ServerOptions{})
+	alice := dial(t, srv, "alice-token", "trip") // first to join, so the owner
+	bob := dial(t, srv, "bob-token", "trip")
+
+	set := map[string]any{"action": "set", "filters": map[string]any{"words": map[string]any{"words": []string{"rain"}}}}
+	send(t, bob, MessageTypeChatFilters, "trip", set)
+	if e := receiveError(t, bob); e.Code != ErrCodeForbidden {
+		t.Errorf("member setting filters: error code = %q, want %q", e.Code, ErrCodeForbidden)
+	}
+
+	send(t, alice, MessageTypeChatFilters, "trip", set)
+	var answer chat.FilterAction
+	if err := json.Unmarshal(receive(t, alice, ofType(MessageTypeChatFilters)).Payload, &answer); err != nil {
+		t.Fatal(err)
+	}
+	if answer.Filters == nil || answer.Filters.Words == nil {
+		t.Fatalf("set answered with %+v", answer.Filters)
+	}
+
+	send(t, bob, MessageTypeChat, "trip", map[string]string{"content": "rain again"})
+	var msg chat.ChatMessage
+	if err := json.Unmarshal(receive(t, alice, ofType(MessageTypeChat)).Payload, &msg); err != nil {
+		t.Fatal(err)
+	}
+	if msg.Content != "**** again" || !msg.Moderated {
+		t.Errorf("chat after set = %q (moderated %v), want masked", msg.Content, msg.Moderated)
+	}
+}
+
+func TestMentionReachesOtherRooms(t *testing.T) {
+	hub := newTestHub(t)
+	srv := newTestServer(t, hub, ServerOptions{})
+	alice := dial(t, srv, "alice-token", "trip")
+	if err := hub.Members.AddMember(context.Background(), "trip", "bob"); err != nil {
+		t.Fatal(err)
+	}
+	bob := dial(t, srv, "bob-token", "other") // not connected to trip
+
+	send(t, alice, MessageTypeChat, "trip", map[string]string{"content": "@bob are you in?"})
+	var event chat.MentionEvent
+	if err := json.Unmarshal(receive(t, bob, ofType(MessageTypeMention)).Payload, &event); err != nil {
+		t.Fatal(err)
+	}
+	if event.RoomID != "trip" || event.FromUserID != "alice" || event.Kind != chat.MentionUser || event.MessageID == "" {
+		t.Errorf("mention = %+v, want alice's in trip", event)
+	}
+}
+
+func TestDirectMessages(t *testing.T) {
+	hub := newTestHub(t)
+	srv := newTestServer(t, hub, ServerOptions{})
+	alice := dial(t, srv, "alice-token", "trip") // owner
+	aliceElsewhere := dial(t, srv, "alice-token", "other")
+	bob := dial(t, srv, "bob-token", "trip")
+	bobElsewhere := dial(t, srv, "bob-token", "other")
+	carol := dial(t, srv, "carol-token", "trip")
+
+	// The message reaches every connection of the recipient and the
+	// sender's other ones, in whatever room.
+	send(t, alice, MessageTypeDirect, "trip", map[string]string{"to_user_id": "bob", "content": "see you at the station"})
+	for name, conn := range map[string]*websocket.Conn{"bob": bob, "bob elsewhere": bobElsewhere, "alice elsewhere": aliceElsewhere} {
+		var msg chat.ChatMessage
+		got := receive(t, conn, ofType(MessageTypeDirect))
+		if err := json.Unmarshal(got.Payload, &msg); err != nil {
+			t.Fatal(err)
+		}
+		if got.RoomID != "dm:alice:bob" || msg.UserID != "alice" || msg.ToUserID != "bob" {
+			t.Errorf("%s got %s %+v, want alice's message in dm:alice:bob", name, got.RoomID, msg)
+		}
+	}
+
+	// Recipients the sender shares no room with are refused, and no room
+	// is created for them.
+	send(t, alice, MessageTypeDirect, "trip", map[string]string{"to_user_id": "mallory", "content": "hi"})
+	if e := receiveError(t, alice); e.Code != ErrCodeForbidden {
+		t.Errorf("message to a stranger: error code = %q, want %q", e.Code, ErrCodeForbidden)
+	}
+	if members, _ := hub.Members.Members(context.Background(), "dm:alice:mallory"); len(members) != 0 {
+		t.Errorf("conversation with a stranger has members %v", members)
+	}
+
+	// A third party can neither join the conversation nor send to it.
+	dialer := websocket.Dialer{Subprotocols: []string{subprotocol(jsonCodec{}, CurrentProtocolVersion), middleware.TokenProtocolPrefix + "carol-token"}}
+	_, resp, err := dialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http")+"/ws?room_id=dm:alice:bob", nil)
+	if err == nil || resp == nil || resp.StatusCode != http.StatusForbidden {
+		t.Errorf("third party joining the conversation: %v, want 403", err)
+	}
+	send(t, carol, MessageTypeChat, "dm:alice:bob", map[string]string{"content": "hello"})
+	if e := receiveError(t, carol); e.Code != ErrCodeForbidden {
+		t.Errorf("third party sending to the conversation: error code = %q, want %q", e.Code, ErrCodeForbidden)
+	}
+
+	// A member muted in the room cannot message its members privately.
+	send(t, alice, MessageTypeModeration, "trip", map[string]any{"action": "mute", "user_id": "carol", "duration_seconds": 60})
+	receive(t, carol, ofType(MessageTypeModeration))
+	send(t, carol, MessageTypeDirect, "trip", map[string]string{"to_user_id": "bob", "content": "psst"})
+	if e := receiveError(t, carol); e.Code != "muted" {
+		t.Errorf("direct message while muted: error code = %q, want muted", e.Code)
+	}
+}
+
+func TestBanCutsUserOff(t *testing.T) {
+	hub := newTestHub(t)
+	srv := newTestServer(t, hub, ServerOptions{})
+	alice := dial(t, srv, "alice-token", "trip") // owner
+	bob := dial(t, srv, "bob-token", "trip")
+	bobElsewhere := dial(t, srv, "bob-token", "other")
+
+	send(t, alice, MessageTypeModeration, "trip", map[string]string{"action": "ban", "user_id": "bob"})
+
+	// Bob learns why before the connection closes.
+	notice := receive(t, bob, ofType(MessageTypeModeration))
+	if !strings.Contains(string(notice.Payload), `"ban"`) {
+		t.Errorf("notice = %s, want the ban", notice.Payload)
+	}
+	bob.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if _, _, err := bob.ReadMessage(); !websocket.IsCloseError(err, CloseBanned) {
+		t.Errorf("after the notice: %v, want close %d", err, CloseBanned)
+	}
+
+	member, err := hub.Members.IsMember(context.Background(), "trip", "bob")
+	if err != nil || member {
+		t.Errorf("banned user still a member (err %v)", err)
+	}
+
+	// Nor can Bob post into the room from another one.
+	send(t, bobElsewhere, MessageTypeChat, "trip", map[string]string{"content": "still here"})
+	if e := receiveError(t, bobElsewhere); e.Code != ErrCodeForbidden {
+		t.Errorf("posting into the room after a ban: error code = %q, want %q", e.Code, ErrCodeForbidden)
+	}
+}
+
+func TestPushNotifications(t *testing.T) {
+	notifier := notify.NewRecordingNotifier()
+	hub := newTestHubWith(t, nil, notifier)
+	srv := newTestServer(t, hub, ServerOptions{})
+	alice := dial(t, srv, "alice-token", "trip")
+
+	// pushed waits for n notifications of kind.
+	pushed := func(kind string, n int) []*notify.Notification {
+		t.Helper()
+		deadline := time.Now().Add(2 * time.Second)
+		for {
+			var sent []*notify.Notification
+			for _, p := range notifier.Sent() {
+				if p.Kind == kind {
+					sent = append(sent, p)
+				}
+			}
+			if len(sent) >= n {
+				return sent
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("%d %s notifications pushed, want %d", len(sent), kind, n)
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+	}
+
+	// Alice's item is added before bob joins, so that its undo would be
+	// bob's first itinerary push.
+	send(t, alice, MessageTypePlanning, "trip", map[string]any{"action": planning.ActionInsert, "item_id": "a", "data": map[string]string{"title": "Temple"}})
+	receive(t, alice, ofType(MessageTypePlanningConflicts))
+	if err := hub.Members.AddMember(context.Background(), "trip", "bob"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Bob is offline, so a mention reaches him as plain text.
+	send(t, alice, MessageTypeChat, "trip", map[string]string{"content": "@bob <b>look</b> & see"})
+	if got := pushed(notify.KindMention, 1)[0]; got.UserID != "bob" || got.Body != "@bob <b>look</b> & see" {
+		t.Errorf("mention push = %+v, want the unescaped content to bob", got)
+	}
+
+	// The undo is not pushed, the next edit is.
+	send(t, alice, MessageTypePlanning, "trip", map[string]string{"action": planning.ActionUndo})
+	receive(t, alice, ofType(MessageTypePlanningConflicts))
+	send(t, alice, MessageTypePlanning, "trip", map[string]any{"action": planning.ActionInsert, "item_id": "b", "data": map[string]string{"title": "Market"}})
+	if got := pushed(notify.KindItinerary, 1); got[0].Data["item_id"] != "b" {
+		t.Errorf("itinerary push = %+v, want the insert of b", got[0])
+	}
+}
+
+// pollGet makes a long-polling request as the owner of token.
+func pollGet(t *testing.T, srv *httptest.Server, token, query string, header http.Header) pollResponse {
+	t.Helper()
+
+	req, err := http.NewRequest(http.MethodGet, srv.URL+"/poll?"+query, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	client := &http.Client{Timeout: 5 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatalf("poll: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("poll: status %d", resp.StatusCode)
+	}
+
+	var body pollResponse
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		t.Fatalf("decode poll response: %v", err)
+	}
+	return body
+}
+
+func TestHTTPTransportsIgnoreBinaryCodecs(t *testing.T) {
+	hub := newTestHub(t)
+	srv := newTestServer(t, hub, ServerOptions{})
+	bob := dial(t, srv, "bob-token", "trip")
+
+	// A client asking for MessagePack over long polling still gets JSON.
+	header := http.Header{"Sec-Websocket-Protocol": {subprotocol(msgpackCodec{}, CurrentProtocolVersion)}}
+	opened := pollGet(t, srv, "alice-token", "room_id=trip", header)
+
+	send(t, bob, MessageTypeChat, "trip", map[string]string{"content": "hello"})
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		for _, raw := range pollGet(t, srv, "alice-token", "session="+opened.SessionID, nil).Messages {
+			var msg Message
+			if err := json.Unmarshal(raw, &msg); err != nil {
+				t.Fatalf("poll message %q is not JSON: %v", raw, err)
+			}
+			if msg.Type == MessageTypeChat {
+				return
+			}
+		}
+	}
+	t.Fatal("chat message not delivered")
+}
+
+// failingPubSub fails to publish while fail is set, and never delivers.
+type failingPubSub struct {
+	fail atomic.Bool
+}
+
+func (p *failingPubSub) Publish(channel string, message []byte) error {
+	if p.fail.Load() {
+		return errors.New("connection refused")
+	}
+	return nil
+}
+
+func (p *failingPubSub) Subscribe(pattern string) <-chan pubsub.PubSubMessage {
+	return make(chan pubsub.PubSubMessage)
+}
+
+func (p *failingPubSub) Close() error { return nil }
+
+func TestRoomEventsDelivery(t *testing.T) {
+	ps := &failingPubSub{}
+	hub := newTestHubWith(t, ps, nil)
+	srv := newTestServer(t, hub, ServerOptions{})
+	bob := dial(t, srv, "bob-token", "trip")
+
+	const secret = "service-secret"
+	s := NewServer(hub, middleware.NewStaticVerifier(testTokens, time.Hour), ServerOptions{ServiceSecret: secret})
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /rooms/{id}/events", s.ServeRoomEvents)
+	api := httptest.NewServer(mux)
+	t.Cleanup(api.Close)
+
+	body := []byte(`{"type":"trip.updated","data":{"name":"Lisbon"}}`)
+	post := func(path, signedPath string) *http.Response {
+		t.Helper()
+		req, _ := http.NewRequest(http.MethodPost, api.URL+path, bytes.NewReader(body))
+		req.Header.Set(IdempotencyKeyHeader, "update-1")
+		signed, _ := http.NewRequest(http.MethodPost, api.URL+signedPath, nil)
+		signature.SetHeaders(signed, []byte(secret), time.Now(), body)
+		req.Header.Set(signature.TimestampHeader, signed.Header.Get(signature.TimestampHeader))
+		req.Header.Set(signature.SignatureHeader, signed.Header.Get(signature.SignatureHeader))
+
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp
+	}
+
+	// A signature is only good for the room it was made for.
+	if resp := post("/rooms/trip/events", "/rooms/other/events"); resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("request signed for another room: status %d, want 401", resp.StatusCode)
+	}
+
+	// A failed delivery leaves the key unused.
+	ps.fail.Store(true)
+	if resp := post("/rooms/trip/events", "/rooms/trip/events"); resp.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("failed delivery: status %d, want 503", resp.StatusCode)
+	}
+	ps.fail.Store(false)
+	if resp := post("/rooms/trip/events", "/rooms/trip/events"); resp.StatusCode != http.StatusAccepted {
+		t.Errorf("retry after a failed delivery: status %d, want 202", resp.StatusCode)
+	}
+	receive(t, bob, ofType(MessageTypeEvent))
+
+	resp := post("/rooms/trip/events", "/rooms/trip/events")
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Idempotent-Replayed") != "true" {
+		t.Errorf("retry after delivery: status %d, want a replay", resp.StatusCode)
+	}
+}
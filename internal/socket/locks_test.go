@@ -0,0 +1,125 @@
+package socket
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/rally-go/rally-realtime/internal/features/planning"
+	"github.com/rally-go/rally-realtime/internal/rooms"
+)
+
+// receiveLock waits for a lock_state frame carrying the given event.
+func receiveLock(t *testing.T, conn *websocket.Conn, event string) *planning.LockEvent {
+	t.Helper()
+
+	var got planning.LockEvent
+	receive(t, conn, func(msg *Message) bool {
+		if msg.Type != MessageTypePlanningLockState {
+			return false
+		}
+		got = planning.LockEvent{}
+		return json.Unmarshal(msg.Payload, &got) == nil && got.Event == event
+	})
+	return &got
+}
+
+func lockItem(t *testing.T, conn *websocket.Conn, action, itemID string) {
+	t.Helper()
+	send(t, conn, MessageTypePlanning, "trip", map[string]any{"action": action, "item_id": itemID})
+}
+
+func TestLockStateBroadcasts(t *testing.T) {
+	srv := newTestServer(t, newTestHub(t), ServerOptions{})
+
+	alice := dial(t, srv, "alice-token", "trip")
+	if snapshot := receiveLock(t, alice, planning.LockSnapshot); len(snapshot.Locks) != 0 {
+		t.Fatalf("snapshot of a new room = %+v, want no locks", snapshot.Locks)
+	}
+
+	// The user who locks is told too.
+	lockItem(t, alice, planning.ActionLock, "a")
+	if e := receiveLock(t, alice, planning.LockLocked); e.ItemID != "a" || e.UserID != "alice" || e.ExpiresAt == nil {
+		t.Fatalf("locked = %+v, want a locked by alice with an expiry", e)
+	}
+
+	// Joiners get the lock table.
+	bob := dial(t, srv, "bob-token", "trip")
+	snapshot := receiveLock(t, bob, planning.LockSnapshot)
+	if len(snapshot.Locks) != 1 || snapshot.Locks[0].ItemID != "a" || snapshot.Locks[0].UserID != "alice" {
+		t.Fatalf("snapshot on join = %+v, want alice's lock on a", snapshot.Locks)
+	}
+
+	lockItem(t, bob, planning.ActionLock, "a")
+	if got := receiveError(t, bob); got.Code != "item_locked" {
+		t.Errorf("locking a held item: error %q, want item_locked", got.Code)
+	}
+
+	lockItem(t, alice, planning.ActionUnlock, "a")
+	if e := receiveLock(t, bob, planning.LockUnlocked); e.ItemID != "a" || e.UserID != "alice" {
+		t.Errorf("unlocked = %+v, want a unlocked by alice", e)
+	}
+
+	// The owner may take a member's lock.
+	lockItem(t, bob, planning.ActionLock, "a")
+	receiveLock(t, alice, planning.LockLocked)
+	send(t, alice, MessageTypePlanning, "trip", map[string]any{"action": planning.ActionLock, "item_id": "a", "steal": true})
+	if e := receiveLock(t, bob, planning.LockStolen); e.UserID != "alice" || e.PreviousUserID != "bob" {
+		t.Errorf("stolen = %+v, want taken by alice from bob", e)
+	}
+}
+
+func TestLocksReleasedOnLastDisconnect(t *testing.T) {
+	hub := newTestHub(t)
+	srv := newTestServer(t, hub, ServerOptions{})
+	disconnected := make(chan ConnEvent, 4)
+	hub.OnDisconnect(func(ctx context.Context, ev ConnEvent) { disconnected <- ev })
+
+	alice := dial(t, srv, "alice-token", "trip")
+	bob := dial(t, srv, "bob-token", "trip")
+	lockItem(t, bob, planning.ActionLock, "a")
+	receiveLock(t, alice, planning.LockLocked)
+
+	// bob's other connection keeps the lock.
+	second := dial(t, srv, "bob-token", "trip")
+	second.Close()
+	<-disconnected
+	lockItem(t, alice, planning.ActionLock, "a")
+	if got := receiveError(t, alice); got.Code != "item_locked" {
+		t.Fatalf("locking while bob is connected: error %q, want item_locked", got.Code)
+	}
+
+	bob.Close()
+	<-disconnected
+	if e := receiveLock(t, alice, planning.LockUnlocked); e.ItemID != "a" || e.UserID != "bob" {
+		t.Errorf("unlocked = %+v, want bob's lock on a released", e)
+	}
+}
+
+func TestLocksReleasedOnPresenceExpiry(t *testing.T) {
+	hub := newTestHub(t)
+	srv := newTestServer(t, hub, ServerOptions{})
+	alice := dial(t, srv, "alice-token", "trip")
+	receiveLock(t, alice, planning.LockSnapshot)
+
+	// bob is connected through an instance that has stopped refreshing
+	// presence.
+	ctx := context.Background()
+	if _, err := hub.Presence.Connect(ctx, "trip", "bob", "lost-conn"); err != nil {
+		t.Fatal(err)
+	}
+	if err := hub.Members.AddMember(ctx, "trip", "bob"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := hub.Planning.ProcessAction(ctx, "bob", "trip", json.RawMessage(`{"action":"lock","item_id":"a"}`)); err != nil {
+		t.Fatal(err)
+	}
+
+	// alice's presence expires too, but only bob holds a lock.
+	hub.expirePresence(time.Now().Add(rooms.PresenceTTL + time.Second))
+	if e := receiveLock(t, alice, planning.LockUnlocked); e.ItemID != "a" || e.UserID != "bob" {
+		t.Errorf("unlocked = %+v, want bob's lock on a released", e)
+	}
+}
@@ -0,0 +1,208 @@
+package socket
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Long-polling timing.
+const (
+	// pollWait is how long a poll waits for messages before returning
+	// empty, short of common proxy timeouts.
+	pollWait = 25 * time.Second
+
+	// pollSessionTimeout is how long a session survives without a poll.
+	pollSessionTimeout = pongWait
+
+	// maxPollQueue is the number of messages held for a session between
+	// polls before it is dropped as too slow, like a full send buffer.
+	maxPollQueue = 256
+)
+
+var (
+	errPollQueueFull = errors.New("poll queue full")
+	errPollTimeout   = errors.New("no poll within session timeout")
+)
+
+// pollTransport holds frames until the peer polls for them.
+type pollTransport struct {
+	mu       sync.Mutex
+	queue    [][]byte
+	closed   *closeEvent // set once the session has ended
+	polling  int         // polls in progress
+	lastPoll time.Time
+
+	// Signalled when frames are queued or the session ends
+	ready chan struct{}
+}
+
+func newPollTransport() *pollTransport {
+	return &pollTransport{lastPoll: time.Now(), ready: make(chan struct{}, 1)}
+}
+
+func (t *pollTransport) Name() string { return "longpoll" }
+
+func (t *pollTransport) signal() {
+	select {
+	case t.ready <- struct{}{}:
+	default:
+	}
+}
+
+func (t *pollTransport) Send(frames [][]byte) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if len(t.queue)+len(frames) > maxPollQueue {
+		return errPollQueueFull
+	}
+	t.queue = append(t.queue, frames...)
+	t.signal()
+	return nil
+}
+
+// Ping fails once the peer has stopped polling.
+func (t *pollTransport) Ping() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.polling == 0 && time.Since(t.lastPoll) > pollSessionTimeout {
+		return errPollTimeout
+	}
+	return nil
+}
+
+func (t *pollTransport) Close(code int, reason string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if code == 0 {
+		// The session failed rather than being closed; report it as a
+		// WebSocket would an abnormal closure.
+		code, reason = 1006, "session ended"
+	}
+	t.closed = &closeEvent{Code: code, Reason: reason}
+	t.signal()
+}
+
+// poll waits up to wait for queued frames or the end of the session and
+// takes them.
+func (t *pollTransport) poll(ctx context.Context, wait time.Duration) ([][]byte, *closeEvent) {
+	t.mu.Lock()
+	t.polling++
+	t.mu.Unlock()
+	defer func() {
+		t.mu.Lock()
+		t.polling--
+		t.lastPoll = time.Now()
+		t.mu.Unlock()
+	}()
+
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+
+	for {
+		t.mu.Lock()
+		if len(t.queue) > 0 || t.closed != nil {
+			frames, closed := t.queue, t.closed
+			t.queue = nil
+			t.mu.Unlock()
+			return frames, closed
+		}
+		t.mu.Unlock()
+
+		select {
+		case <-t.ready:
+		case <-timer.C:
+			return nil, nil
+		case <-ctx.Done():
+			return nil, nil
+		}
+	}
+}
+
+// pollResponse is the body of every GET /poll response.
+type pollResponse struct {
+	SessionID string            `json:"session_id"`
+	Messages  []json.RawMessage `json:"messages"`
+	Close     *closeEvent       `json:"close,omitempty"`
+}
+
+// ServePoll serves the long-polling transport. GET /poll?room_id=<room>
+// opens a session and returns its ID at once; GET /poll?session=<id> then
+// waits up to 25 seconds for messages and returns them, or an empty list.
+// Both need the token. Once the session ends, the response carries the
+// close code and reason and the session is gone.
+func (s *Server) ServePoll(w http.ResponseWriter, r *http.Request) {
+	if !s.allowCORS(w, r) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+	if preflight(w, r, http.MethodGet) {
+		return
+	}
+
+	if r.URL.Query().Get("session") == "" {
+		s.openPollSession(w, r)
+		return
+	}
+
+	client := s.session(w, r)
+	if client == nil {
+		return
+	}
+	transport, ok := client.transport.(*pollTransport)
+	if !ok {
+		http.Error(w, "not a long-polling session", http.StatusBadRequest)
+		return
+	}
+
+	http.NewResponseController(w).SetWriteDeadline(time.Now().Add(pollWait + writeWait))
+	frames, closed := transport.poll(r.Context(), pollWait)
+	if closed != nil {
+		s.removeSession(client.ID)
+	}
+
+	resp := pollResponse{SessionID: client.ID, Messages: make([]json.RawMessage, 0, len(frames)), Close: closed}
+	for _, f := range frames {
+		resp.Messages = append(resp.Messages, f)
+	}
+	writePollResponse(w, &resp)
+}
+
+func (s *Server) openPollSession(w http.ResponseWriter, r *http.Request) {
+	ident, roomID, proto, ok := s.openSession(w, r)
+	if !ok {
+		return
+	}
+
+	transport := newPollTransport()
+	client := NewClient(uuid.New().String(), ident.UserID, roomID, s.hub, transport)
+	client.wire = proto
+
+	s.addSession(client)
+	s.attach(client, ident)
+
+	go func() {
+		client.WritePump()
+		s.hub.Unregister <- client
+		// Keep the session until the peer has had a chance to poll for
+		// the close reason.
+		time.AfterFunc(pollSessionTimeout, func() { s.removeSession(client.ID) })
+	}()
+
+	writePollResponse(w, &pollResponse{SessionID: client.ID, Messages: []json.RawMessage{}})
+}
+
+func writePollResponse(w http.ResponseWriter, resp *pollResponse) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	_ = json.NewEncoder(w).Encode(resp)
+}
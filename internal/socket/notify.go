@@ -0,0 +1,111 @@
+package socket
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"html"
+	"log"
+
+	"github.com/rally-go/rally-realtime/internal/features/chat"
+	"github.com/rally-go/rally-realtime/internal/features/notify"
+	"github.com/rally-go/rally-realtime/internal/features/planning"
+)
+
+// maxNotificationBody is the number of characters of a chat message shown
+// in a mention notification.
+const maxNotificationBody = 140
+
+// handleNotifications applies a notification settings action and answers
+// the sender only.
+func (h *Hub) handleNotifications(client *Client, msg *Message) {
+	if h.Notify == nil {
+		h.sendError(client, msg.RoomID, MessageTypeNotifications, "unavailable", "push notifications are not enabled", nil)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), handlerTimeout)
+	defer cancel()
+
+	action, err := h.Notify.ProcessAction(ctx, client.UserID, msg.RoomID, msg.Payload)
+	if err != nil {
+		log.Printf("Notification action from %s rejected: %v", client.UserID, err)
+		if errors.Is(err, notify.ErrInvalidAction) {
+			h.sendError(client, msg.RoomID, MessageTypeNotifications, ErrCodeInvalidPayload, err.Error(), nil)
+		} else {
+			h.sendError(client, msg.RoomID, MessageTypeNotifications, ErrCodeInternal, "failed to process notification action", nil)
+		}
+		return
+	}
+
+	payload, err := json.Marshal(action)
+	if err != nil {
+		log.Printf("Failed to marshal notification action: %v", err)
+		return
+	}
+	h.sendToClientMessage(client, &Message{Type: MessageTypeNotifications, RoomID: msg.RoomID, Payload: payload})
+}
+
+// notifyMentions pushes a mention to each mentioned member who is not
+// connected to the room. Members connected elsewhere get the mention event
+// in-app as well as the push.
+func (h *Hub) notifyMentions(roomID string, targets []string, chatMsg *chat.ChatMessage) {
+	if h.Notify == nil || len(targets) == 0 {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), handlerTimeout)
+	defer cancel()
+
+	title := "New mention"
+	if chatMsg.Username != "" {
+		title = chatMsg.Username + " mentioned you"
+	}
+	// Content is escaped for display in HTML; notifications are plain text.
+	body := []rune(html.UnescapeString(chatMsg.Content))
+	if len(body) > maxNotificationBody {
+		body = append(body[:maxNotificationBody-1], '…')
+	}
+
+	err := h.Notify.NotifyUsers(ctx, roomID, targets, notify.Notification{
+		Kind:  notify.KindMention,
+		Title: title,
+		Body:  string(body),
+		Data:  map[string]string{"message_id": chatMsg.ID},
+	})
+	if err != nil {
+		log.Printf("Failed to notify mentions in room %s: %v", roomID, err)
+	}
+}
+
+// itineraryBodies holds the notification text of the planning actions that
+// are worth a push. Moves only reorder the day.
+var itineraryBodies = map[string]string{
+	planning.ActionInsert: "An item was added to the itinerary",
+	planning.ActionUpdate: "An itinerary item was changed",
+	planning.ActionDelete: "An item was removed from the itinerary",
+}
+
+// notifyItinerary pushes an itinerary change to the room's members who are
+// not connected to it, but not to the editor. The compensating edits of
+// undo and redo are not pushed: they follow an edit that was already
+// announced.
+func (h *Hub) notifyItinerary(editorID, roomID string, action *planning.PlanningAction) {
+	body, ok := itineraryBodies[action.Action]
+	if h.Notify == nil || !ok || action.Source != "" {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), handlerTimeout)
+	defer cancel()
+
+	err := h.Notify.NotifyRoom(ctx, roomID, editorID, notify.Notification{
+		Kind:  notify.KindItinerary,
+		Title: "Itinerary updated",
+		Body:  body,
+		Data:  map[string]string{"item_id": action.ItemID},
+	})
+	if err != nil {
+		log.Printf("Failed to notify itinerary change in room %s: %v", roomID, err)
+	}
+}
@@ -0,0 +1,135 @@
+package socket
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+
+	"github.com/rally-go/rally-realtime/internal/features/planning"
+	"github.com/rally-go/rally-realtime/internal/features/polls"
+	"github.com/rally-go/rally-realtime/internal/middleware"
+	"github.com/rally-go/rally-realtime/internal/rooms"
+)
+
+// A member's role decides what they may do in a room. The role comes from
+// the token's middleware.RolesClaim, which the backend sets as a Firebase
+// custom claim, or else from the membership store: the first member owns
+// the room, the owner appoints moderators, and everyone else is a member.
+// The router checks the matrix below before a message reaches its feature.
+
+// permissions is the least role each message type requires. Direct
+// messages are not bound to a room and are not listed.
+var permissions = map[MessageType]rooms.Role{
+	MessageTypeChat:          rooms.RoleGuest,
+	MessageTypeNotifications: rooms.RoleGuest,
+	MessageTypeLocation:      rooms.RoleMember,
+	MessageTypePlanning:      rooms.RoleGuest, // see actionPermissions
+	MessageTypePoll:          rooms.RoleGuest, // see actionPermissions
+	MessageTypeModeration:    rooms.RoleModerator,
+	MessageTypeChatFilters:   rooms.RoleModerator,
+}
+
+// actionPermissions refines permissions by the action named in the
+// payload. Actions not listed require RoleMember.
+var actionPermissions = map[MessageType]map[string]rooms.Role{
+	MessageTypePlanning: {
+		planning.ActionSync:    rooms.RoleGuest,
+		planning.ActionHistory: rooms.RoleGuest,
+		planning.ActionLock:    rooms.RoleMember,
+		planning.ActionUnlock:  rooms.RoleMember,
+		planning.ActionInsert:  rooms.RoleMember,
+		planning.ActionUpdate:  rooms.RoleMember,
+		planning.ActionMove:    rooms.RoleMember,
+		planning.ActionDelete:  rooms.RoleMember,
+		planning.ActionUndo:    rooms.RoleMember,
+		planning.ActionRedo:    rooms.RoleMember,
+	},
+	MessageTypePoll: {
+		polls.ActionGet:    rooms.RoleGuest,
+		polls.ActionList:   rooms.RoleGuest,
+		polls.ActionCreate: rooms.RoleMember,
+		polls.ActionVote:   rooms.RoleMember,
+		polls.ActionClose:  rooms.RoleMember,
+	},
+}
+
+// requiredRole returns the least role msg requires.
+func requiredRole(msg *Message) rooms.Role {
+	required := permissions[msg.Type]
+	actions, ok := actionPermissions[msg.Type]
+	if !ok {
+		return required
+	}
+
+	// A malformed payload requires RoleMember here and is rejected by
+	// the feature.
+	var payload struct {
+		Action string `json:"action"`
+	}
+	_ = json.Unmarshal(msg.Payload, &payload)
+	if role, ok := actions[payload.Action]; ok {
+		return role
+	}
+	return rooms.RoleMember
+}
+
+// authorize reports whether client's role allows msg, answering with a
+// forbidden error if not.
+func (h *Hub) authorize(client *Client, msg *Message) bool {
+	required := requiredRole(msg)
+	if required == rooms.RoleNone {
+		return true
+	}
+
+	role, err := h.roomRole(client, msg.RoomID)
+	if err != nil {
+		log.Printf("Failed to load role: user=%s room=%s: %v", client.UserID, msg.RoomID, err)
+		h.sendError(client, msg.RoomID, msg.Type, ErrCodeInternal, "failed to check permissions", nil)
+		return false
+	}
+	if !role.AtLeast(required) {
+		log.Printf("Rejected %s message from %s in room %s: role %q, requires %q", msg.Type, client.UserID, msg.RoomID, role, required)
+		h.sendError(client, msg.RoomID, msg.Type, ErrCodeForbidden, fmt.Sprintf("requires the %s role", required),
+			map[string]any{"role": role, "required": required})
+		return false
+	}
+	return true
+}
+
+// roomRole returns the client's role in roomID: the one its token grants,
+// or else the one in the membership store.
+func (h *Hub) roomRole(client *Client, roomID string) (rooms.Role, error) {
+	if role, ok := client.token.role(roomID); ok {
+		return role, nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), handlerTimeout)
+	defer cancel()
+
+	return h.Members.Role(ctx, roomID, client.UserID)
+}
+
+// claimedRoles returns the valid room roles a token grants.
+func claimedRoles(ident *middleware.Identity) map[string]rooms.Role {
+	var roles map[string]rooms.Role
+	for roomID, name := range ident.Roles {
+		if role := rooms.Role(name); role.IsValid() {
+			if roles == nil {
+				roles = make(map[string]rooms.Role)
+			}
+			roles[roomID] = role
+		}
+	}
+	return roles
+}
+
+// applyRoleClaim records the role the token grants in roomID as the
+// member's claimed role, so that features checking roles themselves, such
+// as moderation, agree with the router. A token without a claim for the
+// room clears the one recorded before, so that revoking it takes effect
+// when the user next connects or refreshes their token.
+func (h *Hub) applyRoleClaim(ctx context.Context, roomID string, ident *middleware.Identity) error {
+	role := claimedRoles(ident)[roomID] // RoleNone if not granted
+	return h.Members.SetClaimedRole(ctx, roomID, ident.UserID, role)
+}
@@ -0,0 +1,183 @@
+package socket
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/rally-go/rally-realtime/internal/features/planning"
+	"github.com/rally-go/rally-realtime/internal/features/polls"
+	"github.com/rally-go/rally-realtime/internal/middleware"
+	"github.com/rally-go/rally-realtime/internal/rooms"
+)
+
+func TestRequiredRole(t *testing.T) {
+	tests := []struct {
+		msgType MessageType
+		payload string
+		want    rooms.Role
+	}{
+		{MessageTypeChat, `{"content":"hi"}`, rooms.RoleGuest},
+		{MessageTypeNotifications, `{"action":"get"}`, rooms.RoleGuest},
+		{MessageTypeLocation, `{"lat":13.75,"lng":100.49}`, rooms.RoleMember},
+		{MessageTypePlanning, `{"action":"sync"}`, rooms.RoleGuest},
+		{MessageTypePlanning, `{"action":"history"}`, rooms.RoleGuest},
+		{MessageTypePlanning, `{"action":"insert"}`, rooms.RoleMember},
+		{MessageTypePlanning, `{"action":"undo"}`, rooms.RoleMember},
+		{MessageTypePlanning, `{"action":"teleport"}`, rooms.RoleMember},
+		{MessageTypePlanning, `{"action":`, rooms.RoleMember},
+		{MessageTypePoll, `{"action":"list"}`, rooms.RoleGuest},
+		{MessageTypePoll, `{"action":"vote"}`, rooms.RoleMember},
+		{MessageTypeModeration, `{"action":"mute"}`, rooms.RoleModerator},
+		{MessageTypeChatFilters, `{"action":"get"}`, rooms.RoleModerator},
+		{MessageTypeDirect, `{"content":"hi"}`, rooms.RoleNone},
+	}
+	for _, tt := range tests {
+		msg := &Message{Type: tt.msgType, RoomID: "trip", Payload: json.RawMessage(tt.payload)}
+		if got := requiredRole(msg); got != tt.want {
+			t.Errorf("requiredRole(%s %s) = %q, want %q", tt.msgType, tt.payload, got, tt.want)
+		}
+	}
+
+	// Every action of the features refined by action is listed.
+	for _, action := range []string{planning.ActionSync, planning.ActionHistory, planning.ActionLock, planning.ActionUnlock,
+		planning.ActionInsert, planning.ActionUpdate, planning.ActionMove, planning.ActionDelete, planning.ActionUndo, planning.ActionRedo} {
+		if _, ok := actionPermissions[MessageTypePlanning][action]; !ok {
+			t.Errorf("planning action %q has no permission", action)
+		}
+	}
+	for _, action := range []string{polls.ActionGet, polls.ActionList, polls.ActionCreate, polls.ActionVote, polls.ActionClose} {
+		if _, ok := actionPermissions[MessageTypePoll][action]; !ok {
+			t.Errorf("poll action %q has no permission", action)
+		}
+	}
+}
+
+// claimVerifier accepts tokens standing for identities with role claims.
+type claimVerifier map[string]middleware.Identity
+
+func (v claimVerifier) Verify(ctx context.Context, token string) (*middleware.Identity, error) {
+	ident, ok := v[token]
+	if !ok {
+		return nil, fmt.Errorf("%w: unknown token", middleware.ErrTokenRejected)
+	}
+	ident.ExpiresAt = time.Now().Add(time.Hour)
+	return &ident, nil
+}
+
+func (v claimVerifier) VerifyRefresh(ctx context.Context, token string) (*middleware.Identity, error) {
+	return v.Verify(ctx, token)
+}
+
+// newClaimServer serves hub's WebSocket transport, accepting the tokens
+// of verifier.
+func newClaimServer(t *testing.T, hub *Hub, verifier claimVerifier) *httptest.Server {
+	t.Helper()
+
+	s := NewServer(hub, verifier, ServerOptions{})
+	mux := http.NewServeMux()
+	mux.HandleFunc("/ws", s.ServeWs)
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestPermissionMatrix(t *testing.T) {
+	hub := newTestHub(t)
+	srv := newClaimServer(t, hub, claimVerifier{
+		"alice-token": {UserID: "alice"},
+		"bob-token":   {UserID: "bob"},
+		"guest-token": {UserID: "carol", Roles: map[string]string{"trip": "guest"}},
+		"dave-token":  {UserID: "dave"},
+	})
+	alice := dial(t, srv, "alice-token", "trip") // owner
+	bob := dial(t, srv, "bob-token", "trip")
+	carol := dial(t, srv, "guest-token", "trip")
+
+	forbidden := func(conn *websocket.Conn, who string, msgType MessageType, payload any, role, required rooms.Role) {
+		t.Helper()
+		send(t, conn, msgType, "trip", payload)
+		e := receiveError(t, conn)
+		if e.Code != ErrCodeForbidden {
+			t.Fatalf("%s sending %s: error code = %q, want %q", who, msgType, e.Code, ErrCodeForbidden)
+		}
+		details, _ := json.Marshal(e.Details)
+		if want := fmt.Sprintf(`{"required":%q,"role":%q}`, required, role); string(details) != want {
+			t.Errorf("%s sending %s: details = %s, want %s", who, msgType, details, want)
+		}
+	}
+
+	// A guest may chat and read the itinerary, but not change it.
+	send(t, carol, MessageTypeChat, "trip", map[string]string{"content": "hello"})
+	receive(t, alice, ofType(MessageTypeChat))
+	send(t, carol, MessageTypePlanning, "trip", map[string]string{"action": planning.ActionSync})
+	receive(t, carol, ofType(MessageTypePlanning))
+	forbidden(carol, "guest", MessageTypePlanning, map[string]any{"action": planning.ActionInsert, "item_id": "a", "data": map[string]string{"title": "Temple"}}, rooms.RoleGuest, rooms.RoleMember)
+	forbidden(carol, "guest", MessageTypeLocation, map[string]float64{"lat": 13.75, "lng": 100.49}, rooms.RoleGuest, rooms.RoleMember)
+	forbidden(carol, "guest", MessageTypePoll, map[string]any{"action": polls.ActionCreate}, rooms.RoleGuest, rooms.RoleMember)
+
+	// A member may edit, but not moderate.
+	send(t, bob, MessageTypePlanning, "trip", map[string]any{"action": planning.ActionInsert, "item_id": "a", "data": map[string]string{"title": "Temple"}})
+	receive(t, alice, ofType(MessageTypePlanning))
+	forbidden(bob, "member", MessageTypeModeration, map[string]string{"action": "mute", "user_id": "carol"}, rooms.RoleMember, rooms.RoleModerator)
+	forbidden(bob, "member", MessageTypeChatFilters, map[string]string{"action": "get"}, rooms.RoleMember, rooms.RoleModerator)
+
+	// The owner may moderate.
+	send(t, alice, MessageTypeModeration, "trip", map[string]any{"action": "mute", "user_id": "carol", "duration_seconds": 60})
+	receive(t, bob, ofType(MessageTypeModeration))
+
+	// Rooms admitting guests by default give that role without a claim.
+	if err := hub.Members.(*rooms.MemoryStore).SetDefaultRole(rooms.RoleGuest); err != nil {
+		t.Fatal(err)
+	}
+	dave := dial(t, srv, "dave-token", "trip")
+	send(t, dave, MessageTypeChat, "trip", map[string]string{"content": "hi"})
+	receive(t, alice, ofType(MessageTypeChat))
+	forbidden(dave, "guest", MessageTypePlanning, map[string]any{"action": planning.ActionInsert, "item_id": "b", "data": map[string]string{"title": "Market"}}, rooms.RoleGuest, rooms.RoleMember)
+	forbidden(dave, "guest", MessageTypePoll, map[string]any{"action": polls.ActionCreate}, rooms.RoleGuest, rooms.RoleMember)
+}
+
+func TestRoleClaimsNotPersisted(t *testing.T) {
+	hub := newTestHub(t)
+	srv := newClaimServer(t, hub, claimVerifier{
+		"alice-token":     {UserID: "alice"},
+		"bob-token":       {UserID: "bob"},
+		"carol-moderator": {UserID: "carol", Roles: map[string]string{"trip": "moderator", "other": "owner"}},
+		"carol-token":     {UserID: "carol", Roles: map[string]string{"other": "owner"}},
+	})
+	ctx := context.Background()
+	alice := dial(t, srv, "alice-token", "trip") // owner
+	dial(t, srv, "bob-token", "trip")
+	carol := dial(t, srv, "carol-moderator", "trip")
+
+	// The claim lets carol moderate, including in the moderation feature's
+	// own checks.
+	send(t, carol, MessageTypeModeration, "trip", map[string]any{"action": "mute", "user_id": "bob", "duration_seconds": 60})
+	receive(t, alice, ofType(MessageTypeModeration))
+	if role, _ := hub.Members.Role(ctx, "trip", "carol"); role != rooms.RoleModerator {
+		t.Errorf("role with the claim = %q, want moderator", role)
+	}
+
+	// Once the claim is gone, so is the role.
+	carol.Close()
+	carol = dial(t, srv, "carol-token", "trip")
+	send(t, carol, MessageTypeModeration, "trip", map[string]any{"action": "unmute", "user_id": "bob"})
+	if e := receiveError(t, carol); e.Code != ErrCodeForbidden {
+		t.Errorf("moderating after the claim was removed: error code = %q, want %q", e.Code, ErrCodeForbidden)
+	}
+	if role, _ := hub.Members.Role(ctx, "trip", "carol"); role != rooms.RoleMember {
+		t.Errorf("role after the claim was removed = %q, want member", role)
+	}
+
+	// An owner claim does not take the room from its owner.
+	dial(t, srv, "bob-token", "other") // owner
+	dial(t, srv, "carol-token", "other")
+	if role, _ := hub.Members.Role(ctx, "other", "bob"); role != rooms.RoleOwner {
+		t.Errorf("owner's role after another's owner claim = %q, want owner", role)
+	}
+}
@@ -0,0 +1,430 @@
+package socket
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"maps"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/rally-go/rally-realtime/internal/features/planning"
+)
+
+// Protocol versions. A client states its version in the subprotocol
+// ("rally.json.v2") or, if it cannot set one, in the "v" query parameter.
+// Clients that state neither are app builds released before versioning
+// and speak version 1.
+const (
+	// ProtocolV1 is the original protocol: chat, location and planning
+	// messages only, with lock changes sent as planning lock and unlock
+	// actions and batched messages separated by newlines.
+	ProtocolV1 = 1
+
+	// ProtocolV2 adds every message type and payload introduced since:
+	// errors, mentions, direct messages, moderation, polls, and planning
+	// conflict and lock_state events. Batched messages are sent as an
+	// array instead of separated by newlines.
+	ProtocolV2 = 2
+
+	// CurrentProtocolVersion is the version the hub and features speak.
+	// Messages for clients on older versions go through an adapter.
+	CurrentProtocolVersion = ProtocolV2
+)
+
+// CloseUpgradeRequired is sent to clients whose protocol version is no
+// longer supported. The close reason names the minimum version.
+const CloseUpgradeRequired = 4426
+
+var errUnsupportedVersion = errors.New("unsupported protocol version")
+
+// subprotocol returns the subprotocol selecting codec at version.
+func subprotocol(codec Codec, version int) string {
+	return "rally." + codec.Name() + ".v" + strconv.Itoa(version)
+}
+
+// subprotocols lists every supported subprotocol for the upgrader, newest
+// version first. Versions below the server's minimum stay listed so that
+// such clients complete the handshake and learn why they are turned away.
+var subprotocols = func() []string {
+	var out []string
+	for v := CurrentProtocolVersion; v >= ProtocolV1; v-- {
+		for _, c := range codecs {
+			out = append(out, subprotocol(c, v))
+		}
+	}
+	return out
+}()
+
+// parseSubprotocol returns the codec and version of a rally subprotocol.
+func parseSubprotocol(p string) (Codec, int, bool) {
+	rest, ok := strings.CutPrefix(p, "rally.")
+	if !ok {
+		return nil, 0, false
+	}
+	name, ver, ok := strings.Cut(rest, ".v")
+	if !ok {
+		return nil, 0, false
+	}
+	version, err := strconv.Atoi(ver)
+	if err != nil || version < ProtocolV1 || version > CurrentProtocolVersion {
+		return nil, 0, false
+	}
+	for _, c := range codecs {
+		if c.Name() == name {
+			return c, version, true
+		}
+	}
+	return nil, 0, false
+}
+
+// wire is a client's negotiated codec and protocol version.
+type wire struct {
+	codec   Codec
+	version int
+}
+
+// currentWire is the hub's own representation: JSON at the current version.
+var currentWire = wire{codec: jsonCodec{}, version: CurrentProtocolVersion}
+
+// negotiateWire picks the codec and protocol version for a connection
+// request. The first supported subprotocol the client offers wins, as in the
+// upgrader; without one, the codec is JSON and the version comes from the
+// "v" query parameter, defaulting to version 1.
+func negotiateWire(r *http.Request) (wire, error) {
+	for _, p := range websocket.Subprotocols(r) {
+		if codec, version, ok := parseSubprotocol(p); ok {
+			return wire{codec: codec, version: version}, nil
+		}
+	}
+
+	w := wire{codec: jsonCodec{}, version: ProtocolV1}
+	if v := r.URL.Query().Get("v"); v != "" {
+		version, err := strconv.Atoi(v)
+		if err != nil || version < ProtocolV1 || version > CurrentProtocolVersion {
+			return wire{}, fmt.Errorf("%w: %q", errUnsupportedVersion, v)
+		}
+		w.version = version
+	}
+	return w, nil
+}
+
+// encode converts a message from the hub's representation to the client's.
+// Adapters may turn one message into several, or drop it.
+func (w wire) encode(data []byte) ([][]byte, error) {
+	if w == currentWire {
+		return [][]byte{data}, nil
+	}
+
+	msg, err := jsonCodec{}.Decode(data)
+	if err != nil {
+		return nil, err
+	}
+	msgs := []*Message{msg}
+	if a, ok := adapters[w.version]; ok {
+		if msgs, err = a.outbound(msg); err != nil {
+			return nil, err
+		}
+	}
+
+	frames := make([][]byte, 0, len(msgs))
+	for _, m := range msgs {
+		frame, err := w.codec.Encode(m)
+		if err != nil {
+			return nil, err
+		}
+		frames = append(frames, frame)
+	}
+	return frames, nil
+}
+
+// batch joins several encoded messages into one frame. Version 1 JSON
+// clients expect messages separated by newlines; everyone else gets the
+// codec's batch envelope.
+func (w wire) batch(frames [][]byte) []byte {
+	if w.version == ProtocolV1 && w.codec.FrameType() == websocket.TextMessage {
+		return bytes.Join(frames, []byte{'\n'})
+	}
+	return w.codec.Batch(frames)
+}
+
+// decode converts a client frame to the hub's representation. It returns a
+// nil message if the client's version has no equivalent. items looks up
+// itinerary state that older versions do not send.
+func (w wire) decode(data []byte, items itemLookup) (*Message, error) {
+	msg, err := w.codec.Decode(data)
+	if err != nil {
+		return nil, err
+	}
+	if a, ok := adapters[w.version]; ok {
+		return a.inbound(msg, items)
+	}
+	return msg, nil
+}
+
+// itemLookup returns the current state of an itinerary item. An empty
+// roomID means the client's own room.
+type itemLookup func(ctx context.Context, roomID, itemID string) (planning.ItemView, bool, error)
+
+// adapter translates messages between an older protocol version and the
+// current one.
+type adapter interface {
+	// outbound translates a current message for the client. It may return
+	// no messages if the client's version has no equivalent.
+	outbound(msg *Message) ([]*Message, error)
+	// inbound translates a message from the client, or returns nil to drop
+	// it.
+	inbound(msg *Message, items itemLookup) (*Message, error)
+}
+
+// adapters holds the adapter of every supported version but the current one.
+var adapters = map[int]adapter{
+	ProtocolV1: v1Adapter{},
+}
+
+// v1Adapter serves clients that only know chat, location and planning
+// messages. Version 1 planning has lock, unlock and update actions, where
+// update carries the whole item and no version. Itinerary edits are turned
+// into such updates and lock changes back into lock and unlock actions;
+// planning errors are reported as actions too, and every other server
+// message is dropped.
+type v1Adapter struct{}
+
+// v1ActionError reports a rejected planning action to a version 1 client,
+// with the error code and message in data. Clients that predate it ignore
+// it.
+const v1ActionError = "error"
+
+// v1PlanningAction is the planning payload of protocol version 1.
+type v1PlanningAction struct {
+	Action    string          `json:"action"` // "lock", "unlock", "update"
+	ItemID    string          `json:"item_id"`
+	UserID    string          `json:"user_id"`
+	Data      json.RawMessage `json:"data,omitempty"`
+	Timestamp time.Time       `json:"timestamp"`
+}
+
+func (v1Adapter) outbound(msg *Message) ([]*Message, error) {
+	switch msg.Type {
+	case MessageTypeChat, MessageTypeLocation:
+		return []*Message{msg}, nil
+	case MessageTypePlanning:
+		var action planning.PlanningAction
+		if err := json.Unmarshal(msg.Payload, &action); err != nil {
+			return nil, err
+		}
+		return v1ItemUpdate(msg.RoomID, &action)
+	case MessageTypePlanningLockState:
+		var event planning.LockEvent
+		if err := json.Unmarshal(msg.Payload, &event); err != nil {
+			return nil, err
+		}
+		return v1LockActions(msg.RoomID, &event)
+	case MessageTypeError:
+		return v1Error(msg)
+	default:
+		return nil, nil
+	}
+}
+
+// v1ItemUpdate turns an insert, update or move into a version 1 update
+// carrying the whole item. Version 1 has no equivalent of the other
+// actions, deletes included.
+func v1ItemUpdate(roomID string, action *planning.PlanningAction) ([]*Message, error) {
+	if action.Item == nil {
+		return nil, nil
+	}
+	data, err := v1Item(action.Item)
+	if err != nil {
+		return nil, err
+	}
+	return v1Messages(roomID, v1PlanningAction{Action: planning.ActionUpdate, ItemID: action.ItemID, UserID: action.UserID, Data: data, Timestamp: action.Timestamp})
+}
+
+// v1Item returns an item as version 1 clients know it: its fields and its
+// order key in one object.
+func v1Item(item *planning.ItemView) (json.RawMessage, error) {
+	fields := make(map[string]json.RawMessage, len(item.Fields)+1)
+	maps.Copy(fields, item.Fields)
+	key, err := json.Marshal(item.Position)
+	if err != nil {
+		return nil, err
+	}
+	fields["order_key"] = key
+	return json.Marshal(fields)
+}
+
+// v1LockActions turns a lock event into version 1 planning actions.
+func v1LockActions(roomID string, event *planning.LockEvent) ([]*Message, error) {
+	var actions []v1PlanningAction
+	switch event.Event {
+	case planning.LockLocked, planning.LockStolen:
+		actions = append(actions, v1PlanningAction{Action: planning.ActionLock, ItemID: event.ItemID, UserID: event.UserID, Timestamp: event.Timestamp})
+	case planning.LockUnlocked, planning.LockExpired:
+		actions = append(actions, v1PlanningAction{Action: planning.ActionUnlock, ItemID: event.ItemID, UserID: event.UserID, Timestamp: event.Timestamp})
+	case planning.LockSnapshot:
+		for _, lock := range event.Locks {
+			actions = append(actions, v1PlanningAction{Action: planning.ActionLock, ItemID: lock.ItemID, UserID: lock.UserID, Timestamp: event.Timestamp})
+		}
+	}
+	return v1Messages(roomID, actions...)
+}
+
+// v1Error turns the error of a rejected planning action into version 1
+// actions: the holder's lock for item_locked, the current item for
+// stale_version, and an error action otherwise. Errors about other
+// messages are dropped.
+func v1Error(msg *Message) ([]*Message, error) {
+	var e struct {
+		Code    string          `json:"code"`
+		Message string          `json:"message"`
+		Type    MessageType     `json:"type"`
+		Details json.RawMessage `json:"details"`
+	}
+	if err := json.Unmarshal(msg.Payload, &e); err != nil {
+		return nil, err
+	}
+	if e.Type != MessageTypePlanning {
+		return nil, nil
+	}
+
+	now := time.Now()
+	switch e.Code {
+	case "item_locked":
+		var lock planning.ItemLock
+		if err := json.Unmarshal(e.Details, &lock); err == nil {
+			return v1Messages(msg.RoomID, v1PlanningAction{Action: planning.ActionLock, ItemID: lock.ItemID, UserID: lock.UserID, Timestamp: now})
+		}
+	case "stale_version":
+		var details struct {
+			Item planning.ItemView `json:"item"`
+		}
+		if err := json.Unmarshal(e.Details, &details); err == nil {
+			return v1ItemUpdate(msg.RoomID, &planning.PlanningAction{ItemID: details.Item.ID, Item: &details.Item, Timestamp: now})
+		}
+	}
+
+	data, err := json.Marshal(map[string]string{"code": e.Code, "message": e.Message})
+	if err != nil {
+		return nil, err
+	}
+	return v1Messages(msg.RoomID, v1PlanningAction{Action: v1ActionError, Data: data, Timestamp: now})
+}
+
+// v1Messages wraps version 1 planning actions in messages.
+func v1Messages(roomID string, actions ...v1PlanningAction) ([]*Message, error) {
+	msgs := make([]*Message, 0, len(actions))
+	for _, a := range actions {
+		payload, err := json.Marshal(a)
+		if err != nil {
+			return nil, err
+		}
+		msgs = append(msgs, &Message{Type: MessageTypePlanning, RoomID: roomID, Payload: payload})
+	}
+	return msgs, nil
+}
+
+func (v1Adapter) inbound(msg *Message, items itemLookup) (*Message, error) {
+	switch msg.Type {
+	case MessageTypeChat, MessageTypeLocation:
+		return msg, nil
+	case MessageTypePlanning:
+		return v1PlanningInbound(msg, items)
+	default:
+		return nil, nil
+	}
+}
+
+// v1PlanningInbound turns a version 1 update, which replaces the whole
+// item, into an update of the item's current version whose merge patch
+// also clears the fields the new item leaves out. Version 1 has no insert,
+// so updating an unknown item inserts it. Other actions, and updates the
+// planning handler will reject anyway, pass unchanged.
+func v1PlanningInbound(msg *Message, items itemLookup) (*Message, error) {
+	var action v1PlanningAction
+	if err := json.Unmarshal(msg.Payload, &action); err != nil || action.Action != planning.ActionUpdate || action.ItemID == "" {
+		return msg, nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), handlerTimeout)
+	defer cancel()
+	current, found, err := items(ctx, msg.RoomID, action.ItemID)
+	if err != nil {
+		return nil, fmt.Errorf("look up item %s: %w", action.ItemID, err)
+	}
+
+	update := planning.PlanningAction{Action: planning.ActionInsert, ItemID: action.ItemID, Data: action.Data}
+	if found {
+		update.Action = planning.ActionUpdate
+		update.BaseVersion = &current.Version
+	}
+
+	// Data that is not an object is left for the handler to reject.
+	if next, ok := decodeJSONObject(action.Data); ok {
+		delete(next, "order_key") // read-only, but sent with every item
+		if found {
+			fields, _ := json.Marshal(current.Fields)
+			prev, _ := decodeJSONObject(fields)
+			next = replacePatch(prev, next)
+		}
+		if update.Data, err = json.Marshal(next); err != nil {
+			return nil, err
+		}
+	}
+
+	payload, err := json.Marshal(&update)
+	if err != nil {
+		return nil, err
+	}
+	return &Message{Type: msg.Type, RoomID: msg.RoomID, Payload: payload}, nil
+}
+
+// replacePatch returns a JSON Merge Patch turning prev into next: members
+// of prev missing from next, at any depth, are set to null.
+func replacePatch(prev, next map[string]any) map[string]any {
+	for name, value := range prev {
+		nv, ok := next[name]
+		if !ok {
+			next[name] = nil
+			continue
+		}
+		pm, ok1 := value.(map[string]any)
+		nm, ok2 := nv.(map[string]any)
+		if ok1 && ok2 {
+			next[name] = replacePatch(pm, nm)
+		}
+	}
+	return next
+}
+
+// decodeJSONObject decodes a JSON object, keeping numbers as written.
+func decodeJSONObject(data []byte) (map[string]any, bool) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var v map[string]any
+	if err := dec.Decode(&v); err != nil || v == nil {
+		return nil, false
+	}
+	return v, true
+}
+
+// rejectVersion completes the handshake only to close the connection with
+// CloseUpgradeRequired, since browsers cannot read the status of a failed
+// handshake.
+func (s *Server) rejectVersion(w http.ResponseWriter, r *http.Request, version int) {
+	conn, err := s.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		log.Printf("WebSocket upgrade failed: %v", err)
+		return
+	}
+	defer conn.Close()
+
+	reason := fmt.Sprintf("protocol version %d is no longer supported; minimum is %d", version, s.minProtocolVersion)
+	_ = conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(CloseUpgradeRequired, reason), time.Now().Add(writeWait))
+}
@@ -0,0 +1,360 @@
+package socket
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/rally-go/rally-realtime/internal/features/planning"
+	"github.com/rally-go/rally-realtime/internal/middleware"
+)
+
+// lockStateMessage returns a planning.lock_state message for event.
+func lockStateMessage(t *testing.T, event planning.LockEvent) []byte {
+	t.Helper()
+
+	payload, _ := json.Marshal(event)
+	data, err := json.Marshal(&Message{Type: MessageTypePlanningLockState, RoomID: "trip", Payload: payload})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+// v1Actions decodes frames produced for a version 1 JSON client.
+func v1Actions(t *testing.T, frames [][]byte) []v1PlanningAction {
+	t.Helper()
+
+	var actions []v1PlanningAction
+	for _, f := range frames {
+		var msg Message
+		if err := json.Unmarshal(f, &msg); err != nil {
+			t.Fatalf("decode %s: %v", f, err)
+		}
+		if msg.Type != MessageTypePlanning || msg.RoomID != "trip" {
+			t.Errorf("version 1 frame = %s %s, want planning in trip", msg.Type, msg.RoomID)
+		}
+		var a v1PlanningAction
+		if err := json.Unmarshal(msg.Payload, &a); err != nil {
+			t.Fatal(err)
+		}
+		actions = append(actions, a)
+	}
+	return actions
+}
+
+func TestV1LockEvents(t *testing.T) {
+	v1 := wire{codec: jsonCodec{}, version: ProtocolV1}
+	now := time.Date(2026, 3, 1, 9, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name  string
+		event planning.LockEvent
+		want  []string // action:item:user
+	}{
+		{name: "locked", event: planning.LockEvent{Event: planning.LockLocked, ItemID: "a", UserID: "alice"}, want: []string{"lock:a:alice"}},
+		{name: "stolen", event: planning.LockEvent{Event: planning.LockStolen, ItemID: "a", UserID: "bob", PreviousUserID: "alice"}, want: []string{"lock:a:bob"}},
+		{name: "unlocked", event: planning.LockEvent{Event: planning.LockUnlocked, ItemID: "a", UserID: "alice"}, want: []string{"unlock:a:alice"}},
+		{name: "expired", event: planning.LockEvent{Event: planning.LockExpired, ItemID: "a", UserID: "alice"}, want: []string{"unlock:a:alice"}},
+		{
+			name: "snapshot",
+			event: planning.LockEvent{Event: planning.LockSnapshot, Locks: []planning.ItemLock{
+				{ItemID: "a", UserID: "alice"},
+				{ItemID: "b", UserID: "bob"},
+			}},
+			want: []string{"lock:a:alice", "lock:b:bob"},
+		},
+		{name: "empty snapshot", event: planning.LockEvent{Event: planning.LockSnapshot}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.event.RoomID = "trip"
+			tt.event.Timestamp = now
+			frames, err := v1.encode(lockStateMessage(t, tt.event))
+			if err != nil {
+				t.Fatalf("encode: %v", err)
+			}
+
+			var got []string
+			for _, a := range v1Actions(t, frames) {
+				got = append(got, a.Action+":"+a.ItemID+":"+a.UserID)
+				if !a.Timestamp.Equal(now) {
+					t.Errorf("timestamp = %v, want %v", a.Timestamp, now)
+				}
+			}
+			if strings.Join(got, " ") != strings.Join(tt.want, " ") {
+				t.Errorf("actions = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestV1Adapter(t *testing.T) {
+	v1 := wire{codec: jsonCodec{}, version: ProtocolV1}
+	message := func(mt MessageType) []byte {
+		data, _ := json.Marshal(&Message{Type: mt, RoomID: "trip", Payload: json.RawMessage(`{"x":1}`)})
+		return data
+	}
+
+	// Chat and location pass both ways; planning is translated (see
+	// TestV1Planning) and newer types are dropped.
+	for _, mt := range []MessageType{MessageTypeChat, MessageTypeLocation} {
+		frames, err := v1.encode(message(mt))
+		if err != nil || len(frames) != 1 || !bytes.Equal(frames[0], message(mt)) {
+			t.Errorf("outbound %s = %q, %v; want it unchanged", mt, frames, err)
+		}
+		msg, err := v1.decode(message(mt), nil)
+		if err != nil || msg == nil || msg.Type != mt {
+			t.Errorf("inbound %s = %+v, %v; want it unchanged", mt, msg, err)
+		}
+	}
+	for _, mt := range []MessageType{MessageTypePoll, MessageTypeError, MessageTypeMention, MessageTypeDirect, MessageTypePlanningConflicts} {
+		if frames, err := v1.encode(message(mt)); err != nil || len(frames) != 0 {
+			t.Errorf("outbound %s = %q, %v; want it dropped", mt, frames, err)
+		}
+		if msg, err := v1.decode(message(mt), nil); err != nil || msg != nil {
+			t.Errorf("inbound %s = %+v, %v; want it dropped", mt, msg, err)
+		}
+	}
+
+	// Batches are separated by newlines for version 1 JSON clients only.
+	frames := [][]byte{message(MessageTypeChat), message(MessageTypeChat)}
+	if got := v1.batch(frames); !bytes.Equal(got, bytes.Join(frames, []byte{'\n'})) {
+		t.Errorf("version 1 batch = %s", got)
+	}
+	if got := currentWire.batch(frames); got[0] != '[' {
+		t.Errorf("version 2 batch = %s, want an array", got)
+	}
+	v1cbor := wire{codec: cborCodec{}, version: ProtocolV1}
+	if got := v1cbor.batch(frames); !bytes.Equal(got, (cborCodec{}).Batch(frames)) {
+		t.Error("version 1 CBOR batch is not a CBOR array")
+	}
+
+	// Binary clients get the adapted messages in their codec.
+	binary, err := wire{codec: msgpackCodec{}, version: ProtocolV1}.encode(lockStateMessage(t, planning.LockEvent{Event: planning.LockLocked, RoomID: "trip", ItemID: "a", UserID: "alice"}))
+	if err != nil || len(binary) != 1 {
+		t.Fatalf("msgpack encode = %d frames, %v", len(binary), err)
+	}
+	msg, err := (msgpackCodec{}).Decode(binary[0])
+	if err != nil || msg.Type != MessageTypePlanning {
+		t.Errorf("msgpack frame = %+v, %v; want a planning action", msg, err)
+	}
+}
+
+func TestV1Planning(t *testing.T) {
+	v1 := wire{codec: jsonCodec{}, version: ProtocolV1}
+	now := time.Date(2026, 3, 1, 9, 0, 0, 0, time.UTC)
+	temple := planning.ItemView{ID: "a", Version: 3, Position: "V", Fields: map[string]json.RawMessage{
+		"title": json.RawMessage(`"Temple"`),
+		"notes": json.RawMessage(`"Closes at 6"`),
+		"place": json.RawMessage(`{"name":"Wat Pho","address":"Sanam Chai Rd"}`),
+	}}
+	items := func(ctx context.Context, roomID, itemID string) (planning.ItemView, bool, error) {
+		if roomID != "trip" {
+			t.Errorf("looked up room %q, want trip", roomID)
+		}
+		return temple, itemID == temple.ID, nil
+	}
+	message := func(mt MessageType, payload string) []byte {
+		data, _ := json.Marshal(&Message{Type: mt, RoomID: "trip", Payload: json.RawMessage(payload)})
+		return data
+	}
+
+	inbound := []struct {
+		name    string
+		payload string
+		want    string // current payload, compared as JSON
+	}{
+		{
+			name:    "update replaces the item",
+			payload: `{"action":"update","item_id":"a","data":{"title":"Wat Pho","place":{"name":"Wat Pho"},"order_key":"V"}}`,
+			want:    `{"action":"update","item_id":"a","user_id":"","base_version":3,"timestamp":"0001-01-01T00:00:00Z","data":{"title":"Wat Pho","notes":null,"place":{"name":"Wat Pho","address":null}}}`,
+		},
+		{
+			name:    "update of an unknown item inserts it",
+			payload: `{"action":"update","item_id":"b","data":{"title":"Market"}}`,
+			want:    `{"action":"insert","item_id":"b","user_id":"","timestamp":"0001-01-01T00:00:00Z","data":{"title":"Market"}}`,
+		},
+		{
+			name:    "data that is not an object",
+			payload: `{"action":"update","item_id":"a","data":"Wat Pho"}`,
+			want:    `{"action":"update","item_id":"a","user_id":"","base_version":3,"timestamp":"0001-01-01T00:00:00Z","data":"Wat Pho"}`,
+		},
+		{name: "lock", payload: `{"action":"lock","item_id":"a"}`, want: `{"action":"lock","item_id":"a"}`},
+	}
+	for _, tt := range inbound {
+		msg, err := v1.decode(message(MessageTypePlanning, tt.payload), items)
+		if err != nil || msg == nil {
+			t.Errorf("%s: inbound = %+v, %v", tt.name, msg, err)
+			continue
+		}
+		var got, want any
+		_ = json.Unmarshal(msg.Payload, &got)
+		_ = json.Unmarshal([]byte(tt.want), &want)
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: inbound payload = %s, want %s", tt.name, msg.Payload, tt.want)
+		}
+	}
+
+	update, _ := json.Marshal(&planning.PlanningAction{Action: planning.ActionMove, ItemID: "a", UserID: "alice", Version: 3, Item: &temple, Timestamp: now})
+	deleted, _ := json.Marshal(&planning.PlanningAction{Action: planning.ActionDelete, ItemID: "a", UserID: "alice", Timestamp: now})
+	locked, _ := json.Marshal(&ErrorPayload{Code: "item_locked", Type: MessageTypePlanning, Details: planning.ItemLock{ItemID: "a", UserID: "alice"}})
+	stale, _ := json.Marshal(&ErrorPayload{Code: "stale_version", Type: MessageTypePlanning, Details: map[string]any{"current_version": 3, "item": temple}})
+	invalid, _ := json.Marshal(&ErrorPayload{Code: "validation_failed", Message: "title: is required", Type: MessageTypePlanning})
+	muted, _ := json.Marshal(&ErrorPayload{Code: "muted", Type: MessageTypeChat})
+
+	outbound := []struct {
+		name string
+		msg  []byte
+		want []string // action:item:user:data
+	}{
+		{
+			name: "edits become updates of the whole item",
+			msg:  message(MessageTypePlanning, string(update)),
+			want: []string{`update:a:alice:{"notes":"Closes at 6","order_key":"V","place":{"name":"Wat Pho","address":"Sanam Chai Rd"},"title":"Temple"}`},
+		},
+		{name: "deletes are dropped", msg: message(MessageTypePlanning, string(deleted))},
+		{name: "item_locked", msg: message(MessageTypeError, string(locked)), want: []string{"lock:a:alice:"}},
+		{
+			name: "stale_version",
+			msg:  message(MessageTypeError, string(stale)),
+			want: []string{`update:a::{"notes":"Closes at 6","order_key":"V","place":{"name":"Wat Pho","address":"Sanam Chai Rd"},"title":"Temple"}`},
+		},
+		{
+			name: "other planning errors",
+			msg:  message(MessageTypeError, string(invalid)),
+			want: []string{`error:::{"code":"validation_failed","message":"title: is required"}`},
+		},
+		{name: "errors about other messages", msg: message(MessageTypeError, string(muted))},
+	}
+	for _, tt := range outbound {
+		frames, err := v1.encode(tt.msg)
+		if err != nil {
+			t.Errorf("%s: encode: %v", tt.name, err)
+			continue
+		}
+		var got []string
+		for _, a := range v1Actions(t, frames) {
+			got = append(got, a.Action+":"+a.ItemID+":"+a.UserID+":"+string(a.Data))
+		}
+		if strings.Join(got, " ") != strings.Join(tt.want, " ") {
+			t.Errorf("%s: actions = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+// readV1 reads from a version 1 client until an action matches want,
+// failing on any message version 1 does not know.
+func readV1(t *testing.T, conn *websocket.Conn, want func(v1PlanningAction) bool) v1PlanningAction {
+	t.Helper()
+
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	defer conn.SetReadDeadline(time.Time{})
+	for {
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			t.Fatalf("read: %v", err)
+		}
+		for _, line := range bytes.Split(data, []byte{'\n'}) {
+			var msg Message
+			if err := json.Unmarshal(line, &msg); err != nil {
+				t.Fatalf("version 1 client got %s: %v", line, err)
+			}
+			if msg.Type != MessageTypeChat && msg.Type != MessageTypeLocation && msg.Type != MessageTypePlanning {
+				t.Fatalf("version 1 client got a %s message", msg.Type)
+			}
+			var a v1PlanningAction
+			_ = json.Unmarshal(msg.Payload, &a)
+			if msg.Type == MessageTypePlanning && want(a) {
+				return a
+			}
+		}
+	}
+}
+
+func TestV1ClientEndToEnd(t *testing.T) {
+	hub := newTestHub(t)
+	srv := newTestServer(t, hub, ServerOptions{AllowQueryToken: true})
+
+	// A version 1 client states no version and passes its token in the
+	// query.
+	old, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http")+"/ws?room_id=trip&token=bob-token", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { old.Close() })
+	action := func(name, itemID, userID string) func(v1PlanningAction) bool {
+		return func(a v1PlanningAction) bool { return a.Action == name && a.ItemID == itemID && a.UserID == userID }
+	}
+
+	alice := dial(t, srv, "alice-token", "trip")
+	send(t, alice, MessageTypePlanning, "trip", map[string]any{"action": planning.ActionInsert, "item_id": "a", "data": map[string]string{"title": "Temple", "notes": "Closes at 6"}})
+	inserted := readV1(t, old, action(planning.ActionUpdate, "a", "alice"))
+	if !strings.Contains(string(inserted.Data), `"title":"Temple"`) {
+		t.Errorf("inserted item = %s, want the whole item", inserted.Data)
+	}
+
+	// Lock changes arrive as actions, and so does the lock an edit runs
+	// into.
+	send(t, alice, MessageTypePlanning, "trip", map[string]any{"action": planning.ActionLock, "item_id": "a"})
+	readV1(t, old, action(planning.ActionLock, "a", "alice"))
+	send(t, old, MessageTypePlanning, "trip", map[string]any{"action": planning.ActionUpdate, "item_id": "a", "data": map[string]string{"title": "Wat Pho"}})
+	readV1(t, old, action(planning.ActionLock, "a", "alice"))
+	send(t, alice, MessageTypePlanning, "trip", map[string]any{"action": planning.ActionUnlock, "item_id": "a"})
+	readV1(t, old, action(planning.ActionUnlock, "a", "alice"))
+
+	// A version 1 update replaces the item with the one the client got,
+	// changed.
+	var item map[string]any
+	if err := json.Unmarshal(inserted.Data, &item); err != nil {
+		t.Fatal(err)
+	}
+	item["title"] = "Wat Pho"
+	delete(item, "notes")
+	send(t, old, MessageTypePlanning, "trip", map[string]any{"action": planning.ActionUpdate, "item_id": "a", "data": item})
+	var updated planning.PlanningAction
+	receive(t, alice, func(msg *Message) bool {
+		return msg.Type == MessageTypePlanning && json.Unmarshal(msg.Payload, &updated) == nil && updated.Action == planning.ActionUpdate
+	})
+	if updated.UserID != "bob" || updated.Item == nil {
+		t.Fatalf("update = %+v, want bob's update with the item", updated)
+	}
+	if got := updated.Item.Fields; string(got["title"]) != `"Wat Pho"` || got["notes"] != nil {
+		t.Errorf("updated fields = %s, want the title only", got)
+	}
+}
+
+func TestMinProtocolVersion(t *testing.T) {
+	hub := newTestHub(t)
+	srv := newTestServer(t, hub, ServerOptions{MinProtocolVersion: ProtocolV2})
+
+	dialer := websocket.Dialer{Subprotocols: []string{"rally.json.v1", middleware.TokenProtocolPrefix + "alice-token"}}
+	conn, _, err := dialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http")+"/ws?room_id=trip", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	_, _, err = conn.ReadMessage()
+	var closeErr *websocket.CloseError
+	if !errors.As(err, &closeErr) || closeErr.Code != CloseUpgradeRequired {
+		t.Fatalf("read error = %v, want close %d", err, CloseUpgradeRequired)
+	}
+	if !strings.Contains(closeErr.Text, "minimum is 2") {
+		t.Errorf("close reason = %q, want the minimum version", closeErr.Text)
+	}
+
+	// Current clients are let in.
+	alice := dial(t, srv, "alice-token", "trip")
+	bob := dial(t, srv, "bob-token", "trip")
+	send(t, alice, MessageTypeChat, "trip", map[string]string{"content": "hello"})
+	receive(t, bob, ofType(MessageTypeChat))
+}
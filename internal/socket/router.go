@@ -1,50 +1,116 @@
 package socket
 
 import (
+	"context"
+	"errors"
 	"log"
 	"net/http"
+	"sync"
+	"time"
 
-	"firebase.google.com/go/v4/auth"
 	"github.com/google/uuid"
 	"github.com/gorilla/websocket"
 	"github.com/rally-go/rally-realtime/internal/middleware"
+	"github.com/rally-go/rally-realtime/internal/rooms"
 )
 
-// Server holds the dependencies for the WebSocket HTTP handler.
+// Server holds the dependencies for the WebSocket and fallback HTTP
+// transport handlers.
 type Server struct {
-	hub          *Hub
-	firebaseAuth *auth.Client
-	upgrader     websocket.Upgrader
+	hub      *Hub
+	verifier middleware.TokenVerifier
+	upgrader websocket.Upgrader
+
+	// Permitted Origin header values; empty allows all
+	allowedOrigins map[string]bool
+
+	// Oldest protocol version accepted; older clients are told to upgrade.
+	minProtocolVersion int
+
+	// Authenticates backend services publishing events; empty disables it
+	serviceSecret []byte
+
+	// Accept WebSocket tokens in the URL, for clients that predate the
+	// subprotocol and first-message forms
+	allowQueryToken bool
+
+	// How long a WebSocket client has to send its auth message
+	authTimeout time.Duration
+
+	// Clients on HTTP transports by session ID, for POST /send and polls
+	sessions   map[string]*Client
+	sessionsMu sync.Mutex
 }
 
-// NewServer creates a Server. allowedOrigins is the list of permitted Origin
-// header values; if empty, all origins are allowed (suitable for development).
-func NewServer(hub *Hub, firebaseAuth *auth.Client, allowedOrigins []string) *Server {
-	allowedSet := make(map[string]bool, len(allowedOrigins))
-	for _, o := range allowedOrigins {
+// ServerOptions configures a Server.
+type ServerOptions struct {
+	// Permitted Origin header values; if empty, all origins are allowed
+	// (suitable for development).
+	AllowedOrigins []string
+
+	// Clients speaking a protocol version below this are closed with
+	// CloseUpgradeRequired.
+	MinProtocolVersion int
+
+	// Shared secret that backend services sign POST /rooms/{id}/events
+	// requests with; if empty, the endpoint is disabled.
+	ServiceSecret string
+
+	// Accept the token in the "token" query parameter of WebSocket
+	// requests. Tokens in URLs end up in proxy and access logs; newer
+	// clients send them in a subprotocol or their first message. It covers
+	// /ws only: SSE, long polling and the itinerary exports always accept
+	// the parameter, since EventSource and calendar apps cannot send
+	// headers.
+	AllowQueryToken bool
+}
+
+// NewServer creates a Server.
+func NewServer(hub *Hub, verifier middleware.TokenVerifier, opts ServerOptions) *Server {
+	allowedSet := make(map[string]bool, len(opts.AllowedOrigins))
+	for _, o := range opts.AllowedOrigins {
 		allowedSet[o] = true
 	}
 
-	return &Server{
-		hub:          hub,
-		firebaseAuth: firebaseAuth,
-		upgrader: websocket.Upgrader{
-			ReadBufferSize:  1024,
-			WriteBufferSize: 1024,
-			CheckOrigin: func(r *http.Request) bool {
-				if len(allowedOrigins) == 0 {
-					return true // development: allow all origins
-				}
-				return allowedSet[r.Header.Get("Origin")]
-			},
-		},
+	s := &Server{
+		hub:                hub,
+		verifier:           verifier,
+		allowedOrigins:     allowedSet,
+		minProtocolVersion: opts.MinProtocolVersion,
+		serviceSecret:      []byte(opts.ServiceSecret),
+		allowQueryToken:    opts.AllowQueryToken,
+		authTimeout:        authTimeout,
+		sessions:           make(map[string]*Client),
+	}
+	s.upgrader = websocket.Upgrader{
+		ReadBufferSize:  1024,
+		WriteBufferSize: 1024,
+		Subprotocols:    subprotocols,
+		// Negotiate permessage-deflate; see compressionThreshold.
+		EnableCompression: true,
+		CheckOrigin:       s.checkOrigin,
 	}
+	return s
+}
+
+// checkOrigin reports whether requests from the request's Origin are
+// allowed.
+func (s *Server) checkOrigin(r *http.Request) bool {
+	if len(s.allowedOrigins) == 0 {
+		return true // development: allow all origins
+	}
+	return s.allowedOrigins[r.Header.Get("Origin")]
 }
 
 // ServeWs handles WebSocket upgrade requests.
 // The client must supply:
 //   - room_id  — the room to join
-//   - token    — a valid Firebase ID token (user_id is derived from the token)
+//   - a valid ID token (user_id is derived from the token), in a
+//     "rally.auth.<token>" subprotocol entry, in an auth message sent
+//     first after the upgrade, or, if allowed, in the token parameter
+//
+// and may state its protocol version and codec in the subprotocol, or the
+// version alone in v.
 func (s *Server) ServeWs(w http.ResponseWriter, r *http.Request) {
 	roomID := r.URL.Query().Get("room_id")
 	if roomID == "" {
@@ -52,13 +118,29 @@ func (s *Server) ServeWs(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Authenticate before upgrading; browsers cannot send auth headers for WS.
-	userID, err := middleware.VerifyWSToken(s.firebaseAuth, r)
+	wire, err := negotiateWire(r)
 	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if wire.version < s.minProtocolVersion {
+		log.Printf("Rejected client on protocol version %d", wire.version)
+		s.rejectVersion(w, r, wire.version)
+		return
+	}
+
+	// Authenticate before upgrading if the token came with the request;
+	// browsers cannot send auth headers for WS. Otherwise the client sends
+	// it in its first message.
+	ident, err := middleware.VerifyWSToken(s.verifier, r, s.allowQueryToken)
+	if err != nil && !errors.Is(err, middleware.ErrNoToken) {
 		log.Printf("WebSocket auth failed: %v", err)
 		http.Error(w, "Unauthorized", http.StatusUnauthorized)
 		return
 	}
+	if ident != nil && !s.admit(w, r, roomID, ident) {
+		return
+	}
 
 	conn, err := s.upgrader.Upgrade(w, r, nil)
 	if err != nil {
@@ -66,13 +148,84 @@ func (s *Server) ServeWs(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	clientID := uuid.New().String()
-	client := NewClient(clientID, userID, roomID, s.hub, conn)
+	transport := newWSTransport(conn, wire)
+	if ident == nil {
+		if ident = s.authenticateFirstMessage(transport, roomID); ident == nil {
+			return
+		}
+	}
 
-	s.hub.Register <- client
+	clientID := uuid.New().String()
+	client := NewClient(clientID, ident.UserID, roomID, s.hub, transport)
+	client.wire = wire
+	s.attach(client, ident)
 
 	go client.WritePump()
-	go client.ReadPump()
+	go transport.readPump(client)
+}
+
+// errForbidden is returned by join when the user may not join the room.
+var errForbidden = errors.New("forbidden")
+
+// admit checks that the user may join roomID, whatever the transport, and
+// records the membership. It writes the error response itself and returns
+// false if the user is turned away.
+func (s *Server) admit(w http.ResponseWriter, r *http.Request, roomID string, ident *middleware.Identity) bool {
+	ctx, cancel := context.WithTimeout(r.Context(), handlerTimeout)
+	defer cancel()
+
+	switch err := s.join(ctx, roomID, ident); {
+	case errors.Is(err, errForbidden):
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return false
+	case err != nil:
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return false
+	}
+	return true
+}
+
+// join checks that the user may join roomID and records the membership
+// along with any role the token grants. It returns errForbidden if the
+// user is turned away.
+func (s *Server) join(ctx context.Context, roomID string, ident *middleware.Identity) error {
+	userID := ident.UserID
+	if !rooms.CanAccess(roomID, userID) {
+		log.Printf("Rejected user %s from direct room %s", userID, roomID)
+		return errForbidden
+	}
+
+	banned, err := s.hub.Moderation.IsBanned(ctx, roomID, userID)
+	if err != nil {
+		log.Printf("Failed to check ban: user=%s room=%s: %v", userID, roomID, err)
+		return err
+	}
+	if banned {
+		log.Printf("Rejected banned user %s from room %s", userID, roomID)
+		return errForbidden
+	}
+
+	if err := s.hub.Members.AddMember(ctx, roomID, userID); err != nil {
+		log.Printf("Failed to record membership: user=%s room=%s: %v", userID, roomID, err)
+		return err
+	}
+	if err := s.hub.applyRoleClaim(ctx, roomID, ident); err != nil {
+		log.Printf("Failed to record role: user=%s room=%s: %v", userID, roomID, err)
+		return err
+	}
+	return nil
+}
+
+// attach registers an admitted client with the hub and sends it the
+// room's current state. The client must present a new token before the
+// one it authenticated with expires; see tokens.go.
+func (s *Server) attach(client *Client, ident *middleware.Identity) {
+	client.token = newTokenLease(ident, s.verifier)
+
+	s.hub.connected(client)
+	s.hub.Register <- client
+	s.hub.SendLocks(client)
+	client.token.start(client)
 
-	log.Printf("New WebSocket connection: client=%s user=%s room=%s", clientID, userID, roomID)
+	log.Printf("New %s connection: client=%s user=%s room=%s", client.transport.Name(), client.ID, client.UserID, client.RoomID)
 }
@@ -0,0 +1,113 @@
+package socket
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// sseTransport streams frames to the peer as server-sent events, one
+// message per event.
+type sseTransport struct {
+	w  http.ResponseWriter
+	rc *http.ResponseController
+
+	// Closed by Close so that the handler can return
+	done chan struct{}
+}
+
+func newSSETransport(w http.ResponseWriter) *sseTransport {
+	return &sseTransport{w: w, rc: http.NewResponseController(w), done: make(chan struct{})}
+}
+
+func (t *sseTransport) Name() string { return "sse" }
+
+// event writes one event and flushes it to the peer.
+func (t *sseTransport) event(name string, data []byte) error {
+	t.rc.SetWriteDeadline(time.Now().Add(writeWait))
+	if name != "" {
+		if _, err := fmt.Fprintf(t.w, "event: %s\n", name); err != nil {
+			return err
+		}
+	}
+	// Frames are compact JSON, so they never span lines.
+	if _, err := fmt.Fprintf(t.w, "data: %s\n\n", data); err != nil {
+		return err
+	}
+	return t.rc.Flush()
+}
+
+func (t *sseTransport) Send(frames [][]byte) error {
+	for _, f := range frames {
+		if err := t.event("", f); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Ping writes a comment line, which EventSource ignores.
+func (t *sseTransport) Ping() error {
+	t.rc.SetWriteDeadline(time.Now().Add(writeWait))
+	if _, err := fmt.Fprint(t.w, ": ping\n\n"); err != nil {
+		return err
+	}
+	return t.rc.Flush()
+}
+
+func (t *sseTransport) Close(code int, reason string) {
+	if code != 0 {
+		_ = t.event("close", mustJSON(closeEvent{Code: code, Reason: reason}))
+	}
+	close(t.done)
+}
+
+// ServeSSE streams a room's messages as server-sent events at
+// GET /sse?room_id=<room>&token=<token>. The first event, "session", names
+// the session to pass to POST /send. Messages follow as unnamed events
+// carrying the same JSON as WebSocket frames; a final "close" event carries
+// the close code and reason when the server ends the session.
+func (s *Server) ServeSSE(w http.ResponseWriter, r *http.Request) {
+	if !s.allowCORS(w, r) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+	if preflight(w, r, http.MethodGet) {
+		return
+	}
+
+	ident, roomID, proto, ok := s.openSession(w, r)
+	if !ok {
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no") // disable proxy buffering
+	w.WriteHeader(http.StatusOK)
+
+	transport := newSSETransport(w)
+	client := NewClient(uuid.New().String(), ident.UserID, roomID, s.hub, transport)
+	client.wire = proto
+	if err := transport.event("session", mustJSON(sessionEvent{SessionID: client.ID})); err != nil {
+		return
+	}
+
+	s.addSession(client)
+	defer s.removeSession(client.ID)
+	s.attach(client, ident)
+
+	go client.WritePump()
+
+	select {
+	case <-r.Context().Done():
+		// The peer went away; unregistering closes Send, which ends the
+		// write pump.
+		s.hub.Unregister <- client
+		<-transport.done
+	case <-transport.done:
+		s.hub.Unregister <- client
+	}
+}
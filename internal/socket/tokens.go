@@ -0,0 +1,236 @@
+package socket
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/rally-go/rally-realtime/internal/middleware"
+	"github.com/rally-go/rally-realtime/internal/rooms"
+)
+
+// Clients authenticate with an ID token, sent with the upgrade request or,
+// over WebSocket, in an auth message within authTimeout of connecting. For
+// Firebase the token expires after an hour. To keep the connection open
+// past that they send a new token in an auth.refresh message. The server
+// reminds them with auth.expiring shortly before, and closes the
+// connection with CloseTokenExpired once the token lapses or a refresh is
+// rejected.
+
+// refreshLead is how long before the token expires the client is reminded
+// to refresh it. Firebase SDKs fetch a new token five minutes before expiry.
+const refreshLead = 5 * time.Minute
+
+// authTimeout is how long a WebSocket client whose upgrade request carried
+// no token has to send it in an auth message.
+const authTimeout = 10 * time.Second
+
+// AuthPayload is the payload of auth, auth.refresh and auth.expiring
+// messages. Clients send the token; the server answers with the expiry.
+type AuthPayload struct {
+	Token     string     `json:"token,omitempty"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+}
+
+// tokenLease tracks when a client's token expires and enforces it, and
+// holds the room roles the token grants.
+type tokenLease struct {
+	verifier middleware.TokenVerifier
+
+	expiresAt time.Time
+	roles     map[string]rooms.Role
+	warn      *time.Timer // sends auth.expiring
+	expire    *time.Timer // closes the connection
+	stopped   bool
+	mu        sync.Mutex
+}
+
+func newTokenLease(ident *middleware.Identity, verifier middleware.TokenVerifier) *tokenLease {
+	return &tokenLease{expiresAt: ident.ExpiresAt, roles: claimedRoles(ident), verifier: verifier}
+}
+
+// start arms the timers for client, which must be registered.
+func (l *tokenLease) start(c *Client) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.armLocked(c)
+}
+
+// renew extends the lease to a refreshed token's expiry and roles.
+func (l *tokenLease) renew(c *Client, ident *middleware.Identity) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.expiresAt = ident.ExpiresAt
+	l.roles = claimedRoles(ident)
+	l.armLocked(c)
+}
+
+// role returns the role the token grants in roomID, if any.
+func (l *tokenLease) role(roomID string) (rooms.Role, bool) {
+	if l == nil {
+		return rooms.RoleNone, false
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	role, ok := l.roles[roomID]
+	return role, ok
+}
+
+func (l *tokenLease) armLocked(c *Client) {
+	if l.stopped {
+		return
+	}
+	l.stopTimersLocked()
+
+	expiresAt := l.expiresAt
+	l.warn = time.AfterFunc(time.Until(expiresAt.Add(-refreshLead)), func() {
+		c.Hub.sendAuthState(c, MessageTypeAuthExpiring, expiresAt)
+	})
+	l.expire = time.AfterFunc(time.Until(expiresAt), func() {
+		log.Printf("Token expired: client=%s user=%s", c.ID, c.UserID)
+		c.Hub.control <- &controlMessage{Action: controlClose, client: c, CloseCode: CloseTokenExpired, Reason: "token expired"}
+	})
+}
+
+// stop disarms the timers once the connection has closed.
+func (l *tokenLease) stop() {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.stopped = true
+	l.stopTimersLocked()
+}
+
+func (l *tokenLease) stopTimersLocked() {
+	if l.warn != nil {
+		l.warn.Stop()
+		l.expire.Stop()
+	}
+}
+
+// authenticateFirstMessage reads the auth message a WebSocket client must
+// send first when its upgrade request carried no token, verifies the token
+// and admits the user to roomID, answering with the token's expiry. On
+// failure it closes the connection and returns nil.
+func (s *Server) authenticateFirstMessage(t *wsTransport, roomID string) *middleware.Identity {
+	t.conn.SetReadLimit(maxMessageSize)
+	t.conn.SetReadDeadline(time.Now().Add(s.authTimeout))
+	_, data, err := t.conn.ReadMessage()
+	if err != nil {
+		log.Printf("WebSocket auth message not received: %v", err)
+		t.Close(CloseTokenExpired, "authentication required")
+		return nil
+	}
+
+	// Decoded with the codec alone, as version adapters drop message types
+	// their version does not know.
+	var req AuthPayload
+	msg, err := t.wire.codec.Decode(data)
+	if err != nil || msg.Type != MessageTypeAuth || json.Unmarshal(msg.Payload, &req) != nil || req.Token == "" {
+		log.Printf("WebSocket auth failed: first message is not an auth message with a token")
+		t.Close(CloseTokenExpired, "first message must be auth with a token")
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), handlerTimeout)
+	defer cancel()
+
+	ident, err := s.verifier.Verify(ctx, req.Token)
+	switch {
+	case errors.Is(err, middleware.ErrTokenRejected):
+		log.Printf("WebSocket auth failed: %v", err)
+		t.Close(CloseTokenExpired, "token rejected")
+		return nil
+	case err != nil:
+		log.Printf("WebSocket auth failed: %v", err)
+		t.Close(websocket.CloseInternalServerErr, "failed to verify token")
+		return nil
+	}
+
+	switch err := s.join(ctx, roomID, ident); {
+	case errors.Is(err, errForbidden):
+		t.Close(CloseForbidden, "forbidden")
+		return nil
+	case err != nil:
+		t.Close(websocket.CloseInternalServerErr, "internal error")
+		return nil
+	}
+
+	// Answer before the room's state follows; nothing else writes to the
+	// connection yet.
+	reply, err := json.Marshal(AuthPayload{ExpiresAt: &ident.ExpiresAt})
+	if err == nil {
+		data, err = json.Marshal(&Message{Type: MessageTypeAuth, RoomID: roomID, Payload: reply})
+	}
+	var frames [][]byte
+	if err == nil {
+		frames, err = t.wire.encode(data)
+	}
+	if err == nil && len(frames) > 0 {
+		err = t.Send(frames)
+	}
+	if err != nil {
+		log.Printf("Failed to answer auth message: %v", err)
+		t.Close(websocket.CloseInternalServerErr, "internal error")
+		return nil
+	}
+	return ident
+}
+
+// refreshToken handles an auth.refresh message. A token that fails
+// verification closes the connection; if the identity provider cannot be
+// reached, the client gets an error and may retry until the current token
+// expires.
+func (c *Client) refreshToken(payload json.RawMessage) {
+	var req AuthPayload
+	if err := json.Unmarshal(payload, &req); err != nil || req.Token == "" {
+		c.Hub.sendError(c, c.RoomID, MessageTypeAuthRefresh, ErrCodeInvalidPayload, "token is required", nil)
+		return
+	}
+	if c.token == nil {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), handlerTimeout)
+	defer cancel()
+
+	ident, err := c.token.verifier.VerifyRefresh(ctx, req.Token)
+	reason := ""
+	switch {
+	case errors.Is(err, middleware.ErrTokenRejected):
+		reason = "token refresh rejected"
+	case err != nil:
+		log.Printf("Token refresh failed: client=%s user=%s: %v", c.ID, c.UserID, err)
+		c.Hub.sendError(c, c.RoomID, MessageTypeAuthRefresh, ErrCodeInternal, "failed to verify token", nil)
+		return
+	case ident.UserID != c.UserID:
+		reason = "token belongs to another user"
+	}
+	if reason != "" {
+		log.Printf("Token refresh rejected: client=%s user=%s: %s (%v)", c.ID, c.UserID, reason, err)
+		c.Hub.control <- &controlMessage{Action: controlClose, client: c, CloseCode: CloseTokenExpired, Reason: reason}
+		return
+	}
+
+	if err := c.Hub.applyRoleClaim(ctx, c.RoomID, ident); err != nil {
+		log.Printf("Failed to record role: user=%s room=%s: %v", c.UserID, c.RoomID, err)
+	}
+	c.token.renew(c, ident)
+	c.Hub.sendAuthState(c, MessageTypeAuthRefresh, ident.ExpiresAt)
+}
+
+// sendAuthState tells a client when its token expires.
+func (h *Hub) sendAuthState(client *Client, typ MessageType, expiresAt time.Time) {
+	payload, err := json.Marshal(AuthPayload{ExpiresAt: &expiresAt})
+	if err != nil {
+		log.Printf("Failed to marshal auth payload: %v", err)
+		return
+	}
+	h.sendToClientMessage(client, &Message{Type: typ, RoomID: client.RoomID, Payload: payload})
+}
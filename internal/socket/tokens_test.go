@@ -0,0 +1,195 @@
+package socket
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/rally-go/rally-realtime/internal/middleware"
+)
+
+// newLeaseServer serves hub's WebSocket transport, accepting testTokens
+// for ttl.
+func newLeaseServer(t *testing.T, hub *Hub, ttl time.Duration) *httptest.Server {
+	t.Helper()
+	return serveWs(t, NewServer(hub, middleware.NewStaticVerifier(testTokens, ttl), ServerOptions{}))
+}
+
+// serveWs serves the WebSocket transport of s.
+func serveWs(t *testing.T, s *Server) *httptest.Server {
+	t.Helper()
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/ws", s.ServeWs)
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+// authState decodes the payload of an auth, auth.refresh or auth.expiring
+// message.
+func authState(t *testing.T, msg *Message) AuthPayload {
+	t.Helper()
+
+	var p AuthPayload
+	if err := json.Unmarshal(msg.Payload, &p); err != nil || p.ExpiresAt == nil {
+		t.Fatalf("%s payload = %s, want an expiry", msg.Type, msg.Payload)
+	}
+	return p
+}
+
+// waitClose reads from conn until the server closes it, and returns the
+// close frame.
+func waitClose(t *testing.T, conn *websocket.Conn, timeout time.Duration) *websocket.CloseError {
+	t.Helper()
+
+	conn.SetReadDeadline(time.Now().Add(timeout))
+	for {
+		_, _, err := conn.ReadMessage()
+		if err == nil {
+			continue
+		}
+		var closeErr *websocket.CloseError
+		if !errors.As(err, &closeErr) {
+			t.Fatalf("read error = %v, want a close frame", err)
+		}
+		return closeErr
+	}
+}
+
+func TestTokenExpiry(t *testing.T) {
+	const ttl = 500 * time.Millisecond
+	srv := newLeaseServer(t, newTestHub(t), ttl)
+
+	start := time.Now()
+	conn := dial(t, srv, "alice-token", "trip")
+
+	// The token lasts less than refreshLead, so the reminder comes at once.
+	expiring := authState(t, receive(t, conn, ofType(MessageTypeAuthExpiring)))
+	if time.Since(start) >= ttl {
+		t.Errorf("auth.expiring arrived after the token expired")
+	}
+	if d := expiring.ExpiresAt.Sub(start); d <= 0 || d > ttl+100*time.Millisecond {
+		t.Errorf("auth.expiring expires_at is %v after connecting, want about %v", d, ttl)
+	}
+
+	closeErr := waitClose(t, conn, 2*time.Second)
+	if closeErr.Code != CloseTokenExpired {
+		t.Errorf("close code = %d, want %d", closeErr.Code, CloseTokenExpired)
+	}
+	if elapsed := time.Since(start); elapsed < ttl {
+		t.Errorf("closed %v after connecting, before the token expired", elapsed)
+	}
+}
+
+func TestTokenRefresh(t *testing.T) {
+	const ttl = 600 * time.Millisecond
+	srv := newLeaseServer(t, newTestHub(t), ttl)
+
+	start := time.Now()
+	conn := dial(t, srv, "alice-token", "trip")
+	first := authState(t, receive(t, conn, ofType(MessageTypeAuthExpiring)))
+
+	time.Sleep(ttl / 2)
+	send(t, conn, MessageTypeAuthRefresh, "", AuthPayload{Token: "alice-token"})
+	renewed := authState(t, receive(t, conn, ofType(MessageTypeAuthRefresh)))
+	if !renewed.ExpiresAt.After(*first.ExpiresAt) {
+		t.Fatalf("refreshed expires_at = %v, want after %v", renewed.ExpiresAt, first.ExpiresAt)
+	}
+
+	// The connection outlives the first token and closes with the second.
+	closeErr := waitClose(t, conn, 2*time.Second)
+	if closeErr.Code != CloseTokenExpired {
+		t.Errorf("close code = %d, want %d", closeErr.Code, CloseTokenExpired)
+	}
+	if elapsed := time.Since(start); elapsed < ttl+ttl/4 {
+		t.Errorf("closed %v after connecting, want the refreshed token to last longer", elapsed)
+	}
+}
+
+func TestTokenRefreshForAnotherUser(t *testing.T) {
+	srv := newLeaseServer(t, newTestHub(t), time.Hour)
+	conn := dial(t, srv, "alice-token", "trip")
+
+	send(t, conn, MessageTypeAuthRefresh, "", AuthPayload{Token: "bob-token"})
+	closeErr := waitClose(t, conn, 2*time.Second)
+	if closeErr.Code != CloseTokenExpired || closeErr.Text != "token belongs to another user" {
+		t.Errorf("close = %d %q, want %d for another user's token", closeErr.Code, closeErr.Text, CloseTokenExpired)
+	}
+}
+
+func TestFirstMessageAuth(t *testing.T) {
+	s := NewServer(newTestHub(t), middleware.NewStaticVerifier(testTokens, time.Hour), ServerOptions{})
+	s.authTimeout = 200 * time.Millisecond
+	srv := serveWs(t, s)
+
+	// connect opens a connection without a token, except in the query,
+	// which the server does not accept by default.
+	connect := func(t *testing.T, query string) *websocket.Conn {
+		t.Helper()
+		dialer := websocket.Dialer{Subprotocols: []string{subprotocol(jsonCodec{}, CurrentProtocolVersion)}}
+		conn, _, err := dialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http")+"/ws?room_id=trip"+query, nil)
+		if err != nil {
+			t.Fatalf("dial: %v", err)
+		}
+		t.Cleanup(func() { conn.Close() })
+		return conn
+	}
+
+	t.Run("valid", func(t *testing.T) {
+		conn := connect(t, "")
+		send(t, conn, MessageTypeAuth, "", AuthPayload{Token: "alice-token"})
+		reply := receive(t, conn, ofType(MessageTypeAuth))
+		if state := authState(t, reply); time.Until(*state.ExpiresAt) <= 0 || reply.RoomID != "trip" {
+			t.Errorf("auth reply = %s %s, want the room and a future expiry", reply.RoomID, reply.Payload)
+		}
+		// The room's state follows.
+		receive(t, conn, ofType(MessageTypePlanningLockState))
+	})
+
+	rejected := []struct {
+		name   string
+		query  string
+		first  func(t *testing.T, conn *websocket.Conn)
+		reason string
+	}{
+		{
+			name: "not an auth message",
+			first: func(t *testing.T, conn *websocket.Conn) {
+				send(t, conn, MessageTypeChat, "", map[string]string{"content": "hi"})
+			},
+			reason: "first message must be auth with a token",
+		},
+		{
+			name:   "no token",
+			first:  func(t *testing.T, conn *websocket.Conn) { send(t, conn, MessageTypeAuth, "", AuthPayload{}) },
+			reason: "first message must be auth with a token",
+		},
+		{
+			name: "invalid token",
+			first: func(t *testing.T, conn *websocket.Conn) {
+				send(t, conn, MessageTypeAuth, "", AuthPayload{Token: "mallory-token"})
+			},
+			reason: "token rejected",
+		},
+		{name: "timeout", reason: "authentication required"},
+		{name: "query token", query: "&token=alice-token", reason: "authentication required"},
+	}
+	for _, tt := range rejected {
+		t.Run(tt.name, func(t *testing.T) {
+			conn := connect(t, tt.query)
+			if tt.first != nil {
+				tt.first(t, conn)
+			}
+			closeErr := waitClose(t, conn, 2*time.Second)
+			if closeErr.Code != CloseTokenExpired || closeErr.Text != tt.reason {
+				t.Errorf("close = %d %q, want %d %q", closeErr.Code, closeErr.Text, CloseTokenExpired, tt.reason)
+			}
+		})
+	}
+}
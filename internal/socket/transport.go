@@ -0,0 +1,26 @@
+package socket
+
+// Transport carries a client's frames to the peer. Every transport shares
+// the hub, authentication and routing; they differ only in how frames
+// reach the peer and how its messages come back:
+//
+//   - websocket: one full-duplex connection (see ServeWs)
+//   - sse: server-sent events down, POST /send up (see ServeSSE)
+//   - longpoll: GET /poll down, POST /send up (see ServePoll)
+type Transport interface {
+	// Name identifies the transport in logs.
+	Name() string
+
+	// Send delivers messages, each encoded for the client's wire, in
+	// order. Several messages may be batched as the transport sees fit.
+	Send(frames [][]byte) error
+
+	// Ping is called periodically while the client is idle. It keeps the
+	// connection open through proxies and returns an error once the peer
+	// is gone.
+	Ping() error
+
+	// Close ends the connection. code is the close code to report to the
+	// peer, or 0 if the connection failed and the peer cannot be told.
+	Close(code int, reason string)
+}
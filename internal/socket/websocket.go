@@ -0,0 +1,77 @@
+package socket
+
+import (
+	"log"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// compressionThreshold is the size below which frames are sent uncompressed
+// even when the peer negotiated permessage-deflate, as deflating them saves
+// little and costs CPU.
+const compressionThreshold = 512
+
+// wsTransport carries frames over a WebSocket connection.
+type wsTransport struct {
+	conn *websocket.Conn
+	wire wire
+}
+
+func newWSTransport(conn *websocket.Conn, w wire) *wsTransport {
+	return &wsTransport{conn: conn, wire: w}
+}
+
+func (t *wsTransport) Name() string { return "websocket" }
+
+// Send writes one message, or several as a batch, in a single frame.
+func (t *wsTransport) Send(frames [][]byte) error {
+	data := frames[0]
+	if len(frames) > 1 {
+		data = t.wire.batch(frames)
+	}
+
+	t.conn.SetWriteDeadline(time.Now().Add(writeWait))
+	t.conn.EnableWriteCompression(len(data) >= compressionThreshold)
+	return t.conn.WriteMessage(t.wire.codec.FrameType(), data)
+}
+
+func (t *wsTransport) Ping() error {
+	t.conn.SetWriteDeadline(time.Now().Add(writeWait))
+	return t.conn.WriteMessage(websocket.PingMessage, nil)
+}
+
+func (t *wsTransport) Close(code int, reason string) {
+	if code != 0 {
+		t.conn.SetWriteDeadline(time.Now().Add(writeWait))
+		t.conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(code, reason))
+	}
+	t.conn.Close()
+}
+
+// readPump pumps messages from the WebSocket connection to the hub.
+func (t *wsTransport) readPump(c *Client) {
+	defer func() {
+		c.Hub.Unregister <- c
+		t.conn.Close()
+	}()
+
+	t.conn.SetReadLimit(maxMessageSize)
+	t.conn.SetReadDeadline(time.Now().Add(pongWait))
+	t.conn.SetPongHandler(func(string) error {
+		t.conn.SetReadDeadline(time.Now().Add(pongWait))
+		return nil
+	})
+
+	for {
+		_, message, err := t.conn.ReadMessage()
+		if err != nil {
+			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure) {
+				log.Printf("WebSocket error: %v", err)
+			}
+			break
+		}
+
+		c.receive(message)
+	}
+}
@@ -0,0 +1,355 @@
+package webhooks
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/rally-go/rally-realtime/internal/signature"
+)
+
+// Header names set on webhook requests besides the signature headers.
+const (
+	EventHeader    = "X-Rally-Event"
+	DeliveryHeader = "X-Rally-Delivery"
+)
+
+// storeTimeout bounds writes to the delivery log and dead letters.
+const storeTimeout = 5 * time.Second
+
+// Options tunes a Dispatcher. Zero values take the defaults noted.
+type Options struct {
+	// Client sends the requests. Default: a client with a 10 second
+	// timeout.
+	Client *http.Client
+	// Workers is the number of deliveries made concurrently. Default: 4.
+	Workers int
+	// QueueSize is the number of deliveries waiting for a worker beyond
+	// which new ones are dead-lettered. Default: 1024.
+	QueueSize int
+	// MaxAttempts is the number of tries before a delivery is
+	// dead-lettered. Default: 8.
+	MaxAttempts int
+	// MinBackoff and MaxBackoff bound the wait before a retry, which
+	// doubles with every attempt. Defaults: 1 second and 10 minutes.
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+}
+
+func (o *Options) setDefaults() {
+	if o.Client == nil {
+		o.Client = &http.Client{Timeout: 10 * time.Second}
+	}
+	if o.Workers <= 0 {
+		o.Workers = 4
+	}
+	if o.QueueSize <= 0 {
+		o.QueueSize = 1024
+	}
+	if o.MaxAttempts <= 0 {
+		o.MaxAttempts = 8
+	}
+	if o.MinBackoff <= 0 {
+		o.MinBackoff = time.Second
+	}
+	if o.MaxBackoff <= 0 {
+		o.MaxBackoff = 10 * time.Minute
+	}
+}
+
+// Dispatcher delivers events to the subscriptions that want them. Events
+// are dispatched by the instance that handled the client message, so each
+// is delivered once across the cluster. Deliveries waiting for a retry are
+// held in memory.
+type Dispatcher struct {
+	subs  map[string]*Subscription
+	store Store
+	opts  Options
+
+	queue   chan *Delivery
+	workers sync.WaitGroup
+
+	// Deliveries waiting for a retry, by delivery ID
+	retries map[string]*retry
+	closed  bool
+	mu      sync.Mutex
+}
+
+type retry struct {
+	delivery *Delivery
+	timer    *time.Timer
+}
+
+// NewDispatcher creates a dispatcher for the given subscriptions and starts
+// its workers.
+func NewDispatcher(subs []Subscription, store Store, opts Options) *Dispatcher {
+	opts.setDefaults()
+
+	d := &Dispatcher{
+		subs:    make(map[string]*Subscription, len(subs)),
+		store:   store,
+		opts:    opts,
+		queue:   make(chan *Delivery, opts.QueueSize),
+		retries: make(map[string]*retry),
+	}
+	for i := range subs {
+		d.subs[subs[i].ID] = &subs[i]
+	}
+
+	for range opts.Workers {
+		d.workers.Add(1)
+		go d.work()
+	}
+	return d
+}
+
+// Dispatch queues an event of eventType in roomID for every subscription
+// that wants it. data is marshalled as the event's data. It never blocks.
+func (d *Dispatcher) Dispatch(eventType, roomID string, data any) {
+	var matched []*Subscription
+	for _, sub := range d.subs {
+		if sub.Matches(eventType, roomID) {
+			matched = append(matched, sub)
+		}
+	}
+	if len(matched) == 0 {
+		return
+	}
+
+	raw, err := json.Marshal(data)
+	if err != nil {
+		log.Printf("Failed to marshal %s webhook event: %v", eventType, err)
+		return
+	}
+	event := &Event{
+		ID:        uuid.New().String(),
+		Type:      eventType,
+		RoomID:    roomID,
+		Data:      raw,
+		Timestamp: time.Now(),
+	}
+
+	for _, sub := range matched {
+		d.enqueue(&Delivery{
+			ID:             uuid.New().String(),
+			SubscriptionID: sub.ID,
+			Event:          event,
+			CreatedAt:      event.Timestamp,
+		})
+	}
+}
+
+// enqueue hands a delivery to the workers, or dead-letters it if they
+// cannot keep up or the dispatcher is closed.
+func (d *Dispatcher) enqueue(del *Delivery) {
+	d.mu.Lock()
+	if d.closed {
+		d.mu.Unlock()
+		del.LastError = "dispatcher closed"
+		d.deadLetter(del)
+		return
+	}
+	select {
+	case d.queue <- del:
+		d.mu.Unlock()
+	default:
+		d.mu.Unlock()
+		del.LastError = "delivery queue full"
+		d.deadLetter(del)
+	}
+}
+
+func (d *Dispatcher) work() {
+	defer d.workers.Done()
+	for del := range d.queue {
+		d.attempt(del)
+	}
+}
+
+// attempt makes one try at a delivery and logs it, then schedules a retry
+// or dead-letters the delivery if it failed.
+func (d *Dispatcher) attempt(del *Delivery) {
+	sub := d.subs[del.SubscriptionID]
+	del.Attempts++
+
+	start := time.Now()
+	resp, err := d.send(sub, del)
+	a := &Attempt{
+		DeliveryID:     del.ID,
+		SubscriptionID: sub.ID,
+		EventID:        del.Event.ID,
+		EventType:      del.Event.Type,
+		Attempt:        del.Attempts,
+		Duration:       time.Since(start),
+		Timestamp:      start,
+	}
+	if err != nil {
+		a.Error = err.Error()
+	} else {
+		a.StatusCode = resp.StatusCode
+		if !a.Succeeded() {
+			a.Error = resp.Status
+		}
+	}
+	d.logAttempt(a)
+	if a.Succeeded() {
+		return
+	}
+
+	del.LastError = a.Error
+	if del.Attempts >= d.opts.MaxAttempts || (err == nil && !retryable(resp.StatusCode)) {
+		log.Printf("Webhook delivery %s to %s failed after %d attempts: %s", del.ID, sub.ID, del.Attempts, del.LastError)
+		d.deadLetter(del)
+		return
+	}
+
+	wait := d.backoff(del.Attempts)
+	if err == nil {
+		if after := retryAfter(resp.Header); after > wait {
+			wait = min(after, d.opts.MaxBackoff)
+		}
+	}
+	d.scheduleRetry(del, wait)
+}
+
+// send POSTs the delivery's event to the subscription, signed with its
+// secret.
+func (d *Dispatcher) send(sub *Subscription, del *Delivery) (*http.Response, error) {
+	body, err := json.Marshal(del.Event)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, sub.URL, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "Rally-Webhooks/1")
+	req.Header.Set(EventHeader, del.Event.Type)
+	req.Header.Set(DeliveryHeader, del.ID)
+	signature.SetHeaders(req, []byte(sub.Secret), time.Now(), body)
+
+	resp, err := d.opts.Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	// Drain a little of the body so the connection can be reused.
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 4<<10))
+	resp.Body.Close()
+	return resp, nil
+}
+
+// retryable reports whether a failed delivery with the given response
+// status may succeed later. Other client errors mean the receiver rejects
+// the request as it is.
+func retryable(status int) bool {
+	return status >= 500 || status == http.StatusRequestTimeout || status == http.StatusTooManyRequests
+}
+
+// retryAfter returns the wait requested by a Retry-After header in
+// seconds, or zero.
+func retryAfter(h http.Header) time.Duration {
+	secs, err := strconv.Atoi(h.Get("Retry-After"))
+	if err != nil || secs <= 0 {
+		return 0
+	}
+	return time.Duration(secs) * time.Second
+}
+
+// backoff returns the wait before the retry following the given attempt:
+// MinBackoff doubled for every earlier attempt, capped at MaxBackoff, less
+// up to half at random so that retries of a burst spread out.
+func (d *Dispatcher) backoff(attempt int) time.Duration {
+	wait := d.opts.MinBackoff
+	for i := 1; i < attempt && wait < d.opts.MaxBackoff; i++ {
+		wait *= 2
+	}
+	wait = min(wait, d.opts.MaxBackoff)
+	return wait - rand.N(wait/2+1)
+}
+
+func (d *Dispatcher) scheduleRetry(del *Delivery, wait time.Duration) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.closed {
+		del.LastError = fmt.Sprintf("dispatcher closed before retry: %s", del.LastError)
+		go d.deadLetter(del)
+		return
+	}
+	d.retries[del.ID] = &retry{
+		delivery: del,
+		timer: time.AfterFunc(wait, func() {
+			d.mu.Lock()
+			delete(d.retries, del.ID)
+			d.mu.Unlock()
+			d.enqueue(del)
+		}),
+	}
+}
+
+func (d *Dispatcher) logAttempt(a *Attempt) {
+	ctx, cancel := context.WithTimeout(context.Background(), storeTimeout)
+	defer cancel()
+
+	if err := d.store.LogAttempt(ctx, a); err != nil {
+		log.Printf("Failed to log webhook attempt %s/%d: %v", a.DeliveryID, a.Attempt, err)
+	}
+}
+
+func (d *Dispatcher) deadLetter(del *Delivery) {
+	ctx, cancel := context.WithTimeout(context.Background(), storeTimeout)
+	defer cancel()
+
+	if err := d.store.DeadLetter(ctx, del); err != nil {
+		log.Printf("Failed to store dead webhook delivery %s: %v", del.ID, err)
+	}
+}
+
+// Close stops accepting events, dead-letters deliveries waiting for a
+// retry and waits until the queued ones have been tried once, or ctx is
+// done.
+func (d *Dispatcher) Close(ctx context.Context) error {
+	d.mu.Lock()
+	if d.closed {
+		d.mu.Unlock()
+		return nil
+	}
+	d.closed = true
+	var pending []*Delivery
+	for id, r := range d.retries {
+		if r.timer.Stop() {
+			pending = append(pending, r.delivery)
+		}
+		delete(d.retries, id)
+	}
+	close(d.queue)
+	d.mu.Unlock()
+
+	for _, del := range pending {
+		del.LastError = fmt.Sprintf("dispatcher closed before retry: %s", del.LastError)
+		d.deadLetter(del)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		d.workers.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("webhook deliveries still in flight: %w", ctx.Err())
+	}
+}
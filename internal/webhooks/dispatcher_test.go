@@ -0,0 +1,250 @@
+package webhooks
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/rally-go/rally-realtime/internal/signature"
+)
+
+const testSecret = "whsec-test"
+
+// receiver is a webhook endpoint answering with the statuses in turn, and
+// the last one once they run out.
+type receiver struct {
+	statuses []int
+	requests []time.Time
+	mu       sync.Mutex
+}
+
+func (rc *receiver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	rc.mu.Lock()
+	n := len(rc.requests)
+	rc.requests = append(rc.requests, time.Now())
+	rc.mu.Unlock()
+
+	w.WriteHeader(rc.statuses[min(n, len(rc.statuses)-1)])
+}
+
+func (rc *receiver) times() []time.Time {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	return append([]time.Time(nil), rc.requests...)
+}
+
+// newTestDispatcher returns a dispatcher with one subscription to every
+// event, delivered to handler.
+func newTestDispatcher(t *testing.T, handler http.Handler, opts Options) (*Dispatcher, *MemoryStore) {
+	t.Helper()
+
+	srv := httptest.NewServer(handler)
+	t.Cleanup(srv.Close)
+
+	store := NewMemoryStore()
+	d := NewDispatcher([]Subscription{{ID: "sub", URL: srv.URL + "/hooks/rally", Secret: testSecret, Events: []string{"*"}}}, store, opts)
+	t.Cleanup(func() { _ = d.Close(context.Background()) })
+	return d, store
+}
+
+// waitFor polls cond until it holds or two seconds have passed.
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+
+	deadline := time.Now().Add(2 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func attempts(t *testing.T, store *MemoryStore) []*Attempt {
+	t.Helper()
+
+	list, err := store.Attempts(context.Background(), "sub", 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return list
+}
+
+func deadLetters(t *testing.T, store *MemoryStore) []*Delivery {
+	t.Helper()
+
+	list, err := store.DeadLetters(context.Background(), "sub", 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return list
+}
+
+func TestDeliverySigned(t *testing.T) {
+	received := make(chan *Event, 1)
+	d, store := newTestDispatcher(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if err := signature.Verify([]byte(testSecret), r, body, time.Now()); err != nil {
+			t.Errorf("signature: %v", err)
+		}
+		if err := signature.Verify([]byte("other"), r, body, time.Now()); err == nil {
+			t.Error("signature verified with another secret")
+		}
+		if r.Header.Get(EventHeader) != EventChatMessage || r.Header.Get(DeliveryHeader) == "" {
+			t.Errorf("headers = %v", r.Header)
+		}
+
+		var event Event
+		if err := json.Unmarshal(body, &event); err != nil {
+			t.Errorf("body %s: %v", body, err)
+		}
+		received <- &event
+	}), Options{})
+
+	d.Dispatch(EventChatMessage, "trip", map[string]string{"content": "hi"})
+
+	select {
+	case event := <-received:
+		if event.Type != EventChatMessage || event.RoomID != "trip" || string(event.Data) != `{"content":"hi"}` {
+			t.Errorf("event = %+v", event)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("no delivery")
+	}
+	waitFor(t, "the attempt log", func() bool { return len(attempts(t, store)) == 1 })
+	if a := attempts(t, store)[0]; !a.Succeeded() || a.Attempt != 1 {
+		t.Errorf("attempt = %+v, want a first successful one", a)
+	}
+}
+
+func TestRetryWithBackoff(t *testing.T) {
+	rc := &receiver{statuses: []int{http.StatusInternalServerError, http.StatusBadGateway, http.StatusOK}}
+	backoff := 40 * time.Millisecond
+	d, store := newTestDispatcher(t, rc, Options{MinBackoff: backoff, MaxBackoff: time.Second})
+
+	d.Dispatch(EventChatMessage, "trip", map[string]string{"content": "hi"})
+	waitFor(t, "three attempts", func() bool { return len(attempts(t, store)) == 3 })
+
+	log := attempts(t, store)
+	if log[0].StatusCode != http.StatusOK || log[1].StatusCode != http.StatusBadGateway || log[2].StatusCode != http.StatusInternalServerError {
+		t.Errorf("statuses = %d %d %d, want 500 502 200", log[2].StatusCode, log[1].StatusCode, log[0].StatusCode)
+	}
+	if log[0].Attempt != 3 || log[0].DeliveryID != log[2].DeliveryID {
+		t.Errorf("last attempt = %+v, want the third of the same delivery", log[0])
+	}
+
+	// Retries wait at least half the backoff, which doubles every attempt.
+	times := rc.times()
+	if gap := times[1].Sub(times[0]); gap < backoff/2 {
+		t.Errorf("first retry after %v, want at least %v", gap, backoff/2)
+	}
+	if gap := times[2].Sub(times[1]); gap < backoff {
+		t.Errorf("second retry after %v, want at least %v", gap, backoff)
+	}
+	if len(deadLetters(t, store)) != 0 {
+		t.Error("delivered event dead-lettered")
+	}
+}
+
+func TestNoRetryOnClientError(t *testing.T) {
+	rc := &receiver{statuses: []int{http.StatusBadRequest, http.StatusOK}}
+	d, store := newTestDispatcher(t, rc, Options{MinBackoff: time.Millisecond})
+
+	d.Dispatch(EventChatMessage, "trip", map[string]string{"content": "hi"})
+	waitFor(t, "a dead letter", func() bool { return len(deadLetters(t, store)) == 1 })
+
+	time.Sleep(50 * time.Millisecond)
+	if n := len(rc.times()); n != 1 {
+		t.Errorf("receiver got %d requests, want 1", n)
+	}
+	if dead := deadLetters(t, store)[0]; dead.Attempts != 1 || dead.LastError != "400 Bad Request" {
+		t.Errorf("dead letter = %+v", dead)
+	}
+}
+
+func TestDeadLetterAfterMaxAttempts(t *testing.T) {
+	rc := &receiver{statuses: []int{http.StatusServiceUnavailable}}
+	d, store := newTestDispatcher(t, rc, Options{MaxAttempts: 3, MinBackoff: time.Millisecond, MaxBackoff: 5 * time.Millisecond})
+
+	d.Dispatch(EventChatMessage, "trip", map[string]string{"content": "hi"})
+	waitFor(t, "a dead letter", func() bool { return len(deadLetters(t, store)) == 1 })
+
+	dead := deadLetters(t, store)[0]
+	if dead.Attempts != 3 || dead.LastError != "503 Service Unavailable" || dead.Event.Type != EventChatMessage {
+		t.Errorf("dead letter = %+v", dead)
+	}
+	time.Sleep(50 * time.Millisecond)
+	if n := len(rc.times()); n != 3 {
+		t.Errorf("receiver got %d requests, want 3", n)
+	}
+	if n := len(attempts(t, store)); n != 3 {
+		t.Errorf("%d attempts logged, want 3", n)
+	}
+}
+
+func TestRetryable(t *testing.T) {
+	tests := []struct {
+		status int
+		want   bool
+	}{
+		{http.StatusBadRequest, false},
+		{http.StatusUnauthorized, false},
+		{http.StatusNotFound, false},
+		{http.StatusRequestTimeout, true},
+		{http.StatusTooManyRequests, true},
+		{http.StatusInternalServerError, true},
+		{http.StatusServiceUnavailable, true},
+	}
+	for _, tt := range tests {
+		if got := retryable(tt.status); got != tt.want {
+			t.Errorf("retryable(%d) = %v, want %v", tt.status, got, tt.want)
+		}
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	d := &Dispatcher{opts: Options{MinBackoff: time.Second, MaxBackoff: 10 * time.Second}}
+	for attempt, limit := range map[int]time.Duration{1: time.Second, 2: 2 * time.Second, 3: 4 * time.Second, 4: 8 * time.Second, 5: 10 * time.Second, 30: 10 * time.Second} {
+		for range 20 {
+			if got := d.backoff(attempt); got < limit/2 || got > limit {
+				t.Errorf("backoff(%d) = %v, want between %v and %v", attempt, got, limit/2, limit)
+			}
+		}
+	}
+}
+
+func TestThrottle(t *testing.T) {
+	throttle := NewThrottle(30 * time.Second)
+	now := time.Date(2026, 3, 1, 9, 0, 0, 0, time.UTC)
+
+	steps := []struct {
+		key   string
+		after time.Duration
+		want  bool
+	}{
+		{"trip\x00alice", 0, true},
+		{"trip\x00alice", 5 * time.Second, false},
+		{"trip\x00bob", 5 * time.Second, true},
+		{"other\x00alice", 5 * time.Second, true},
+		{"trip\x00alice", 29 * time.Second, false},
+		{"trip\x00alice", 30 * time.Second, true},
+		{"trip\x00alice", 45 * time.Second, false},
+		{"trip\x00bob", 45 * time.Second, true},
+	}
+	for _, s := range steps {
+		if got := throttle.Allow(s.key, now.Add(s.after)); got != s.want {
+			t.Errorf("Allow(%q) at +%v = %v, want %v", s.key, s.after, got, s.want)
+		}
+	}
+
+	// Quiet keys are forgotten.
+	throttle.Allow("trip\x00carol", now.Add(5*time.Minute))
+	if len(throttle.next) != 1 {
+		t.Errorf("throttle holds %d keys, want 1", len(throttle.next))
+	}
+}
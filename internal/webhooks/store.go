@@ -0,0 +1,201 @@
+package webhooks
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Delivery is one event on its way to one subscription.
+type Delivery struct {
+	ID             string    `json:"id"`
+	SubscriptionID string    `json:"subscription_id"`
+	Event          *Event    `json:"event"`
+	Attempts       int       `json:"attempts"`
+	LastError      string    `json:"last_error,omitempty"`
+	CreatedAt      time.Time `json:"created_at"`
+}
+
+// Attempt is an entry of the delivery log.
+type Attempt struct {
+	DeliveryID     string        `json:"delivery_id"`
+	SubscriptionID string        `json:"subscription_id"`
+	EventID        string        `json:"event_id"`
+	EventType      string        `json:"event_type"`
+	Attempt        int           `json:"attempt"` // 1 for the first try
+	StatusCode     int           `json:"status_code,omitempty"`
+	Error          string        `json:"error,omitempty"`
+	Duration       time.Duration `json:"duration"`
+	Timestamp      time.Time     `json:"timestamp"`
+}
+
+// Succeeded reports whether the receiver accepted the delivery.
+func (a *Attempt) Succeeded() bool {
+	return a.Error == "" && a.StatusCode >= 200 && a.StatusCode < 300
+}
+
+// Store keeps the delivery log and dead letters of each subscription. Both
+// are capped at the most recent entries.
+type Store interface {
+	LogAttempt(ctx context.Context, a *Attempt) error
+	// Attempts returns up to limit log entries, newest first.
+	Attempts(ctx context.Context, subscriptionID string, limit int) ([]*Attempt, error)
+
+	DeadLetter(ctx context.Context, d *Delivery) error
+	// DeadLetters returns up to limit dead letters, newest first.
+	DeadLetters(ctx context.Context, subscriptionID string, limit int) ([]*Delivery, error)
+}
+
+// Caps on stored entries per subscription.
+const (
+	maxLogEntries  = 1000
+	maxDeadLetters = 10000
+	deadLetterTTL  = 14 * 24 * time.Hour
+)
+
+// MemoryStore implements Store in process memory.
+// It is only suitable for a single server instance or local development.
+type MemoryStore struct {
+	log  map[string][]*Attempt  // subscription -> oldest first
+	dead map[string][]*Delivery // subscription -> oldest first
+	mu   sync.RWMutex
+}
+
+// NewMemoryStore creates an empty in-memory webhook store.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		log:  make(map[string][]*Attempt),
+		dead: make(map[string][]*Delivery),
+	}
+}
+
+// LogAttempt appends an attempt to the delivery log.
+func (s *MemoryStore) LogAttempt(ctx context.Context, a *Attempt) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.log[a.SubscriptionID] = capped(append(s.log[a.SubscriptionID], a), maxLogEntries)
+	return nil
+}
+
+// Attempts returns the most recent log entries.
+func (s *MemoryStore) Attempts(ctx context.Context, subscriptionID string, limit int) ([]*Attempt, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return newestFirst(s.log[subscriptionID], limit), nil
+}
+
+// DeadLetter stores a delivery that will not be retried.
+func (s *MemoryStore) DeadLetter(ctx context.Context, d *Delivery) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.dead[d.SubscriptionID] = capped(append(s.dead[d.SubscriptionID], d), maxDeadLetters)
+	return nil
+}
+
+// DeadLetters returns the most recent dead letters.
+func (s *MemoryStore) DeadLetters(ctx context.Context, subscriptionID string, limit int) ([]*Delivery, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return newestFirst(s.dead[subscriptionID], limit), nil
+}
+
+func capped[T any](list []T, n int) []T {
+	if len(list) > n {
+		return list[len(list)-n:]
+	}
+	return list
+}
+
+func newestFirst[T any](list []T, limit int) []T {
+	if limit <= 0 {
+		return nil
+	}
+	out := make([]T, 0, min(len(list), limit))
+	for i := len(list) - 1; i >= 0 && len(out) < limit; i-- {
+		out = append(out, list[i])
+	}
+	return out
+}
+
+// RedisStore implements Store using Redis, with a capped list per
+// subscription for the log and another for dead letters.
+type RedisStore struct {
+	client *redis.Client
+}
+
+// NewRedisStore creates a webhook store backed by the given Redis client.
+func NewRedisStore(client *redis.Client) *RedisStore {
+	return &RedisStore{client: client}
+}
+
+func logKey(subscriptionID string) string {
+	return "rally:webhooks:" + subscriptionID + ":log"
+}
+
+func deadKey(subscriptionID string) string {
+	return "rally:webhooks:" + subscriptionID + ":dead"
+}
+
+// LogAttempt appends an attempt to the delivery log.
+func (s *RedisStore) LogAttempt(ctx context.Context, a *Attempt) error {
+	return s.push(ctx, logKey(a.SubscriptionID), a, maxLogEntries, 0)
+}
+
+// Attempts returns the most recent log entries.
+func (s *RedisStore) Attempts(ctx context.Context, subscriptionID string, limit int) ([]*Attempt, error) {
+	return rangeJSON[Attempt](ctx, s.client, logKey(subscriptionID), limit)
+}
+
+// DeadLetter stores a delivery that will not be retried. Dead letters are
+// kept for two weeks after the last one.
+func (s *RedisStore) DeadLetter(ctx context.Context, d *Delivery) error {
+	return s.push(ctx, deadKey(d.SubscriptionID), d, maxDeadLetters, deadLetterTTL)
+}
+
+// DeadLetters returns the most recent dead letters.
+func (s *RedisStore) DeadLetters(ctx context.Context, subscriptionID string, limit int) ([]*Delivery, error) {
+	return rangeJSON[Delivery](ctx, s.client, deadKey(subscriptionID), limit)
+}
+
+func (s *RedisStore) push(ctx context.Context, key string, v any, n int, ttl time.Duration) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	pipe := s.client.TxPipeline()
+	pipe.LPush(ctx, key, data)
+	pipe.LTrim(ctx, key, 0, int64(n-1))
+	if ttl > 0 {
+		pipe.Expire(ctx, key, ttl)
+	}
+	_, err = pipe.Exec(ctx)
+	return err
+}
+
+func rangeJSON[T any](ctx context.Context, client *redis.Client, key string, limit int) ([]*T, error) {
+	if limit <= 0 {
+		return nil, nil
+	}
+	items, err := client.LRange(ctx, key, 0, int64(limit-1)).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	out := make([]*T, 0, len(items))
+	for _, item := range items {
+		var v T
+		if err := json.Unmarshal([]byte(item), &v); err != nil {
+			return nil, err
+		}
+		out = append(out, &v)
+	}
+	return out, nil
+}
@@ -0,0 +1,52 @@
+package webhooks
+
+import (
+	"sync"
+	"time"
+)
+
+// LocationInterval is the least time between two location.updated events
+// for the same user in the same room. Clients share their position every
+// few seconds while moving, which is far more than a receiver needs.
+const LocationInterval = 30 * time.Second
+
+// Throttle allows an event per key once per interval. It is kept in
+// process memory: events are dispatched by the instance that handled the
+// client message, and a user's updates in a room arrive on one connection.
+type Throttle struct {
+	interval time.Duration
+	next     map[string]time.Time // key -> time of the next allowed event
+	pruned   time.Time
+	mu       sync.Mutex
+}
+
+// NewThrottle creates a throttle allowing one event per key and interval.
+func NewThrottle(interval time.Duration) *Throttle {
+	return &Throttle{
+		interval: interval,
+		next:     make(map[string]time.Time),
+	}
+}
+
+// Allow reports whether an event for key may be dispatched at now, and if
+// so holds back further ones for the interval.
+func (t *Throttle) Allow(key string, now time.Time) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	// Forget keys that have gone quiet, at most once per interval.
+	if now.Sub(t.pruned) >= t.interval {
+		for k, next := range t.next {
+			if !now.Before(next) {
+				delete(t.next, k)
+			}
+		}
+		t.pruned = now
+	}
+
+	if next, ok := t.next[key]; ok && now.Before(next) {
+		return false
+	}
+	t.next[key] = now.Add(t.interval)
+	return true
+}
@@ -0,0 +1,128 @@
+// Package webhooks delivers room events to other services over HTTP, so
+// that they can react to chat messages, location updates and itinerary
+// changes without holding a socket.
+//
+// Every delivery is a POST of a JSON event signed like the requests that
+// services send to POST /rooms/{id}/events (see package signature), made
+// from a background worker and retried with exponential backoff. Each
+// attempt is written to a delivery log, and deliveries that never succeed
+// are kept as dead letters.
+package webhooks
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// Event types delivered to subscriptions. Itinerary events are named after
+// the planning action, e.g. "itinerary.insert", or after its source for the
+// compensating edit of an undo or redo, e.g. "itinerary.undo".
+const (
+	EventChatMessage     = "chat.message"
+	EventLocationUpdated = "location.updated"
+	EventItineraryPrefix = "itinerary."
+)
+
+// Event is the body of a webhook request.
+type Event struct {
+	ID        string          `json:"id"`
+	Type      string          `json:"type"`
+	RoomID    string          `json:"room_id"`
+	Data      json.RawMessage `json:"data"`
+	Timestamp time.Time       `json:"timestamp"`
+}
+
+// LocationData is the data of a location.updated event.
+type LocationData struct {
+	UserID   string          `json:"user_id"`
+	Location json.RawMessage `json:"location"`
+}
+
+// Subscription sends events of the listed types to a URL.
+type Subscription struct {
+	ID     string `json:"id"`
+	URL    string `json:"url"`
+	Secret string `json:"secret"`
+	// Event types to deliver. "*" matches every type and a trailing ".*"
+	// every type under a prefix, e.g. "itinerary.*".
+	Events []string `json:"events"`
+	// Rooms to deliver events of; empty means every room.
+	Rooms []string `json:"rooms,omitempty"`
+}
+
+// Matches reports whether the subscription wants an event of eventType in
+// roomID.
+func (s *Subscription) Matches(eventType, roomID string) bool {
+	if len(s.Rooms) > 0 && !contains(s.Rooms, roomID) {
+		return false
+	}
+	for _, pattern := range s.Events {
+		if pattern == "*" || pattern == eventType {
+			return true
+		}
+		if prefix, ok := strings.CutSuffix(pattern, "*"); ok && strings.HasSuffix(prefix, ".") && strings.HasPrefix(eventType, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+func contains(list []string, s string) bool {
+	for _, e := range list {
+		if e == s {
+			return true
+		}
+	}
+	return false
+}
+
+// Config lists webhook subscriptions.
+type Config struct {
+	Subscriptions []Subscription `json:"subscriptions"`
+}
+
+// LoadConfig reads and validates a webhook config from a JSON file.
+func LoadConfig(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var cfg Config
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return nil, fmt.Errorf("parse webhook config %s: %w", path, err)
+	}
+	if err := cfg.validate(); err != nil {
+		return nil, fmt.Errorf("webhook config %s: %w", path, err)
+	}
+	return &cfg, nil
+}
+
+func (c *Config) validate() error {
+	seen := make(map[string]bool)
+	for i, s := range c.Subscriptions {
+		if s.ID == "" {
+			return fmt.Errorf("subscription %d: id is required", i)
+		}
+		if seen[s.ID] {
+			return fmt.Errorf("subscription %s: duplicate id", s.ID)
+		}
+		seen[s.ID] = true
+
+		u, err := url.Parse(s.URL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("subscription %s: url must be an absolute http(s) URL", s.ID)
+		}
+		if s.Secret == "" {
+			return fmt.Errorf("subscription %s: secret is required", s.ID)
+		}
+		if len(s.Events) == 0 {
+			return fmt.Errorf("subscription %s: events is required", s.ID)
+		}
+	}
+	return nil
+}